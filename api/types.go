@@ -22,12 +22,21 @@ limitations under the License.
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 )
 
-// ClientHeader is the header version for all requests. It should be updated on version bumps
-const ClientHeader = "CerberusGoClient/0.3.1"
+// Version is the version of the client. It should be updated on version bumps
+const Version = "0.3.1"
+
+// ClientHeader is the X-Cerberus-Client header value for all requests
+const ClientHeader = "CerberusGoClient/" + Version
+
+// DefaultUserAgent is the User-Agent header value for all requests unless it is changed
+const DefaultUserAgent = "cerberus-go-client/" + Version
 
 // AuthStatus is the status of a UserAuthResponse
 type AuthStatus string
@@ -46,6 +55,26 @@ var ErrorUnauthenticated = fmt.Errorf("Unable to complete request: Not Authentic
 // ErrorUnauthorized is returned when the request fails because of invalid credentials
 var ErrorUnauthorized = fmt.Errorf("Invalid credentials given")
 
+// ErrorForbidden is returned when Cerberus accepted the credentials but they aren't allowed
+// to do what was asked (an HTTP 403). Unlike ErrorUnauthorized, authenticating again won't help
+var ErrorForbidden = fmt.Errorf("The given credentials are not permitted to perform this action")
+
+// ErrorTokenDraining is returned when the token is being logged out and can't be used for new requests
+var ErrorTokenDraining = fmt.Errorf("Unable to complete request: the token is being logged out")
+
+// ErrorReadOnly is returned when a client created in read-only mode is asked to change something
+var ErrorReadOnly = fmt.Errorf("Unable to complete request: the client is read-only")
+
+// ErrorRenewalLimitExceeded is returned when Cerberus won't renew a token any further, because it
+// has been renewed as many times as it can be or isn't renewable at all. Logging in again is the
+// only way to get a new token
+var ErrorRenewalLimitExceeded = fmt.Errorf("Unable to renew the token: it can't be renewed any further")
+
+// ErrorEmptyResponse is returned when Cerberus responds with a success status code but no
+// content, which usually means something between the client and Cerberus (such as a proxy)
+// stripped the body
+var ErrorEmptyResponse = fmt.Errorf("Cerberus returned a successful response with no content")
+
 // ErrorResponse represents an error response from the API
 type ErrorResponse struct {
 	ErrorID string `json:"error_id"`
@@ -63,13 +92,94 @@ func (e ErrorResponse) Error() string {
 	return fmt.Sprintf("Error from API. ID: %s, Details: %+v", e.ErrorID, e.Errors)
 }
 
+// CerberusError is returned when Cerberus rejects a request with a structured error body. It has
+// the HTTP status code along with the error ID and details from the body, so that different
+// kinds of failures (such as a validation error and a policy error) can be told apart
+type CerberusError struct {
+	StatusCode int
+	ErrorResponse
+}
+
+func (e *CerberusError) Error() string {
+	details := make([]string, 0, len(e.Errors))
+	for _, d := range e.Errors {
+		details = append(details, fmt.Sprintf("%s (code %d)", d.Message, d.Code))
+	}
+	return fmt.Sprintf("Error from API. Got HTTP status code %d. ID: %s, Details: %s", e.StatusCode, e.ErrorID, strings.Join(details, ", "))
+}
+
+// ParseCerberusError reads a structured error body from Cerberus. It returns nil if the body
+// isn't valid JSON or doesn't have an error ID or any errors, in which case the caller should
+// fall back to reporting the status code
+func ParseCerberusError(statusCode int, body io.Reader) *CerberusError {
+	var resp ErrorResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil
+	}
+	if resp.ErrorID == "" && len(resp.Errors) == 0 {
+		return nil
+	}
+	return &CerberusError{StatusCode: statusCode, ErrorResponse: resp}
+}
+
+// ErrorRateLimited is matched (with errors.Is) by the RateLimitError returned when Cerberus
+// responds with a 429 Too Many Requests
+var ErrorRateLimited = fmt.Errorf("Rate limited by Cerberus")
+
+// RateLimitError is returned when Cerberus rate limits a request and it isn't going to be
+// retried. RetryAfter is how long Cerberus asked clients to wait before trying again, taken
+// from the Retry-After header, and is 0 if it didn't say
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%v. Retry after %v", ErrorRateLimited, e.RetryAfter)
+	}
+	return ErrorRateLimited.Error()
+}
+
+// Is makes errors.Is(err, ErrorRateLimited) true for a RateLimitError
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrorRateLimited
+}
+
 // IAMAuthResponse represents a response from the iam-principal authentication endpoint
 type IAMAuthResponse struct {
 	Token     string `json:"client_token"`
 	Policies  []string
 	Metadata  AWSMetadata
-	Duration  int `json:"lease_duration"`
-	Renewable bool
+	Duration  int  `json:"lease_duration"`
+	Renewable bool `json:"renewable"`
+}
+
+// TokenRenewResponse represents the response from the /v1/auth/token/self-renew endpoint
+type TokenRenewResponse struct {
+	Auth RenewedToken `json:"auth"`
+}
+
+// RenewedToken is the token returned when renewing, with its new lease
+type RenewedToken struct {
+	Token     string `json:"client_token"`
+	Policies  []string
+	Duration  int  `json:"lease_duration"`
+	Renewable bool `json:"renewable"`
+}
+
+// TokenLookupResponse represents the response from the /v1/auth/token/lookup-self endpoint
+type TokenLookupResponse struct {
+	Data TokenLookupData `json:"data"`
+}
+
+// TokenLookupData describes a token as returned by a lookup. TTL is how many seconds are
+// left on its lease
+type TokenLookupData struct {
+	ID        string       `json:"id"`
+	Policies  []string     `json:"policies"`
+	Metadata  UserMetadata `json:"meta"`
+	TTL       int          `json:"ttl"`
+	Renewable bool         `json:"renewable"`
 }
 
 // AWSMetadata contains additional information about the ARN that was used to log in
@@ -165,6 +275,13 @@ type Category struct {
 	LastUpdatedBy string    `json:"last_updated_by"`
 }
 
+// CategorySummary is a category along with the number of SDBs in it
+type CategorySummary struct {
+	ID    string
+	Name  string
+	Count int
+}
+
 // MetadataResponse is an object that wraps a list of SDBMetadata for convenience with pagination
 type MetadataResponse struct {
 	HasNext     bool `json:"has_next"`
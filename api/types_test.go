@@ -17,7 +17,11 @@ limitations under the License.
 package api
 
 import (
+	"errors"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -42,3 +46,51 @@ func TestErrorResponse(t *testing.T) {
 		})
 	})
 }
+
+var cerberusErrorBody = `{
+	"error_id": "d2ad5ce3-d6a2-4f3d-9a9f-7c3e2b1e5f10",
+	"errors": [{
+		"code": 99217,
+		"message": "The SDB name is already in use."
+	}, {
+		"code": 99105,
+		"message": "Insufficient permissions"
+	}]
+}`
+
+func TestParseCerberusError(t *testing.T) {
+	Convey("A structured error body", t, func() {
+		e := ParseCerberusError(http.StatusBadRequest, strings.NewReader(cerberusErrorBody))
+		Convey("Should be parsed with the status code", func() {
+			So(e, ShouldNotBeNil)
+			So(e.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(e.ErrorID, ShouldEqual, "d2ad5ce3-d6a2-4f3d-9a9f-7c3e2b1e5f10")
+			So(e.Errors, ShouldHaveLength, 2)
+			So(e.Errors[1].Code, ShouldEqual, 99105)
+		})
+		Convey("Should include every error in the message", func() {
+			So(e.Error(), ShouldContainSubstring, "400")
+			So(e.Error(), ShouldContainSubstring, "The SDB name is already in use. (code 99217)")
+			So(e.Error(), ShouldContainSubstring, "Insufficient permissions (code 99105)")
+		})
+	})
+	Convey("A body that isn't JSON", t, func() {
+		So(ParseCerberusError(http.StatusBadGateway, strings.NewReader("<html>Bad Gateway</html>")), ShouldBeNil)
+	})
+	Convey("A JSON body without errors", t, func() {
+		So(ParseCerberusError(http.StatusInternalServerError, strings.NewReader(`{"message": "oops"}`)), ShouldBeNil)
+	})
+}
+
+func TestRateLimitError(t *testing.T) {
+	Convey("A RateLimitError", t, func() {
+		Convey("Should include the delay if there is one", func() {
+			err := &RateLimitError{RetryAfter: 5 * time.Second}
+			So(err.Error(), ShouldEqual, "Rate limited by Cerberus. Retry after 5s")
+			So(errors.Is(err, ErrorRateLimited), ShouldBeTrue)
+		})
+		Convey("Should still make sense without a delay", func() {
+			So((&RateLimitError{}).Error(), ShouldEqual, "Rate limited by Cerberus")
+		})
+	})
+}
@@ -20,10 +20,15 @@ limitations under the License.
 package auth
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
@@ -34,12 +39,35 @@ import (
 // network request time and clock skew
 const expiryDelta time.Duration = 60 * time.Second
 
+// DefaultTimeout is how long requests made to Cerberus while authenticating, refreshing, or
+// logging out are given to finish before they are abandoned, unless changed with SetTimeout
+const DefaultTimeout = 30 * time.Second
+
+// DefaultLeaseDuration is how long a token from AWS authentication is treated as valid
+// when Cerberus doesn't say how long its lease is (a lease_duration of 0 or none at all)
+const DefaultLeaseDuration = 1 * time.Hour
+
+// leaseExpiry returns when a token with the given lease (in seconds) should be treated as
+// expired, using fallback if there is no lease. Short leases are only cut by half rather
+// than by the whole expiryDelta, so that they aren't already expired when they arrive
+func leaseExpiry(seconds int, fallback time.Duration) time.Time {
+	lease := time.Duration(seconds) * time.Second
+	if lease <= 0 {
+		lease = fallback
+	}
+	delta := expiryDelta
+	if lease <= 2*delta {
+		delta = lease / 2
+	}
+	return time.Now().Add(lease - delta)
+}
+
 // The Auth interface describes the methods that all authentication providers must satisfy
 type Auth interface {
 	// GetToken should either return an existing token or perform all authentication steps
-	// necessary to get a new token. It takes a file object as an argument as a place to
-	// read an OTP for MFA flow
-	GetToken(*os.File) (string, error)
+	// necessary to get a new token. Any requests made to authenticate should be abandoned
+	// if the context is cancelled or its deadline passes
+	GetToken(context.Context) (string, error)
 	// IsAuthenticated should return whether or not there is a valid token. A valid token
 	// is one that exists and is not expired
 	IsAuthenticated() bool
@@ -51,22 +79,86 @@ type Auth interface {
 	// It returns a basic set of headers asking for a JSON response and has
 	// the authorization header set with the proper token
 	GetHeaders() (http.Header, error)
+	// GetURL returns the Cerberus URL the auth method authenticates against
 	GetURL() *url.URL
 }
 
+// Every auth type in this package can be used anywhere an Auth is expected
+var (
+	_ Auth = (*AWSAuth)(nil)
+	_ Auth = (*AWSSTSAuth)(nil)
+	_ Auth = (*UserAuth)(nil)
+	_ Auth = (*TokenAuth)(nil)
+	_ Auth = (*ChainAuth)(nil)
+)
+
+// Reauthenticator is implemented by auth methods that can log in again on demand, which is
+// used to recover when Cerberus rejects a token that still looks valid to the client
+type Reauthenticator interface {
+	// Reauthenticate gets a new token even if the current one hasn't expired yet
+	Reauthenticate() error
+}
+
+var (
+	_ Reauthenticator = (*AWSAuth)(nil)
+	_ Reauthenticator = (*AWSSTSAuth)(nil)
+	_ Reauthenticator = (*UserAuth)(nil)
+	_ Reauthenticator = (*ChainAuth)(nil)
+)
+
+// EnsureAuthenticated makes sure the given auth method has a valid token, authenticating with
+// GetToken if it doesn't. It does nothing if there is already a token that isn't close to
+// expiring. It returns nil once a valid token is available, or the error from authenticating.
+// The context is checked before authenticating, so a cancelled context returns its error
+// without making any requests
+func EnsureAuthenticated(ctx context.Context, a Auth) error {
+	if a.IsAuthenticated() {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := a.GetToken(ctx)
+	return err
+}
+
 // Refresh contains logic for refreshing a token against the API. Because
-// all tokens can be refreshed this way, it is better to keep this in one place
+// all tokens can be refreshed this way, it is better to keep this in one place.
+// The request is given DefaultTimeout to finish
 func Refresh(builtURL url.URL, headers http.Header) (*api.UserAuthResponse, error) {
-	builtURL.Path = "/v2/auth/user/refresh"
+	return RefreshWithTimeout(DefaultTimeout, builtURL, headers)
+}
+
+// RefreshWithTimeout is the same as Refresh, but gives up on the request if Cerberus doesn't
+// respond within the timeout. The error returned when it does wraps context.DeadlineExceeded
+func RefreshWithTimeout(d time.Duration, builtURL url.URL, headers http.Header) (*api.UserAuthResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return RefreshWithContext(ctx, builtURL, headers)
+}
+
+// RefreshWithContext is the same as Refresh, but gives up on the request if the context
+// is cancelled or its deadline passes
+func RefreshWithContext(ctx context.Context, builtURL url.URL, headers http.Header) (*api.UserAuthResponse, error) {
+	return refresh(ctx, defaultClient, NopLogger, builtURL, apiEndpoints[DefaultAPIVersion].refresh, headers, NopMetrics)
+}
+
+// refresh gets a new token for the one in the headers from the refresh endpoint using the given
+// HTTP client, logging the request to l and recording it with the given MetricsRecorder
+func refresh(ctx context.Context, client *http.Client, l Logger, builtURL url.URL, endpoint string, headers http.Header, metrics MetricsRecorder) (*api.UserAuthResponse, error) {
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
 	req, err := http.NewRequest("GET", builtURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header = headers
-	resp, err := (&http.Client{}).Do(req)
+	start := time.Now()
+	resp, err := send(ctx, client, l, req, 1)
+	observeRequest(ctx, metrics, endpoint, resp, start)
 	if err != nil {
-		return nil, fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+		return nil, requestError(ctx, err)
 	}
+	defer closeBody(resp)
 	r, checkErr := utils.CheckAndParse(resp)
 	if checkErr != nil {
 		return nil, checkErr
@@ -74,20 +166,191 @@ func Refresh(builtURL url.URL, headers http.Header) (*api.UserAuthResponse, erro
 	return r, nil
 }
 
+// renew extends the lease of the token in the headers by increment with the renew endpoint using
+// the given HTTP client, logging the request to l and recording it with the given
+// MetricsRecorder. An increment of 0 leaves the length of the lease up to Cerberus
+func renew(ctx context.Context, client *http.Client, l Logger, builtURL url.URL, endpoint string, headers http.Header, increment time.Duration, metrics MetricsRecorder) (*api.RenewedToken, error) {
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
+	body := &bytes.Buffer{}
+	if increment > 0 {
+		if err := json.NewEncoder(body).Encode(map[string]int64{"increment": int64(increment / time.Second)}); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest("POST", builtURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+	start := time.Now()
+	resp, err := send(ctx, client, l, req, 1)
+	observeRequest(ctx, metrics, endpoint, resp, start)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer closeBody(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, api.ErrorUnauthorized
+	case http.StatusForbidden:
+		return nil, api.ErrorForbidden
+	case http.StatusBadRequest:
+		// Cerberus refuses to renew a token that has run out of renewals with a 400, but a 400
+		// can also mean something else was wrong with the request
+		apiErr := api.ParseCerberusError(resp.StatusCode, resp.Body)
+		if apiErr == nil {
+			return nil, fmt.Errorf("Unable to renew the token. Got HTTP response code %d", resp.StatusCode)
+		}
+		if isRenewalLimitError(apiErr) {
+			return nil, fmt.Errorf("%w: %v", api.ErrorRenewalLimitExceeded, apiErr)
+		}
+		return nil, apiErr
+	default:
+		if apiErr := api.ParseCerberusError(resp.StatusCode, resp.Body); apiErr != nil {
+			return nil, apiErr
+		}
+		return nil, fmt.Errorf("Unable to renew the token. Got HTTP response code %d", resp.StatusCode)
+	}
+	r := &api.TokenRenewResponse{}
+	err = json.NewDecoder(resp.Body).Decode(r)
+	if err == io.EOF {
+		return nil, api.ErrorEmptyResponse
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error while trying to parse response from Cerberus: %v", err)
+	}
+	return &r.Auth, nil
+}
+
+// isRenewalLimitError returns whether an error from Cerberus says that a token can't be renewed
+// any further
+func isRenewalLimitError(apiErr *api.CerberusError) bool {
+	for _, d := range apiErr.Errors {
+		msg := strings.ToLower(d.Message)
+		if strings.Contains(msg, "maximum number of times") || strings.Contains(msg, "not renewable") ||
+			strings.Contains(msg, "renewal limit") {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup describes the token in the headers with the lookup endpoint using the given HTTP client,
+// logging the request to l and recording it with the given MetricsRecorder. A token that
+// Cerberus rejects returns ErrorUnauthorized
+func lookup(ctx context.Context, client *http.Client, l Logger, builtURL url.URL, endpoint string, headers http.Header, metrics MetricsRecorder) (*api.UserClientToken, error) {
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
+	req, err := http.NewRequest("GET", builtURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+	start := time.Now()
+	resp, err := send(ctx, client, l, req, 1)
+	observeRequest(ctx, metrics, endpoint, resp, start)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer closeBody(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// Any valid token is allowed to look itself up, so a 403 also means the token was rejected
+		return nil, api.ErrorUnauthorized
+	default:
+		if apiErr := api.ParseCerberusError(resp.StatusCode, resp.Body); apiErr != nil {
+			return nil, apiErr
+		}
+		return nil, fmt.Errorf("Unable to look up the token. Got HTTP response code %d", resp.StatusCode)
+	}
+	r := &api.TokenLookupResponse{}
+	err = json.NewDecoder(resp.Body).Decode(r)
+	if err == io.EOF {
+		return nil, api.ErrorEmptyResponse
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error while trying to parse response from Cerberus: %v", err)
+	}
+	return &api.UserClientToken{
+		ClientToken: r.Data.ID,
+		Policies:    r.Data.Policies,
+		Metadata:    r.Data.Metadata,
+		Duration:    r.Data.TTL,
+		Renewable:   r.Data.Renewable,
+	}, nil
+}
+
 // Logout takes a set of headers containing a vault token and a URL and logs out of Cerberus.
+// The request is given DefaultTimeout to finish
 func Logout(builtURL url.URL, headers http.Header) error {
-	builtURL.Path = "/v1/auth"
+	return LogoutWithTimeout(DefaultTimeout, builtURL, headers)
+}
+
+// LogoutWithTimeout is the same as Logout, but gives up on the request if Cerberus doesn't
+// respond within the timeout. The error returned when it does wraps context.DeadlineExceeded
+func LogoutWithTimeout(d time.Duration, builtURL url.URL, headers http.Header) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return LogoutWithContext(ctx, builtURL, headers)
+}
+
+// LogoutWithContext is the same as Logout, but gives up on the request if the context
+// is cancelled or its deadline passes
+func LogoutWithContext(ctx context.Context, builtURL url.URL, headers http.Header) error {
+	return logout(ctx, defaultClient, NopLogger, builtURL, apiEndpoints[DefaultAPIVersion].logout, headers, NopMetrics)
+}
+
+// logout revokes the token in the headers with the logout endpoint using the given HTTP client,
+// logging the request to l and recording it with the given MetricsRecorder
+func logout(ctx context.Context, client *http.Client, l Logger, builtURL url.URL, endpoint string, headers http.Header, metrics MetricsRecorder) error {
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
 	req, err := http.NewRequest("DELETE", builtURL.String(), nil)
 	if err != nil {
 		return err
 	}
 	req.Header = headers
-	resp, err := (&http.Client{}).Do(req)
+	start := time.Now()
+	resp, err := send(ctx, client, l, req, 1)
+	observeRequest(ctx, metrics, endpoint, resp, start)
 	if err != nil {
-		return fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+		return requestError(ctx, err)
+	}
+	defer closeBody(resp)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return api.ErrorUnauthorized
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return api.ErrorForbidden
 	}
 	if resp.StatusCode != http.StatusNoContent {
+		if apiErr := api.ParseCerberusError(resp.StatusCode, resp.Body); apiErr != nil {
+			return apiErr
+		}
 		return fmt.Errorf("Unable to log out. Got HTTP response code %d", resp.StatusCode)
 	}
+	metrics.IncAuth(AuthResultLogout)
 	return nil
 }
+
+// requestError describes a request to Cerberus that failed. If it failed because the context
+// was cancelled or its deadline passed, the context's error is wrapped so that callers can
+// tell it apart from a problem with Cerberus (e.g. with errors.Is(err, context.Canceled)).
+// A request that ran past the HTTP client's timeout also wraps context.DeadlineExceeded
+func requestError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("Request to Cerberus was abandoned: %w", ctxErr)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("Request to Cerberus timed out: %w", err)
+	}
+	return fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+}
+
+// withBasePath returns a copy of the URL with its path replaced by the normalized base path
+func withBasePath(baseURL *url.URL, basePath string) *url.URL {
+	u := *baseURL
+	u.Path = utils.NormalizeBasePath(basePath)
+	u.RawPath = ""
+	return &u
+}
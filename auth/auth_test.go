@@ -17,13 +17,20 @@ limitations under the License.
 package auth
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	. "github.com/smartystreets/goconvey/convey"
 	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 var authResponseBody = `{
@@ -89,6 +96,18 @@ func TestingServer(returnCode int, expectedPath, expectedMethod, body string, ex
 	}
 }
 
+// slowServer returns a server that doesn't respond until the request is abandoned
+func slowServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body has to be read for the server to notice the client going away
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+}
+
 func TestRefresh(t *testing.T) {
 	var testToken = "a-test-token"
 	var expectedHeaders = map[string]string{
@@ -116,6 +135,15 @@ func TestRefresh(t *testing.T) {
 		})
 	}))
 
+	Convey("A forbidden refresh request", t, TestingServer(http.StatusForbidden, "/v2/auth/user/refresh", http.MethodGet, "", expectedHeaders, func(ts *httptest.Server) {
+		u, _ := url.Parse(ts.URL)
+		Convey("Should return ErrorForbidden", func() {
+			resp, err := Refresh(*u, testHeaders)
+			So(err, ShouldEqual, api.ErrorForbidden)
+			So(resp, ShouldBeNil)
+		})
+	}))
+
 	Convey("A refresh request to an non-responsive server", t, func() {
 		u, _ := url.Parse("http://127.0.0.1:32876")
 		Convey("Should return an error", func() {
@@ -124,6 +152,40 @@ func TestRefresh(t *testing.T) {
 			So(resp, ShouldBeNil)
 		})
 	})
+
+	Convey("A refresh request that is cancelled", t, func() {
+		ts := slowServer()
+		u, _ := url.Parse(ts.URL)
+		Convey("Should return promptly with the context's error", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			resp, err := RefreshWithContext(ctx, *u, testHeaders)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(resp, ShouldBeNil)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestRefreshWithTimeout(t *testing.T) {
+	Convey("A refresh request to a server that hangs", t, func() {
+		ts := slowServer()
+		u, _ := url.Parse(ts.URL)
+		Convey("Should give up after the timeout", func() {
+			start := time.Now()
+			resp, err := RefreshWithTimeout(50*time.Millisecond, *u, http.Header{})
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(resp, ShouldBeNil)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
 }
 
 func TestLogout(t *testing.T) {
@@ -145,7 +207,15 @@ func TestLogout(t *testing.T) {
 		u, _ := url.Parse(ts.URL)
 		Convey("Should error", func() {
 			err := Logout(*u, testHeaders)
-			So(err, ShouldNotBeNil)
+			So(err, ShouldEqual, api.ErrorUnauthorized)
+		})
+	}))
+
+	Convey("A forbidden logout request", t, TestingServer(http.StatusForbidden, "/v1/auth", http.MethodDelete, "", expectedHeaders, func(ts *httptest.Server) {
+		u, _ := url.Parse(ts.URL)
+		Convey("Should return ErrorForbidden", func() {
+			err := Logout(*u, testHeaders)
+			So(err, ShouldEqual, api.ErrorForbidden)
 		})
 	}))
 
@@ -156,4 +226,75 @@ func TestLogout(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 	})
+
+	Convey("A logout request that is cancelled", t, func() {
+		ts := slowServer()
+		u, _ := url.Parse(ts.URL)
+		Convey("Should return promptly with the context's error", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+			err := LogoutWithContext(ctx, *u, testHeaders)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A logout request that times out", t, func() {
+		ts := slowServer()
+		u, _ := url.Parse(ts.URL)
+		Convey("Should give up after the timeout", func() {
+			start := time.Now()
+			err := LogoutWithTimeout(50*time.Millisecond, *u, testHeaders)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestFailedRequestsReuseConnections(t *testing.T) {
+	Convey("A server that rejects every request with a long body", t, func() {
+		var conns, requests int32
+		statuses := []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError}
+		body := strings.Repeat("upstream is having a bad day\n", 1000)
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&requests, 1)
+			w.WriteHeader(statuses[int(n)%len(statuses)])
+			w.Write([]byte(body))
+		}))
+		ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		ts.Start()
+		Reset(ts.Close)
+		u, _ := url.Parse(ts.URL)
+		headers := http.Header{}
+		headers.Add("X-Vault-Token", "a-test-token")
+		Convey("Should reuse one connection for failed authentication", func() {
+			a, err := NewAWSAuthWithRole(ts.URL, "boba", "kamino", nil)
+			So(err, ShouldBeNil)
+			a.kmsClient = mockKMS{data: awsResponseBody}
+			for i := 0; i < 100; i++ {
+				_, err := a.GetToken(context.Background())
+				So(err, ShouldNotBeNil)
+			}
+			So(atomic.LoadInt32(&conns), ShouldEqual, 1)
+		})
+		Convey("Should reuse one connection for failed refreshes and logouts", func() {
+			for i := 0; i < 50; i++ {
+				_, err := Refresh(*u, headers)
+				So(err, ShouldNotBeNil)
+				So(Logout(*u, headers), ShouldNotBeNil)
+			}
+			So(atomic.LoadInt32(&conns), ShouldEqual, 1)
+		})
+	})
 }
@@ -0,0 +1,232 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package authtest provides a fake auth.Auth for testing code that uses Cerberus, without
+// needing AWS credentials or a real Cerberus to log in to. It is meant to be used with a
+// test server (such as one from net/http/httptest) standing in for Cerberus
+package authtest
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	"github.com/ecimionatto/cerberus-go-client/auth"
+)
+
+// FakeAuth is an auth.Auth that hands out a canned token instead of authenticating. Errors
+// can be programmed for GetToken, Refresh, and Logout, and every call to them is counted so
+// that tests can check how the auth method was used (for example, that a client refreshed
+// the token after a 401). It is safe for concurrent use
+type FakeAuth struct {
+	lock           sync.Mutex
+	baseURL        *url.URL
+	token          string
+	expiry         time.Time
+	headers        http.Header
+	refreshedToken string
+	getTokenErr    error
+	refreshErr     error
+	logoutErr      error
+	getTokenCalls  int
+	refreshCalls   int
+	logoutCalls    int
+}
+
+var _ auth.Auth = (*FakeAuth)(nil)
+
+// NewFakeAuth returns a FakeAuth for the Cerberus at the given URL (usually the URL of a test
+// server) that is authenticated with the given token, which never expires. It panics if the
+// URL can't be parsed, since that is a mistake in the test
+func NewFakeAuth(cerberusURL, token string) *FakeAuth {
+	baseURL, err := url.Parse(cerberusURL)
+	if err != nil {
+		panic("authtest: invalid Cerberus URL: " + err.Error())
+	}
+	return &FakeAuth{
+		baseURL: baseURL,
+		token:   token,
+		headers: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}
+}
+
+// SetToken replaces the token and when it expires. A zero expiry means the token never
+// expires, and an empty token means the FakeAuth is not authenticated
+func (f *FakeAuth) SetToken(token string, expiry time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.token = token
+	f.expiry = expiry
+}
+
+// SetRefreshedToken sets the token that Refresh replaces the current one with. By default
+// Refresh keeps the current token
+func (f *FakeAuth) SetRefreshedToken(token string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.refreshedToken = token
+}
+
+// SetHeaders sets the headers returned by GetHeaders, along with the token in X-Vault-Token.
+// By default the only other header is a JSON Content-Type
+func (f *FakeAuth) SetHeaders(headers http.Header) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.headers = cloneHeaders(headers)
+}
+
+// SetGetTokenError makes GetToken return err until it is set back to nil
+func (f *FakeAuth) SetGetTokenError(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.getTokenErr = err
+}
+
+// SetRefreshError makes Refresh return err (without changing the token) until it is set
+// back to nil
+func (f *FakeAuth) SetRefreshError(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.refreshErr = err
+}
+
+// SetLogoutError makes Logout return err (without clearing the token) until it is set
+// back to nil
+func (f *FakeAuth) SetLogoutError(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.logoutErr = err
+}
+
+// GetToken returns the token, or the error set with SetGetTokenError. Like the real auth
+// methods it returns the context's error if the context is already done, and it returns
+// api.ErrorUnauthenticated if there is no valid token
+func (f *FakeAuth) GetToken(ctx context.Context) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.getTokenCalls++
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if f.getTokenErr != nil {
+		return "", f.getTokenErr
+	}
+	if !f.isAuthenticated() {
+		return "", api.ErrorUnauthenticated
+	}
+	return f.token, nil
+}
+
+// IsAuthenticated returns whether there is a token that hasn't expired
+func (f *FakeAuth) IsAuthenticated() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.isAuthenticated()
+}
+
+// isAuthenticated is IsAuthenticated with the lock held
+func (f *FakeAuth) isAuthenticated() bool {
+	return f.token != "" && (f.expiry.IsZero() || time.Now().Before(f.expiry))
+}
+
+// Refresh replaces the token with the one set with SetRefreshedToken, if there is one, or
+// returns the error set with SetRefreshError. Returns api.ErrorUnauthenticated if there is
+// no valid token
+func (f *FakeAuth) Refresh() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.refreshCalls++
+	if f.refreshErr != nil {
+		return f.refreshErr
+	}
+	if !f.isAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	if f.refreshedToken != "" {
+		f.token = f.refreshedToken
+	}
+	return nil
+}
+
+// Logout clears the token, or returns the error set with SetLogoutError. Returns
+// api.ErrorUnauthenticated if there is no valid token
+func (f *FakeAuth) Logout() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.logoutCalls++
+	if f.logoutErr != nil {
+		return f.logoutErr
+	}
+	if !f.isAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	f.token = ""
+	f.expiry = time.Time{}
+	return nil
+}
+
+// GetHeaders returns a copy of the headers with the token set in X-Vault-Token. Returns
+// api.ErrorUnauthenticated if there is no valid token
+func (f *FakeAuth) GetHeaders() (http.Header, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.isAuthenticated() {
+		return nil, api.ErrorUnauthenticated
+	}
+	headers := cloneHeaders(f.headers)
+	headers.Set("X-Vault-Token", f.token)
+	return headers, nil
+}
+
+// GetURL returns the Cerberus URL the FakeAuth was created with
+func (f *FakeAuth) GetURL() *url.URL {
+	return f.baseURL
+}
+
+// GetTokenCalls returns how many times GetToken has been called
+func (f *FakeAuth) GetTokenCalls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.getTokenCalls
+}
+
+// RefreshCalls returns how many times Refresh has been called, including calls that failed
+func (f *FakeAuth) RefreshCalls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.refreshCalls
+}
+
+// LogoutCalls returns how many times Logout has been called, including calls that failed
+func (f *FakeAuth) LogoutCalls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.logoutCalls
+}
+
+// cloneHeaders returns a deep copy of the headers, which is never nil
+func cloneHeaders(headers http.Header) http.Header {
+	clone := make(http.Header, len(headers)+1)
+	for k, v := range headers {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFakeAuth(t *testing.T) {
+	Convey("A FakeAuth with a token", t, func() {
+		f := NewFakeAuth("https://test.example.com", "a-token")
+		Convey("Should hand out the token", func() {
+			So(f.IsAuthenticated(), ShouldBeTrue)
+			tok, err := f.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-token")
+			So(f.GetTokenCalls(), ShouldEqual, 1)
+			So(f.GetURL().Host, ShouldEqual, "test.example.com")
+		})
+		Convey("Should return headers with the token", func() {
+			f.SetHeaders(http.Header{"X-Cerberus-Client": []string{"a-client"}})
+			headers, err := f.GetHeaders()
+			So(err, ShouldBeNil)
+			So(headers.Get("X-Vault-Token"), ShouldEqual, "a-token")
+			So(headers.Get("X-Cerberus-Client"), ShouldEqual, "a-client")
+			Convey("Which are a copy", func() {
+				headers.Set("X-Vault-Token", "changed")
+				again, _ := f.GetHeaders()
+				So(again.Get("X-Vault-Token"), ShouldEqual, "a-token")
+			})
+		})
+		Convey("Should replace the token when refreshed", func() {
+			f.SetRefreshedToken("a-new-token")
+			So(f.Refresh(), ShouldBeNil)
+			tok, _ := f.GetToken(context.Background())
+			So(tok, ShouldEqual, "a-new-token")
+			So(f.RefreshCalls(), ShouldEqual, 1)
+		})
+		Convey("Should clear the token when logged out", func() {
+			So(f.Logout(), ShouldBeNil)
+			So(f.LogoutCalls(), ShouldEqual, 1)
+			So(f.IsAuthenticated(), ShouldBeFalse)
+			_, err := f.GetToken(context.Background())
+			So(err, ShouldEqual, api.ErrorUnauthenticated)
+			_, err = f.GetHeaders()
+			So(err, ShouldEqual, api.ErrorUnauthenticated)
+			So(f.Refresh(), ShouldEqual, api.ErrorUnauthenticated)
+			So(f.Logout(), ShouldEqual, api.ErrorUnauthenticated)
+			So(f.RefreshCalls(), ShouldEqual, 1)
+			So(f.LogoutCalls(), ShouldEqual, 2)
+		})
+		Convey("Should return the context's error", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := f.GetToken(ctx)
+			So(err, ShouldEqual, context.Canceled)
+		})
+	})
+
+	Convey("A FakeAuth with programmed errors", t, func() {
+		f := NewFakeAuth("https://test.example.com", "a-token")
+		boom := fmt.Errorf("boom")
+		f.SetGetTokenError(boom)
+		f.SetRefreshError(boom)
+		f.SetLogoutError(boom)
+		Convey("Should return them and count the calls", func() {
+			_, err := f.GetToken(context.Background())
+			So(err, ShouldEqual, boom)
+			So(f.Refresh(), ShouldEqual, boom)
+			So(f.Logout(), ShouldEqual, boom)
+			So(f.GetTokenCalls(), ShouldEqual, 1)
+			So(f.RefreshCalls(), ShouldEqual, 1)
+			So(f.LogoutCalls(), ShouldEqual, 1)
+			Convey("And keep the token", func() {
+				So(f.IsAuthenticated(), ShouldBeTrue)
+			})
+		})
+		Convey("Should stop returning them once cleared", func() {
+			f.SetGetTokenError(nil)
+			_, err := f.GetToken(context.Background())
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("A FakeAuth with an expired token", t, func() {
+		f := NewFakeAuth("https://test.example.com", "")
+		f.SetToken("a-token", time.Now().Add(-time.Minute))
+		Convey("Should not be authenticated", func() {
+			So(f.IsAuthenticated(), ShouldBeFalse)
+			_, err := f.GetToken(context.Background())
+			So(err, ShouldEqual, api.ErrorUnauthenticated)
+		})
+	})
+
+	Convey("An invalid URL", t, func() {
+		So(func() { NewFakeAuth("https://test.%example.com", "a-token") }, ShouldPanic)
+	})
+}
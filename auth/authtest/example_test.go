@@ -0,0 +1,57 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authtest_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/ecimionatto/cerberus-go-client/auth/authtest"
+	"github.com/ecimionatto/cerberus-go-client/cerberus"
+)
+
+// A client that gets a new token when Cerberus rejects the one it has, tested without
+// logging in to a real Cerberus
+func ExampleFakeAuth() {
+	// A stand in for Cerberus that only accepts the refreshed token
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "a-new-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data": {"password": "hunter2"}}`)
+	}))
+	defer ts.Close()
+
+	fake := authtest.NewFakeAuth(ts.URL, "an-expired-token")
+	fake.SetRefreshedToken("a-new-token")
+	client, err := cerberus.NewClient(fake, cerberus.WithReauthOnUnauthorized())
+	if err != nil {
+		panic(err)
+	}
+	secret, err := client.Secret().Read("app/my-sdb/config")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(secret.Data["password"])
+	fmt.Println("refreshes:", fake.RefreshCalls())
+	// Output:
+	// hunter2
+	// refreshes: 1
+}
@@ -18,34 +18,106 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/ecimionatto/cerberus-go-client/api"
-	"github.com/ecimionatto/cerberus-go-client/utils"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
-    "github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"strings"
+	"github.com/ecimionatto/cerberus-go-client/api"
+	"github.com/ecimionatto/cerberus-go-client/utils"
 )
 
-// AWSAuth uses AWS roles and authentication to authenticate to Cerberus
+// AWSAuth uses AWS roles and authentication to authenticate to Cerberus. It is safe for
+// concurrent use, and only one goroutine authenticates at a time
 type AWSAuth struct {
-	token     string
+	// lock is held for writing while authenticating or logging out, so that concurrent
+	// callers wait for the new token instead of all authenticating at once
+	lock      sync.RWMutex
 	region    string
 	roleARN   string
-	expiry    time.Time
 	baseURL   *url.URL
 	headers   http.Header
 	kmsClient kmsiface.KMSAPI
+	// creds are the credentials for the assumed role that the KMS client signs requests with
+	creds *credentials.Credentials
+	// defaultLease is used for tokens that come with no lease duration
+	defaultLease time.Duration
+	renewable    bool
+	// persisted is where renewable tokens are saved to be reused by a later AWSAuth, if anywhere
+	persisted *FileTokenStore
+	tokenHolder
+	retrier
+	refreshNotifier
+	autoRefresher
+}
+
+// credentialsExpiryWindow is how long before they expire the credentials for the assumed role
+// are treated as expired, so that they are never used for a request that outlives them
+const credentialsExpiryWindow = 5 * time.Minute
+
+// ErrorNoLeaseDuration is returned when Cerberus gives AWSAuth a token with no lease duration
+// and SetDefaultLeaseDuration has been set to 0
+var ErrorNoLeaseDuration = fmt.Errorf("Cerberus returned a token with no lease duration")
+
+// KMSDecryptError is returned when the token sent by Cerberus can't be decrypted with KMS,
+// which is usually caused by the KMS key policy, a region mismatch, or expired credentials.
+// Code and RequestID are set when the error came from AWS, and are the values to look for
+// when troubleshooting with CloudTrail or AWS support. Use errors.As to get at the details
+type KMSDecryptError struct {
+	// Err is the error returned by KMS, which is an awserr.Error if it came from AWS
+	Err error
+	// Code is the AWS error code, such as AccessDeniedException or InvalidCiphertextException
+	Code string
+	// RequestID is the ID of the KMS request
+	RequestID string
+	// CiphertextLength is the size in bytes of the data that was sent to be decrypted
+	CiphertextLength int
+}
+
+// newKMSDecryptError returns a KMSDecryptError for the given failure, pulling out the
+// details of AWS errors
+func newKMSDecryptError(err error, ciphertextLength int) *KMSDecryptError {
+	e := &KMSDecryptError{Err: err, CiphertextLength: ciphertextLength}
+	if aerr, ok := err.(awserr.Error); ok {
+		e.Code = aerr.Code()
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		e.RequestID = reqErr.RequestID()
+	}
+	return e
+}
+
+func (e *KMSDecryptError) Error() string {
+	cause := e.Err.Error()
+	// The message of an AWS error spans several lines, so it is put back together on one
+	if aerr, ok := e.Err.(awserr.Error); ok {
+		cause = fmt.Sprintf("%s: %s", aerr.Code(), aerr.Message())
+	}
+	msg := fmt.Sprintf("Error while decrypting response: %s", cause)
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request ID %s)", e.RequestID)
+	}
+	return msg + fmt.Sprintf(". Ciphertext was %d bytes", e.CiphertextLength)
+}
+
+// Unwrap returns the error returned by KMS
+func (e *KMSDecryptError) Unwrap() error {
+	return e.Err
 }
 
 type awsAuthBody struct {
@@ -57,97 +129,337 @@ type iamIntermediateResp struct {
 	AuthData string `json:"auth_data"`
 }
 
-// NewAWSAuth returns an AWSAuth given a valid URL, ARN, and region. If the CERBERUS_URL
+// AWSAuthOption is a functional option for configuring an AWSAuth. Options can be passed to
+// any of the AWSAuth constructors and are applied in order
+type AWSAuthOption func(*AWSAuth)
+
+// WithTimeout sets how long each request to Cerberus is given to finish, the same as
+// calling SetTimeout. Defaults to DefaultTimeout
+func WithTimeout(d time.Duration) AWSAuthOption {
+	return func(a *AWSAuth) {
+		a.SetTimeout(d)
+	}
+}
+
+// NewAWSAuth returns an AWSAuth given a valid URL and region. If the CERBERUS_URL
 // environment variable is set, it will be used over anything passed to this function.
 // It also expects you to have valid AWS credentials configured either by environment
-// variable or through a credentials config file
-func NewAWSAuth(cerberusURL, region string) (*AWSAuth, error) {
-	fmt.Printf("NEW AUTH")
+// variable or through a credentials config file. The IAM role to authenticate as is
+// looked up from the EC2 instance profile, so this only works on EC2. Use
+// NewAWSAuthWithRole anywhere else. If region is empty, the region of the instance is
+// looked up from EC2 metadata as well
+func NewAWSAuth(cerberusURL, region string, opts ...AWSAuthOption) (*AWSAuth, error) {
+	parsedURL, err := parseAWSAuthURL(cerberusURL)
+	if err != nil {
+		return nil, err
+	}
+	return newAWSAuthInRegion(parsedURL, region, opts...)
+}
 
-	// Check for the environment variable if the user has set it
-	if os.Getenv("CERBERUS_URL") != "" {
-		cerberusURL = os.Getenv("CERBERUS_URL")
+// newAWSAuthInRegion is NewAWSAuth with a URL that has already been parsed
+func newAWSAuthInRegion(parsedURL *url.URL, region string, opts ...AWSAuthOption) (*AWSAuth, error) {
+	config := &aws.Config{}
+	if len(region) != 0 {
+		config.Region = aws.String(region)
 	}
-	if len(region) == 0 {
-		return nil, fmt.Errorf("Region should not be nil")
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create AWS session: %s", err)
 	}
-	if len(cerberusURL) == 0 {
-		return nil, fmt.Errorf("Cerberus URL cannot be empty")
+	return newAWSAuthWithSession(parsedURL, region, sess, opts...)
+}
+
+// NewAWSAuthWithStore is the same as NewAWSAuth, but also saves each renewable token it gets
+// to store and picks up a token that is still valid from there instead of authenticating
+// again. A saved token is only used if it was issued for the same Cerberus URL and IAM role,
+// so a store shared by several programs never sends a token to the wrong server.
+// Non-renewable tokens are never saved. An error is returned if the store can't be read
+func NewAWSAuthWithStore(cerberusURL, region string, store *FileTokenStore, opts ...AWSAuthOption) (*AWSAuth, error) {
+	if store == nil {
+		return nil, fmt.Errorf("Token store cannot be nil")
 	}
-	parsedURL, err := utils.ValidateURL(cerberusURL)
+	a, err := NewAWSAuth(cerberusURL, region, opts...)
 	if err != nil {
 		return nil, err
 	}
-	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
-	svc := ec2metadata.New(sess)
-	ec2IAMInfo, e := svc.IAMInfo()
-	if e != nil {
-		return nil, e
+	if err := a.usePersistedStore(store); err != nil {
+		return nil, err
 	}
+	return a, nil
+}
+
+// usePersistedStore makes store where tokens are saved, and loads the token saved there if it
+// was issued for this URL and role and hasn't expired. Only renewable tokens are ever saved, so
+// a loaded token is renewable too
+func (a *AWSAuth) usePersistedStore(store *FileTokenStore) error {
+	saved, err := store.load()
+	if err != nil {
+		return fmt.Errorf("Error while loading saved token: %v", err)
+	}
+	a.persisted = store
+	if saved.Token == "" {
+		return nil
+	}
+	if saved.URL != a.baseURL.String() || saved.RoleARN != a.roleARN {
+		a.debugf("Saved token was issued for a different Cerberus URL or IAM role, it will not be used")
+		return nil
+	}
+	if !time.Now().Before(saved.Expiry) {
+		a.debugf("Saved token expired at %s, it will not be used", saved.Expiry.Format(time.RFC3339))
+		return store.Clear()
+	}
+	if err := a.store.Store(saved.Token, saved.Expiry); err != nil {
+		return err
+	}
+	a.setPolicies(saved.Policies)
+	a.renewable = true
+	a.debugf("Loaded saved token, it expires at %s", saved.Expiry.Format(time.RFC3339))
+	return nil
+}
 
+// persistToken saves the token to the persisted store, along with the URL and role it was
+// issued for and its policies, if there is a store. A token that isn't renewable is not
+// saved, and any token saved before is removed instead so that it isn't picked up later in
+// place of the new one
+func (a *AWSAuth) persistToken(token string, expiry time.Time, renewable bool, policies []string) error {
+	if a.persisted == nil {
+		return nil
+	}
+	if !renewable {
+		return a.persisted.Clear()
+	}
+	return a.persisted.save(fileToken{
+		Token:    token,
+		Expiry:   expiry,
+		URL:      a.baseURL.String(),
+		RoleARN:  a.roleARN,
+		Policies: policies,
+	})
+}
+
+// clearPersistedToken removes the token from the persisted store, if there is one
+func (a *AWSAuth) clearPersistedToken() error {
+	if a.persisted == nil {
+		return nil
+	}
+	return a.persisted.Clear()
+}
+
+// NewAWSAuthWithSession is the same as NewAWSAuth, but uses the given AWS session (and whatever
+// credentials it was set up with) instead of creating one. The IAM role to authenticate as is
+// still looked up from the EC2 instance profile. If region is empty, the session's region is
+// used, and if the session doesn't have one either it is looked up from EC2 metadata
+func NewAWSAuthWithSession(cerberusURL, region string, sess *session.Session, opts ...AWSAuthOption) (*AWSAuth, error) {
+	parsedURL, err := parseAWSAuthURL(cerberusURL)
+	if err != nil {
+		return nil, err
+	}
+	return newAWSAuthWithSession(parsedURL, region, sess, opts...)
+}
+
+// newAWSAuthWithSession is NewAWSAuthWithSession with a URL that has already been parsed
+func newAWSAuthWithSession(parsedURL *url.URL, region string, sess *session.Session, opts ...AWSAuthOption) (*AWSAuth, error) {
+	var err error
+	if sess == nil {
+		return nil, fmt.Errorf("AWS session cannot be nil")
+	}
+	svc := ec2metadata.New(sess)
+	if len(region) == 0 {
+		region = aws.StringValue(sess.Config.Region)
+	}
+	if len(region) == 0 {
+		if region, err = svc.Region(); err != nil {
+			return nil, fmt.Errorf("No region was given and it could not be looked up from EC2 metadata: %s", err)
+		}
+	}
+	ec2IAMInfo, err := svc.IAMInfo()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up the IAM role from EC2 metadata: %s", err)
+	}
 	iamRole := strings.Replace(ec2IAMInfo.InstanceProfileArn, ":instance-profile/", ":role/", 1)
-	creds := stscreds.NewCredentials(sess, iamRole)
 
-	fmt.Printf("SEESION DEFAULT CREDENTIAL PROVIDER")
+	return newAWSAuth(parsedURL, iamRole, region, sess, opts...), nil
+}
 
+// NewAWSAuthWithRole returns an AWSAuth that authenticates as the given IAM role, which is
+// assumed with the credentials from the AWS session to decrypt the token from Cerberus. No
+// EC2 metadata lookup is done, so this works anywhere with AWS credentials, such as local
+// development, Fargate, or Lambda. If sess is nil, a new session is created for the region
+// using the default credential chain
+func NewAWSAuthWithRole(cerberusURL, roleARN, region string, sess *session.Session, opts ...AWSAuthOption) (*AWSAuth, error) {
+	if len(roleARN) == 0 {
+		return nil, fmt.Errorf("Role ARN cannot be empty")
+	}
+	if len(region) == 0 {
+		return nil, fmt.Errorf("Region should not be nil")
+	}
+	parsedURL, err := parseAWSAuthURL(cerberusURL)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to create AWS session: %s", err)
+		return nil, err
+	}
+	if sess == nil {
+		if sess, err = session.NewSession(&aws.Config{Region: aws.String(region)}); err != nil {
+			return nil, fmt.Errorf("Unable to create AWS session: %s", err)
+		}
 	}
-	return &AWSAuth{
+	return newAWSAuth(parsedURL, roleARN, region, sess, opts...), nil
+}
+
+// parseAWSAuthURL returns the Cerberus URL for the AWSAuth constructors to use, which is taken
+// from CERBERUS_URL if it is set
+func parseAWSAuthURL(cerberusURL string) (*url.URL, error) {
+	// Check for the environment variable if the user has set it
+	if os.Getenv("CERBERUS_URL") != "" {
+		cerberusURL = os.Getenv("CERBERUS_URL")
+	}
+	if len(cerberusURL) == 0 {
+		return nil, fmt.Errorf("Cerberus URL cannot be empty")
+	}
+	return utils.ValidateURL(cerberusURL)
+}
+
+// newAWSAuth returns an AWSAuth that decrypts tokens with KMS as the given role, with the
+// options applied
+func newAWSAuth(baseURL *url.URL, roleARN, region string, sess *session.Session, opts ...AWSAuthOption) *AWSAuth {
+	creds := stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.ExpiryWindow = credentialsExpiryWindow
+	})
+	a := &AWSAuth{
 		region:  region,
-		roleARN: iamRole,
-		baseURL: parsedURL,
+		roleARN: roleARN,
+		baseURL: baseURL,
 		headers: http.Header{
 			"X-Cerberus-Client": []string{api.ClientHeader},
 			"Content-Type":      []string{"application/json"},
 		},
-		kmsClient: kms.New(sess, &aws.Config{Credentials: creds}),
-	}, nil
+		kmsClient:    kms.New(sess, &aws.Config{Credentials: creds, Region: aws.String(region)}),
+		creds:        creds,
+		defaultLease: DefaultLeaseDuration,
+		tokenHolder:  newTokenHolder(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// SetDefaultLeaseDuration sets how long a token is treated as valid when Cerberus returns it
+// with a lease_duration of 0 (or none at all). Defaults to DefaultLeaseDuration. Setting it to
+// 0 makes authenticating fail with ErrorNoLeaseDuration instead
+func (a *AWSAuth) SetDefaultLeaseDuration(d time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.defaultLease = d
+}
+
+// Renewable returns whether Cerberus said the current token can be renewed. Refresh always
+// reauthenticates rather than renewing, so this is only informational
+func (a *AWSAuth) Renewable() bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.renewable
 }
 
 // GetURL returns the configured Cerberus URL
 func (a *AWSAuth) GetURL() *url.URL {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
 	return a.baseURL
 }
 
+// SetBasePath sets the path that every endpoint is prefixed with when Cerberus is behind a
+// reverse proxy (such as /cerberus), replacing any path the URL was given with. It should be
+// called before authenticating
+func (a *AWSAuth) SetBasePath(basePath string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.baseURL = withBasePath(a.baseURL, basePath)
+}
+
 // GetToken returns a token if it already exists and is not expired. Otherwise,
 // it authenticates using the provided ARN and region and then returns the token.
-// If there are any errors during authentication,
-func (a *AWSAuth) GetToken(f *os.File) (string, error) {
-	if a.IsAuthenticated() {
-		return a.token, nil
+// If there are any errors during authentication, they are returned. Authenticating
+// gives up if the context is cancelled or its deadline passes
+func (a *AWSAuth) GetToken(ctx context.Context) (string, error) {
+	if err := a.checkDraining(); err != nil {
+		return "", err
 	}
-	err := a.authenticate()
-	return a.token, err
+	a.lock.RLock()
+	if a.isAuthenticated() {
+		defer a.lock.RUnlock()
+		token, _, err := a.loadToken()
+		return token, err
+	}
+	a.lock.RUnlock()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// Another goroutine may have authenticated while this one was waiting for the lock
+	if !a.isAuthenticated() {
+		if err := a.authenticate(ctx); err != nil {
+			return "", err
+		}
+	}
+	token, _, err := a.loadToken()
+	return token, err
+}
+
+// reauthenticate is authenticate with the write lock held
+func (a *AWSAuth) reauthenticate(ctx context.Context) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.authenticate(ctx)
 }
 
-func (a *AWSAuth) authenticate() error {
+// authenticate logs in with the IAM principal and counts whether that worked
+func (a *AWSAuth) authenticate(ctx context.Context) error {
+	ctx, span := a.startSpan(ctx, spanAuthenticate, utils.AttributeRegion.String(a.region))
+	return span.End(a.recordAuth(a.login(ctx)))
+}
+
+func (a *AWSAuth) login(ctx context.Context) error {
 	// Make a copy of the base URL
 	builtURL := *a.baseURL
-	builtURL.Path = "/v2/auth/iam-principal"
+	endpoint := a.endpoints.awsLogin
+	if endpoint == "" {
+		return ErrorEndpointUnsupported
+	}
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
 	// Encode the body to send in the request if one was given
 	body := &bytes.Buffer{}
 	err := json.NewEncoder(body).Encode(awsAuthBody{
+		PrincipalArn: a.roleARN,
 		Region:       a.region,
 	})
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", builtURL.String(), body)
-	if err != nil {
-		return fmt.Errorf("Problem while performing request to Cerberus: %v", err)
-	}
-	req.Header = a.headers
-	cl := http.Client{}
-
-	resp, err := cl.Do(req)
+	a.debugf("Authenticating to %s as %s in %s", utils.RedactURL(&builtURL), a.roleARN, a.region)
+	start := time.Now()
+	resp, err := a.do(ctx, a.httpClient(a.requestTimeout()), a.logger(), func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", builtURL.String(), bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+		}
+		req.Header = a.headers
+		return req, nil
+	})
+	observeRequest(ctx, a.metricsRecorder(), endpoint, resp, start)
 	if err != nil {
-		return fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+		return requestError(ctx, err)
 	}
-	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+	defer closeBody(resp)
+	a.debugf("Cerberus responded to authentication with HTTP %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusUnauthorized {
 		return api.ErrorUnauthorized
 	}
+	if resp.StatusCode == http.StatusForbidden {
+		return api.ErrorForbidden
+	}
 	if resp.StatusCode != http.StatusOK {
+		if apiErr := api.ParseCerberusError(resp.StatusCode, resp.Body); apiErr != nil {
+			return apiErr
+		}
 		return fmt.Errorf("Error while trying to authenticate. Got HTTP response code %d", resp.StatusCode)
 	}
 
@@ -157,8 +469,11 @@ func (a *AWSAuth) authenticate() error {
 	decoder := json.NewDecoder(resp.Body)
 	intermediate := &iamIntermediateResp{}
 	dErr := decoder.Decode(intermediate)
+	if dErr == io.EOF {
+		return api.ErrorEmptyResponse
+	}
 	if dErr != nil {
-		return fmt.Errorf("Error while trying to parse response from Cerberus: %v", err)
+		return fmt.Errorf("Error while trying to parse response from Cerberus: %v", dErr)
 	}
 
 	// Decode the binary data from base64
@@ -169,29 +484,140 @@ func (a *AWSAuth) authenticate() error {
 	input := &kms.DecryptInput{
 		CiphertextBlob: binaryData,
 	}
-	result, err := a.kmsClient.Decrypt(input)
+	a.debugf("Decrypting %d bytes of authentication data with KMS", len(binaryData))
+	result, err := a.kmsClient.DecryptWithContext(ctx, input)
+	if isExpiredCredentials(err) && ctx.Err() == nil {
+		// The KMS client gets new credentials on its own once they are within the expiry
+		// window of expiring, but they can still be rejected early (e.g. if the clock is
+		// off or the role session was revoked), so get new ones and try once more
+		a.debugf("KMS rejected the AWS credentials as expired, refreshing them")
+		if err := a.refreshCredentials(); err != nil {
+			return err
+		}
+		result, err = a.kmsClient.DecryptWithContext(ctx, input)
+	}
 	if err != nil {
-		return fmt.Errorf("Error while decrypting response: %s", err)
+		if ctx.Err() != nil {
+			return fmt.Errorf("Decrypting the response was abandoned: %w", ctx.Err())
+		}
+		return newKMSDecryptError(err, len(binaryData))
 	}
-	r := &api.IAMAuthResponse{}
-	parseErr := json.Unmarshal(result.Plaintext, r)
+	r, parseErr := parseIAMAuthResponse(result.Plaintext)
 	if parseErr != nil {
-		return fmt.Errorf("Error while parsing decrypted response: %s", parseErr)
+		return parseErr
+	}
+	if r.Duration <= 0 {
+		if a.defaultLease <= 0 {
+			return ErrorNoLeaseDuration
+		}
+		a.debugf("Cerberus returned no lease duration, using %v", a.defaultLease)
+	}
+	expiry := leaseExpiry(r.Duration, a.defaultLease)
+	if err := a.store.Store(r.Token, expiry); err != nil {
+		return err
+	}
+	a.setPolicies(r.Policies)
+	a.setAWSMetadata(r.Metadata)
+	a.renewable = r.Renewable
+	// The token is already usable, so failing to save it for later isn't worth failing over
+	if err := a.persistToken(r.Token, expiry, r.Renewable, r.Policies); err != nil {
+		a.debugf("Error while saving token: %v", err)
+	}
+	a.debugf("Authenticated with Cerberus, token expires at %s", expiry.Format(time.RFC3339))
+	a.notify(expiry)
+	return nil
+}
+
+// RefreshCredentials gets new AWS credentials for the assumed role right away, instead of
+// waiting for the current ones to expire. Credentials are refreshed automatically shortly
+// before they expire and when KMS rejects them as expired, so this is only needed to pick up
+// changes (such as to the role's policies) sooner
+func (a *AWSAuth) RefreshCredentials() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.refreshCredentials()
+}
+
+// refreshCredentials throws away the current AWS credentials and retrieves new ones
+func (a *AWSAuth) refreshCredentials() error {
+	a.creds.Expire()
+	if _, err := a.creds.Get(); err != nil {
+		return fmt.Errorf("Error while refreshing AWS credentials: %v", err)
 	}
-	a.token = r.Token
-	// Set the auth header up to make things easier
-	a.headers.Set("X-Vault-Token", r.Token)
-	a.expiry = time.Now().Add(time.Duration(r.Duration) * time.Second)
 	return nil
 }
 
+// isExpiredCredentials returns whether the error is AWS rejecting a request because the
+// credentials it was signed with have expired
+func isExpiredCredentials(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "ExpiredToken", "ExpiredTokenException", "RequestExpired":
+		return true
+	}
+	return false
+}
+
+// parseIAMAuthResponse parses the decrypted auth data from Cerberus. Depending on the version of
+// Cerberus, the token and its details are either at the top level (the "flat" shape) or in an
+// object under client_token (the "nested" shape), as in:
+//
+//	{"client_token": "a-token", "lease_duration": 3600, ...}
+//	{"client_token": {"client_token": "a-token", "lease_duration": 3600, ...}}
+//
+// The shape is picked based on whether client_token is a string or an object
+func parseIAMAuthResponse(plaintext []byte) (*api.IAMAuthResponse, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(plaintext, &fields); err != nil {
+		return nil, fmt.Errorf("Error while parsing decrypted response: %s", err)
+	}
+	raw := bytes.TrimSpace(fields["client_token"])
+	if len(raw) > 0 && raw[0] == '{' {
+		// The nested shape, so the real response is the inner object
+		plaintext = raw
+	} else if len(raw) == 0 || raw[0] != '"' {
+		return nil, fmt.Errorf("Error while parsing decrypted response: no client_token was found in either the flat or nested format")
+	}
+	r := &api.IAMAuthResponse{}
+	if err := json.Unmarshal(plaintext, r); err != nil {
+		return nil, fmt.Errorf("Error while parsing decrypted response: %s", err)
+	}
+	if r.Token == "" {
+		return nil, fmt.Errorf("Error while parsing decrypted response: client_token was empty")
+	}
+	return r, nil
+}
+
+// Reauthenticate logs in again with the IAM principal, replacing the current token
+func (a *AWSAuth) Reauthenticate() error {
+	return a.reauthenticate(context.Background())
+}
+
 // IsAuthenticated returns whether or not the current token is set and is not expired
 func (a *AWSAuth) IsAuthenticated() bool {
-	return len(a.token) > 0 && time.Now().Before(a.expiry)
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.isAuthenticated()
+}
+
+// isAuthenticated is IsAuthenticated for callers that already hold the lock
+func (a *AWSAuth) isAuthenticated() bool {
+	token, expiry, err := a.loadToken()
+	return err == nil && len(token) > 0 && time.Now().Before(expiry)
+}
+
+// hasToken returns whether there is a token at all, even an expired one. It must be called with
+// the lock held
+func (a *AWSAuth) hasToken() bool {
+	token, _, err := a.loadToken()
+	return err == nil && len(token) > 0
 }
 
 // Refresh refreshes the current token. For AWS Auth, this is just an alias to
-// reauthenticate against the API.
+// reauthenticate against the API, whether or not the token is renewable.
 func (a *AWSAuth) Refresh() error {
 	//if !a.IsAuthenticated() {
 	//	return api.ErrorUnauthenticated
@@ -204,30 +630,101 @@ func (a *AWSAuth) Refresh() error {
 	// operations. This is less than ideal but better than having an arbitary
 	// bound on the number of refreshes and having to track how many have been
 	// done.
-	return a.authenticate()
+	return a.RefreshContext(context.Background())
+}
+
+// RefreshContext is the same as Refresh, but gives up if the context is cancelled or its
+// deadline passes
+func (a *AWSAuth) RefreshContext(ctx context.Context) error {
+	a.incAuth(AuthResultRefresh)
+	ctx, span := a.startSpan(ctx, spanRefresh, utils.AttributeRegion.String(a.region))
+	return span.End(a.reauthenticate(ctx))
+}
+
+// StartAutoRefresh starts a goroutine that reauthenticates leeway before the current token
+// expires (or right away if there is no token yet), so that GetToken and GetHeaders always
+// have a valid token to hand out. A failed refresh is sent on the returned channel and tried
+// again 10 seconds later. Errors are buffered, and if they aren't read new ones are dropped.
+// The goroutine exits and the channel is closed when the context is done or StopAutoRefresh
+// is called. It returns ErrorAutoRefreshRunning if auto refresh is already running
+func (a *AWSAuth) StartAutoRefresh(ctx context.Context, leeway time.Duration) (<-chan error, error) {
+	return a.start(ctx, leeway, func() time.Time {
+		a.lock.RLock()
+		defer a.lock.RUnlock()
+		_, expiry, _ := a.loadToken()
+		return expiry
+	}, a.RefreshContext)
 }
 
-// Logout deauthorizes the current valid token. This will return an error if the token
-// is expired or non-existent
+// Logout deauthorizes the current token, even if it looks expired. This will return an
+// error if there is no token
 func (a *AWSAuth) Logout() error {
-	//if !a.IsAuthenticated() {
-	//	return api.ErrorUnauthenticated
-	//}
+	a.lock.RLock()
+	hasToken := a.hasToken()
+	a.lock.RUnlock()
+	if !hasToken {
+		return api.ErrorUnauthenticated
+	}
+	return a.LogoutContext(context.Background())
+}
+
+// LogoutContext is the same as Logout, but gives up on the request if the context is
+// cancelled or its deadline passes. Unlike LogoutWithTimeout, the token is only cleared
+// if it was revoked
+func (a *AWSAuth) LogoutContext(ctx context.Context) error {
+	finish := a.beginLogout()
+	defer finish()
+	// The lock is only taken once draining is over, so that GetHeaders can keep refusing
+	// new requests while the old ones finish
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	headers, err := a.withToken(a.headers)
+	if err != nil {
+		return err
+	}
 	// Use a copy of the base URL
-	if err := Logout(*a.baseURL, a.headers); err != nil {
+	if err := a.revoke(ctx, a.httpClient(a.requestTimeout()), *a.baseURL, headers); err != nil {
 		return err
 	}
-	// Reset the token and header
-	a.token = ""
-	a.headers.Del("X-Vault-Token")
-	return nil
+	if err := a.clearPersistedToken(); err != nil {
+		return err
+	}
+	return a.clearToken()
+}
+
+// Lookup asks Cerberus to describe the current token, which is a cheap way to check it is still valid
+func (a *AWSAuth) Lookup() (*api.UserClientToken, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.lookupToken(*a.baseURL, a.headers)
+}
+
+// LogoutWithTimeout revokes the current token like Logout, but gives up if Cerberus
+// doesn't respond within the timeout. The token is cleared locally either way, which
+// makes this suitable for calling during shutdown
+func (a *AWSAuth) LogoutWithTimeout(d time.Duration) error {
+	// The lock isn't held while logging out, since that waits for requests in flight that
+	// need it to get their headers
+	a.lock.RLock()
+	baseURL, headers := *a.baseURL, a.headers.Clone()
+	a.lock.RUnlock()
+	logoutErr := a.logoutWithTimeout(d, baseURL, headers)
+	if err := a.clearPersistedToken(); err != nil && logoutErr == nil {
+		return err
+	}
+	return logoutErr
 }
 
 // GetHeaders returns the headers needed to authenticate against Cerberus. This will
-// return an error if the token is expired or non-existent
+// return an error if there is no token. A token that looks expired is still returned, since
+// Cerberus may still accept it and a 401 lets the client reauthenticate, which it couldn't
+// if the request was never sent. A new copy of the headers is returned every time, so
+// changing them doesn't affect the AWSAuth
 func (a *AWSAuth) GetHeaders() (http.Header, error) {
-	//if !a.IsAuthenticated() {
-	//	return nil, api.ErrorUnauthenticated
-	//}
-	return a.headers, nil
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if !a.hasToken() {
+		return nil, api.ErrorUnauthenticated
+	}
+	return a.requestHeaders(a.headers)
 }
@@ -17,18 +17,34 @@ limitations under the License.
 package auth
 
 import (
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
+	"github.com/ecimionatto/cerberus-go-client/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 	. "github.com/smartystreets/goconvey/convey"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var fakeData = base64.StdEncoding.EncodeToString([]byte("This is a random string"))
@@ -57,9 +73,14 @@ type mockKMS struct {
 	kmsiface.KMSAPI
 	data        string
 	shouldError bool
+	// err is returned instead of the generic error if set
+	err error
 }
 
 func (m mockKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
 	if m.shouldError {
 		return nil, fmt.Errorf("Your decryption errored")
 	}
@@ -68,9 +89,16 @@ func (m mockKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
 	}, nil
 }
 
+func (m mockKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Decrypt(input)
+}
+
 func TestNewAWSAuth(t *testing.T) {
 	Convey("A valid URL, arn, and region", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "darth-vader", "death-star")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "darth-vader", "death-star", nil)
 		Convey("Should return a valid AWSAuth", func() {
 			So(err, ShouldBeNil)
 			So(a, ShouldNotBeNil)
@@ -79,7 +107,7 @@ func TestNewAWSAuth(t *testing.T) {
 
 	Convey("Cerberus URL set by environment variable", t, func() {
 		os.Setenv("CERBERUS_URL", "https://test.example.com")
-		a, err := NewAWSAuth("https://test.example.com", "palpatine", "endor")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "palpatine", "endor", nil)
 		Convey("Should return a valid AWSAuth", func() {
 			So(err, ShouldBeNil)
 			So(a, ShouldNotBeNil)
@@ -93,7 +121,7 @@ func TestNewAWSAuth(t *testing.T) {
 	})
 
 	Convey("An empty URL", t, func() {
-		a, err := NewAWSAuth("", "admiral-piett", "star-destroyer")
+		a, err := NewAWSAuthWithRole("", "admiral-piett", "star-destroyer", nil)
 		Convey("Should error", func() {
 			So(err, ShouldNotBeNil)
 			So(a, ShouldBeNil)
@@ -101,7 +129,7 @@ func TestNewAWSAuth(t *testing.T) {
 	})
 
 	Convey("An empty ARN", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "", "tydirium")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "", "tydirium", nil)
 		Convey("Should error", func() {
 			So(err, ShouldNotBeNil)
 			So(a, ShouldBeNil)
@@ -109,7 +137,7 @@ func TestNewAWSAuth(t *testing.T) {
 	})
 
 	Convey("An empty region", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "tie-interceptor", "")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "tie-interceptor", "", nil)
 		Convey("Should error", func() {
 			So(err, ShouldNotBeNil)
 			So(a, ShouldBeNil)
@@ -117,19 +145,256 @@ func TestNewAWSAuth(t *testing.T) {
 	})
 
 	Convey("An invalid URL", t, func() {
-		a, err := NewAWSAuth("https://test.example.com/a/path", "tie-bomber", "at-st")
+		a, err := NewAWSAuthWithRole("https://test.example.com?a=query", "tie-bomber", "at-st", nil)
 		Convey("Should error", func() {
 			So(err, ShouldNotBeNil)
 			So(a, ShouldBeNil)
 		})
 	})
+
+	Convey("A session with static credentials", t, func() {
+		sess, err := session.NewSession(&aws.Config{
+			Region:      aws.String("hoth"),
+			Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		})
+		So(err, ShouldBeNil)
+		Convey("Should be used by NewAWSAuthWithRole", func() {
+			a, err := NewAWSAuthWithRole("https://test.example.com", "tauntaun", "hoth", sess)
+			So(err, ShouldBeNil)
+			So(a, ShouldNotBeNil)
+			So(a.roleARN, ShouldEqual, "tauntaun")
+		})
+		Convey("Should be required by NewAWSAuthWithSession", func() {
+			a, err := NewAWSAuthWithSession("https://test.example.com", "hoth", nil)
+			So(err, ShouldNotBeNil)
+			So(a, ShouldBeNil)
+		})
+	})
+}
+
+// metadataServer returns a fake EC2 metadata service for an instance in us-west-2a
+func metadataServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/meta-data/placement/availability-zone":
+			w.Write([]byte("us-west-2a"))
+		case "/latest/meta-data/iam/info":
+			w.Write([]byte(`{
+				"Code": "Success",
+				"InstanceProfileArn": "arn:aws:iam::111111111:instance-profile/millennium-falcon",
+				"InstanceProfileId": "AIPAABCDEFGHIJKLMN"
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestRegionFromMetadataAWS(t *testing.T) {
+	Convey("An EC2 instance", t, func() {
+		ts := metadataServer()
+		Reset(ts.Close)
+		sess, err := session.NewSession(&aws.Config{
+			Endpoint:    aws.String(ts.URL + "/latest"),
+			Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		})
+		So(err, ShouldBeNil)
+		Convey("Should use the region that was given", func() {
+			a, err := NewAWSAuthWithSession("https://test.example.com", "eu-central-1", sess)
+			So(err, ShouldBeNil)
+			So(a.region, ShouldEqual, "eu-central-1")
+			So(a.roleARN, ShouldEqual, "arn:aws:iam::111111111:role/millennium-falcon")
+		})
+		Convey("Should look up the region if none was given", func() {
+			a, err := NewAWSAuthWithSession("https://test.example.com", "", sess)
+			So(err, ShouldBeNil)
+			So(a.region, ShouldEqual, "us-west-2")
+		})
+		Convey("Should use the session's region if it has one", func() {
+			a, err := NewAWSAuthWithSession("https://test.example.com", "", sess.Copy(&aws.Config{Region: aws.String("us-east-1")}))
+			So(err, ShouldBeNil)
+			So(a.region, ShouldEqual, "us-east-1")
+		})
+	})
+	Convey("A machine without EC2 metadata", t, func() {
+		ts := metadataServer()
+		ts.Close()
+		sess, err := session.NewSession(&aws.Config{
+			Endpoint:    aws.String(ts.URL + "/latest"),
+			Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+			MaxRetries:  aws.Int(0),
+		})
+		So(err, ShouldBeNil)
+		Convey("Should error if no region was given", func() {
+			a, err := NewAWSAuthWithSession("https://test.example.com", "", sess)
+			So(a, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "No region was given and it could not be looked up from EC2 metadata")
+		})
+	})
+}
+
+func TestConcurrentAWS(t *testing.T) {
+	Convey("An AWSAuth shared by many goroutines", t, func() {
+		var hits int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			// Give the other goroutines time to pile up behind the first one
+			time.Sleep(20 * time.Millisecond)
+			w.Write([]byte(fakeAuthBody))
+		}))
+		Reset(ts.Close)
+		a, err := NewAWSAuthWithRole(ts.URL, "jabba", "tatooine", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should only authenticate once", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, 50)
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					tok, err := a.GetToken(context.Background())
+					if err == nil && tok != "a-cool-token" {
+						err = fmt.Errorf("Got token %q", tok)
+					}
+					errs <- err
+					a.IsAuthenticated()
+					a.GetHeaders()
+					a.GetURL()
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+			So(atomic.LoadInt32(&hits), ShouldEqual, 1)
+		})
+		Convey("Should not race with refreshes", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					a.GetToken(context.Background())
+					a.GetHeaders()
+				}()
+				go func() {
+					defer wg.Done()
+					a.Refresh()
+				}()
+			}
+			wg.Wait()
+			So(a.IsAuthenticated(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestLeaseDurationAWS(t *testing.T) {
+	noLeaseBody := `{"client_token": "a-cool-token", "lease_duration": 0, "renewable": false}`
+	Convey("A token with no lease duration", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "bib-fortuna", "tatooine", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: noLeaseBody}
+		Convey("Should be valid for the default lease", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(a.IsAuthenticated(), ShouldBeTrue)
+			_, expiry, _ := a.loadToken()
+			So(expiry, ShouldHappenAfter, time.Now().Add(DefaultLeaseDuration-expiryDelta-time.Minute))
+			So(a.Renewable(), ShouldBeFalse)
+		})
+		Convey("Should use the configured default lease", func() {
+			a.SetDefaultLeaseDuration(10 * time.Minute)
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			_, expiry, _ := a.loadToken()
+			So(expiry, ShouldHappenBefore, time.Now().Add(10*time.Minute))
+			So(expiry, ShouldHappenAfter, time.Now().Add(8*time.Minute))
+		})
+		Convey("Should error without a default lease", func() {
+			a.SetDefaultLeaseDuration(0)
+			tok, err := a.GetToken(context.Background())
+			So(tok, ShouldBeEmpty)
+			So(err, ShouldEqual, ErrorNoLeaseDuration)
+		})
+	}))
+
+	Convey("A non-renewable token with a short lease", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "bib-fortuna", "tatooine", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: `{"client_token": "a-cool-token", "lease_duration": 30, "renewable": false}`}
+		Convey("Should still be authenticated", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(a.IsAuthenticated(), ShouldBeTrue)
+			So(a.Renewable(), ShouldBeFalse)
+			Convey("And should reauthenticate on Refresh", func() {
+				So(a.Refresh(), ShouldBeNil)
+				So(a.IsAuthenticated(), ShouldBeTrue)
+			})
+		})
+	}))
+
+	Convey("A renewable token", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "bib-fortuna", "tatooine", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		_, err = a.GetToken(context.Background())
+		So(err, ShouldBeNil)
+		So(a.Renewable(), ShouldBeTrue)
+	}))
+}
+
+func TestNewAWSAuthSessionError(t *testing.T) {
+	Convey("An AWS session that can't be created", t, func() {
+		// A CA bundle that doesn't exist makes session creation fail before any network calls
+		os.Setenv("AWS_CA_BUNDLE", "/this/bundle/does/not/exist.pem")
+		Reset(func() {
+			os.Unsetenv("AWS_CA_BUNDLE")
+		})
+		Convey("Should return the session error from NewAWSAuth", func() {
+			a, err := NewAWSAuth("https://test.example.com", "kessel")
+			So(a, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Unable to create AWS session")
+			So(err.Error(), ShouldNotContainSubstring, "metadata")
+		})
+		Convey("Should return the session error from NewAWSAuthWithRole", func() {
+			a, err := NewAWSAuthWithRole("https://test.example.com", "lando", "kessel", nil)
+			So(a, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Unable to create AWS session")
+		})
+	})
+}
+
+func TestPrincipalARNAWS(t *testing.T) {
+	Convey("An AWSAuth with an explicit role", t, func() {
+		var sent awsAuthBody
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&sent)
+			w.Write([]byte(fakeAuthBody))
+		}))
+		Reset(ts.Close)
+		a, err := NewAWSAuthWithRole(ts.URL, "arn:aws:iam::111111111:role/wampa", "hoth", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should send the role as the IAM principal", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(sent.PrincipalArn, ShouldEqual, "arn:aws:iam::111111111:role/wampa")
+			So(sent.Region, ShouldEqual, "hoth")
+		})
+	})
 }
 
 func TestGetTokenAWS(t *testing.T) {
 	Convey("A valid AWSAuth", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{
 		"X-Cerberus-Client": api.ClientHeader,
 	}, func(ts *httptest.Server) {
-		a, err := NewAWSAuth(ts.URL, "han-solo", "falcon")
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		a.kmsClient = mockKMS{
@@ -137,13 +402,14 @@ func TestGetTokenAWS(t *testing.T) {
 			data:        awsResponseBody,
 		}
 		Convey("Should not error with getting a token", func() {
-			tok, err := a.GetToken(nil)
+			tok, err := a.GetToken(context.Background())
 			So(err, ShouldBeNil)
 			Convey("And should have a valid token", func() {
 				So(tok, ShouldEqual, "a-cool-token")
 			})
 			Convey("And should have a valid expiry time", func() {
-				So(a.expiry, ShouldHappenOnOrBefore, time.Now().Add(1*time.Hour))
+				_, expiry, _ := a.loadToken()
+				So(expiry, ShouldHappenOnOrBefore, time.Now().Add(1*time.Hour))
 			})
 		})
 	}))
@@ -151,7 +417,7 @@ func TestGetTokenAWS(t *testing.T) {
 	Convey("A valid AWSAuth", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, "{", map[string]string{
 		"X-Cerberus-Client": api.ClientHeader,
 	}, func(ts *httptest.Server) {
-		a, err := NewAWSAuth(ts.URL, "han-solo", "falcon")
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		a.kmsClient = mockKMS{
@@ -159,7 +425,7 @@ func TestGetTokenAWS(t *testing.T) {
 			data:        awsResponseBody,
 		}
 		Convey("Should error with an invalid response from Cerberus", func() {
-			tok, err := a.GetToken(nil)
+			tok, err := a.GetToken(context.Background())
 			So(tok, ShouldBeEmpty)
 			So(err, ShouldNotBeNil)
 		})
@@ -168,7 +434,7 @@ func TestGetTokenAWS(t *testing.T) {
 	Convey("A valid AWSAuth", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{
 		"X-Cerberus-Client": api.ClientHeader,
 	}, func(ts *httptest.Server) {
-		a, err := NewAWSAuth(ts.URL, "han-solo", "falcon")
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		a.kmsClient = mockKMS{
@@ -176,59 +442,222 @@ func TestGetTokenAWS(t *testing.T) {
 			data:        awsResponseBody,
 		}
 		Convey("Should error if decryption fails", func() {
-			tok, err := a.GetToken(nil)
+			tok, err := a.GetToken(context.Background())
 			So(tok, ShouldBeEmpty)
 			So(err, ShouldNotBeNil)
 		})
 	}))
 	Convey("A valid AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "luke", "x-wing")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "luke", "x-wing", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
-		a.expiry = time.Now().Add(100 * time.Second)
-		a.token = "mon-calamari"
+		a.store.Store("mon-calamari", time.Now().Add(100*time.Second))
 		Convey("Should return a token if one is set", func() {
-			tok, err := a.GetToken(nil)
+			tok, err := a.GetToken(context.Background())
 			So(err, ShouldBeNil)
 			So(tok, ShouldEqual, "mon-calamari")
 		})
 	})
 	Convey("A valid AWSAuth", t, TestingServer(http.StatusUnauthorized, "/v2/auth/iam-principal", http.MethodPost, "", map[string]string{}, func(ts *httptest.Server) {
-		a, err := NewAWSAuth(ts.URL, "han-solo", "falcon")
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		Convey("Should error with invalid login", func() {
-			tok, err := a.GetToken(nil)
+			tok, err := a.GetToken(context.Background())
 			So(err, ShouldEqual, api.ErrorUnauthorized)
 			So(tok, ShouldBeEmpty)
 		})
 	}))
+	Convey("A valid AWSAuth", t, TestingServer(http.StatusBadRequest, "/v2/auth/iam-principal", http.MethodPost, `{
+		"error_id": "1b27b6f0-4c40-4a73-95ab-e1cc9a8c6b47",
+		"errors": [{"code": 99227, "message": "The specified IAM principal is not associated with any SDBs"}]
+	}`, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		Convey("Should return the error from Cerberus", func() {
+			_, err := a.GetToken(context.Background())
+			cerberusErr, ok := err.(*api.CerberusError)
+			So(ok, ShouldBeTrue)
+			So(cerberusErr.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(cerberusErr.Errors[0].Code, ShouldEqual, 99227)
+		})
+	}))
+	Convey("A valid AWSAuth", t, TestingServer(http.StatusForbidden, "/v2/auth/iam-principal", http.MethodPost, "", map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		So(a, ShouldNotBeNil)
+		Convey("Should return ErrorForbidden when the principal isn't allowed", func() {
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldEqual, api.ErrorForbidden)
+			So(tok, ShouldBeEmpty)
+		})
+	}))
 	Convey("A valid AWSAuth", t, TestingServer(http.StatusInternalServerError, "/v2/auth/iam-principal", http.MethodPost, "", map[string]string{}, func(ts *httptest.Server) {
-		a, err := NewAWSAuth(ts.URL, "han-solo", "falcon")
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		Convey("Should error with bad API response", func() {
-			tok, err := a.GetToken(nil)
+			tok, err := a.GetToken(context.Background())
 			So(err, ShouldNotBeNil)
 			So(tok, ShouldBeEmpty)
 		})
 	}))
 }
 
+func TestGetTokenCancelledAWS(t *testing.T) {
+	Convey("An AWS login that is cancelled", t, func() {
+		ts := slowServer()
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should return promptly with the context's error", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			tok, err := a.GetToken(ctx)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(tok, ShouldBeEmpty)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestTimeoutAWS(t *testing.T) {
+	Convey("An AWS login to a server that hangs", t, func() {
+		ts := slowServer()
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil, WithTimeout(50*time.Millisecond))
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should give up after the timeout", func() {
+			start := time.Now()
+			tok, err := a.GetToken(context.Background())
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(tok, ShouldBeEmpty)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "timed out")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+// expiringProvider hands out credentials with a new access key every time they are retrieved,
+// which stay valid until expired is set
+type expiringProvider struct {
+	retrieves int
+	expired   bool
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	p.retrieves++
+	p.expired = false
+	return credentials.Value{AccessKeyID: fmt.Sprintf("key-%d", p.retrieves), SecretAccessKey: "secret"}, nil
+}
+
+func (p *expiringProvider) IsExpired() bool {
+	return p.expired
+}
+
+// credentialsKMS is a KMS client that rejects the given access key as expired
+type credentialsKMS struct {
+	kmsiface.KMSAPI
+	creds      *credentials.Credentials
+	expiredKey string
+	// keys records the access key used for every decryption
+	keys *[]string
+}
+
+func (m credentialsKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	v, err := m.creds.Get()
+	if err != nil {
+		return nil, err
+	}
+	*m.keys = append(*m.keys, v.AccessKeyID)
+	if v.AccessKeyID == m.expiredKey {
+		return nil, awserr.New("ExpiredTokenException", "The security token included in the request is expired", nil)
+	}
+	return &kms.DecryptOutput{Plaintext: []byte(awsResponseBody)}, nil
+}
+
+func TestRefreshCredentialsAWS(t *testing.T) {
+	Convey("An AWSAuth with credentials that expire", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		provider := &expiringProvider{}
+		a.creds = credentials.NewCredentials(provider)
+		keys := []string{}
+		Convey("Should use new credentials once the old ones expire", func() {
+			a.kmsClient = credentialsKMS{creds: a.creds, keys: &keys}
+			So(a.Refresh(), ShouldBeNil)
+			provider.expired = true
+			So(a.Refresh(), ShouldBeNil)
+			So(keys, ShouldResemble, []string{"key-1", "key-2"})
+		})
+		Convey("Should refresh them and try again if KMS says they expired", func() {
+			a.kmsClient = credentialsKMS{creds: a.creds, expiredKey: "key-1", keys: &keys}
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-cool-token")
+			So(keys, ShouldResemble, []string{"key-1", "key-2"})
+		})
+		Convey("Should get new ones on demand", func() {
+			So(a.RefreshCredentials(), ShouldBeNil)
+			So(a.RefreshCredentials(), ShouldBeNil)
+			So(provider.retrieves, ShouldEqual, 2)
+		})
+	}))
+}
+
+func TestKMSDecryptError(t *testing.T) {
+	Convey("A KMS decryption that AWS rejected", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{
+			err: awserr.NewRequestFailure(awserr.New("AccessDeniedException", "The ciphertext refers to a key that you don't have access to", nil), http.StatusBadRequest, "a-request-id"),
+		}
+		Convey("Should return a KMSDecryptError with the AWS details", func() {
+			_, err := a.GetToken(context.Background())
+			var kmsErr *KMSDecryptError
+			So(errors.As(err, &kmsErr), ShouldBeTrue)
+			So(kmsErr.Code, ShouldEqual, "AccessDeniedException")
+			So(kmsErr.RequestID, ShouldEqual, "a-request-id")
+			So(kmsErr.CiphertextLength, ShouldEqual, len("This is a random string"))
+			So(err.Error(), ShouldEqual, "Error while decrypting response: AccessDeniedException: The ciphertext refers to a key that you don't have access to (request ID a-request-id). Ciphertext was 23 bytes")
+		})
+	}))
+
+	Convey("A KMS decryption that failed before reaching AWS", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{shouldError: true}
+		Convey("Should return a KMSDecryptError without AWS details", func() {
+			_, err := a.GetToken(context.Background())
+			var kmsErr *KMSDecryptError
+			So(errors.As(err, &kmsErr), ShouldBeTrue)
+			So(kmsErr.Code, ShouldBeEmpty)
+			So(kmsErr.RequestID, ShouldBeEmpty)
+			So(kmsErr.Err.Error(), ShouldEqual, "Your decryption errored")
+		})
+	}))
+}
+
 func TestIsAuthenticatedAWS(t *testing.T) {
 	Convey("A valid AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "luke", "x-wing")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "luke", "x-wing", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
-		a.expiry = time.Now().Add(100 * time.Second)
-		a.token = "ackbar"
+		a.store.Store("ackbar", time.Now().Add(100*time.Second))
 		Convey("Should return true", func() {
 			So(a.IsAuthenticated(), ShouldBeTrue)
 		})
 	})
 
 	Convey("An unauthenticated AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "luke", "x-wing")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "luke", "x-wing", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		Convey("Should return false", func() {
@@ -238,12 +667,49 @@ func TestIsAuthenticatedAWS(t *testing.T) {
 }
 
 func TestRefreshAWS(t *testing.T) {
-	Convey("An unauthenticated AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "sarlacc", "pit")
+	Convey("An unauthenticated AWSAuth", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "sarlacc", "pit", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
-		Convey("Should error", func() {
-			So(a.Refresh(), ShouldEqual, api.ErrorUnauthenticated)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should authenticate again", func() {
+			So(a.Refresh(), ShouldBeNil)
+			So(a.IsAuthenticated(), ShouldBeTrue)
+		})
+	}))
+}
+
+func TestConnectionReuseAWS(t *testing.T) {
+	Convey("An AWSAuth that authenticates, refreshes, and logs out repeatedly", t, func() {
+		var conns int32
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Write([]byte(fakeAuthBody))
+		}))
+		ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		ts.Start()
+		Reset(ts.Close)
+		a, err := NewAWSAuthWithRole(ts.URL, "lando", "bespin", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should use the same HTTP client every time", func() {
+			So(a.httpClient(a.requestTimeout()), ShouldEqual, a.httpClient(a.requestTimeout()))
+		})
+		Convey("Should only open one connection", func() {
+			for i := 0; i < 5; i++ {
+				_, err := a.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(a.Refresh(), ShouldBeNil)
+				So(a.Logout(), ShouldBeNil)
+			}
+			So(atomic.LoadInt32(&conns), ShouldEqual, 1)
 		})
 	})
 }
@@ -258,30 +724,41 @@ func TestLogoutAWS(t *testing.T) {
 		testHeaders := http.Header{}
 		testHeaders.Add("X-Vault-Token", testToken)
 		testHeaders.Add("X-Cerberus-Client", api.ClientHeader)
-		a, err := NewAWSAuth(ts.URL, "chewie", "rancor")
+		a, err := NewAWSAuthWithRole(ts.URL, "chewie", "rancor", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
-		a.expiry = time.Now().Add(100 * time.Second)
-		a.token = testToken
+		a.store.Store(testToken, time.Now().Add(100*time.Second))
 		a.headers = testHeaders
 		Convey("Should not error on logout", func() {
 			err := a.Logout()
 			So(err, ShouldBeNil)
 			Convey("And should have an empty token", func() {
-				So(a.token, ShouldBeEmpty)
+				tok, _, _ := a.loadToken()
+				So(tok, ShouldBeEmpty)
 			})
 		})
+		Convey("Should not race with setting the base path while logging out", func() {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					a.SetBasePath("")
+				}
+			}()
+			So(a.LogoutWithTimeout(time.Second), ShouldBeNil)
+			wg.Wait()
+		})
 	}))
 
 	Convey("A valid AWSAuth", t, TestingServer(http.StatusInternalServerError, "/v1/auth", http.MethodDelete, "", expectedHeaders, func(ts *httptest.Server) {
 		testHeaders := http.Header{}
 		testHeaders.Add("X-Vault-Token", testToken)
 		testHeaders.Add("X-Cerberus-Client", api.ClientHeader)
-		a, err := NewAWSAuth(ts.URL, "chewie", "rancor")
+		a, err := NewAWSAuthWithRole(ts.URL, "chewie", "rancor", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
-		a.expiry = time.Now().Add(100 * time.Second)
-		a.token = testToken
+		a.store.Store(testToken, time.Now().Add(100*time.Second))
 		a.headers = testHeaders
 		Convey("Should error with invalid response from server", func() {
 			err := a.Logout()
@@ -290,7 +767,7 @@ func TestLogoutAWS(t *testing.T) {
 	}))
 
 	Convey("An unauthenticated AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "chewie", "rancor")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "chewie", "rancor", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		Convey("Should error on logout", func() {
@@ -304,11 +781,10 @@ func TestGetHeadersAWS(t *testing.T) {
 	testHeaders := http.Header{}
 	testHeaders.Add("X-Vault-Token", testToken)
 	Convey("A valid AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "chewie", "rancor")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "chewie", "rancor", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
-		a.expiry = time.Now().Add(100 * time.Second)
-		a.token = testToken
+		a.store.Store(testToken, time.Now().Add(100*time.Second))
 		a.headers = testHeaders
 		Convey("Should return headers", func() {
 			headers, err := a.GetHeaders()
@@ -316,10 +792,51 @@ func TestGetHeadersAWS(t *testing.T) {
 			So(headers, ShouldNotBeNil)
 			So(headers.Get("X-Vault-Token"), ShouldContainSubstring, testToken)
 		})
+		Convey("Should return a copy of the headers", func() {
+			headers, err := a.GetHeaders()
+			So(err, ShouldBeNil)
+			headers.Set("X-Vault-Token", "")
+			headers.Set("X-Cerberus-Client", "jawa")
+			headers.Add("X-Extra", "r2-d2")
+			again, err := a.GetHeaders()
+			So(err, ShouldBeNil)
+			So(again.Get("X-Vault-Token"), ShouldEqual, testToken)
+			So(again.Get("X-Cerberus-Client"), ShouldBeEmpty)
+			So(again.Get("X-Extra"), ShouldBeEmpty)
+			So(a.headers.Get("X-Extra"), ShouldBeEmpty)
+		})
+	})
+
+	Convey("An AWSAuth whose headers were changed by a caller", t, TestingServer(http.StatusNoContent, "/v1/auth", http.MethodDelete, "", map[string]string{
+		"X-Vault-Token":     "lightsaber",
+		"X-Cerberus-Client": api.ClientHeader,
+	}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "chewie", "rancor", nil)
+		So(err, ShouldBeNil)
+		a.store.Store(testToken, time.Now().Add(100*time.Second))
+		headers, err := a.GetHeaders()
+		So(err, ShouldBeNil)
+		headers.Set("X-Vault-Token", "")
+		headers.Del("X-Cerberus-Client")
+		Convey("Should still log out with the original headers", func() {
+			So(a.Logout(), ShouldBeNil)
+		})
+	}))
+
+	Convey("An AWSAuth whose token looks expired", t, func() {
+		a, err := NewAWSAuthWithRole("https://test.example.com", "chewie", "rancor", nil)
+		So(err, ShouldBeNil)
+		a.store.Store(testToken, time.Now().Add(-time.Second))
+		So(a.IsAuthenticated(), ShouldBeFalse)
+		Convey("Should still return headers with the token, so Cerberus can decide", func() {
+			headers, err := a.GetHeaders()
+			So(err, ShouldBeNil)
+			So(headers.Get("X-Vault-Token"), ShouldEqual, testToken)
+		})
 	})
 
 	Convey("An unauthenticated AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "chewie", "rancor")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "chewie", "rancor", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		Convey("Should return an error when getting headers", func() {
@@ -332,7 +849,7 @@ func TestGetHeadersAWS(t *testing.T) {
 
 func TestGetURLAWS(t *testing.T) {
 	Convey("A valid AWSAuth", t, func() {
-		a, err := NewAWSAuth("https://test.example.com", "chewie", "rancor")
+		a, err := NewAWSAuthWithRole("https://test.example.com", "chewie", "rancor", nil)
 		So(err, ShouldBeNil)
 		So(a, ShouldNotBeNil)
 		Convey("Should return a URL", func() {
@@ -341,3 +858,251 @@ func TestGetURLAWS(t *testing.T) {
 		})
 	})
 }
+
+var nestedAWSResponseBody = `{
+    "client_token": {
+        "client_token": "a-nested-token",
+        "policies": [ "foo-bar-read", "lookup-self" ],
+        "metadata": {
+            "aws_region": "us-west-2",
+            "iam_principal_arn": "arn:aws:iam::111111111:role/fake-role"
+        },
+        "lease_duration": 1800,
+        "renewable": true
+    }
+}`
+
+func TestParseIAMAuthResponse(t *testing.T) {
+	Convey("A flat decrypted response", t, func() {
+		r, err := parseIAMAuthResponse([]byte(awsResponseBody))
+		Convey("Should parse the token from the top level", func() {
+			So(err, ShouldBeNil)
+			So(r.Token, ShouldEqual, "a-cool-token")
+			So(r.Duration, ShouldEqual, 3600)
+			So(r.Metadata.Region, ShouldEqual, "us-west-2")
+		})
+	})
+
+	Convey("A nested decrypted response", t, func() {
+		r, err := parseIAMAuthResponse([]byte(nestedAWSResponseBody))
+		Convey("Should parse the token from under client_token", func() {
+			So(err, ShouldBeNil)
+			So(r.Token, ShouldEqual, "a-nested-token")
+			So(r.Duration, ShouldEqual, 1800)
+			So(r.Policies, ShouldResemble, []string{"foo-bar-read", "lookup-self"})
+		})
+	})
+
+	Convey("A decrypted response in neither shape", t, func() {
+		Convey("Should error", func() {
+			for _, body := range []string{`{"token": "a-token"}`, `{"client_token": 42}`, `{"client_token": ""}`, `{"client_token": {"policies": []}}`, `not json`} {
+				_, err := parseIAMAuthResponse([]byte(body))
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestMetricsAWS(t *testing.T) {
+	Convey("An AWS auth with a metrics recorder", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "lando", "bespin", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		recorder := &fakeRecorder{}
+		a.SetMetricsRecorder(recorder)
+		Convey("Should record logging in", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(recorder.auth, ShouldResemble, []string{AuthResultSuccess})
+			So(recorder.requests, ShouldResemble, []string{"/v2/auth/iam-principal 200"})
+		})
+		Convey("Should record a refresh along with the login it does", func() {
+			So(a.Refresh(), ShouldBeNil)
+			So(recorder.auth, ShouldResemble, []string{AuthResultRefresh, AuthResultSuccess})
+		})
+	}))
+
+	Convey("An AWS auth that can't decrypt the token", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "lando", "bespin", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{shouldError: true}
+		recorder := &fakeRecorder{}
+		a.SetMetricsRecorder(recorder)
+		Convey("Should record a failure", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldNotBeNil)
+			So(recorder.auth, ShouldResemble, []string{AuthResultFailure})
+		})
+	}))
+}
+
+func TestTracingAWS(t *testing.T) {
+	Convey("An AWS auth with tracing on", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "lando", "bespin", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		recorder := tracetest.NewSpanRecorder()
+		a.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		Convey("Should trace a refresh and the login it does with the region", func() {
+			So(a.Refresh(), ShouldBeNil)
+			spans := recorder.Ended()
+			So(spans, ShouldHaveLength, 2)
+			So(spans[0].Name(), ShouldEqual, spanAuthenticate)
+			So(spans[0].Parent().SpanID(), ShouldEqual, spans[1].SpanContext().SpanID())
+			So(spans[1].Name(), ShouldEqual, spanRefresh)
+			So(spanAttribute(spans[0], string(utils.AttributeRegion)), ShouldEqual, "bespin")
+		})
+	}))
+}
+
+func TestBasePathAWS(t *testing.T) {
+	Convey("An AWS auth with a base path in its URL", t, TestingServer(http.StatusOK, "/cerberus/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL+"/cerberus/", "lando", "bespin", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		recorder := &fakeRecorder{}
+		a.SetMetricsRecorder(recorder)
+		Convey("Should log in under the base path", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(a.GetURL().Path, ShouldEqual, "/cerberus")
+			Convey("And record the endpoint without it", func() {
+				So(recorder.requests, ShouldResemble, []string{"/v2/auth/iam-principal 200"})
+			})
+		})
+	}))
+
+	Convey("An AWS auth given a base path", t, TestingServer(http.StatusOK, "/cerberus/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "lando", "bespin", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		a.SetBasePath("cerberus")
+		Convey("Should log in under the base path", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+		})
+	}))
+}
+
+func TestAWSAuthWithStore(t *testing.T) {
+	Convey("An AWS auth with a file token store", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		dir, err := ioutil.TempDir("", "cerberus-store")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		store, err := NewFileTokenStore(filepath.Join(dir, "token"))
+		So(err, ShouldBeNil)
+		a, err := NewAWSAuthWithRole(ts.URL, "bib-fortuna", "tatooine", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("And a saved token that is still valid", func() {
+			expiry := time.Now().Add(time.Hour).Round(time.Second)
+			So(store.save(fileToken{
+				Token:    "a-saved-token",
+				Expiry:   expiry,
+				URL:      a.GetURL().String(),
+				RoleARN:  "bib-fortuna",
+				Policies: []string{"foo-bar-read"},
+			}), ShouldBeNil)
+			So(a.usePersistedStore(store), ShouldBeNil)
+			Convey("Should use it without authenticating", func() {
+				So(a.IsAuthenticated(), ShouldBeTrue)
+				So(a.Renewable(), ShouldBeTrue)
+				tok, err := a.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "a-saved-token")
+				So(a.ExpiresAt().Equal(expiry), ShouldBeTrue)
+			})
+			Convey("Should restore its policies", func() {
+				So(a.HasPolicy("foo-bar-read"), ShouldBeTrue)
+				So(a.HasPolicy("lookup-self"), ShouldBeFalse)
+			})
+		})
+		Convey("And a saved token for a different Cerberus URL", func() {
+			So(store.save(fileToken{
+				Token:   "a-saved-token",
+				Expiry:  time.Now().Add(time.Hour),
+				URL:     "https://other.example.com",
+				RoleARN: "bib-fortuna",
+			}), ShouldBeNil)
+			So(a.usePersistedStore(store), ShouldBeNil)
+			Convey("Should not use it", func() {
+				So(a.IsAuthenticated(), ShouldBeFalse)
+				tok, err := a.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "a-cool-token")
+			})
+		})
+		Convey("And a saved token for a different role", func() {
+			So(store.save(fileToken{
+				Token:   "a-saved-token",
+				Expiry:  time.Now().Add(time.Hour),
+				URL:     a.GetURL().String(),
+				RoleARN: "jabba",
+			}), ShouldBeNil)
+			So(a.usePersistedStore(store), ShouldBeNil)
+			Convey("Should not use it", func() {
+				So(a.IsAuthenticated(), ShouldBeFalse)
+			})
+		})
+		Convey("And a saved token that doesn't say who it was issued for", func() {
+			So(store.Store("a-saved-token", time.Now().Add(time.Hour)), ShouldBeNil)
+			So(a.usePersistedStore(store), ShouldBeNil)
+			Convey("Should not use it", func() {
+				So(a.IsAuthenticated(), ShouldBeFalse)
+			})
+		})
+		Convey("And a saved token that has expired", func() {
+			So(store.Store("an-old-token", time.Now().Add(-time.Minute)), ShouldBeNil)
+			So(a.usePersistedStore(store), ShouldBeNil)
+			Convey("Should authenticate and save the new token", func() {
+				So(a.IsAuthenticated(), ShouldBeFalse)
+				tok, err := a.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "a-cool-token")
+				saved, err := store.load()
+				So(err, ShouldBeNil)
+				So(saved.Token, ShouldEqual, "a-cool-token")
+				So(saved.URL, ShouldEqual, a.GetURL().String())
+				So(saved.RoleARN, ShouldEqual, "bib-fortuna")
+				So(saved.Policies, ShouldResemble, []string{"foo-bar-read", "lookup-self"})
+				Convey("And remove it on logout even if revoking fails", func() {
+					ts.Close()
+					So(a.LogoutWithTimeout(time.Second), ShouldNotBeNil)
+					saved, _, err := store.Load()
+					So(err, ShouldBeNil)
+					So(saved, ShouldBeEmpty)
+				})
+			})
+		})
+		Convey("And a non-renewable token", func() {
+			So(store.Store("an-old-token", time.Now().Add(-time.Minute)), ShouldBeNil)
+			So(a.usePersistedStore(store), ShouldBeNil)
+			a.kmsClient = mockKMS{data: `{"client_token": "a-cool-token", "lease_duration": 3600, "renewable": false}`}
+			Convey("Should not save it", func() {
+				_, err := a.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				saved, _, err := store.Load()
+				So(err, ShouldBeNil)
+				So(saved, ShouldBeEmpty)
+			})
+		})
+		Convey("And a saved token that others can read", func() {
+			So(store.Store("a-saved-token", time.Now().Add(time.Hour)), ShouldBeNil)
+			So(os.Chmod(filepath.Join(dir, "token"), 0644), ShouldBeNil)
+			Convey("Should return an error", func() {
+				err := a.usePersistedStore(store)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "other users can access it")
+				So(a.IsAuthenticated(), ShouldBeFalse)
+			})
+		})
+	}))
+
+	Convey("A nil token store", t, func() {
+		a, err := NewAWSAuthWithStore("https://test.example.com", "tatooine", nil)
+		So(a, ShouldBeNil)
+		So(err, ShouldNotBeNil)
+	})
+}
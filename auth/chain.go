@@ -0,0 +1,196 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+)
+
+// ChainAuth is an Auth that tries a list of auth methods in order, using the first one that
+// works, similar to the AWS credential chain. A common use is AWS authentication with a
+// static token to fall back on if AWS authentication fails:
+//
+//	awsAuth, _ := auth.NewAWSAuth(url, region)
+//	tokenAuth, _ := auth.NewTokenAuth(url, token)
+//	authMethod := auth.Chain(awsAuth, tokenAuth)
+//
+// The method that returns a token from GetToken becomes the active method and is used for
+// everything else (Refresh, Logout, GetHeaders, etc.). As long as the active method is still
+// authenticated, GetToken keeps using it. Once it isn't (for example because its token expired
+// or it was logged out), the next GetToken starts again from the first method in the chain,
+// so a preferred method that failed earlier gets another chance. TLS settings, timeouts, and
+// transport wrappers given to the chain (such as by the client) are passed on to every method
+// in it
+type ChainAuth struct {
+	sources []Auth
+	lock    sync.Mutex
+	active  Auth
+}
+
+// Chain returns a ChainAuth that tries the given auth methods in the order they are passed
+func Chain(sources ...Auth) *ChainAuth {
+	return &ChainAuth{sources: sources}
+}
+
+// GetToken returns the token from the active method if it is still authenticated. Otherwise
+// it calls GetToken on each method in order and the first one to succeed becomes the active
+// method. If none of them succeed, the error lists why each one failed
+func (c *ChainAuth) GetToken(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.active != nil && c.active.IsAuthenticated() {
+		return c.active.GetToken(ctx)
+	}
+	c.active = nil
+	if len(c.sources) == 0 {
+		return "", fmt.Errorf("No auth methods were given to the chain")
+	}
+	errs := make([]string, 0, len(c.sources))
+	for i, source := range c.sources {
+		token, err := source.GetToken(ctx)
+		if err == nil {
+			c.active = source
+			return token, nil
+		}
+		errs = append(errs, fmt.Sprintf("%d (%T): %v", i, source, err))
+	}
+	return "", fmt.Errorf("Every auth method in the chain failed: %s", strings.Join(errs, "; "))
+}
+
+// Active returns the auth method currently in use, or nil if GetToken hasn't succeeded yet
+func (c *ChainAuth) Active() Auth {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.active
+}
+
+// IsAuthenticated returns whether there is an active method and it is authenticated
+func (c *ChainAuth) IsAuthenticated() bool {
+	active := c.Active()
+	return active != nil && active.IsAuthenticated()
+}
+
+// Refresh refreshes the token of the active method. Returns ErrorUnauthenticated if
+// there is no active method
+func (c *ChainAuth) Refresh() error {
+	active := c.Active()
+	if active == nil {
+		return api.ErrorUnauthenticated
+	}
+	return active.Refresh()
+}
+
+// Logout logs out of the active method and clears it, so the next GetToken goes through
+// the whole chain again. Returns ErrorUnauthenticated if there is no active method
+func (c *ChainAuth) Logout() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.active == nil {
+		return api.ErrorUnauthenticated
+	}
+	err := c.active.Logout()
+	c.active = nil
+	return err
+}
+
+// GetHeaders returns the headers of the active method. Returns ErrorUnauthenticated if
+// there is no active method
+func (c *ChainAuth) GetHeaders() (http.Header, error) {
+	active := c.Active()
+	if active == nil {
+		return nil, api.ErrorUnauthenticated
+	}
+	return active.GetHeaders()
+}
+
+// GetURL returns the Cerberus URL of the active method, or of the first method in the
+// chain if there is no active method yet
+func (c *ChainAuth) GetURL() *url.URL {
+	if active := c.Active(); active != nil {
+		return active.GetURL()
+	}
+	if len(c.sources) == 0 {
+		return nil
+	}
+	return c.sources[0].GetURL()
+}
+
+// SetTLSConfig sets the TLS configuration of every method in the chain that takes one, so
+// that whichever method ends up logging in uses the same TLS settings as the client
+func (c *ChainAuth) SetTLSConfig(conf *tls.Config) {
+	for _, source := range c.sources {
+		if setter, ok := source.(interface {
+			SetTLSConfig(*tls.Config)
+		}); ok {
+			setter.SetTLSConfig(conf)
+		}
+	}
+}
+
+// SetTimeout sets the request timeout of every method in the chain that takes one
+func (c *ChainAuth) SetTimeout(d time.Duration) {
+	for _, source := range c.sources {
+		if setter, ok := source.(interface {
+			SetTimeout(time.Duration)
+		}); ok {
+			setter.SetTimeout(d)
+		}
+	}
+}
+
+// WrapTransport sets the transport wrapper of every method in the chain that takes one
+func (c *ChainAuth) WrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	for _, source := range c.sources {
+		if wrapper, ok := source.(interface {
+			WrapTransport(func(http.RoundTripper) http.RoundTripper)
+		}); ok {
+			wrapper.WrapTransport(wrap)
+		}
+	}
+}
+
+// Reauthenticate gets a new token from the active method, by logging in again if it is a
+// Reauthenticator and by refreshing it otherwise. Returns ErrorUnauthenticated if there is
+// no active method
+func (c *ChainAuth) Reauthenticate() error {
+	active := c.Active()
+	if active == nil {
+		return api.ErrorUnauthenticated
+	}
+	if r, ok := active.(Reauthenticator); ok {
+		return r.Reauthenticate()
+	}
+	return active.Refresh()
+}
+
+// TrackRequest tracks a request with the active method if it is a RequestTracker, so that
+// logging out of it waits for the request. Otherwise there is nothing to wait for
+func (c *ChainAuth) TrackRequest() (func(), error) {
+	if tracker, ok := c.Active().(RequestTracker); ok {
+		return tracker.TrackRequest()
+	}
+	return func() {}, nil
+}
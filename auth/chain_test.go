@@ -0,0 +1,190 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeAuth is an Auth that either always or never authenticates
+type fakeAuth struct {
+	name     string
+	fail     bool
+	token    string
+	attempts int
+	logouts  int
+}
+
+func (f *fakeAuth) GetToken(context.Context) (string, error) {
+	f.attempts++
+	if f.fail {
+		return "", fmt.Errorf("%s is down", f.name)
+	}
+	f.token = f.name + "-token"
+	return f.token, nil
+}
+
+func (f *fakeAuth) IsAuthenticated() bool {
+	return f.token != ""
+}
+
+func (f *fakeAuth) Refresh() error {
+	if f.token == "" {
+		return api.ErrorUnauthenticated
+	}
+	f.token = f.name + "-refreshed"
+	return nil
+}
+
+func (f *fakeAuth) Logout() error {
+	f.logouts++
+	f.token = ""
+	return nil
+}
+
+func (f *fakeAuth) GetHeaders() (http.Header, error) {
+	return http.Header{"X-Vault-Token": []string{f.token}}, nil
+}
+
+func (f *fakeAuth) GetURL() *url.URL {
+	u, _ := url.Parse("https://" + f.name + ".example.com")
+	return u
+}
+
+func TestChain(t *testing.T) {
+	Convey("A chain whose first method fails", t, func() {
+		first := &fakeAuth{name: "aws", fail: true}
+		second := &fakeAuth{name: "static"}
+		c := Chain(first, second)
+		Convey("Should not be authenticated before GetToken", func() {
+			So(c.IsAuthenticated(), ShouldBeFalse)
+			So(c.Active(), ShouldBeNil)
+			So(c.Refresh(), ShouldEqual, api.ErrorUnauthenticated)
+			_, err := c.GetHeaders()
+			So(err, ShouldEqual, api.ErrorUnauthenticated)
+			So(c.GetURL().Host, ShouldEqual, "aws.example.com")
+		})
+		Convey("Should fall back to the next method", func() {
+			tok, err := c.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "static-token")
+			So(c.Active(), ShouldEqual, second)
+			So(c.IsAuthenticated(), ShouldBeTrue)
+			So(c.GetURL().Host, ShouldEqual, "static.example.com")
+			Convey("And should keep using it while it is authenticated", func() {
+				first.fail = false
+				tok, err := c.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "static-token")
+				So(first.attempts, ShouldEqual, 1)
+			})
+			Convey("And should refresh the active method", func() {
+				So(c.Refresh(), ShouldBeNil)
+				headers, err := c.GetHeaders()
+				So(err, ShouldBeNil)
+				So(headers.Get("X-Vault-Token"), ShouldEqual, "static-refreshed")
+			})
+			Convey("And should reauthenticate the active method", func() {
+				So(c.Reauthenticate(), ShouldBeNil)
+				So(second.token, ShouldEqual, "static-refreshed")
+				So(first.attempts, ShouldEqual, 1)
+			})
+			Convey("And should start from the top of the chain after logging out", func() {
+				So(c.Logout(), ShouldBeNil)
+				So(second.logouts, ShouldEqual, 1)
+				So(c.Active(), ShouldBeNil)
+				first.fail = false
+				tok, err := c.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "aws-token")
+				So(c.Active(), ShouldEqual, first)
+			})
+		})
+	})
+
+	Convey("A chain of auth types", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+		tok, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		c := Chain(&fakeAuth{name: "aws", fail: true}, tok)
+		Convey("Should not track requests or reauthenticate before GetToken", func() {
+			done, err := c.TrackRequest()
+			So(err, ShouldBeNil)
+			done()
+			So(c.Reauthenticate(), ShouldEqual, api.ErrorUnauthenticated)
+		})
+		Convey("Should track requests with the active method", func() {
+			_, err := c.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			tok.SetLogoutGracePeriod(time.Second)
+			done, err := c.TrackRequest()
+			So(err, ShouldBeNil)
+			So(tok.drain.inFlight, ShouldEqual, 1)
+			done()
+			So(tok.drain.inFlight, ShouldEqual, 0)
+		})
+		Convey("Should wrap the transport of every method that has one", func() {
+			var paths []string
+			c.WrapTransport(func(next http.RoundTripper) http.RoundTripper {
+				return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					paths = append(paths, req.URL.Path)
+					return next.RoundTrip(req)
+				})
+			})
+			_, err := c.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(c.Logout(), ShouldBeNil)
+			So(paths, ShouldResemble, []string{"/v1/auth"})
+		})
+		Convey("Should set the timeout of every method that has one", func() {
+			c.SetTimeout(time.Minute)
+			So(tok.requestTimeout(), ShouldEqual, time.Minute)
+		})
+	})
+
+	Convey("A chain where every method fails", t, func() {
+		c := Chain(&fakeAuth{name: "aws", fail: true}, &fakeAuth{name: "static", fail: true})
+		Convey("Should return an error with every failure", func() {
+			_, err := c.GetToken(context.Background())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "aws is down")
+			So(err.Error(), ShouldContainSubstring, "static is down")
+			So(c.Active(), ShouldBeNil)
+		})
+	})
+
+	Convey("An empty chain", t, func() {
+		c := Chain()
+		Convey("Should error", func() {
+			_, err := c.GetToken(context.Background())
+			So(err, ShouldNotBeNil)
+			So(c.GetURL(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// Config holds defaults for connecting to Cerberus, normally loaded from a config file with
+// LoadConfig. Any field can be left empty
+type Config struct {
+	// URL is the Cerberus URL
+	URL string `json:"url"`
+	// Region is the AWS region to authenticate in
+	Region string `json:"region"`
+	// resolved is set on configs returned by Resolve, so that they aren't resolved again
+	resolved bool
+}
+
+// DefaultConfigPath is where LoadConfig looks for the config file when no path is given,
+// relative to the user's home directory
+var DefaultConfigPath = filepath.Join(".cerberus", "config")
+
+// LoadConfig reads a config file from the given path, or from DefaultConfigPath in the user's
+// home directory if path is empty. The file can be JSON, or YAML with one "key: value" pair per
+// line, and its keys are url and region:
+//
+//	url: https://cerberus.example.com
+//	region: us-west-2
+//
+// A file that ends in .json or starts with "{" is read as JSON. Unknown keys are ignored. If
+// the file doesn't exist an empty Config is returned rather than an error, so that the file is
+// always optional
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return &Config{}, nil
+		}
+		path = filepath.Join(home, DefaultConfigPath)
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading Cerberus config file: %v", err)
+	}
+	cfg := &Config{}
+	if filepath.Ext(path) == ".json" || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("Error while parsing Cerberus config file %s: %v", path, err)
+		}
+		return cfg, nil
+	}
+	if err := parseYAMLConfig(data, cfg); err != nil {
+		return nil, fmt.Errorf("Error while parsing Cerberus config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// parseYAMLConfig parses the flat subset of YAML used by config files: "key: value" lines,
+// where the value can be quoted, along with blank lines and comments starting with "#"
+func parseYAMLConfig(data []byte, cfg *Config) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("line %d is not a \"key: value\" pair", line)
+		}
+		value := strings.TrimSpace(parts[1])
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "url":
+			cfg.URL = value
+		case "region":
+			cfg.Region = value
+		}
+	}
+	return scanner.Err()
+}
+
+// Resolve returns the URL and region to use, picking each one from the first place it is set,
+// in this order:
+//
+//  1. the cerberusURL and region passed to Resolve
+//  2. the CERBERUS_URL and AWS_REGION (or AWS_DEFAULT_REGION) environment variables
+//  3. the config itself, as loaded from the config file
+//  4. the defaults, which are no URL (an error when authenticating) and the region of the
+//     EC2 instance
+//
+// Pass the result to NewAWSAuthFromConfig to use exactly what was resolved. Resolving a config
+// that was already resolved returns it as is
+func (c *Config) Resolve(cerberusURL, region string) *Config {
+	if c.resolved {
+		return c
+	}
+	return &Config{
+		URL:      firstNonEmpty(cerberusURL, os.Getenv("CERBERUS_URL"), c.URL),
+		Region:   firstNonEmpty(region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), c.Region),
+		resolved: true,
+	}
+}
+
+// firstNonEmpty returns the first of the values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// NewAWSAuthFromConfig returns an AWSAuth like NewAWSAuth, with the URL and region taken from
+// the config. Unless the config came from Resolve, it is resolved first with nothing passed
+// explicitly, so environment variables take precedence over the config file. Pass
+// cfg.Resolve(cerberusURL, region) to give explicit values precedence over both. If no region
+// is set anywhere it is looked up from EC2 metadata
+func NewAWSAuthFromConfig(cfg *Config) (*AWSAuth, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	cfg = cfg.Resolve("", "")
+	if len(cfg.URL) == 0 {
+		return nil, fmt.Errorf("Cerberus URL cannot be empty")
+	}
+	parsedURL, err := utils.ValidateURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	return newAWSAuthInRegion(parsedURL, cfg.Region)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeConfig writes a config file into a new temporary directory and returns its path
+func writeConfig(name, contents string) string {
+	dir, err := ioutil.TempDir("", "cerberus-config")
+	So(err, ShouldBeNil)
+	Reset(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	So(ioutil.WriteFile(path, []byte(contents), 0600), ShouldBeNil)
+	return path
+}
+
+// clearConfigEnv unsets the environment variables that Resolve reads for the rest of the test,
+// putting back whatever was there afterwards
+func clearConfigEnv() {
+	for _, key := range []string{"CERBERUS_URL", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if old, ok := os.LookupEnv(key); ok {
+			key := key
+			Reset(func() { os.Setenv(key, old) })
+		} else {
+			key := key
+			Reset(func() { os.Unsetenv(key) })
+		}
+		os.Unsetenv(key)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	Convey("A YAML config file", t, func() {
+		path := writeConfig("config", "# Cerberus defaults\n---\nurl: https://cerberus.example.com\nregion: 'us-west-2' # Oregon\nother: ignored\n")
+		cfg, err := LoadConfig(path)
+		So(err, ShouldBeNil)
+		So(cfg.URL, ShouldEqual, "https://cerberus.example.com")
+		So(cfg.Region, ShouldEqual, "us-west-2")
+	})
+
+	Convey("A JSON config file", t, func() {
+		path := writeConfig("config", `{"url": "https://cerberus.example.com", "region": "us-east-1"}`)
+		cfg, err := LoadConfig(path)
+		So(err, ShouldBeNil)
+		So(cfg.URL, ShouldEqual, "https://cerberus.example.com")
+		So(cfg.Region, ShouldEqual, "us-east-1")
+	})
+
+	Convey("A missing config file", t, func() {
+		cfg, err := LoadConfig(filepath.Join(os.TempDir(), "no-such-dir", "config"))
+		Convey("Should return an empty config", func() {
+			So(err, ShouldBeNil)
+			So(cfg, ShouldResemble, &Config{})
+		})
+	})
+
+	Convey("The default path", t, func() {
+		home, err := ioutil.TempDir("", "cerberus-home")
+		So(err, ShouldBeNil)
+		old := os.Getenv("HOME")
+		os.Setenv("HOME", home)
+		Reset(func() {
+			os.Setenv("HOME", old)
+			os.RemoveAll(home)
+		})
+		So(os.MkdirAll(filepath.Join(home, ".cerberus"), 0700), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(home, DefaultConfigPath), []byte("region: eu-west-1\n"), 0600), ShouldBeNil)
+		cfg, err := LoadConfig("")
+		So(err, ShouldBeNil)
+		So(cfg.Region, ShouldEqual, "eu-west-1")
+	})
+
+	Convey("An invalid config file", t, func() {
+		Convey("Should error for bad YAML", func() {
+			cfg, err := LoadConfig(writeConfig("config", "region us-west-2\n"))
+			So(cfg, ShouldBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 1 is not a \"key: value\" pair")
+		})
+		Convey("Should error for bad JSON", func() {
+			cfg, err := LoadConfig(writeConfig("config.json", `url: https://cerberus.example.com`))
+			So(cfg, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestResolveConfig(t *testing.T) {
+	Convey("Resolving a config", t, func() {
+		clearConfigEnv()
+		cfg := &Config{URL: "https://file.example.com", Region: "file-region"}
+		Convey("Should use explicit values first", func() {
+			os.Setenv("CERBERUS_URL", "https://env.example.com")
+			os.Setenv("AWS_REGION", "env-region")
+			r := cfg.Resolve("https://explicit.example.com", "explicit-region")
+			So(r.URL, ShouldEqual, "https://explicit.example.com")
+			So(r.Region, ShouldEqual, "explicit-region")
+		})
+		Convey("Should use environment variables over the file", func() {
+			os.Setenv("CERBERUS_URL", "https://env.example.com")
+			os.Setenv("AWS_DEFAULT_REGION", "default-env-region")
+			r := cfg.Resolve("", "")
+			So(r.URL, ShouldEqual, "https://env.example.com")
+			So(r.Region, ShouldEqual, "default-env-region")
+			Convey("With AWS_REGION over AWS_DEFAULT_REGION", func() {
+				os.Setenv("AWS_REGION", "env-region")
+				So(cfg.Resolve("", "").Region, ShouldEqual, "env-region")
+			})
+		})
+		Convey("Should use the file when nothing else is set", func() {
+			r := cfg.Resolve("", "")
+			So(r.URL, ShouldEqual, "https://file.example.com")
+			So(r.Region, ShouldEqual, "file-region")
+		})
+		Convey("Should fall back to the defaults", func() {
+			r := (&Config{}).Resolve("", "")
+			So(r.URL, ShouldBeEmpty)
+			So(r.Region, ShouldBeEmpty)
+		})
+		Convey("Should not resolve twice", func() {
+			r := cfg.Resolve("https://explicit.example.com", "")
+			os.Setenv("CERBERUS_URL", "https://env.example.com")
+			So(r.Resolve("", "").URL, ShouldEqual, "https://explicit.example.com")
+		})
+	})
+
+	Convey("NewAWSAuthFromConfig", t, func() {
+		clearConfigEnv()
+		Convey("Should error without a URL anywhere", func() {
+			a, err := NewAWSAuthFromConfig(&Config{Region: "us-west-2"})
+			So(a, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Should validate the resolved URL", func() {
+			os.Setenv("CERBERUS_URL", "http://env.example.com")
+			a, err := NewAWSAuthFromConfig(&Config{URL: "https://file.example.com", Region: "us-west-2"})
+			So(a, ShouldBeNil)
+			So(err.Error(), ShouldContainSubstring, `"http" scheme`)
+		})
+	})
+}
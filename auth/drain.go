@@ -0,0 +1,142 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+)
+
+// RequestTracker is implemented by auth methods that keep track of the requests using their
+// token, so that logging out can wait for them to finish. See SetLogoutGracePeriod
+type RequestTracker interface {
+	// TrackRequest marks the start of a request using the current token. The returned
+	// function must be called once the request is done. It returns api.ErrorTokenDraining
+	// if the token is being logged out
+	TrackRequest() (func(), error)
+}
+
+// drainState tracks the requests in flight so a logout can wait for them
+type drainState struct {
+	lock     sync.Mutex
+	grace    time.Duration
+	draining bool
+	inFlight int
+	// generation goes up each time a logout finishes draining, so that requests started
+	// before then don't count against the requests started after it
+	generation int
+	// idle is closed when the last request in flight finishes while draining
+	idle chan struct{}
+}
+
+// SetLogoutGracePeriod makes Logout wait up to the given amount of time for requests that are
+// already using the token to finish before revoking it. While Logout is waiting, the token is
+// draining: GetToken, GetHeaders, and TrackRequest return api.ErrorTokenDraining so that no new
+// requests start with it. The token is revoked and cleared as soon as the last request in flight
+// finishes or the grace period is over, whichever is first. Only requests that were started with
+// TrackRequest (which the Cerberus client does for every request) are waited for. The default of
+// 0 revokes the token immediately
+func (t *tokenHolder) SetLogoutGracePeriod(d time.Duration) {
+	t.drain.lock.Lock()
+	defer t.drain.lock.Unlock()
+	t.drain.grace = d
+}
+
+// TrackRequest marks the start of a request using the current token. The returned function
+// must be called once the request is done. Returns api.ErrorTokenDraining if the token is
+// being logged out
+func (t *tokenHolder) TrackRequest() (func(), error) {
+	d := t.drain
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.draining {
+		return nil, api.ErrorTokenDraining
+	}
+	d.inFlight++
+	generation := d.generation
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			if generation != d.generation {
+				// This request outlived a logout's grace period and is no longer counted
+				return
+			}
+			d.inFlight--
+			if d.draining && d.inFlight == 0 {
+				close(d.idle)
+			}
+		})
+	}, nil
+}
+
+// checkDraining returns api.ErrorTokenDraining if the token is being logged out
+func (t *tokenHolder) checkDraining() error {
+	t.drain.lock.Lock()
+	defer t.drain.lock.Unlock()
+	if t.drain.draining {
+		return api.ErrorTokenDraining
+	}
+	return nil
+}
+
+// requestHeaders is withToken for headers that will be used for a new request, so it
+// refuses to hand out the token while it is draining
+func (t *tokenHolder) requestHeaders(headers http.Header) (http.Header, error) {
+	if err := t.checkDraining(); err != nil {
+		return nil, err
+	}
+	return t.withToken(headers)
+}
+
+// beginLogout starts draining the token and waits for the requests in flight to finish or
+// the grace period to end. The returned function ends the draining and must be called once
+// the logout is done
+func (t *tokenHolder) beginLogout() func() {
+	d := t.drain
+	d.lock.Lock()
+	if d.grace <= 0 || d.draining {
+		d.lock.Unlock()
+		return func() {}
+	}
+	d.draining = true
+	d.idle = make(chan struct{})
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+	idle, grace := d.idle, d.grace
+	d.lock.Unlock()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+	return func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.draining = false
+		// Requests that outlived the grace period no longer count against the next logout
+		d.inFlight = 0
+		d.generation++
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogoutGracePeriod(t *testing.T) {
+	Convey("A UserAuth with a logout grace period", t, WithServer(api.AuthUserSuccess, http.StatusNoContent, "", "/v1/auth", http.MethodDelete, map[string]string{"X-Vault-Token": "an-old-token"}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		c.setToken("an-old-token", 3600)
+		c.SetLogoutGracePeriod(5 * time.Second)
+		Convey("Should log out right away with nothing in flight", func() {
+			start := time.Now()
+			So(c.Logout(), ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(c.IsAuthenticated(), ShouldBeFalse)
+		})
+		Convey("Should wait for a request in flight and refuse new ones", func() {
+			done, err := c.TrackRequest()
+			So(err, ShouldBeNil)
+			logoutErr := make(chan error, 1)
+			go func() {
+				logoutErr <- c.Logout()
+			}()
+			// Wait for the logout to start draining
+			for c.checkDraining() == nil {
+				time.Sleep(time.Millisecond)
+			}
+			_, err = c.GetToken(context.Background())
+			So(err, ShouldEqual, api.ErrorTokenDraining)
+			_, err = c.GetHeaders()
+			So(err, ShouldEqual, api.ErrorTokenDraining)
+			_, err = c.TrackRequest()
+			So(err, ShouldEqual, api.ErrorTokenDraining)
+			So(c.IsAuthenticated(), ShouldBeTrue)
+			done()
+			select {
+			case err := <-logoutErr:
+				So(err, ShouldBeNil)
+			case <-time.After(time.Second):
+				t.Fatal("Logout did not finish after the request was done")
+			}
+			So(c.IsAuthenticated(), ShouldBeFalse)
+			So(c.checkDraining(), ShouldBeNil)
+		})
+		Convey("Should stop waiting after the grace period", func() {
+			c.SetLogoutGracePeriod(50 * time.Millisecond)
+			_, err := c.TrackRequest()
+			So(err, ShouldBeNil)
+			start := time.Now()
+			So(c.Logout(), ShouldBeNil)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+			So(c.IsAuthenticated(), ShouldBeFalse)
+		})
+		Convey("Should not let a request that outlived the grace period end the next logout early", func() {
+			c.SetLogoutGracePeriod(50 * time.Millisecond)
+			stale, err := c.TrackRequest()
+			So(err, ShouldBeNil)
+			So(c.Logout(), ShouldBeNil)
+			c.setToken("an-old-token", 3600)
+			c.SetLogoutGracePeriod(5 * time.Second)
+			done, err := c.TrackRequest()
+			So(err, ShouldBeNil)
+			stale()
+			logoutErr := make(chan error, 1)
+			go func() {
+				logoutErr <- c.Logout()
+			}()
+			for c.checkDraining() == nil {
+				time.Sleep(time.Millisecond)
+			}
+			select {
+			case <-logoutErr:
+				t.Fatal("Logout did not wait for the request in flight")
+			case <-time.After(50 * time.Millisecond):
+			}
+			done()
+			select {
+			case err := <-logoutErr:
+				So(err, ShouldBeNil)
+			case <-time.After(time.Second):
+				t.Fatal("Logout did not finish after the request was done")
+			}
+		})
+	}))
+}
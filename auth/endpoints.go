@@ -0,0 +1,96 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import "fmt"
+
+// APIVersion is a version of the Cerberus authentication API, which decides the paths that the
+// auth types log in and refresh at
+type APIVersion int
+
+const (
+	// APIVersion1 uses the /v1/auth endpoints, for older Cerberus servers. It only supports
+	// UserAuth and TokenAuth
+	APIVersion1 APIVersion = 1
+	// APIVersion2 uses the /v2/auth endpoints
+	APIVersion2 APIVersion = 2
+)
+
+// DefaultAPIVersion is the version of the authentication API used unless another is set
+const DefaultAPIVersion = APIVersion2
+
+// endpoints are the paths of the authentication endpoints for one APIVersion
+type endpoints struct {
+	awsLogin  string
+	stsLogin  string
+	userLogin string
+	mfaCheck  string
+	refresh   string
+	// logout, renew, and lookup are for the token itself, which only has v1 endpoints
+	logout string
+	renew  string
+	lookup string
+}
+
+// apiEndpoints has the endpoints for every supported APIVersion. Cerberus only has one logout
+// endpoint (DELETE /v1/auth), so every version uses it. Version 1 has no IAM principal or STS
+// login, so those are left empty
+var apiEndpoints = map[APIVersion]endpoints{
+	APIVersion1: {
+		userLogin: "/v1/auth/user",
+		mfaCheck:  "/v1/auth/mfa_check",
+		refresh:   "/v1/auth/user/refresh",
+		logout:    "/v1/auth",
+		renew:     "/v1/auth/token/self-renew",
+		lookup:    "/v1/auth/token/lookup-self",
+	},
+	APIVersion2: {
+		awsLogin:  "/v2/auth/iam-principal",
+		stsLogin:  "/v2/auth/sts-identity",
+		userLogin: "/v2/auth/user",
+		mfaCheck:  "/v2/auth/mfa_check",
+		refresh:   "/v2/auth/user/refresh",
+		logout:    "/v1/auth",
+		renew:     "/v1/auth/token/self-renew",
+		lookup:    "/v1/auth/token/lookup-self",
+	},
+}
+
+// ErrorEndpointUnsupported is returned when logging in with a method that the API version set
+// with SetAPIVersion doesn't have an endpoint for
+var ErrorEndpointUnsupported = fmt.Errorf("The authentication method is not supported by the configured API version")
+
+// endpointsFor returns the endpoints for the given version, or an error if it isn't supported
+func endpointsFor(v APIVersion) (endpoints, error) {
+	e, ok := apiEndpoints[v]
+	if !ok {
+		return endpoints{}, fmt.Errorf("Unsupported API version %d", v)
+	}
+	return e, nil
+}
+
+// SetAPIVersion changes which version of the authentication API is used to log in and refresh.
+// Logging out always uses DELETE /v1/auth. It should be called before authenticating. The
+// default is DefaultAPIVersion
+func (t *tokenHolder) SetAPIVersion(v APIVersion) error {
+	e, err := endpointsFor(v)
+	if err != nil {
+		return err
+	}
+	t.endpoints = e
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// versionedUserServer is a Cerberus that lets a user log in and refresh under the given auth
+// prefix (such as /v1/auth) and log out, and records the path of every request it gets
+func versionedUserServer(prefix string, paths *[]string, lock *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		*paths = append(*paths, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+		switch r.URL.Path {
+		case prefix + "/user":
+			fmt.Fprintf(w, validLogin, api.AuthUserSuccess, "a-token")
+		case prefix + "/user/refresh":
+			fmt.Fprintf(w, validLogin, api.AuthUserSuccess, "a-new-token")
+		case "/v1/auth":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestAPIVersion(t *testing.T) {
+	for _, version := range []APIVersion{APIVersion1, APIVersion2} {
+		prefix := fmt.Sprintf("/v%d/auth", version)
+		Convey(fmt.Sprintf("A user auth using API version %d", version), t, func() {
+			var lock sync.Mutex
+			var paths []string
+			ts := versionedUserServer(prefix, &paths, &lock)
+			defer ts.Close()
+			u, err := NewUserAuth(ts.URL, "user", "password")
+			So(err, ShouldBeNil)
+			So(u.SetAPIVersion(version), ShouldBeNil)
+			Convey("Should log in and refresh under "+prefix, func() {
+				_, err := u.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(u.Refresh(), ShouldBeNil)
+				So(u.Logout(), ShouldBeNil)
+				lock.Lock()
+				defer lock.Unlock()
+				So(paths, ShouldResemble, []string{
+					"GET " + prefix + "/user",
+					"GET " + prefix + "/user/refresh",
+					"DELETE /v1/auth",
+				})
+			})
+		})
+
+		Convey(fmt.Sprintf("A token auth using API version %d", version), t, func() {
+			var lock sync.Mutex
+			var paths []string
+			ts := versionedUserServer(prefix, &paths, &lock)
+			defer ts.Close()
+			a, err := NewTokenAuth(ts.URL, "a-token")
+			So(err, ShouldBeNil)
+			So(a.SetAPIVersion(version), ShouldBeNil)
+			Convey("Should log out under /v1/auth", func() {
+				So(a.Logout(), ShouldBeNil)
+				lock.Lock()
+				defer lock.Unlock()
+				So(paths, ShouldResemble, []string{"DELETE /v1/auth"})
+			})
+		})
+
+		Convey(fmt.Sprintf("The endpoints for API version %d", version), t, func() {
+			e, err := endpointsFor(version)
+			So(err, ShouldBeNil)
+			Convey("Should put the user endpoints under "+prefix, func() {
+				for _, path := range []string{e.userLogin, e.mfaCheck, e.refresh} {
+					So(strings.HasPrefix(path, prefix), ShouldBeTrue)
+				}
+			})
+			Convey("Should keep the token endpoints under v1", func() {
+				So(e.logout, ShouldEqual, "/v1/auth")
+				So(e.renew, ShouldEqual, "/v1/auth/token/self-renew")
+				So(e.lookup, ShouldEqual, "/v1/auth/token/lookup-self")
+			})
+		})
+	}
+
+	Convey("A new auth method", t, func() {
+		u, err := NewUserAuth("https://cerberus.example.com", "user", "password")
+		So(err, ShouldBeNil)
+		Convey("Should use the default API version", func() {
+			So(u.endpoints, ShouldResemble, apiEndpoints[DefaultAPIVersion])
+			So(u.endpoints.logout, ShouldEqual, "/v1/auth")
+		})
+		Convey("Should refuse an unsupported API version", func() {
+			So(u.SetAPIVersion(APIVersion(3)), ShouldNotBeNil)
+			So(u.endpoints, ShouldResemble, apiEndpoints[DefaultAPIVersion])
+		})
+	})
+}
+
+func TestAPIVersion1AWS(t *testing.T) {
+	Convey("An AWSAuth using API version 1", t, func() {
+		var requests int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		Reset(ts.Close)
+		a, err := NewAWSAuthWithRole(ts.URL, "ackbar", "mon-cala", nil)
+		So(err, ShouldBeNil)
+		So(a.SetAPIVersion(APIVersion1), ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should refuse to log in without making a request", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldEqual, ErrorEndpointUnsupported)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 0)
+		})
+	})
+}
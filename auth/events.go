@@ -0,0 +1,85 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// refreshEventBuffer is the number of events that will be held for a slow consumer.
+// Once the buffer is full, new events are dropped rather than blocking authentication
+const refreshEventBuffer = 10
+
+// RefreshEvent is sent every time an authentication method gets a new token, whether
+// that is from the initial login, a refresh, a renewal, or a reauthentication
+type RefreshEvent struct {
+	// Expiry is when the new token expires. It is the zero time if the expiry
+	// is not known (as is the case with TokenAuth until its token is renewed)
+	Expiry time.Time
+}
+
+// refreshNotifier is embedded in each of the auth types to provide RefreshEvents and Close
+type refreshNotifier struct {
+	lock   sync.Mutex
+	events chan RefreshEvent
+	closed bool
+}
+
+// init lazily creates the channel so the zero value is usable. The lock must be held
+func (r *refreshNotifier) init() {
+	if r.events == nil {
+		r.events = make(chan RefreshEvent, refreshEventBuffer)
+	}
+}
+
+// RefreshEvents returns a channel that receives a RefreshEvent each time a new token is
+// obtained. Events are buffered, and if the consumer falls behind new events are dropped
+// so that authentication is never blocked. The channel is closed when Close is called
+func (r *refreshNotifier) RefreshEvents() <-chan RefreshEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.init()
+	return r.events
+}
+
+// notify sends a RefreshEvent without blocking
+func (r *refreshNotifier) notify(expiry time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	r.init()
+	select {
+	case r.events <- RefreshEvent{Expiry: expiry}:
+	default:
+	}
+}
+
+// Close closes the channel returned by RefreshEvents. It is safe to call more than once
+func (r *refreshNotifier) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.init()
+	close(r.events)
+	r.closed = true
+	return nil
+}
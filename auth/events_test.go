@@ -0,0 +1,81 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRefreshEvents(t *testing.T) {
+	Convey("A refresh of a user token", t, WithServer(api.AuthUserSuccess, http.StatusOK, "a-new-token", "/v2/auth/user/refresh", http.MethodGet, map[string]string{}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		c.setToken("an-old-token", 3600)
+		events := c.RefreshEvents()
+		// Drain the event from setting the initial token
+		<-events
+		Convey("Should emit an event with the new expiry", func() {
+			So(c.Refresh(), ShouldBeNil)
+			So(events, ShouldHaveLength, 1)
+			ev := <-events
+			_, expiry, _ := c.loadToken()
+			So(ev.Expiry, ShouldEqual, expiry)
+		})
+	}))
+
+	Convey("A consumer that never reads events", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		Convey("Should not block authentication", func() {
+			for i := 0; i < refreshEventBuffer*2; i++ {
+				c.setToken("a-token", 3600)
+			}
+			So(c.RefreshEvents(), ShouldHaveLength, refreshEventBuffer)
+		})
+	})
+
+	Convey("A closed notifier", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		events := c.RefreshEvents()
+		So(c.Close(), ShouldBeNil)
+		Convey("Should close the channel", func() {
+			_, ok := <-events
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Should not panic when notified or closed again", func() {
+			So(func() { c.setToken("a-token", 3600) }, ShouldNotPanic)
+			So(c.Close(), ShouldBeNil)
+		})
+	})
+
+	Convey("A TokenAuth refresh", t, WithServer(api.AuthUserSuccess, http.StatusOK, "a-new-token", "/v2/auth/user/refresh", http.MethodGet, map[string]string{}, func(ts *httptest.Server) {
+		c, _ := NewTokenAuth(ts.URL, "a-token")
+		So(c, ShouldNotBeNil)
+		Convey("Should emit an event with an unknown expiry", func() {
+			So(c.Refresh(), ShouldBeNil)
+			ev := <-c.RefreshEvents()
+			So(ev.Expiry, ShouldResemble, time.Time{})
+		})
+	}))
+}
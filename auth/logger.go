@@ -0,0 +1,159 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// Logger receives diagnostic messages from an auth method. They are only meant for
+// debugging, and never include tokens or credentials
+type Logger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// WarnLogger is a Logger that can also receive warnings, which are about things that should
+// never happen in production, such as TLS certificate verification being turned off
+type WarnLogger interface {
+	Logger
+	Warnf(format string, args ...interface{})
+}
+
+// Warnf logs a warning to l. A Logger that isn't a WarnLogger gets the warning as a debug
+// message starting with "WARNING: "
+func Warnf(l Logger, format string, args ...interface{}) {
+	if w, ok := l.(WarnLogger); ok {
+		w.Warnf(format, args...)
+		return
+	}
+	l.Debugf("WARNING: "+format, args...)
+}
+
+// NopLogger is a Logger that discards everything, which is the default
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+// NewStdLogger returns a Logger that writes debug messages and warnings to a *log.Logger
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Debugf(format string, args ...interface{}) {
+	s.l.Printf("[DEBUG] "+format, args...)
+}
+
+func (s stdLogger) Warnf(format string, args ...interface{}) {
+	s.l.Printf("[WARN] "+format, args...)
+}
+
+// loggingState holds the Logger an auth method writes to
+type loggingState struct {
+	lock   sync.RWMutex
+	logger Logger
+}
+
+// SetLogger sets where diagnostic messages are written, including a RequestEvent for every
+// request made to Cerberus. Nothing is logged by default, and passing nil goes back to
+// discarding everything. It should be set before the auth method is used
+func (t *tokenHolder) SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger
+	}
+	t.logging.lock.Lock()
+	defer t.logging.lock.Unlock()
+	t.logging.logger = l
+}
+
+// logger returns the current Logger
+func (t *tokenHolder) logger() Logger {
+	t.logging.lock.RLock()
+	defer t.logging.lock.RUnlock()
+	return t.logging.logger
+}
+
+// debugf logs a debug message to the current Logger
+func (t *tokenHolder) debugf(format string, args ...interface{}) {
+	t.logger().Debugf(format, args...)
+}
+
+// send sends an authentication request with the given HTTP client and logs it to l as the
+// given attempt. Every request made while authenticating, refreshing, renewing, looking up,
+// or logging out goes through here
+func send(ctx context.Context, client *http.Client, l Logger, req *http.Request, attempt int) (*http.Response, error) {
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	LogRequest(l, req, resp, err, start, attempt)
+	return resp, err
+}
+
+// RequestEvent describes a finished request to Cerberus. It is what LogRequest logs, encoded as
+// JSON. Tokens and credentials are redacted from the URL and headers
+type RequestEvent struct {
+	Event      string      `json:"event"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Status     int         `json:"status,omitempty"`
+	DurationMS float64     `json:"duration_ms"`
+	Attempt    int         `json:"attempt"`
+	Header     http.Header `json:"request_headers,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// LogRequest logs a RequestEvent for a request that was started at start to l at debug level,
+// as a line of JSON. attempt is the number of the attempt, starting at 1, and resp and err are
+// what sending the request returned. The values of token and credential headers never appear
+// in the output, even if they made their way into an error message
+func LogRequest(l Logger, req *http.Request, resp *http.Response, err error, start time.Time, attempt int) {
+	if l == nil || l == NopLogger {
+		// Don't bother building an event that would be thrown away
+		return
+	}
+	event := RequestEvent{
+		Event:      "request",
+		Method:     req.Method,
+		URL:        utils.RedactURL(req.URL),
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+		Attempt:    attempt,
+		Header:     utils.RedactHeaders(req.Header),
+	}
+	if resp != nil {
+		event.Status = resp.StatusCode
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	line, jsonErr := json.Marshal(event)
+	if jsonErr != nil {
+		l.Debugf("Error while encoding request event: %v", jsonErr)
+		return
+	}
+	l.Debugf("%s", utils.RedactSecrets(string(line), req.Header))
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) {
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func TestAWSAuthLogger(t *testing.T) {
+	Convey("An AWSAuth with a logger", t, TestingServer(http.StatusOK, "/v2/auth/iam-principal", http.MethodPost, fakeAuthBody, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "boba-fett", "slave-1", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		l := &recordingLogger{}
+		a.SetLogger(l)
+		Convey("Should log debug messages while authenticating", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(l.messages, ShouldNotBeEmpty)
+			So(strings.Join(l.messages, "\n"), ShouldContainSubstring, "boba-fett")
+			Convey("And should never log the token", func() {
+				So(strings.Join(l.messages, "\n"), ShouldNotContainSubstring, "a-cool-token")
+			})
+		})
+		Convey("Should go back to discarding messages when set to nil", func() {
+			a.SetLogger(nil)
+			So(a.logger(), ShouldResemble, NopLogger)
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(l.messages, ShouldBeEmpty)
+		})
+	}))
+}
+
+func TestWarnf(t *testing.T) {
+	Convey("A logger that only takes debug messages", t, func() {
+		l := &recordingLogger{}
+		Convey("Should get warnings as debug messages", func() {
+			Warnf(l, "Shields at %d%%", 10)
+			So(l.messages, ShouldResemble, []string{"WARNING: Shields at 10%"})
+		})
+	})
+}
+
+func TestStdLogger(t *testing.T) {
+	Convey("A standard library logger", t, func() {
+		buf := &bytes.Buffer{}
+		l := NewStdLogger(log.New(buf, "", 0))
+		Convey("Should write debug messages", func() {
+			l.Debugf("Looking for %s", "droids")
+			So(buf.String(), ShouldEqual, "[DEBUG] Looking for droids\n")
+		})
+		Convey("Should write warnings", func() {
+			Warnf(l, "These aren't the %s", "droids")
+			So(buf.String(), ShouldEqual, "[WARN] These aren't the droids\n")
+		})
+	})
+}
+
+func TestLogRequest(t *testing.T) {
+	Convey("A request with a token in its headers", t, func() {
+		buf := &bytes.Buffer{}
+		l := NewStdLogger(log.New(buf, "", 0))
+		req, err := http.NewRequest(http.MethodGet, "https://cerberus.example.com/v1/secret/app/sdb?token=a-cool-token", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-Vault-Token", "a-cool-token")
+		Convey("Should log a JSON event for the response", func() {
+			LogRequest(l, req, &http.Response{StatusCode: http.StatusOK}, nil, time.Now().Add(-time.Second), 2)
+			So(buf.String(), ShouldStartWith, "[DEBUG] ")
+			event := RequestEvent{}
+			So(json.Unmarshal([]byte(strings.TrimPrefix(buf.String(), "[DEBUG] ")), &event), ShouldBeNil)
+			So(event.Event, ShouldEqual, "request")
+			So(event.Method, ShouldEqual, http.MethodGet)
+			So(event.URL, ShouldEqual, "https://cerberus.example.com/v1/secret/app/sdb?token=REDACTED")
+			So(event.Status, ShouldEqual, http.StatusOK)
+			So(event.Attempt, ShouldEqual, 2)
+			So(event.DurationMS, ShouldBeGreaterThanOrEqualTo, 1000)
+			So(event.Header.Get("X-Vault-Token"), ShouldEqual, "REDACTED")
+			Convey("And should never include the token", func() {
+				So(buf.String(), ShouldNotContainSubstring, "a-cool-token")
+			})
+		})
+		Convey("Should never include the token, even in an error", func() {
+			LogRequest(l, req, nil, errors.New("the token a-cool-token was rejected"), time.Now(), 1)
+			So(buf.String(), ShouldContainSubstring, "the token REDACTED was rejected")
+			So(buf.String(), ShouldNotContainSubstring, "a-cool-token")
+		})
+	})
+}
+
+// requestEvents returns the RequestEvents that were logged to l, in order
+func requestEvents(l *recordingLogger) []RequestEvent {
+	events := []RequestEvent{}
+	for _, m := range l.messages {
+		event := RequestEvent{}
+		if json.Unmarshal([]byte(m), &event) == nil && event.Event == "request" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestAuthRequestLogging(t *testing.T) {
+	Convey("A UserAuth with a logger", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch r.URL.Path {
+			case "/v2/auth/user":
+				fmt.Fprintf(w, validLoginMFA, api.AuthUserNeedsMFA, "a-state-token")
+			case "/v2/auth/mfa_check":
+				fmt.Fprintf(w, validLogin, api.AuthUserSuccess, "a-token")
+			case "/v2/auth/user/refresh":
+				fmt.Fprintf(w, validLogin, api.AuthUserSuccess, "a-new-token")
+			case "/v1/auth/token/self-renew":
+				fmt.Fprint(w, `{"auth": {"client_token": "a-new-token", "policies": ["web"], "lease_duration": 7200}}`)
+			case "/v1/auth/token/lookup-self":
+				fmt.Fprint(w, `{"data": {"id": "a-new-token", "policies": ["web"], "ttl": 7200}}`)
+			case "/v1/auth":
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		Reset(ts.Close)
+		u, err := NewUserAuth(ts.URL, "user", "a-password")
+		So(err, ShouldBeNil)
+		u.SetMFAInput(strings.NewReader("123456\n"))
+		l := &recordingLogger{}
+		u.SetLogger(l)
+		Convey("Should log every request it makes", func() {
+			_, err := u.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(u.Refresh(), ShouldBeNil)
+			So(u.RenewToken(time.Hour), ShouldBeNil)
+			_, err = u.Lookup()
+			So(err, ShouldBeNil)
+			So(u.Logout(), ShouldBeNil)
+			events := requestEvents(l)
+			So(events, ShouldHaveLength, 6)
+			for i, want := range []string{"/v2/auth/user", "/v2/auth/mfa_check", "/v2/auth/user/refresh", "/v1/auth/token/self-renew", "/v1/auth/token/lookup-self", "/v1/auth"} {
+				So(events[i].URL, ShouldEqual, ts.URL+want)
+				So(events[i].Status, ShouldBeGreaterThanOrEqualTo, http.StatusOK)
+				So(events[i].Attempt, ShouldEqual, 1)
+			}
+			So(events[0].Header.Get("Authorization"), ShouldEqual, "REDACTED")
+			So(events[2].Header.Get("X-Vault-Token"), ShouldEqual, "REDACTED")
+			Convey("And should never log the tokens or credentials", func() {
+				logged := strings.Join(l.messages, "\n")
+				for _, secret := range []string{"a-password", base64.StdEncoding.EncodeToString([]byte("user:a-password")), "a-state-token", "123456", "a-token", "a-new-token"} {
+					So(logged, ShouldNotContainSubstring, secret)
+				}
+			})
+		})
+	})
+
+	Convey("An AWSSTSAuth with a logger", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(stsResponseBody))
+		}))
+		Reset(ts.Close)
+		a := newTestSTSAuth(ts.URL)
+		l := &recordingLogger{}
+		a.SetLogger(l)
+		Convey("Should log the login without the signed headers", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			events := requestEvents(l)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Method, ShouldEqual, http.MethodPost)
+			So(events[0].URL, ShouldEqual, ts.URL+"/v2/auth/sts-identity")
+			So(events[0].Header.Get("Authorization"), ShouldEqual, "REDACTED")
+			logged := strings.Join(l.messages, "\n")
+			So(logged, ShouldNotContainSubstring, "a-cool-token")
+			So(logged, ShouldNotContainSubstring, "a-session-token")
+		})
+	})
+}
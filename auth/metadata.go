@@ -0,0 +1,72 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+)
+
+// metadataState holds the metadata Cerberus returned with the current token. It is nil
+// until there has been an auth response
+type metadataState struct {
+	lock     sync.RWMutex
+	metadata *api.UserMetadata
+}
+
+// setMetadata replaces the metadata of the current token. Nil means it isn't known
+func (t *tokenHolder) setMetadata(metadata *api.UserMetadata) {
+	t.metadata.lock.Lock()
+	defer t.metadata.lock.Unlock()
+	t.metadata.metadata = metadata
+}
+
+// setAWSMetadata replaces the metadata of the current token with what came back from logging
+// in with AWS
+func (t *tokenHolder) setAWSMetadata(metadata api.AWSMetadata) {
+	t.setMetadata(&api.UserMetadata{
+		Username: metadata.Username,
+		IsAdmin:  metadata.IsAdmin,
+		Groups:   metadata.Groups,
+	})
+}
+
+// IsAdmin returns whether the current token belongs to a Cerberus admin, going by the is_admin
+// flag in the metadata of the last auth response. Cerberus sends the flag as a string, which is
+// parsed here so callers don't have to. Returns ErrorUnauthenticated if there hasn't been an
+// auth response for the current token yet (such as for a token passed to NewTokenAuth that
+// hasn't been refreshed), and an error if the response didn't include the flag
+func (t *tokenHolder) IsAdmin() (bool, error) {
+	t.metadata.lock.RLock()
+	defer t.metadata.lock.RUnlock()
+	if t.metadata.metadata == nil {
+		return false, api.ErrorUnauthenticated
+	}
+	flag := strings.TrimSpace(t.metadata.metadata.IsAdmin)
+	if flag == "" {
+		return false, fmt.Errorf("Cerberus did not say whether the token belongs to an admin")
+	}
+	isAdmin, err := strconv.ParseBool(flag)
+	if err != nil {
+		return false, fmt.Errorf("Error while parsing the is_admin metadata %q: %v", flag, err)
+	}
+	return isAdmin, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIsAdmin(t *testing.T) {
+	Convey("An auth response for an admin", t, func() {
+		h := newTokenHolder()
+		h.setMetadata(&api.UserMetadata{Username: "yoda", IsAdmin: "true"})
+		Convey("Should be an admin", func() {
+			isAdmin, err := h.IsAdmin()
+			So(err, ShouldBeNil)
+			So(isAdmin, ShouldBeTrue)
+		})
+		Convey("Should no longer be known once the token is cleared", func() {
+			So(h.clearToken(), ShouldBeNil)
+			_, err := h.IsAdmin()
+			So(err, ShouldEqual, api.ErrorUnauthenticated)
+		})
+	})
+	Convey("An auth response for someone who isn't an admin", t, func() {
+		h := newTokenHolder()
+		h.setAWSMetadata(api.AWSMetadata{PrincipalARN: "arn:aws:iam::123456789012:role/padawan", IsAdmin: "false"})
+		isAdmin, err := h.IsAdmin()
+		So(err, ShouldBeNil)
+		So(isAdmin, ShouldBeFalse)
+	})
+	Convey("An auth response without the admin flag", t, func() {
+		h := newTokenHolder()
+		h.setMetadata(&api.UserMetadata{Username: "yoda"})
+		_, err := h.IsAdmin()
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, api.ErrorUnauthenticated)
+	})
+	Convey("An admin flag that isn't a boolean", t, func() {
+		h := newTokenHolder()
+		h.setMetadata(&api.UserMetadata{IsAdmin: "maybe"})
+		_, err := h.IsAdmin()
+		So(err, ShouldNotBeNil)
+	})
+	Convey("No auth response", t, func() {
+		h := newTokenHolder()
+		_, err := h.IsAdmin()
+		So(err, ShouldEqual, api.ErrorUnauthenticated)
+	})
+	Convey("A UserAuth that logged in", t, WithServer(api.AuthUserSuccess, http.StatusOK, "a-cool-token", "/v2/auth/user", http.MethodGet, map[string]string{}, func(ts *httptest.Server) {
+		u, err := NewUserAuth(ts.URL, "user", "password")
+		So(err, ShouldBeNil)
+		_, err = u.GetToken(context.Background())
+		So(err, ShouldBeNil)
+		Convey("Should parse the admin flag from the login response", func() {
+			isAdmin, err := u.IsAdmin()
+			So(err, ShouldBeNil)
+			So(isAdmin, ShouldBeFalse)
+		})
+	}))
+}
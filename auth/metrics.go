@@ -0,0 +1,113 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// MetricsRecorder receives metrics about requests made to Cerberus and about authenticating,
+// so that they can be exported to a monitoring system. The prometheus subpackage has a ready
+// made implementation. Implementations must be safe for concurrent use and should return
+// quickly, since they are called while requests are being made
+type MetricsRecorder interface {
+	// ObserveRequest records a request to an endpoint (the path of the request, such as
+	// /v2/auth/iam-principal) that finished with the given HTTP status code after dur. The
+	// status is 0 if no response was received
+	ObserveRequest(endpoint string, status int, dur time.Duration)
+	// IncAuth counts an auth event. The result is one of the AuthResult values
+	IncAuth(result string)
+}
+
+// The results passed to MetricsRecorder.IncAuth. Every attempt to log in is counted as either
+// a success or a failure. A refresh is counted whenever a token is refreshed, whether or not
+// it worked; for the auth types that refresh by logging in again, the login is counted too.
+// A renewal is counted whenever a token's lease is renewed, whether or not it worked. A logout
+// is counted when a token is revoked
+const (
+	AuthResultSuccess = "success"
+	AuthResultFailure = "failure"
+	AuthResultRefresh = "refresh"
+	AuthResultRenew   = "renew"
+	AuthResultLogout  = "logout"
+)
+
+// NopMetrics is a MetricsRecorder that discards everything, which is the default
+var NopMetrics MetricsRecorder = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRequest(endpoint string, status int, dur time.Duration) {}
+
+func (nopMetrics) IncAuth(result string) {}
+
+// metricsState holds the MetricsRecorder of an auth type. It is shared by pointer so that
+// copies of a tokenHolder all report to the same recorder
+type metricsState struct {
+	lock     sync.RWMutex
+	recorder MetricsRecorder
+}
+
+// SetMetricsRecorder sets where metrics about authenticating and the requests made to do it
+// are sent. Nothing is recorded by default, and passing nil goes back to discarding everything
+func (t *tokenHolder) SetMetricsRecorder(m MetricsRecorder) {
+	if m == nil {
+		m = NopMetrics
+	}
+	t.metrics.lock.Lock()
+	defer t.metrics.lock.Unlock()
+	t.metrics.recorder = m
+}
+
+// metricsRecorder returns the current MetricsRecorder
+func (t *tokenHolder) metricsRecorder() MetricsRecorder {
+	t.metrics.lock.RLock()
+	defer t.metrics.lock.RUnlock()
+	return t.metrics.recorder
+}
+
+// incAuth counts an auth event with the current MetricsRecorder
+func (t *tokenHolder) incAuth(result string) {
+	t.metricsRecorder().IncAuth(result)
+}
+
+// recordAuth counts the outcome of logging in and returns its error unchanged
+func (t *tokenHolder) recordAuth(err error) error {
+	if err != nil {
+		t.incAuth(AuthResultFailure)
+	} else {
+		t.incAuth(AuthResultSuccess)
+	}
+	return err
+}
+
+// observeRequest records a request that was started at start and got the given response,
+// which is nil if the request failed without one, with the metrics recorder and on the span
+// in the context
+func observeRequest(ctx context.Context, m MetricsRecorder, endpoint string, resp *http.Response, start time.Time) {
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	m.ObserveRequest(endpoint, status, time.Since(start))
+	utils.CurrentSpan(ctx).SetRequest(endpoint, status)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRecorder is a MetricsRecorder that keeps everything it is given
+type fakeRecorder struct {
+	lock     sync.Mutex
+	requests []string
+	auth     []string
+}
+
+func (f *fakeRecorder) ObserveRequest(endpoint string, status int, dur time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests = append(f.requests, fmt.Sprintf("%s %d", endpoint, status))
+}
+
+func (f *fakeRecorder) IncAuth(result string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.auth = append(f.auth, result)
+}
+
+// userServer is a Cerberus that lets a user log in, refresh, and log out, or rejects every
+// login if loginCode isn't a 200
+func userServer(loginCode int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/auth/user":
+			w.WriteHeader(loginCode)
+			if loginCode == http.StatusOK {
+				fmt.Fprintf(w, validLogin, api.AuthUserSuccess, "a-token")
+			}
+		case "/v2/auth/user/refresh":
+			fmt.Fprintf(w, validLogin, api.AuthUserSuccess, "a-new-token")
+		case "/v1/auth":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	Convey("A user auth with a metrics recorder", t, func() {
+		ts := userServer(http.StatusOK)
+		defer ts.Close()
+		recorder := &fakeRecorder{}
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		u.SetMetricsRecorder(recorder)
+		_, err := u.GetToken(context.Background())
+		So(err, ShouldBeNil)
+		Convey("Should record a successful login", func() {
+			So(recorder.auth, ShouldResemble, []string{AuthResultSuccess})
+			So(recorder.requests, ShouldResemble, []string{"/v2/auth/user 200"})
+		})
+		Convey("Should record a refresh", func() {
+			So(u.Refresh(), ShouldBeNil)
+			So(recorder.auth, ShouldResemble, []string{AuthResultSuccess, AuthResultRefresh})
+			So(recorder.requests[1:], ShouldResemble, []string{"/v2/auth/user/refresh 200"})
+		})
+		Convey("Should record a logout", func() {
+			So(u.Logout(), ShouldBeNil)
+			So(recorder.auth, ShouldResemble, []string{AuthResultSuccess, AuthResultLogout})
+			So(recorder.requests[1:], ShouldResemble, []string{"/v1/auth 204"})
+		})
+		Convey("Should record a logout with a timeout", func() {
+			So(u.LogoutWithTimeout(time.Second), ShouldBeNil)
+			So(recorder.auth, ShouldResemble, []string{AuthResultSuccess, AuthResultLogout})
+		})
+	})
+
+	Convey("A failed login", t, func() {
+		ts := userServer(http.StatusUnauthorized)
+		defer ts.Close()
+		recorder := &fakeRecorder{}
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		u.SetMetricsRecorder(recorder)
+		_, err := u.GetToken(context.Background())
+		So(err, ShouldNotBeNil)
+		Convey("Should be recorded as a failure", func() {
+			So(recorder.auth, ShouldResemble, []string{AuthResultFailure})
+			So(recorder.requests, ShouldResemble, []string{"/v2/auth/user 401"})
+		})
+	})
+
+	Convey("A login that doesn't get a response", t, func() {
+		ts := userServer(http.StatusOK)
+		ts.Close()
+		recorder := &fakeRecorder{}
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		u.SetMetricsRecorder(recorder)
+		_, err := u.GetToken(context.Background())
+		So(err, ShouldNotBeNil)
+		Convey("Should be recorded with a status of 0", func() {
+			So(recorder.auth, ShouldResemble, []string{AuthResultFailure})
+			So(recorder.requests, ShouldResemble, []string{"/v2/auth/user 0"})
+		})
+	})
+
+	Convey("A token auth with a metrics recorder", t, func() {
+		ts := userServer(http.StatusOK)
+		defer ts.Close()
+		recorder := &fakeRecorder{}
+		a, _ := NewTokenAuth(ts.URL, "a-token")
+		a.SetMetricsRecorder(recorder)
+		Convey("Should record a refresh and a logout", func() {
+			So(a.Refresh(), ShouldBeNil)
+			So(a.Logout(), ShouldBeNil)
+			So(recorder.auth, ShouldResemble, []string{AuthResultRefresh, AuthResultLogout})
+			So(recorder.requests, ShouldResemble, []string{"/v2/auth/user/refresh 200", "/v1/auth 204"})
+		})
+	})
+
+	Convey("Setting a nil recorder", t, func() {
+		a, _ := NewTokenAuth("https://test.example.com", "a-token")
+		a.SetMetricsRecorder(nil)
+		Convey("Should go back to discarding metrics", func() {
+			So(a.metricsRecorder() == NopMetrics, ShouldBeTrue)
+		})
+	})
+}
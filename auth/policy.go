@@ -0,0 +1,91 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// policyState holds the policies granted to the current token
+type policyState struct {
+	lock     sync.RWMutex
+	policies []string
+}
+
+// setPolicies replaces the policies granted to the current token. Cerberus returns policies
+// as a list, but an entry can also be a comma separated string of several policies, so every
+// entry is split and trimmed to get one name per policy
+func (t *tokenHolder) setPolicies(policies []string) {
+	var names []string
+	for _, p := range policies {
+		for _, name := range strings.Split(p, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	t.policies.lock.Lock()
+	defer t.policies.lock.Unlock()
+	t.policies.policies = names
+}
+
+// clearToken removes the current token from the store along with its policies and metadata
+func (t *tokenHolder) clearToken() error {
+	t.setPolicies(nil)
+	t.setMetadata(nil)
+	return t.store.Clear()
+}
+
+// Policies returns the names of the policies granted to the current token, as returned by
+// Cerberus when the token was issued or last refreshed. This is empty if there is no token
+// or the policies aren't known, such as for a token passed to NewTokenAuth that hasn't been
+// refreshed
+func (t *tokenHolder) Policies() []string {
+	t.policies.lock.RLock()
+	defer t.policies.lock.RUnlock()
+	return append([]string(nil), t.policies.policies...)
+}
+
+// HasPolicy returns whether the current token was granted the policy with the given name.
+// This only checks the policies returned with the token and doesn't contact Cerberus
+func (t *tokenHolder) HasPolicy(name string) bool {
+	t.policies.lock.RLock()
+	defer t.policies.lock.RUnlock()
+	for _, p := range t.policies.policies {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPolicy returns whether the current token was granted any policy matching the given
+// glob, such as "*-read". The glob uses the syntax of path.Match, so "*" matches any run of
+// characters, "?" matches any single character, and "[...]" matches a character class. An
+// invalid glob never matches. Like HasPolicy, this doesn't contact Cerberus
+func (t *tokenHolder) MatchPolicy(glob string) bool {
+	t.policies.lock.RLock()
+	defer t.policies.lock.RUnlock()
+	for _, p := range t.policies.policies {
+		if ok, err := path.Match(glob, p); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicies(t *testing.T) {
+	Convey("A token with policies", t, func() {
+		h := newTokenHolder()
+		h.setPolicies([]string{"foo-bar-read", "lookup-self"})
+		Convey("Should return the policies", func() {
+			So(h.Policies(), ShouldResemble, []string{"foo-bar-read", "lookup-self"})
+		})
+		Convey("Should match exact names", func() {
+			So(h.HasPolicy("lookup-self"), ShouldBeTrue)
+			So(h.HasPolicy("lookup"), ShouldBeFalse)
+		})
+		Convey("Should match globs", func() {
+			So(h.MatchPolicy("*-read"), ShouldBeTrue)
+			So(h.MatchPolicy("foo-*-read"), ShouldBeTrue)
+			So(h.MatchPolicy("*-write"), ShouldBeFalse)
+			So(h.MatchPolicy("lookup-sel?"), ShouldBeTrue)
+		})
+		Convey("Should never match an invalid glob", func() {
+			So(h.MatchPolicy("[foo"), ShouldBeFalse)
+		})
+		Convey("Should not be affected by changing the returned list", func() {
+			h.Policies()[0] = "root"
+			So(h.HasPolicy("root"), ShouldBeFalse)
+		})
+		Convey("Should have no policies once the token is cleared", func() {
+			So(h.clearToken(), ShouldBeNil)
+			So(h.Policies(), ShouldBeEmpty)
+			So(h.MatchPolicy("*"), ShouldBeFalse)
+		})
+	})
+	Convey("Comma joined policies", t, func() {
+		h := newTokenHolder()
+		h.setPolicies([]string{"foo-bar-read, lookup-self", "", " web "})
+		Convey("Should be split into separate names", func() {
+			So(h.Policies(), ShouldResemble, []string{"foo-bar-read", "lookup-self", "web"})
+			So(h.HasPolicy("lookup-self"), ShouldBeTrue)
+			So(h.HasPolicy("web"), ShouldBeTrue)
+		})
+	})
+}
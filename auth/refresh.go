@@ -0,0 +1,129 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorAutoRefreshRunning is returned when StartAutoRefresh is called while auto refresh
+// is already running
+var ErrorAutoRefreshRunning = fmt.Errorf("Auto refresh is already running")
+
+// autoRefreshRetryDelay is how long the auto refresher waits to try again after a failed refresh
+const autoRefreshRetryDelay = 10 * time.Second
+
+// autoRefreshMinInterval keeps the auto refresher from hammering Cerberus when the leeway is
+// longer than the lifetime of the tokens being handed out
+const autoRefreshMinInterval = 1 * time.Second
+
+// autoRefreshErrorBuffer is the number of refresh errors that will be held for a slow consumer.
+// Once the buffer is full, new errors are dropped
+const autoRefreshErrorBuffer = 10
+
+// autoRefresher is embedded in auth types that can refresh their token in the background
+type autoRefresher struct {
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start runs the refresh loop in a goroutine. expiry returns when the current token expires
+// (the zero time if there isn't one) and refresh gets a new token
+func (r *autoRefresher) start(ctx context.Context, leeway time.Duration, expiry func() time.Time, refresh func(context.Context) error) (<-chan error, error) {
+	if leeway < 0 {
+		return nil, fmt.Errorf("Auto refresh leeway cannot be negative, got %v", leeway)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cancel != nil {
+		return nil, ErrorAutoRefreshRunning
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	errs := make(chan error, autoRefreshErrorBuffer)
+	r.cancel = cancel
+	r.done = done
+	go func() {
+		defer close(done)
+		defer close(errs)
+		defer r.finished(done)
+		// The first refresh happens as soon as it is due, but any after that are spaced out
+		minWait := time.Duration(0)
+		for {
+			wait := time.Until(expiry().Add(-leeway))
+			if wait < minWait {
+				wait = minWait
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			minWait = autoRefreshMinInterval
+			if err := refresh(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case errs <- err:
+				default:
+				}
+				// Wait out the retry delay even if the old token still has time left
+				timer := time.NewTimer(autoRefreshRetryDelay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				minWait = 0
+			}
+		}
+	}()
+	return errs, nil
+}
+
+// finished forgets about a refresh loop that has exited on its own because its context was
+// done, so that auto refresh can be started again
+func (r *autoRefresher) finished(done chan struct{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.done == done {
+		r.cancel()
+		r.cancel, r.done = nil, nil
+	}
+}
+
+// StopAutoRefresh stops auto refresh and waits for the background goroutine to exit. It does
+// nothing if auto refresh isn't running
+func (r *autoRefresher) StopAutoRefresh() {
+	r.lock.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.lock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
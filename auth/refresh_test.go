@@ -0,0 +1,116 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAutoRefreshAWS(t *testing.T) {
+	Convey("An AWSAuth with a token about to expire", t, func() {
+		var hits int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Write([]byte(fakeAuthBody))
+		}))
+		Reset(ts.Close)
+		a, err := NewAWSAuthWithRole(ts.URL, "greedo", "mos-eisley", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		a.store.Store("old-token", time.Now().Add(100*time.Millisecond))
+		errs, err := a.StartAutoRefresh(context.Background(), 50*time.Millisecond)
+		So(err, ShouldBeNil)
+		Reset(a.StopAutoRefresh)
+		Convey("Should reauthenticate before it expires", func() {
+			So(waitFor(func() bool { return atomic.LoadInt32(&hits) == 1 }), ShouldBeTrue)
+			So(waitFor(func() bool {
+				tok, _, _ := a.loadToken()
+				return tok == "a-cool-token"
+			}), ShouldBeTrue)
+			So(a.IsAuthenticated(), ShouldBeTrue)
+			Convey("And should not refresh the new token early", func() {
+				time.Sleep(100 * time.Millisecond)
+				So(atomic.LoadInt32(&hits), ShouldEqual, 1)
+			})
+		})
+		Convey("Should not start twice", func() {
+			_, err := a.StartAutoRefresh(context.Background(), time.Second)
+			So(err, ShouldEqual, ErrorAutoRefreshRunning)
+		})
+		Convey("Should close the error channel when stopped", func() {
+			a.StopAutoRefresh()
+			_, ok := <-errs
+			So(ok, ShouldBeFalse)
+			Convey("And should be able to start again", func() {
+				_, err := a.StartAutoRefresh(context.Background(), time.Second)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("An AWSAuth that fails to refresh", t, TestingServer(http.StatusInternalServerError, "/v2/auth/iam-principal", http.MethodPost, "", map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewAWSAuthWithRole(ts.URL, "greedo", "mos-eisley", nil)
+		So(err, ShouldBeNil)
+		ctx, cancel := context.WithCancel(context.Background())
+		errs, err := a.StartAutoRefresh(ctx, time.Minute)
+		So(err, ShouldBeNil)
+		Convey("Should send the error on the channel", func() {
+			select {
+			case err := <-errs:
+				So(err, ShouldNotBeNil)
+			case <-time.After(2 * time.Second):
+				So("no error was sent", ShouldBeEmpty)
+			}
+			Convey("And should stop when the context is cancelled", func() {
+				cancel()
+				select {
+				case _, ok := <-errs:
+					So(ok, ShouldBeFalse)
+				case <-time.After(2 * time.Second):
+					So("the channel was not closed", ShouldBeEmpty)
+				}
+			})
+		})
+		Reset(cancel)
+	}))
+
+	Convey("A negative leeway", t, func() {
+		a, err := NewAWSAuthWithRole("https://test.example.com", "greedo", "mos-eisley", nil)
+		So(err, ShouldBeNil)
+		_, err = a.StartAutoRefresh(context.Background(), -time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// waitFor polls cond for up to 2 seconds and returns whether it became true
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
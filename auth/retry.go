@@ -0,0 +1,76 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// retrier retries authentication requests that fail with a transient server error or a
+// network error. The zero value doesn't retry
+type retrier struct {
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// SetRetry enables retrying authentication requests that fail with a transient server error
+// (500, 502, 503, or 504) or a network error, such as while Cerberus is being deployed. Client
+// errors like a 400, 401, or 403 are never retried. maxAttempts is the total number of times a
+// request is tried, including the first, and baseDelay is where the exponential backoff with
+// jitter between attempts starts. Waiting between attempts stops if the request's context is
+// done. It should be set before the auth method is used. By default requests are not retried
+func (r *retrier) SetRetry(maxAttempts int, baseDelay time.Duration) error {
+	if maxAttempts < 1 {
+		return fmt.Errorf("Max attempts must be at least 1, got %d", maxAttempts)
+	}
+	r.maxAttempts = maxAttempts
+	r.baseDelay = baseDelay
+	return nil
+}
+
+// do sends the requests built by newRequest until one succeeds, fails in a way that isn't
+// worth retrying, or the attempts run out, and returns the last response. A new request is
+// built for every attempt so that its body can be sent again. Every attempt is logged to logger
+func (r *retrier) do(ctx context.Context, client *http.Client, logger Logger, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	base := r.baseDelay
+	if base <= 0 {
+		base = utils.DefaultRetryBaseDelay
+	}
+	backoff := utils.ExponentialJitterBackoff(base, utils.DefaultRetryMaxDelay)
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := send(ctx, client, logger, req, attempt)
+		retryable := err != nil || utils.IsRetryableStatus(resp.StatusCode)
+		if !retryable || attempt >= r.maxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		closeBody(resp)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingServer returns a server that responds with the given status code the first
+// failures times it is called, and with a successful AWS login after that
+func failingServer(status int, failures int32, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), "han-solo") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if atomic.AddInt32(hits, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(fakeAuthBody))
+	}))
+}
+
+func TestRetryAWS(t *testing.T) {
+	Convey("A server that fails twice and then succeeds", t, func() {
+		var hits int32
+		ts := failingServer(http.StatusServiceUnavailable, 2, &hits)
+		Reset(ts.Close)
+		a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		a.kmsClient = mockKMS{data: awsResponseBody}
+		Convey("Should succeed with enough retries", func() {
+			So(a.SetRetry(3, time.Millisecond), ShouldBeNil)
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-cool-token")
+			So(atomic.LoadInt32(&hits), ShouldEqual, 3)
+		})
+		Convey("Should return the last failure when out of attempts", func() {
+			So(a.SetRetry(2, time.Millisecond), ShouldBeNil)
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "503")
+			So(atomic.LoadInt32(&hits), ShouldEqual, 2)
+		})
+		Convey("Should not retry by default", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldNotBeNil)
+			So(atomic.LoadInt32(&hits), ShouldEqual, 1)
+		})
+		Convey("Should stop retrying when the context is cancelled", func() {
+			So(a.SetRetry(5, time.Second), ShouldBeNil)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := a.GetToken(ctx)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+	})
+	Convey("A server that rejects the login", t, func() {
+		for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden} {
+			var hits int32
+			ts := failingServer(status, 1, &hits)
+			a, err := NewAWSAuthWithRole(ts.URL, "han-solo", "falcon", nil)
+			So(err, ShouldBeNil)
+			So(a.SetRetry(3, time.Millisecond), ShouldBeNil)
+			_, err = a.GetToken(context.Background())
+			So(err, ShouldNotBeNil)
+			So(atomic.LoadInt32(&hits), ShouldEqual, 1)
+			ts.Close()
+		}
+	})
+	Convey("An invalid number of attempts", t, func() {
+		a, err := NewAWSAuthWithRole("https://example.com", "han-solo", "falcon", nil)
+		So(err, ShouldBeNil)
+		So(a.SetRetry(0, time.Millisecond), ShouldNotBeNil)
+	})
+}
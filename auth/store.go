@@ -0,0 +1,462 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+)
+
+// TokenStore holds the current token for an authentication method along with when
+// it expires. All of the auth types use a MemoryTokenStore by default, and a different
+// store can be set with SetTokenStore. Implementations must be safe for concurrent use
+type TokenStore interface {
+	// Store saves the token and its expiry, replacing anything already stored
+	Store(token string, expiry time.Time) error
+	// Load returns the stored token and its expiry. The token is empty if nothing is stored
+	Load() (string, time.Time, error)
+	// Clear removes the stored token
+	Clear() error
+}
+
+// MemoryTokenStore is the default TokenStore. It keeps the token in memory as plain text
+type MemoryTokenStore struct {
+	lock   sync.RWMutex
+	token  string
+	expiry time.Time
+}
+
+// NewMemoryTokenStore returns an empty MemoryTokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{}
+}
+
+// Store saves the token and its expiry
+func (m *MemoryTokenStore) Store(token string, expiry time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.token = token
+	m.expiry = expiry
+	return nil
+}
+
+// Load returns the stored token and its expiry
+func (m *MemoryTokenStore) Load() (string, time.Time, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.token, m.expiry, nil
+}
+
+// Clear removes the stored token
+func (m *MemoryTokenStore) Clear() error {
+	return m.Store("", time.Time{})
+}
+
+// EncryptedMemoryTokenStore is a TokenStore that keeps the token encrypted in memory
+// with AES-GCM using a random key that is generated when the store is created and never
+// leaves the process. The token is only decrypted when it is needed, such as when building
+// the headers for a request.
+//
+// This is defense in depth against a limited threat: something that gets a copy of the
+// process memory after the fact (a core dump, a swapped out page, or a heap snapshot sent
+// off for debugging) and scans it for tokens. It does not protect against anything that
+// can read the memory of the running process at will, since the key is in that memory too,
+// and short-lived plain text copies of the token still exist while a request is being made
+type EncryptedMemoryTokenStore struct {
+	lock   sync.RWMutex
+	aead   cipher.AEAD
+	sealed []byte
+	expiry time.Time
+}
+
+// NewEncryptedMemoryTokenStore returns an empty EncryptedMemoryTokenStore with a new random key
+func NewEncryptedMemoryTokenStore() (*EncryptedMemoryTokenStore, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("Error while generating token store key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	// The cipher has its own copy of the key, so don't leave another one lying around
+	for i := range key {
+		key[i] = 0
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error while setting up token store cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("Error while setting up token store cipher: %v", err)
+	}
+	return &EncryptedMemoryTokenStore{aead: aead}, nil
+}
+
+// Store encrypts and saves the token and its expiry
+func (e *EncryptedMemoryTokenStore) Store(token string, expiry time.Time) error {
+	if token == "" {
+		return e.Clear()
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("Error while encrypting token: %v", err)
+	}
+	plaintext := []byte(token)
+	sealed := e.aead.Seal(nonce, nonce, plaintext, nil)
+	for i := range plaintext {
+		plaintext[i] = 0
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sealed = sealed
+	e.expiry = expiry
+	return nil
+}
+
+// Load decrypts and returns the stored token and its expiry
+func (e *EncryptedMemoryTokenStore) Load() (string, time.Time, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if len(e.sealed) == 0 {
+		return "", e.expiry, nil
+	}
+	size := e.aead.NonceSize()
+	plaintext, err := e.aead.Open(nil, e.sealed[:size], e.sealed[size:], nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error while decrypting token: %v", err)
+	}
+	token := string(plaintext)
+	for i := range plaintext {
+		plaintext[i] = 0
+	}
+	return token, e.expiry, nil
+}
+
+// Clear removes the stored token
+func (e *EncryptedMemoryTokenStore) Clear() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.sealed = nil
+	e.expiry = time.Time{}
+	return nil
+}
+
+// FileTokenStore is a TokenStore that keeps the token and its expiry in a file, so that a
+// token can be reused across runs of a short-lived program (such as a CLI) instead of
+// authenticating every time. The file is written with 0600 permissions, and Load refuses
+// to read a file that anyone other than its owner can access since the token in it could
+// have been copied or replaced
+type FileTokenStore struct {
+	lock sync.Mutex
+	path string
+}
+
+// fileToken is the format of the file written by a FileTokenStore. URL, RoleARN, and Policies
+// are only set for tokens saved by an AWSAuth, which uses them to tell whether a token was
+// issued to it
+type fileToken struct {
+	Token    string    `json:"token"`
+	Expiry   time.Time `json:"expiry"`
+	URL      string    `json:"url,omitempty"`
+	RoleARN  string    `json:"role_arn,omitempty"`
+	Policies []string  `json:"policies,omitempty"`
+}
+
+// NewFileTokenStore returns a FileTokenStore that keeps the token in the file at path. The
+// file (and the directory it is in) is created the first time a token is stored
+func NewFileTokenStore(path string) (*FileTokenStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("Token file path cannot be empty")
+	}
+	return &FileTokenStore{path: path}, nil
+}
+
+// Store writes the token and its expiry to the file. The file is written to a temporary
+// file first and then renamed into place, so a reader never sees a partially written token
+func (f *FileTokenStore) Store(token string, expiry time.Time) error {
+	return f.save(fileToken{Token: token, Expiry: expiry})
+}
+
+// save is Store for a token along with the details of who it was issued to
+func (f *FileTokenStore) save(saved fileToken) error {
+	if saved.Token == "" {
+		return f.Clear()
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("Error while encoding token: %v", err)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	dir := filepath.Dir(f.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Error while creating token file directory: %v", err)
+	}
+	// TempFile creates the file with 0600 permissions
+	tmp, err := ioutil.TempFile(dir, ".cerberus-token-")
+	if err != nil {
+		return fmt.Errorf("Error while writing token file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Error while writing token file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Error while writing token file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("Error while writing token file: %v", err)
+	}
+	return nil
+}
+
+// Load reads the token and its expiry from the file. The token is empty if the file doesn't
+// exist. An error is returned if the file can be read or written by anyone but its owner
+func (f *FileTokenStore) Load() (string, time.Time, error) {
+	saved, err := f.load()
+	return saved.Token, saved.Expiry, err
+}
+
+// load is Load that also returns the details of who the token was issued to
+func (f *FileTokenStore) load() (fileToken, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		return fileToken{}, nil
+	}
+	if err != nil {
+		return fileToken{}, fmt.Errorf("Error while reading token file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fileToken{}, fmt.Errorf("Token file %s has permissions %#o, refusing to use it because other users can access it. Its permissions should be 0600", f.path, perm)
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return fileToken{}, fmt.Errorf("Error while reading token file: %v", err)
+	}
+	var saved fileToken
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fileToken{}, fmt.Errorf("Error while decoding token file: %v", err)
+	}
+	return saved, nil
+}
+
+// Clear removes the file. It is not an error if it doesn't exist
+func (f *FileTokenStore) Clear() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error while removing token file: %v", err)
+	}
+	return nil
+}
+
+// tokenHolder is embedded in each of the auth types to keep their token in a TokenStore
+type tokenHolder struct {
+	store     TokenStore
+	drain     *drainState
+	policies  *policyState
+	metrics   *metricsState
+	tracing   *tracingState
+	transport *transportState
+	metadata  *metadataState
+	logging   *loggingState
+	endpoints endpoints
+}
+
+// newTokenHolder returns a tokenHolder using the default store
+func newTokenHolder() tokenHolder {
+	return tokenHolder{
+		store:     NewMemoryTokenStore(),
+		drain:     &drainState{},
+		policies:  &policyState{},
+		metrics:   &metricsState{recorder: NopMetrics},
+		tracing:   &tracingState{},
+		transport: newTransportState(),
+		metadata:  &metadataState{},
+		logging:   &loggingState{logger: NopLogger},
+		endpoints: apiEndpoints[DefaultAPIVersion],
+	}
+}
+
+// SetTokenStore changes where the token is kept. It should be called before authenticating
+// because a token held in the previous store is not carried over. Passing nil goes back to
+// a new default in-memory store
+func (t *tokenHolder) SetTokenStore(store TokenStore) {
+	if store == nil {
+		store = NewMemoryTokenStore()
+	}
+	t.store = store
+}
+
+// loadToken returns the current token and its expiry from the store
+func (t *tokenHolder) loadToken() (string, time.Time, error) {
+	return t.store.Load()
+}
+
+// ExpiresAt returns when the current token expires. It returns the zero time if there is
+// no token, or if its expiry isn't known (as with TokenAuth, which is given a token
+// without one, until the token is renewed with RenewToken)
+func (t *tokenHolder) ExpiresAt() time.Time {
+	token, expiry, err := t.loadToken()
+	if err != nil || token == "" {
+		return time.Time{}
+	}
+	return expiry
+}
+
+// TokenTTL returns how long is left until the current token expires, which is useful for
+// scheduling a refresh. It returns 0 if the token has already expired, and in the same
+// cases that ExpiresAt returns the zero time
+func (t *tokenHolder) TokenTTL() time.Duration {
+	expiry := t.ExpiresAt()
+	if expiry.IsZero() {
+		return 0
+	}
+	if ttl := time.Until(expiry); ttl > 0 {
+		return ttl
+	}
+	return 0
+}
+
+// withToken returns a copy of the given headers with the current token set, if there is one.
+// A copy is made so that the plain text token never stays in a long-lived header map
+func (t *tokenHolder) withToken(headers http.Header) (http.Header, error) {
+	token, _, err := t.loadToken()
+	if err != nil {
+		return nil, err
+	}
+	h := make(http.Header, len(headers)+1)
+	for k, v := range headers {
+		h[k] = append([]string(nil), v...)
+	}
+	if token != "" {
+		h.Set("X-Vault-Token", token)
+	}
+	return h, nil
+}
+
+// logoutWithTimeout revokes the current token, giving up on the request after the timeout.
+// The token is cleared from the store whether or not the revocation succeeded so that it
+// is never used again
+func (t *tokenHolder) logoutWithTimeout(d time.Duration, baseURL url.URL, headers http.Header) error {
+	finish := t.beginLogout()
+	defer finish()
+	withToken, err := t.withToken(headers)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	// The context has the timeout, so the client must not cut it short with one of its own
+	logoutErr := t.revoke(ctx, t.httpClient(0), baseURL, withToken)
+	if err := t.clearToken(); err != nil && logoutErr == nil {
+		return err
+	}
+	return logoutErr
+}
+
+// refreshRequest refreshes the token in the headers with the user refresh endpoint like Refresh,
+// recording the request with the metrics recorder and tracing it
+func (t *tokenHolder) refreshRequest(baseURL url.URL, headers http.Header) (*api.UserAuthResponse, error) {
+	t.incAuth(AuthResultRefresh)
+	ctx, cancel := t.withTimeout(context.Background())
+	defer cancel()
+	ctx, span := t.startSpan(ctx, spanRefresh)
+	r, err := refresh(ctx, t.httpClient(t.requestTimeout()), t.logger(), baseURL, t.endpoints.refresh, headers, t.metricsRecorder())
+	return r, span.End(err)
+}
+
+// renewRequest renews the lease of the token in the headers, recording the request with the
+// metrics recorder and tracing it
+func (t *tokenHolder) renewRequest(baseURL url.URL, headers http.Header, increment time.Duration) (*api.RenewedToken, error) {
+	t.incAuth(AuthResultRenew)
+	ctx, cancel := t.withTimeout(context.Background())
+	defer cancel()
+	ctx, span := t.startSpan(ctx, spanRenew)
+	r, err := renew(ctx, t.httpClient(t.requestTimeout()), t.logger(), baseURL, t.endpoints.renew, headers, increment, t.metricsRecorder())
+	return r, span.End(err)
+}
+
+// renewToken renews the lease of the current token and stores it with its new expiry, which
+// is returned
+func (t *tokenHolder) renewToken(baseURL url.URL, headers http.Header, increment time.Duration) (time.Time, error) {
+	withToken, err := t.withToken(headers)
+	if err != nil {
+		return time.Time{}, err
+	}
+	r, err := t.renewRequest(baseURL, withToken, increment)
+	if err != nil {
+		return time.Time{}, err
+	}
+	token := r.Token
+	if token == "" {
+		// Renewing keeps the same token, so there is nothing lost if Cerberus leaves it out
+		token = withToken.Get("X-Vault-Token")
+	}
+	expiry := leaseExpiry(r.Duration, DefaultLeaseDuration)
+	if err := t.store.Store(token, expiry); err != nil {
+		return time.Time{}, err
+	}
+	t.setPolicies(r.Policies)
+	return expiry, nil
+}
+
+// lookupToken describes the current token with the self-lookup endpoint, returning its policies,
+// metadata, and the seconds left on its lease as Duration. Returns ErrorUnauthenticated if there
+// is no token and ErrorUnauthorized if Cerberus rejects it
+func (t *tokenHolder) lookupToken(baseURL url.URL, headers http.Header) (*api.UserClientToken, error) {
+	withToken, err := t.withToken(headers)
+	if err != nil {
+		return nil, err
+	}
+	if withToken.Get("X-Vault-Token") == "" {
+		return nil, api.ErrorUnauthenticated
+	}
+	ctx, cancel := t.withTimeout(context.Background())
+	defer cancel()
+	ctx, span := t.startSpan(ctx, spanLookup)
+	r, err := lookup(ctx, t.httpClient(t.requestTimeout()), t.logger(), baseURL, t.endpoints.lookup, withToken, t.metricsRecorder())
+	return r, span.End(err)
+}
+
+// logoutRequest revokes the token in the headers like Logout
+func (t *tokenHolder) logoutRequest(baseURL url.URL, headers http.Header) error {
+	ctx, cancel := t.withTimeout(context.Background())
+	defer cancel()
+	return t.revoke(ctx, t.httpClient(t.requestTimeout()), baseURL, headers)
+}
+
+// revoke revokes the token in the headers using the given HTTP client, recording the request
+// with the metrics recorder and tracing it
+func (t *tokenHolder) revoke(ctx context.Context, client *http.Client, baseURL url.URL, headers http.Header) error {
+	ctx, span := t.startSpan(ctx, spanLogout)
+	return span.End(logout(ctx, client, t.logger(), baseURL, t.endpoints.logout, headers, t.metricsRecorder()))
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryptedMemoryTokenStore(t *testing.T) {
+	Convey("An encrypted token store", t, func() {
+		s, err := NewEncryptedMemoryTokenStore()
+		So(err, ShouldBeNil)
+		So(s, ShouldNotBeNil)
+		Convey("Should be empty to start", func() {
+			tok, _, err := s.Load()
+			So(err, ShouldBeNil)
+			So(tok, ShouldBeEmpty)
+		})
+		Convey("With a stored token", func() {
+			expiry := time.Now().Add(time.Hour)
+			So(s.Store("a-secret-token", expiry), ShouldBeNil)
+			Convey("Should return the token and expiry", func() {
+				tok, exp, err := s.Load()
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "a-secret-token")
+				So(exp, ShouldEqual, expiry)
+			})
+			Convey("Should not hold the token in plain text", func() {
+				So(bytes.Contains(s.sealed, []byte("a-secret-token")), ShouldBeFalse)
+			})
+			Convey("Should be empty after clearing", func() {
+				So(s.Clear(), ShouldBeNil)
+				tok, _, err := s.Load()
+				So(err, ShouldBeNil)
+				So(tok, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("A UserAuth with an encrypted token store", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		s, _ := NewEncryptedMemoryTokenStore()
+		c.SetTokenStore(s)
+		So(c.setToken("an-old-token", 3600), ShouldBeNil)
+		Convey("Should decrypt the token when building headers", func() {
+			So(c.IsAuthenticated(), ShouldBeTrue)
+			headers, err := c.GetHeaders()
+			So(err, ShouldBeNil)
+			So(headers.Get("X-Vault-Token"), ShouldEqual, "an-old-token")
+		})
+		Convey("Should not keep the token in its own headers", func() {
+			So(c.headers.Get("X-Vault-Token"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestTokenLifetime(t *testing.T) {
+	Convey("A UserAuth that never authenticated", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		Convey("Should have no expiry or TTL", func() {
+			So(c.ExpiresAt().IsZero(), ShouldBeTrue)
+			So(c.TokenTTL(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("An authenticated UserAuth", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		So(c.setToken("a-token", 3600), ShouldBeNil)
+		Convey("Should return when the token expires", func() {
+			_, expiry, _ := c.loadToken()
+			So(c.ExpiresAt(), ShouldEqual, expiry)
+		})
+		Convey("Should return how long is left", func() {
+			ttl := c.TokenTTL()
+			So(ttl, ShouldBeGreaterThan, 58*time.Minute)
+			So(ttl, ShouldBeLessThanOrEqualTo, time.Hour)
+		})
+		Convey("Should have no expiry or TTL after the token is cleared", func() {
+			So(c.store.Clear(), ShouldBeNil)
+			So(c.ExpiresAt().IsZero(), ShouldBeTrue)
+			So(c.TokenTTL(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("A UserAuth with an expired token", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		expiry := time.Now().Add(-time.Minute)
+		So(c.store.Store("an-old-token", expiry), ShouldBeNil)
+		Convey("Should still return when it expired", func() {
+			So(c.ExpiresAt(), ShouldEqual, expiry)
+		})
+		Convey("Should have a TTL of 0", func() {
+			So(c.TokenTTL(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("A TokenAuth", t, func() {
+		a, _ := NewTokenAuth("https://example.com", "a-token")
+		So(a, ShouldNotBeNil)
+		Convey("Should not know when its token expires", func() {
+			So(a.ExpiresAt().IsZero(), ShouldBeTrue)
+			So(a.TokenTTL(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestFileTokenStore(t *testing.T) {
+	Convey("A file token store", t, func() {
+		dir, err := ioutil.TempDir("", "cerberus-store")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		path := filepath.Join(dir, "nested", "token")
+		s, err := NewFileTokenStore(path)
+		So(err, ShouldBeNil)
+		Convey("Should be empty when the file doesn't exist", func() {
+			tok, expiry, err := s.Load()
+			So(err, ShouldBeNil)
+			So(tok, ShouldBeEmpty)
+			So(expiry.IsZero(), ShouldBeTrue)
+		})
+		Convey("With a stored token", func() {
+			expiry := time.Now().Add(time.Hour).Round(time.Second)
+			So(s.Store("a-secret-token", expiry), ShouldBeNil)
+			Convey("Should write the file so only its owner can access it", func() {
+				info, err := os.Stat(path)
+				So(err, ShouldBeNil)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+			})
+			Convey("Should load the token and expiry", func() {
+				tok, loaded, err := s.Load()
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "a-secret-token")
+				So(loaded.Equal(expiry), ShouldBeTrue)
+			})
+			Convey("Should load the token from a new store", func() {
+				other, err := NewFileTokenStore(path)
+				So(err, ShouldBeNil)
+				tok, _, err := other.Load()
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "a-secret-token")
+			})
+			Convey("Should refuse to load a file others can read", func() {
+				So(os.Chmod(path, 0644), ShouldBeNil)
+				tok, _, err := s.Load()
+				So(err, ShouldNotBeNil)
+				So(tok, ShouldBeEmpty)
+			})
+			Convey("Should remove the file when cleared", func() {
+				So(s.Clear(), ShouldBeNil)
+				_, err := os.Stat(path)
+				So(os.IsNotExist(err), ShouldBeTrue)
+				So(s.Clear(), ShouldBeNil)
+			})
+			Convey("Should remove the file when an empty token is stored", func() {
+				So(s.Store("", time.Time{}), ShouldBeNil)
+				tok, _, err := s.Load()
+				So(err, ShouldBeNil)
+				So(tok, ShouldBeEmpty)
+			})
+		})
+		Convey("Should not be created with an empty path", func() {
+			_, err := NewFileTokenStore("")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
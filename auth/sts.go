@@ -0,0 +1,280 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/ecimionatto/cerberus-go-client/api"
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// stsIdentityBody is the body of the signed GetCallerIdentity request
+const stsIdentityBody = "Action=GetCallerIdentity&Version=2011-06-15"
+
+// AWSSTSAuth uses AWS credentials to authenticate to Cerberus with the STS identity flow. Instead
+// of having Cerberus encrypt a token with KMS for the client to decrypt, the client signs an STS
+// GetCallerIdentity request with its credentials and sends the signature to Cerberus, which uses
+// it to verify who the caller is. This needs no KMS permissions, so it also works in regions
+// where KMS auth is disabled
+type AWSSTSAuth struct {
+	region  string
+	baseURL *url.URL
+	headers http.Header
+	signer  *v4.Signer
+	// stsURL is the STS endpoint the signed request is for
+	stsURL string
+	tokenHolder
+	refreshNotifier
+}
+
+// NewAWSSTSAuth returns an AWSSTSAuth given a valid URL and region. If the CERBERUS_URL
+// environment variable is set, it will be used over anything passed to this function.
+// Like NewAWSAuth, it expects you to have valid AWS credentials configured either by
+// environment variable, a credentials config file, or an instance role
+func NewAWSSTSAuth(cerberusURL, region string) (*AWSSTSAuth, error) {
+	// Check for the environment variable if the user has set it
+	if os.Getenv("CERBERUS_URL") != "" {
+		cerberusURL = os.Getenv("CERBERUS_URL")
+	}
+	if len(region) == 0 {
+		return nil, fmt.Errorf("Region should not be nil")
+	}
+	if len(cerberusURL) == 0 {
+		return nil, fmt.Errorf("Cerberus URL cannot be empty")
+	}
+	parsedURL, err := utils.ValidateURL(cerberusURL)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create AWS session: %s", err)
+	}
+	return newAWSSTSAuth(parsedURL, region, sess.Config.Credentials), nil
+}
+
+// newAWSSTSAuth returns an AWSSTSAuth that signs with the given credentials
+func newAWSSTSAuth(baseURL *url.URL, region string, creds *credentials.Credentials) *AWSSTSAuth {
+	return &AWSSTSAuth{
+		region:  region,
+		baseURL: baseURL,
+		headers: http.Header{
+			"X-Cerberus-Client": []string{api.ClientHeader},
+			"Content-Type":      []string{"application/json"},
+		},
+		signer:      v4.NewSigner(creds),
+		stsURL:      fmt.Sprintf("https://sts.%s.amazonaws.com/", region),
+		tokenHolder: newTokenHolder(),
+	}
+}
+
+// GetURL returns the configured Cerberus URL
+func (a *AWSSTSAuth) GetURL() *url.URL {
+	return a.baseURL
+}
+
+// SetBasePath sets the path that every endpoint is prefixed with, the same as
+// AWSAuth.SetBasePath
+func (a *AWSSTSAuth) SetBasePath(basePath string) {
+	a.baseURL = withBasePath(a.baseURL, basePath)
+}
+
+// GetToken returns a token if it already exists and is not expired. Otherwise, it
+// authenticates with a signed STS request and then returns the token. Authenticating
+// gives up if the context is cancelled or its deadline passes
+func (a *AWSSTSAuth) GetToken(ctx context.Context) (string, error) {
+	if err := a.checkDraining(); err != nil {
+		return "", err
+	}
+	if !a.IsAuthenticated() {
+		if err := a.authenticate(ctx); err != nil {
+			return "", err
+		}
+	}
+	token, _, err := a.loadToken()
+	return token, err
+}
+
+// signedIdentityHeaders signs a GetCallerIdentity request for STS and returns the headers
+// Cerberus needs to verify it
+func (a *AWSSTSAuth) signedIdentityHeaders() (http.Header, error) {
+	req, err := http.NewRequest(http.MethodPost, a.stsURL, strings.NewReader(stsIdentityBody))
+	if err != nil {
+		return nil, fmt.Errorf("Error while building STS request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if _, err := a.signer.Sign(req, strings.NewReader(stsIdentityBody), "sts", a.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("Error while signing STS request: %v", err)
+	}
+	return req.Header, nil
+}
+
+// authenticate logs in with a signed STS request and counts whether that worked
+func (a *AWSSTSAuth) authenticate(ctx context.Context) error {
+	ctx, span := a.startSpan(ctx, spanAuthenticate, utils.AttributeRegion.String(a.region))
+	return span.End(a.recordAuth(a.login(ctx)))
+}
+
+func (a *AWSSTSAuth) login(ctx context.Context) error {
+	signed, err := a.signedIdentityHeaders()
+	if err != nil {
+		return err
+	}
+	// Make a copy of the base URL
+	builtURL := *a.baseURL
+	endpoint := a.endpoints.stsLogin
+	if endpoint == "" {
+		return ErrorEndpointUnsupported
+	}
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
+	req, err := http.NewRequest(http.MethodPost, builtURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+	}
+	req.Header = http.Header{}
+	for k, v := range a.headers {
+		req.Header[k] = v
+	}
+	for _, h := range []string{"Authorization", "X-Amz-Date", "X-Amz-Security-Token"} {
+		if v := signed.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	start := time.Now()
+	resp, err := send(ctx, a.httpClient(a.requestTimeout()), a.logger(), req, 1)
+	observeRequest(ctx, a.metricsRecorder(), endpoint, resp, start)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer closeBody(resp)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return api.ErrorUnauthorized
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return api.ErrorForbidden
+	}
+	if resp.StatusCode != http.StatusOK {
+		if apiErr := api.ParseCerberusError(resp.StatusCode, resp.Body); apiErr != nil {
+			return apiErr
+		}
+		return fmt.Errorf("Error while trying to authenticate. Got HTTP response code %d", resp.StatusCode)
+	}
+	// Unlike the KMS flow, the token comes back as plain JSON
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Error while trying to read response from Cerberus: %v", err)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return api.ErrorEmptyResponse
+	}
+	r, err := parseIAMAuthResponse(body)
+	if err != nil {
+		return err
+	}
+	expiry := leaseExpiry(r.Duration, DefaultLeaseDuration)
+	if err := a.store.Store(r.Token, expiry); err != nil {
+		return err
+	}
+	a.setPolicies(r.Policies)
+	a.setAWSMetadata(r.Metadata)
+	a.notify(expiry)
+	return nil
+}
+
+// Reauthenticate signs a new STS request and logs in again, replacing the current token
+func (a *AWSSTSAuth) Reauthenticate() error {
+	return a.authenticate(context.Background())
+}
+
+// IsAuthenticated returns whether or not the current token is set and is not expired
+func (a *AWSSTSAuth) IsAuthenticated() bool {
+	token, expiry, err := a.loadToken()
+	return err == nil && len(token) > 0 && time.Now().Before(expiry)
+}
+
+// Refresh gets a new token. As with AWSAuth, this authenticates again rather than using
+// the refresh endpoint, which limits how many times a token can be refreshed
+func (a *AWSSTSAuth) Refresh() error {
+	return a.RefreshContext(context.Background())
+}
+
+// RefreshContext is the same as Refresh, but gives up if the context is cancelled or its
+// deadline passes
+func (a *AWSSTSAuth) RefreshContext(ctx context.Context) error {
+	a.incAuth(AuthResultRefresh)
+	ctx, span := a.startSpan(ctx, spanRefresh, utils.AttributeRegion.String(a.region))
+	return span.End(a.authenticate(ctx))
+}
+
+// Logout deauthorizes the current valid token. This will return an error if the token
+// is expired or non-existent
+func (a *AWSSTSAuth) Logout() error {
+	if !a.IsAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	return a.LogoutContext(context.Background())
+}
+
+// LogoutContext is the same as Logout, but gives up on the request if the context is
+// cancelled or its deadline passes. Unlike LogoutWithTimeout, the token is only cleared
+// if it was revoked
+func (a *AWSSTSAuth) LogoutContext(ctx context.Context) error {
+	finish := a.beginLogout()
+	defer finish()
+	headers, err := a.withToken(a.headers)
+	if err != nil {
+		return err
+	}
+	// Use a copy of the base URL
+	if err := a.revoke(ctx, a.httpClient(a.requestTimeout()), *a.baseURL, headers); err != nil {
+		return err
+	}
+	return a.clearToken()
+}
+
+// LogoutWithTimeout revokes the current token like Logout, but gives up if Cerberus
+// doesn't respond within the timeout. The token is cleared locally either way
+func (a *AWSSTSAuth) LogoutWithTimeout(d time.Duration) error {
+	return a.logoutWithTimeout(d, *a.baseURL, a.headers)
+}
+
+// Lookup asks Cerberus to describe the current token, which is a cheap way to check it is still valid
+func (a *AWSSTSAuth) Lookup() (*api.UserClientToken, error) {
+	return a.lookupToken(*a.baseURL, a.headers)
+}
+
+// GetHeaders returns the headers needed to authenticate against Cerberus. This will
+// return an error if the token is expired or non-existent
+func (a *AWSSTSAuth) GetHeaders() (http.Header, error) {
+	if !a.IsAuthenticated() {
+		return nil, api.ErrorUnauthenticated
+	}
+	return a.requestHeaders(a.headers)
+}
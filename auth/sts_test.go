@@ -0,0 +1,220 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var stsResponseBody = `{
+    "client_token": "a-cool-token",
+    "policies": [ "foo-bar-read", "lookup-self" ],
+    "metadata": {
+        "aws_region": "us-west-2",
+        "iam_principal_arn": "arn:aws:iam::111111111:role/fake-role",
+        "username": "arn:aws:iam::111111111:role/fake-role",
+        "is_admin": "false",
+        "groups": "registered-iam-principals"
+    },
+    "lease_duration": 3600,
+    "renewable": true
+}`
+
+func newTestSTSAuth(serverURL string) *AWSSTSAuth {
+	u, _ := url.Parse(serverURL)
+	return newAWSSTSAuth(u, "us-west-2", credentials.NewStaticCredentials("AKIDEXAMPLE", "a-secret-key", "a-session-token"))
+}
+
+func TestNewAWSSTSAuth(t *testing.T) {
+	Convey("A valid URL and region", t, func() {
+		a, err := NewAWSSTSAuth("https://test.example.com", "us-west-2")
+		Convey("Should return a valid AWSSTSAuth", func() {
+			So(err, ShouldBeNil)
+			So(a, ShouldNotBeNil)
+			So(a.GetURL().String(), ShouldEqual, "https://test.example.com")
+			So(a.stsURL, ShouldEqual, "https://sts.us-west-2.amazonaws.com/")
+		})
+	})
+	Convey("An empty region", t, func() {
+		a, err := NewAWSSTSAuth("https://test.example.com", "")
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(a, ShouldBeNil)
+		})
+	})
+	Convey("An empty URL", t, func() {
+		a, err := NewAWSSTSAuth("", "us-west-2")
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(a, ShouldBeNil)
+		})
+	})
+}
+
+func TestGetTokenSTS(t *testing.T) {
+	Convey("A valid STS login", t, func() {
+		var got http.Header
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v2/auth/sts-identity" || r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			got = r.Header
+			w.Write([]byte(stsResponseBody))
+		}))
+		a := newTestSTSAuth(ts.URL)
+		Convey("Should return the token", func() {
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-cool-token")
+			So(a.IsAuthenticated(), ShouldBeTrue)
+			So(a.HasPolicy("lookup-self"), ShouldBeTrue)
+			Convey("And should have a valid expiry time", func() {
+				_, expiry, _ := a.loadToken()
+				So(expiry, ShouldHappenOnOrBefore, time.Now().Add(1*time.Hour))
+			})
+			Convey("And should have sent the signed STS headers", func() {
+				So(got.Get("Authorization"), ShouldStartWith, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/")
+				So(got.Get("Authorization"), ShouldContainSubstring, "/us-west-2/sts/aws4_request")
+				So(got.Get("X-Amz-Date"), ShouldNotBeEmpty)
+				So(got.Get("X-Amz-Security-Token"), ShouldEqual, "a-session-token")
+				So(got.Get("X-Cerberus-Client"), ShouldEqual, api.ClientHeader)
+			})
+			Convey("And should set the token header", func() {
+				headers, err := a.GetHeaders()
+				So(err, ShouldBeNil)
+				So(headers.Get("X-Vault-Token"), ShouldEqual, "a-cool-token")
+			})
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A forbidden STS login", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		Reset(ts.Close)
+		a := newTestSTSAuth(ts.URL)
+		Convey("Should return ErrorForbidden", func() {
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldEqual, api.ErrorForbidden)
+			So(tok, ShouldBeEmpty)
+		})
+	})
+
+	Convey("A rejected STS login", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		a := newTestSTSAuth(ts.URL)
+		Convey("Should return ErrorUnauthorized", func() {
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldEqual, api.ErrorUnauthorized)
+			So(tok, ShouldBeEmpty)
+			So(a.IsAuthenticated(), ShouldBeFalse)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("An STS login with an empty response", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		a := newTestSTSAuth(ts.URL)
+		Convey("Should return ErrorEmptyResponse", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldEqual, api.ErrorEmptyResponse)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("An STS login with an unexpected response", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"auth_data": "c29tZXRoaW5n"}`))
+		}))
+		a := newTestSTSAuth(ts.URL)
+		Convey("Should error", func() {
+			_, err := a.GetToken(context.Background())
+			So(err, ShouldNotBeNil)
+			So(strings.Contains(err.Error(), "client_token"), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestGetTokenCancelledSTS(t *testing.T) {
+	Convey("An STS login that is cancelled", t, func() {
+		ts := slowServer()
+		a := newTestSTSAuth(ts.URL)
+		Convey("Should return promptly with the context's error", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			tok, err := a.GetToken(ctx)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(tok, ShouldBeEmpty)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			So(a.IsAuthenticated(), ShouldBeFalse)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestLogoutSTS(t *testing.T) {
+	Convey("Logging out an STS token", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/v2/auth/sts-identity":
+				w.Write([]byte(stsResponseBody))
+			case r.URL.Path == "/v1/auth" && r.Method == http.MethodDelete && r.Header.Get("X-Vault-Token") == "a-cool-token":
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		a := newTestSTSAuth(ts.URL)
+		_, err := a.GetToken(context.Background())
+		So(err, ShouldBeNil)
+		Convey("Should clear the token", func() {
+			So(a.Logout(), ShouldBeNil)
+			So(a.IsAuthenticated(), ShouldBeFalse)
+			So(a.Policies(), ShouldBeEmpty)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
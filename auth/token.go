@@ -17,9 +17,15 @@ limitations under the License.
 package auth
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
 	"github.com/ecimionatto/cerberus-go-client/utils"
@@ -27,9 +33,19 @@ import (
 
 // TokenAuth uses a preexisting token to authenticate to Cerberus
 type TokenAuth struct {
-	token   string
-	headers http.Header
-	baseURL *url.URL
+	headers   http.Header
+	baseURL   *url.URL
+	tokenFile *tokenFile
+	tokenHolder
+	refreshNotifier
+}
+
+// tokenFile tracks a file that a TokenAuth reads its token from
+type tokenFile struct {
+	lock    sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
 }
 
 // NewTokenAuth takes a Cerberus URL and valid token and returns a new TokenAuth.
@@ -37,7 +53,25 @@ type TokenAuth struct {
 // expects the a valid token. The URL and token can also be set using the CERBERUS_URL
 // and CERBERUS_TOKEN environment variables. These will always take precedence over
 // any arguments to the function
-func NewTokenAuth(cerberusURL string) (*TokenAuth, error) {
+func NewTokenAuth(cerberusURL, token string) (*TokenAuth, error) {
+	if os.Getenv("CERBERUS_TOKEN") != "" {
+		token = os.Getenv("CERBERUS_TOKEN")
+	}
+	if len(token) == 0 {
+		return nil, fmt.Errorf("Token cannot be empty")
+	}
+	t, err := newTokenAuth(cerberusURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.store.Store(token, time.Time{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// newTokenAuth returns a TokenAuth without a token
+func newTokenAuth(cerberusURL string) (*TokenAuth, error) {
 	// Check for the environment variable if the user has set it
 	if os.Getenv("CERBERUS_URL") != "" {
 		cerberusURL = os.Getenv("CERBERUS_URL")
@@ -54,66 +88,176 @@ func NewTokenAuth(cerberusURL string) (*TokenAuth, error) {
 	headers.Set("Content-Type", "application/json")
 	headers.Set("Accept", "application/json")
 	return &TokenAuth{
-		baseURL: parsedURL,
-		headers: headers,
+		baseURL:     parsedURL,
+		headers:     headers,
+		tokenHolder: newTokenHolder(),
 	}, nil
 }
 
-// GetToken returns the token passed when creating the TokenAuth. Nil should
-// be passed as the argument to the function. The argument exists for compatibility
+// NewTokenAuthFromFile returns a new TokenAuth that reads its token from a file, such as one
+// written to a shared volume by a Kubernetes init container or sidecar. Any whitespace around
+// the token is trimmed. Returns an error if the file can't be read or is empty. The file is
+// checked again whenever the token is used, and if it has changed the new token is loaded so
+// that a token rotated on disk is picked up without restarting. If a changed file can't be read
+// or is empty (for example while it is in the middle of being rewritten) the previous token is
+// kept. The URL can be set with the CERBERUS_URL environment variable like with NewTokenAuth
+func NewTokenAuthFromFile(cerberusURL, tokenFilePath string) (*TokenAuth, error) {
+	t, err := newTokenAuth(cerberusURL)
+	if err != nil {
+		return nil, err
+	}
+	t.tokenFile = &tokenFile{path: tokenFilePath}
+	if err := t.reloadTokenFile(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reloadTokenFile loads the token from the token file if there is one and it has
+// changed since it was last read
+func (t *TokenAuth) reloadTokenFile() error {
+	f := t.tokenFile
+	if f == nil {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("Unable to read token file %s: %v", f.path, err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("Unable to read token file %s: %v", f.path, err)
+	}
+	token := strings.TrimSpace(string(contents))
+	if token == "" {
+		return fmt.Errorf("Token file %s is empty", f.path)
+	}
+	if err := t.store.Store(token, time.Time{}); err != nil {
+		return err
+	}
+	// The policies and metadata of a token from a file aren't known
+	t.setPolicies(nil)
+	t.setMetadata(nil)
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+// GetToken returns the token passed when creating the TokenAuth, or an error if it has
+// been logged out. No requests are made, so the context is only there for compatibility
 // with the Auth interface
-func (t *TokenAuth) GetToken(f *os.File) (string, error) {
-	//if !t.IsAuthenticated() {
-	//	return "", api.ErrorUnauthenticated
-	//}
-	return t.token, nil
+func (t *TokenAuth) GetToken(ctx context.Context) (string, error) {
+	if err := t.checkDraining(); err != nil {
+		return "", err
+	}
+	// A failed reload keeps the current token, so the error is ignored
+	t.reloadTokenFile()
+	if !t.IsAuthenticated() {
+		return "", api.ErrorUnauthenticated
+	}
+	token, _, err := t.loadToken()
+	return token, err
 }
 
 // IsAuthenticated always returns true if there is a token. If Logout has been
 // called, it will return false
 func (t *TokenAuth) IsAuthenticated() bool {
-	return t.token != ""
+	token, _, err := t.loadToken()
+	return err == nil && token != ""
 }
 
-// Refresh attempts to refresh the token
+// Refresh attempts to refresh the token with the user refresh endpoint and replaces it
+// with the new token
 func (t *TokenAuth) Refresh() error {
-	//if !t.IsAuthenticated() {
-	//	return api.ErrorUnauthenticated
-	//}
-	r, err := Refresh(*t.baseURL, t.headers)
+	if !t.IsAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	headers, err := t.withToken(t.headers)
+	if err != nil {
+		return err
+	}
+	r, err := t.refreshRequest(*t.baseURL, headers)
+	if err != nil {
+		return err
+	}
+	// The expiry isn't tracked for tokens so it is left as the zero time
+	if err := t.store.Store(r.Data.ClientToken.ClientToken, time.Time{}); err != nil {
+		return err
+	}
+	t.setPolicies(r.Data.ClientToken.Policies)
+	t.setMetadata(&r.Data.ClientToken.Metadata)
+	t.notify(time.Time{})
+	return nil
+}
+
+// RenewToken extends the lease of the current token by increment (or by however long Cerberus
+// decides if it is 0) with the self-renew endpoint, keeping the same token. Unlike Refresh,
+// the expiry of the token is known afterwards and is returned by ExpiresAt. Returns
+// ErrorUnauthenticated if there is no token and ErrorRenewalLimitExceeded if Cerberus won't
+// renew it any further
+func (t *TokenAuth) RenewToken(increment time.Duration) error {
+	if !t.IsAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	expiry, err := t.renewToken(*t.baseURL, t.headers, increment)
 	if err != nil {
 		return err
 	}
-	t.token = r.Data.ClientToken.ClientToken
-	t.headers.Set("X-Vault-Token", r.Data.ClientToken.ClientToken)
+	t.notify(expiry)
 	return nil
 }
 
+// Lookup asks Cerberus to describe the current token, which is a cheap way to check it is still valid
+func (t *TokenAuth) Lookup() (*api.UserClientToken, error) {
+	return t.lookupToken(*t.baseURL, t.headers)
+}
+
 // Logout logs the current token out and removes it from the authentication type
 func (t *TokenAuth) Logout() error {
-	//if !t.IsAuthenticated() {
-	//	return api.ErrorUnauthenticated
-	//}
+	if !t.IsAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	finish := t.beginLogout()
+	defer finish()
+	headers, err := t.withToken(t.headers)
+	if err != nil {
+		return err
+	}
 	// Use a copy of the base URL
-	if err := Logout(*t.baseURL, t.headers); err != nil {
+	if err := t.logoutRequest(*t.baseURL, headers); err != nil {
 		return err
 	}
-	// Reset the token and header
-	t.token = ""
-	t.headers.Del("X-Vault-Token")
-	return nil
+	return t.clearToken()
+}
+
+// LogoutWithTimeout revokes the current token like Logout, but gives up if Cerberus
+// doesn't respond within the timeout. The token is cleared locally either way
+func (t *TokenAuth) LogoutWithTimeout(d time.Duration) error {
+	return t.logoutWithTimeout(d, *t.baseURL, t.headers)
 }
 
 // GetHeaders returns HTTP headers used for requests if the method is currently authenticated.
 // Returns an error otherwise
 func (t *TokenAuth) GetHeaders() (http.Header, error) {
-	//if !t.IsAuthenticated() {
-	//	return nil, api.ErrorUnauthenticated
-	//}
-	return t.headers, nil
+	t.reloadTokenFile()
+	if !t.IsAuthenticated() {
+		return nil, api.ErrorUnauthenticated
+	}
+	return t.requestHeaders(t.headers)
 }
 
 // GetURL returns the URL for cerberus
 func (t *TokenAuth) GetURL() *url.URL {
 	return t.baseURL
 }
+
+// SetBasePath sets the path that every endpoint is prefixed with, the same as
+// AWSAuth.SetBasePath
+func (t *TokenAuth) SetBasePath(basePath string) {
+	t.baseURL = withBasePath(t.baseURL, basePath)
+}
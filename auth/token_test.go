@@ -0,0 +1,483 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewTokenAuth(t *testing.T) {
+	Convey("A valid URL and token", t, func() {
+		a, err := NewTokenAuth("https://test.example.com", "yoda")
+		So(err, ShouldBeNil)
+		So(a, ShouldNotBeNil)
+		Convey("Should be authenticated with the token", func() {
+			So(a.IsAuthenticated(), ShouldBeTrue)
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "yoda")
+		})
+		Convey("Should return headers with the token", func() {
+			headers, err := a.GetHeaders()
+			So(err, ShouldBeNil)
+			So(headers.Get("X-Vault-Token"), ShouldEqual, "yoda")
+			So(headers.Get("X-Cerberus-Client"), ShouldEqual, api.ClientHeader)
+		})
+		Convey("Should return the URL", func() {
+			So(a.GetURL().String(), ShouldEqual, "https://test.example.com")
+		})
+	})
+
+	Convey("A token set by environment variable", t, func() {
+		os.Setenv("CERBERUS_TOKEN", "obi-wan")
+		Reset(func() {
+			os.Unsetenv("CERBERUS_TOKEN")
+		})
+		Convey("Should be used over the token passed in", func() {
+			a, err := NewTokenAuth("https://test.example.com", "yoda")
+			So(err, ShouldBeNil)
+			tok, _ := a.GetToken(context.Background())
+			So(tok, ShouldEqual, "obi-wan")
+		})
+		Convey("Should be used when no token is passed in", func() {
+			a, err := NewTokenAuth("https://test.example.com", "")
+			So(err, ShouldBeNil)
+			tok, _ := a.GetToken(context.Background())
+			So(tok, ShouldEqual, "obi-wan")
+		})
+	})
+
+	Convey("An empty token", t, func() {
+		a, err := NewTokenAuth("https://test.example.com", "")
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(a, ShouldBeNil)
+		})
+	})
+
+	Convey("An invalid URL", t, func() {
+		a, err := NewTokenAuth("https://test.example.com?a=query", "yoda")
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(a, ShouldBeNil)
+		})
+	})
+}
+
+func TestRefreshToken(t *testing.T) {
+	Convey("A valid TokenAuth", t, WithServer(api.AuthUserSuccess, http.StatusOK, "a-new-token", "/v2/auth/user/refresh", http.MethodGet, map[string]string{
+		"X-Vault-Token": "yoda",
+	}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should replace the token when refreshed", func() {
+			So(a.Refresh(), ShouldBeNil)
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-new-token")
+		})
+	}))
+}
+
+var renewedToken = `{
+    "auth": {
+        "client_token": "yoda",
+        "policies": ["jedi", "master"],
+        "lease_duration": 3600,
+        "renewable": true
+    }
+}`
+
+var renewalLimitError = `{
+    "error_id": "3e7a6e9f-1d5a-4eed-8e8a-6dc18bdf96db",
+    "errors": [{
+        "code": 99216,
+        "message": "The token has been renewed the maximum number of times"
+    }]
+}`
+
+var badIncrementError = `{
+    "error_id": "5d4dd2ec-5ee8-4d6f-a8f3-4f4a1b0e6a4b",
+    "errors": [{
+        "code": 99200,
+        "message": "The increment must be a positive number"
+    }]
+}`
+
+func TestRenewToken(t *testing.T) {
+	Convey("A valid TokenAuth", t, TestingServer(http.StatusOK, "/v1/auth/token/self-renew", http.MethodPost, renewedToken, map[string]string{
+		"X-Vault-Token": "yoda",
+	}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		So(a.ExpiresAt().IsZero(), ShouldBeTrue)
+		Convey("Should renew the token", func() {
+			So(a.RenewToken(time.Hour), ShouldBeNil)
+			Convey("And know when it expires", func() {
+				So(a.TokenTTL(), ShouldBeGreaterThan, 58*time.Minute)
+				So(a.TokenTTL(), ShouldBeLessThanOrEqualTo, time.Hour)
+			})
+			Convey("And keep the same token", func() {
+				tok, err := a.GetToken(context.Background())
+				So(err, ShouldBeNil)
+				So(tok, ShouldEqual, "yoda")
+				So(a.Policies(), ShouldResemble, []string{"jedi", "master"})
+			})
+		})
+	}))
+
+	Convey("A TokenAuth that can't be renewed any more", t, TestingServer(http.StatusBadRequest, "/v1/auth/token/self-renew", http.MethodPost, renewalLimitError, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should return ErrorRenewalLimitExceeded with the details", func() {
+			err := a.RenewToken(time.Hour)
+			So(errors.Is(err, api.ErrorRenewalLimitExceeded), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "renewed the maximum number of times")
+			Convey("And keep the token", func() {
+				So(a.IsAuthenticated(), ShouldBeTrue)
+			})
+		})
+	}))
+
+	Convey("A TokenAuth whose renewal is rejected for another reason", t, TestingServer(http.StatusBadRequest, "/v1/auth/token/self-renew", http.MethodPost, badIncrementError, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should return the error from Cerberus", func() {
+			err := a.RenewToken(time.Hour)
+			So(errors.Is(err, api.ErrorRenewalLimitExceeded), ShouldBeFalse)
+			var apiErr *api.CerberusError
+			So(errors.As(err, &apiErr), ShouldBeTrue)
+			So(apiErr.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(err.Error(), ShouldContainSubstring, "increment must be a positive number")
+		})
+	}))
+
+	Convey("A TokenAuth without a token", t, func() {
+		a, err := NewTokenAuth("https://test.example.com", "yoda")
+		So(err, ShouldBeNil)
+		So(a.store.Clear(), ShouldBeNil)
+		Convey("Should not renew", func() {
+			So(a.RenewToken(time.Hour), ShouldEqual, api.ErrorUnauthenticated)
+		})
+	})
+}
+
+var tokenLookup = `{
+    "data": {
+        "id": "yoda",
+        "policies": ["jedi", "master"],
+        "meta": {
+            "username": "yoda@jedi.org",
+            "is_admin": "true",
+            "groups": "council"
+        },
+        "ttl": 1800,
+        "renewable": true
+    }
+}`
+
+func TestLookupToken(t *testing.T) {
+	Convey("A valid token", t, TestingServer(http.StatusOK, "/v1/auth/token/lookup-self", http.MethodGet, tokenLookup, map[string]string{
+		"X-Vault-Token": "yoda",
+	}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should be described by Cerberus", func() {
+			tok, err := a.Lookup()
+			So(err, ShouldBeNil)
+			So(tok.ClientToken, ShouldEqual, "yoda")
+			So(tok.Policies, ShouldResemble, []string{"jedi", "master"})
+			So(tok.Metadata.Username, ShouldEqual, "yoda@jedi.org")
+			So(tok.Metadata.IsAdmin, ShouldEqual, "true")
+			So(tok.Duration, ShouldEqual, 1800)
+			So(tok.Renewable, ShouldBeTrue)
+		})
+	}))
+
+	Convey("An expired token", t, TestingServer(http.StatusForbidden, "/v1/auth/token/lookup-self", http.MethodGet, `{"errors": ["permission denied"]}`, map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should be rejected", func() {
+			tok, err := a.Lookup()
+			So(err, ShouldEqual, api.ErrorUnauthorized)
+			So(tok, ShouldBeNil)
+		})
+	}))
+
+	Convey("A TokenAuth with no token", t, TestingServer(http.StatusNoContent, "/v1/auth", http.MethodDelete, "", map[string]string{}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		So(a.Logout(), ShouldBeNil)
+		Convey("Should not be looked up", func() {
+			tok, err := a.Lookup()
+			So(err, ShouldEqual, api.ErrorUnauthenticated)
+			So(tok, ShouldBeNil)
+		})
+	}))
+}
+
+func TestLogoutToken(t *testing.T) {
+	Convey("A valid TokenAuth", t, TestingServer(http.StatusNoContent, "/v1/auth", http.MethodDelete, "", map[string]string{
+		"X-Vault-Token": "yoda",
+	}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should log out", func() {
+			So(a.Logout(), ShouldBeNil)
+			Convey("And should no longer be authenticated", func() {
+				So(a.IsAuthenticated(), ShouldBeFalse)
+				_, err := a.GetToken(context.Background())
+				So(err, ShouldEqual, api.ErrorUnauthenticated)
+				_, err = a.GetHeaders()
+				So(err, ShouldEqual, api.ErrorUnauthenticated)
+				So(a.Refresh(), ShouldEqual, api.ErrorUnauthenticated)
+				So(a.Logout(), ShouldEqual, api.ErrorUnauthenticated)
+			})
+		})
+	}))
+}
+
+func TestBasePathToken(t *testing.T) {
+	Convey("A TokenAuth behind a path prefix", t, TestingServer(http.StatusNoContent, "/cerberus/v1/auth", http.MethodDelete, "", map[string]string{
+		"X-Vault-Token": "yoda",
+	}, func(ts *httptest.Server) {
+		a, err := NewTokenAuth(ts.URL+"/cerberus", "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should log out under the base path", func() {
+			So(a.Logout(), ShouldBeNil)
+		})
+	}))
+}
+
+func TestProxyToken(t *testing.T) {
+	Convey("A TokenAuth with a proxy", t, func() {
+		var connects []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodConnect {
+				connects = append(connects, r.Host)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer proxy.Close()
+		tok, err := NewTokenAuth("https://cerberus.example.com", "yoda")
+		So(err, ShouldBeNil)
+		So(tok.SetProxy("http://"+proxy.Listener.Addr().String()), ShouldBeNil)
+		Convey("Should send requests to Cerberus through the proxy", func() {
+			So(tok.Refresh(), ShouldNotBeNil)
+			So(connects, ShouldResemble, []string{"cerberus.example.com:443"})
+		})
+	})
+
+	Convey("A TokenAuth given an invalid proxy", t, func() {
+		tok, err := NewTokenAuth("https://cerberus.example.com", "yoda")
+		So(err, ShouldBeNil)
+		So(tok.SetProxy("ftp://proxy.example.com"), ShouldNotBeNil)
+	})
+}
+
+func TestTLSConfigToken(t *testing.T) {
+	Convey("A TokenAuth for a server that requires a client certificate", t, func() {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		ts.StartTLS()
+		defer ts.Close()
+		rootCAs := x509.NewCertPool()
+		rootCAs.AddCert(ts.Certificate())
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should present the certificate it was given", func() {
+			a.SetTLSConfig(&tls.Config{RootCAs: rootCAs, Certificates: ts.TLS.Certificates})
+			So(a.Logout(), ShouldBeNil)
+		})
+		Convey("Should fail the handshake without a certificate", func() {
+			a.SetTLSConfig(&tls.Config{RootCAs: rootCAs})
+			So(a.Logout(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUserAgentToken(t *testing.T) {
+	Convey("A TokenAuth", t, func() {
+		var userAgents []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgents = append(userAgents, r.Header.Get("User-Agent"))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		Convey("Should send the default user agent", func() {
+			So(a.Logout(), ShouldBeNil)
+			So(userAgents, ShouldResemble, []string{api.DefaultUserAgent})
+		})
+		Convey("Should send the user agent it was given", func() {
+			So(a.SetUserAgent("dagobah-service/1.0"), ShouldBeNil)
+			So(a.Logout(), ShouldBeNil)
+			So(userAgents, ShouldResemble, []string{"dagobah-service/1.0"})
+		})
+		Convey("Should reject an invalid user agent", func() {
+			So(a.SetUserAgent("dagobah\r\nX-Injected: true"), ShouldNotBeNil)
+		})
+	})
+}
+
+// roundTripFunc lets a function be used as an http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWrapTransportToken(t *testing.T) {
+	Convey("A TokenAuth with a wrapped transport", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		var paths []string
+		a.WrapTransport(func(next http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Path)
+				return next.RoundTrip(req)
+			})
+		})
+		Convey("Should send requests through the wrapper", func() {
+			So(a.Logout(), ShouldBeNil)
+			So(paths, ShouldResemble, []string{"/v1/auth"})
+		})
+		Convey("Should keep the wrapper when the transport is rebuilt", func() {
+			So(a.SetUserAgent("dagobah-service/1.0"), ShouldBeNil)
+			So(a.Logout(), ShouldBeNil)
+			So(paths, ShouldResemble, []string{"/v1/auth"})
+		})
+		Convey("Should stop using the wrapper when it is removed", func() {
+			a.WrapTransport(nil)
+			So(a.Logout(), ShouldBeNil)
+			So(paths, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestTimeoutToken(t *testing.T) {
+	Convey("A TokenAuth with a timeout talking to a server that hangs", t, func() {
+		ts := slowServer()
+		a, err := NewTokenAuth(ts.URL, "yoda")
+		So(err, ShouldBeNil)
+		a.SetTimeout(50 * time.Millisecond)
+		So(a.requestTimeout(), ShouldEqual, 50*time.Millisecond)
+		Convey("Should give up on renewing after the timeout", func() {
+			start := time.Now()
+			err := a.RenewToken(time.Hour)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Convey("Should give up on looking up the token after the timeout", func() {
+			start := time.Now()
+			_, err := a.Lookup()
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Convey("Should give up on logging out after the timeout", func() {
+			start := time.Now()
+			err := a.Logout()
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestNewTokenAuthFromFile(t *testing.T) {
+	Convey("A token file", t, func() {
+		dir, err := ioutil.TempDir("", "cerberus-token")
+		So(err, ShouldBeNil)
+		path := filepath.Join(dir, "token")
+		So(ioutil.WriteFile(path, []byte("  a-mounted-token\n"), 0600), ShouldBeNil)
+		a, err := NewTokenAuthFromFile("https://test.example.com", path)
+		So(err, ShouldBeNil)
+		So(a, ShouldNotBeNil)
+		Convey("Should load the trimmed token", func() {
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-mounted-token")
+			headers, err := a.GetHeaders()
+			So(err, ShouldBeNil)
+			So(headers.Get("X-Vault-Token"), ShouldEqual, "a-mounted-token")
+		})
+		Convey("Should pick up a rotated token", func() {
+			So(ioutil.WriteFile(path, []byte("a-rotated-token\n"), 0600), ShouldBeNil)
+			// Make sure the change is seen even on file systems with coarse timestamps
+			later := time.Now().Add(time.Minute)
+			So(os.Chtimes(path, later, later), ShouldBeNil)
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-rotated-token")
+		})
+		Convey("Should keep the old token if the file is emptied", func() {
+			So(ioutil.WriteFile(path, []byte("\n"), 0600), ShouldBeNil)
+			tok, err := a.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-mounted-token")
+		})
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+
+	Convey("A missing token file", t, func() {
+		a, err := NewTokenAuthFromFile("https://test.example.com", "/does/not/exist")
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(a, ShouldBeNil)
+		})
+	})
+
+	Convey("An empty token file", t, func() {
+		f, err := ioutil.TempFile("", "cerberus-token")
+		So(err, ShouldBeNil)
+		f.WriteString(" \n")
+		f.Close()
+		a, err := NewTokenAuthFromFile("https://test.example.com", f.Name())
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "empty")
+			So(a, ShouldBeNil)
+		})
+		Reset(func() {
+			os.Remove(f.Name())
+		})
+	})
+}
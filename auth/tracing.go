@@ -0,0 +1,61 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The names of the spans started by the auth types
+const (
+	spanAuthenticate = "cerberus.authenticate"
+	spanRefresh      = "cerberus.refresh"
+	spanRenew        = "cerberus.renew"
+	spanLookup       = "cerberus.lookup"
+	spanLogout       = "cerberus.logout"
+)
+
+// tracingState holds the tracer of an auth type, which is nil when tracing is off. Like
+// metricsState, it is shared by pointer between copies of a tokenHolder
+type tracingState struct {
+	lock   sync.RWMutex
+	tracer trace.Tracer
+}
+
+// SetTracerProvider turns on OpenTelemetry tracing, so that logging in, refreshing, and logging
+// out each get a span (cerberus.authenticate, cerberus.refresh, and cerberus.logout) under the
+// span in the context they are given. Spans have the endpoint, the HTTP status code, and for
+// the AWS auth types the region as attributes, and are marked as failed when there is an error.
+// Tracing is off by default, and passing nil turns it off again
+func (t *tokenHolder) SetTracerProvider(tp trace.TracerProvider) {
+	t.tracing.lock.Lock()
+	defer t.tracing.lock.Unlock()
+	t.tracing.tracer = utils.Tracer(tp)
+}
+
+// startSpan starts a span if tracing is on. The returned span is nil (and a no-op) if it isn't
+func (t *tokenHolder) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, *utils.Span) {
+	t.tracing.lock.RLock()
+	tracer := t.tracing.tracer
+	t.tracing.lock.RUnlock()
+	return utils.StartSpan(ctx, tracer, name, attrs...)
+}
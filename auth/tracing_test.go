@@ -0,0 +1,89 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttribute returns the value of the attribute with the given key on a span, or nil
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) interface{} {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.AsInterface()
+		}
+	}
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	Convey("A user auth with tracing on", t, func() {
+		ts := userServer(http.StatusOK)
+		defer ts.Close()
+		recorder := tracetest.NewSpanRecorder()
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		u.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		_, err := u.GetToken(context.Background())
+		So(err, ShouldBeNil)
+		Convey("Should trace logging in", func() {
+			spans := recorder.Ended()
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].Name(), ShouldEqual, spanAuthenticate)
+			So(spans[0].Status().Code, ShouldEqual, codes.Unset)
+			So(spanAttribute(spans[0], string(utils.AttributeEndpoint)), ShouldEqual, "/v2/auth/user")
+			So(spanAttribute(spans[0], string(utils.AttributeStatusCode)), ShouldEqual, http.StatusOK)
+		})
+		Convey("Should trace a refresh and a logout", func() {
+			So(u.Refresh(), ShouldBeNil)
+			So(u.Logout(), ShouldBeNil)
+			spans := recorder.Ended()
+			So(spans, ShouldHaveLength, 3)
+			So(spans[1].Name(), ShouldEqual, spanRefresh)
+			So(spans[2].Name(), ShouldEqual, spanLogout)
+			So(spanAttribute(spans[2], string(utils.AttributeStatusCode)), ShouldEqual, http.StatusNoContent)
+		})
+		Convey("Should stop tracing when the provider is set to nil", func() {
+			u.SetTracerProvider(nil)
+			So(u.Logout(), ShouldBeNil)
+			So(recorder.Ended(), ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("A failed login with tracing on", t, func() {
+		ts := userServer(http.StatusUnauthorized)
+		defer ts.Close()
+		recorder := tracetest.NewSpanRecorder()
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		u.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		_, err := u.GetToken(context.Background())
+		So(err, ShouldNotBeNil)
+		Convey("Should mark the span as failed", func() {
+			spans := recorder.Ended()
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].Status().Code, ShouldEqual, codes.Error)
+			So(spanAttribute(spans[0], string(utils.AttributeStatusCode)), ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
@@ -19,28 +19,42 @@ package auth
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
 	"github.com/ecimionatto/cerberus-go-client/utils"
 )
 
+// ErrorNoMFADevices is returned when Cerberus asks for an MFA token but the user has no
+// MFA devices enrolled to get one from
+var ErrorNoMFADevices = fmt.Errorf("MFA is required but no MFA devices are enrolled")
+
+// ErrorNoMFAToken is returned when MFA is required and no token could be read from the MFA input
+var ErrorNoMFAToken = fmt.Errorf("MFA is required but no MFA token was given")
+
 // UserAuth uses username and password authentication to authenticate against Cerberus
 type UserAuth struct {
-	username string
-	password string
-	baseURL  *url.URL
-	token    string
-	expiry   time.Time
-	headers  http.Header
-	client   *http.Client
+	// refreshes counts the refresh requests sent to Cerberus. It is only accessed atomically
+	// and is kept first so that it is 64-bit aligned on 32-bit platforms
+	refreshes int64
+	username  string
+	password  string
+	baseURL   *url.URL
+	headers   http.Header
+	// mfaInput is where MFA tokens are read from. Nil means prompting on stdin
+	mfaInput io.Reader
+	tokenHolder
+	refreshNotifier
 }
 
 // NewUserAuth returns a new UserAuth object given a valid Cerberus URL, username, and password
@@ -71,22 +85,33 @@ func NewUserAuth(cerberusURL, username, password string) (*UserAuth, error) {
 			"Content-Type":      []string{"application/json"},
 			"X-Cerberus-Client": []string{api.ClientHeader},
 		},
-		client: &http.Client{},
+		tokenHolder: newTokenHolder(),
 	}, nil
 }
 
+// SetMFAInput sets where the MFA token is read from when MFA is required. The token is
+// read up to the first new line. By default it is prompted for on stdin
+func (u *UserAuth) SetMFAInput(r io.Reader) {
+	u.mfaInput = r
+}
+
 // GetToken returns an existing token or performs all authentication steps
 // necessary to get a new token. This should be called to authenticate the
-// client once it has been setup
-func (u *UserAuth) GetToken(f *os.File) (string, error) {
-	if u.IsAuthenticated() {
-		return u.token, nil
-	}
-	// Try to log in
-	if err := u.authenticate(f); err != nil {
+// client once it has been setup. If MFA is required, the token is read from the
+// input set with SetMFAInput. The requests to Cerberus are abandoned if the context
+// is cancelled or its deadline passes
+func (u *UserAuth) GetToken(ctx context.Context) (string, error) {
+	if err := u.checkDraining(); err != nil {
 		return "", err
 	}
-	return u.token, nil
+	if !u.IsAuthenticated() {
+		// Try to log in
+		if err := u.authenticate(ctx); err != nil {
+			return "", err
+		}
+	}
+	token, _, err := u.loadToken()
+	return token, err
 }
 
 // GetURL returns the URL used for Cerberus
@@ -94,10 +119,23 @@ func (u *UserAuth) GetURL() *url.URL {
 	return u.baseURL
 }
 
+// SetBasePath sets the path that every endpoint is prefixed with, the same as
+// AWSAuth.SetBasePath
+func (u *UserAuth) SetBasePath(basePath string) {
+	u.baseURL = withBasePath(u.baseURL, basePath)
+}
+
+// Reauthenticate logs in again with the username and password, replacing the current token.
+// If MFA is required, the token is read the same way as in GetToken
+func (u *UserAuth) Reauthenticate() error {
+	return u.authenticate(context.Background())
+}
+
 // IsAuthenticated returns whether or not there is a valid token. A valid token
 // is one that exists and is not expired
 func (u *UserAuth) IsAuthenticated() bool {
-	return len(u.token) > 0 && time.Now().Before(u.expiry)
+	token, expiry, err := u.loadToken()
+	return err == nil && len(token) > 0 && time.Now().Before(expiry)
 }
 
 // Refresh uses the current valid token to retrieve a new one. Returns
@@ -106,29 +144,94 @@ func (u *UserAuth) Refresh() error {
 	if !u.IsAuthenticated() {
 		return api.ErrorUnauthenticated
 	}
+	headers, err := u.withToken(u.headers)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&u.refreshes, 1)
 	// Pass a copy of the base URL
-	r, err := Refresh(*u.baseURL, u.headers)
+	r, err := u.refreshRequest(*u.baseURL, headers)
 	if err != nil {
 		return err
 	}
-	u.setToken(r.Data.ClientToken.ClientToken, r.Data.ClientToken.Duration)
+	u.setPolicies(r.Data.ClientToken.Policies)
+	u.setMetadata(&r.Data.ClientToken.Metadata)
+	return u.setToken(r.Data.ClientToken.ClientToken, r.Data.ClientToken.Duration)
+}
+
+// RenewToken extends the lease of the current token by increment (or by however long Cerberus
+// decides if it is 0) with the self-renew endpoint. This keeps the same token and is cheaper
+// than Refresh, which gets a new one. Returns ErrorUnauthenticated if not already authenticated
+// and ErrorRenewalLimitExceeded if Cerberus won't renew the token any further, in which case
+// Reauthenticate gets a new one
+func (u *UserAuth) RenewToken(increment time.Duration) error {
+	if !u.IsAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	expiry, err := u.renewToken(*u.baseURL, u.headers, increment)
+	if err != nil {
+		return err
+	}
+	u.notify(expiry)
 	return nil
 }
 
+// Lookup asks Cerberus to describe the current token, which is a cheap way to check it is still valid
+func (u *UserAuth) Lookup() (*api.UserClientToken, error) {
+	return u.lookupToken(*u.baseURL, u.headers)
+}
+
+// RefreshIfNeeded refreshes the token only if it expires within the given window, and otherwise
+// keeps the current token without contacting Cerberus. It returns whether a refresh request was
+// sent to Cerberus, which lets callers that are careful about rate limits see how often they are
+// really refreshing. Returns ErrorUnauthenticated if not already authenticated
+func (u *UserAuth) RefreshIfNeeded(window time.Duration) (bool, error) {
+	if !u.IsAuthenticated() {
+		return false, api.ErrorUnauthenticated
+	}
+	_, expiry, err := u.loadToken()
+	if err != nil {
+		return false, err
+	}
+	if time.Until(expiry) > window {
+		return false, nil
+	}
+	return true, u.Refresh()
+}
+
+// RefreshCount returns the number of refresh requests that have been sent to Cerberus,
+// including ones that failed
+func (u *UserAuth) RefreshCount() int64 {
+	return atomic.LoadInt64(&u.refreshes)
+}
+
 // Logout revokes the current token. Returns ErrorUnauthenticated if
 // not already authenticated
 func (u *UserAuth) Logout() error {
 	if !u.IsAuthenticated() {
 		return api.ErrorUnauthenticated
 	}
+	finish := u.beginLogout()
+	defer finish()
+	headers, err := u.withToken(u.headers)
+	if err != nil {
+		return err
+	}
 	// Use a copy of the base URL
-	if err := Logout(*u.baseURL, u.headers); err != nil {
+	if err := u.logoutRequest(*u.baseURL, headers); err != nil {
 		return err
 	}
-	// Reset the token and header
-	u.token = ""
-	u.headers.Del("X-Vault-Token")
-	return nil
+	return u.clearToken()
+}
+
+// LogoutWithTimeout revokes the current token like Logout, but gives up if Cerberus
+// doesn't respond within the timeout. The token is cleared locally either way. Returns
+// ErrorUnauthenticated if not already authenticated
+func (u *UserAuth) LogoutWithTimeout(d time.Duration) error {
+	if !u.IsAuthenticated() {
+		return api.ErrorUnauthenticated
+	}
+	return u.logoutWithTimeout(d, *u.baseURL, u.headers)
 }
 
 // GetHeaders is a helper for any client using the authentication strategy.
@@ -138,10 +241,16 @@ func (u *UserAuth) GetHeaders() (http.Header, error) {
 	if !u.IsAuthenticated() {
 		return nil, api.ErrorUnauthenticated
 	}
-	return u.headers, nil
+	return u.requestHeaders(u.headers)
 }
 
-func (u *UserAuth) authenticate(f *os.File) error {
+// authenticate logs in with the username and password and counts whether that worked
+func (u *UserAuth) authenticate(ctx context.Context) error {
+	ctx, span := u.startSpan(ctx, spanAuthenticate)
+	return span.End(u.recordAuth(u.login(ctx)))
+}
+
+func (u *UserAuth) login(ctx context.Context) error {
 	encodedCreds := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", u.username, u.password)))
 	headers := http.Header{
 		"Authorization":     []string{fmt.Sprintf("Basic %s", encodedCreds)},
@@ -149,16 +258,20 @@ func (u *UserAuth) authenticate(f *os.File) error {
 	}
 	// Make a copy of the base URL
 	builtURL := *u.baseURL
-	builtURL.Path = "/v2/auth/user"
+	endpoint := u.endpoints.userLogin
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
 	req, err := http.NewRequest("GET", builtURL.String(), nil)
 	if err != nil {
 		return err
 	}
 	req.Header = headers
-	resp, err := u.client.Do(req)
+	start := time.Now()
+	resp, err := send(ctx, u.httpClient(u.requestTimeout()), u.logger(), req, 1)
+	observeRequest(ctx, u.metricsRecorder(), endpoint, resp, start)
 	if err != nil {
-		return fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+		return requestError(ctx, err)
 	}
+	defer closeBody(resp)
 	r, checkErr := utils.CheckAndParse(resp)
 	if checkErr != nil {
 		return checkErr
@@ -166,61 +279,78 @@ func (u *UserAuth) authenticate(f *os.File) error {
 	// Check for MFA
 	if r.Status == api.AuthUserNeedsMFA {
 		// If MFA is enabled, there should always be at least one device
+		if len(r.Data.Devices) == 0 {
+			return ErrorNoMFADevices
+		}
 		// TODO: This ain't pretty because it only works for one device. See comment in doMFA as well
-		return u.doMFA(r.Data.StateToken, r.Data.Devices[0].ID, f)
+		return u.doMFA(ctx, r.Data.StateToken, r.Data.Devices[0].ID)
 	}
-	u.setToken(r.Data.ClientToken.ClientToken, r.Data.ClientToken.Duration)
-	return nil
+	u.setPolicies(r.Data.ClientToken.Policies)
+	u.setMetadata(&r.Data.ClientToken.Metadata)
+	return u.setToken(r.Data.ClientToken.ClientToken, r.Data.ClientToken.Duration)
 }
 
-// doMFA is the handler for MFA and reads a OTP token from a file. If file is nil, os.Stdin is used
-func (u *UserAuth) doMFA(stateToken, deviceID string, readFrom *os.File) error {
+// doMFA is the handler for MFA and reads a OTP token from the MFA input. If there is no input
+// set, os.Stdin is used
+func (u *UserAuth) doMFA(ctx context.Context, stateToken, deviceID string) error {
 	// TODO: There has got to be a smarter way to do this. This is copied from the python client logic
 	var body = map[string]string{
 		"device_id":   deviceID,
 		"state_token": stateToken,
 	}
-	var source *os.File
-	// Set the source of the input
-	if readFrom == nil {
+	source := u.mfaInput
+	if source == nil {
 		source = os.Stdin
-	} else {
-		source = readFrom
+		// Only print a prompt if the source is stdin
+		fmt.Print("Enter token from device: ")
 	}
 	// Capture the OTP from the user
 	reader := bufio.NewReader(source)
-	// Only print a prompt if the source is stdin
-	if source == os.Stdin {
-		fmt.Print("Enter token from device: ")
-	}
 	token, _ := reader.ReadString('\n')
 	// Clean it up and put it in the body
-	body["otp_token"] = strings.TrimSpace(token)
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrorNoMFAToken
+	}
+	body["otp_token"] = token
 	// Make a copy of the base URL
 	builtURL := *u.baseURL
-	builtURL.Path = "/v2/auth/mfa_check"
+	endpoint := u.endpoints.mfaCheck
+	builtURL.Path = utils.JoinPath(builtURL.Path, endpoint)
 	// Put the body into a buffer
 	data := &bytes.Buffer{}
 	if err := json.NewEncoder(data).Encode(body); err != nil {
 		return fmt.Errorf("Error while trying to encode MFA response: %v", err)
 	}
-	resp, err := http.Post(builtURL.String(), "application/json", data)
+	req, err := http.NewRequest(http.MethodPost, builtURL.String(), data)
 	if err != nil {
-		return fmt.Errorf("Problem while performing request to Cerberus: %v", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cerberus-Client", api.ClientHeader)
+	start := time.Now()
+	resp, err := send(ctx, u.httpClient(u.requestTimeout()), u.logger(), req, 1)
+	observeRequest(ctx, u.metricsRecorder(), endpoint, resp, start)
+	if err != nil {
+		return requestError(ctx, err)
 	}
+	defer closeBody(resp)
 	r, checkErr := utils.CheckAndParse(resp)
 	if checkErr != nil {
 		return checkErr
 	}
-	u.setToken(r.Data.ClientToken.ClientToken, r.Data.ClientToken.Duration)
-	return nil
+	u.setPolicies(r.Data.ClientToken.Policies)
+	u.setMetadata(&r.Data.ClientToken.Metadata)
+	return u.setToken(r.Data.ClientToken.ClientToken, r.Data.ClientToken.Duration)
 }
 
 // setToken is a helper method so that both the traditional and MFA user auth methods can set the token
 // without repeating any logic
-func (u *UserAuth) setToken(token string, duration int) {
-	u.token = token
-	// Set the auth header up to make things easier
-	u.headers.Set("X-Vault-Token", token)
-	u.expiry = time.Now().Add((time.Duration(duration) * time.Second) - expiryDelta)
+func (u *UserAuth) setToken(token string, duration int) error {
+	expiry := time.Now().Add((time.Duration(duration) * time.Second) - expiryDelta)
+	if err := u.store.Store(token, expiry); err != nil {
+		return err
+	}
+	u.notify(expiry)
+	return nil
 }
@@ -17,13 +17,15 @@ limitations under the License.
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -89,7 +91,7 @@ func TestNewUserAuth(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 		Convey("should error with invalid URL", func() {
-			c, err := NewUserAuth("https://test.example.com/a/path", "user", "password")
+			c, err := NewUserAuth("https://test.example.com?a=query", "user", "password")
 			So(c, ShouldBeNil)
 			So(err, ShouldNotBeNil)
 		})
@@ -123,11 +125,11 @@ func TestNewUserAuth(t *testing.T) {
 
 func TestGetURL(t *testing.T) {
 	Convey("A valid client", t, func() {
-		c, _ := NewUserAuth("http://example.com", "user", "pass")
+		c, _ := NewUserAuth("https://example.com", "user", "pass")
 		So(c, ShouldNotBeNil)
 		Convey("Should return URL", func() {
 			So(c.GetURL(), ShouldNotBeNil)
-			So(c.GetURL().String(), ShouldEqual, "http://example.com")
+			So(c.GetURL().String(), ShouldEqual, "https://example.com")
 		})
 	})
 }
@@ -164,14 +166,21 @@ func TestGetTokenUser(t *testing.T) {
 		c, _ := NewUserAuth(ts.URL, "user", "password")
 		So(c, ShouldNotBeNil)
 		Convey("Should return a valid token", func() {
-			t, err := c.GetToken(nil)
+			t, err := c.GetToken(context.Background())
 			So(err, ShouldBeNil)
 			So(t, ShouldEqual, token)
 			Convey("And should have a valid expiry time", func() {
-				So(c.expiry, ShouldHappenOnOrBefore, time.Now().Add(1*time.Hour))
+				_, expiry, _ := c.loadToken()
+				So(expiry, ShouldHappenOnOrBefore, time.Now().Add(1*time.Hour))
 			})
 			Convey("X-Vault-Token header should be set", func() {
-				So(c.headers.Get("X-Vault-Token"), ShouldEqual, token)
+				headers, _ := c.GetHeaders()
+				So(headers.Get("X-Vault-Token"), ShouldEqual, token)
+			})
+			Convey("And should capture the granted policies", func() {
+				So(c.Policies(), ShouldResemble, []string{"web", "stage"})
+				So(c.HasPolicy("web"), ShouldBeTrue)
+				So(c.MatchPolicy("st*"), ShouldBeTrue)
 			})
 		})
 	}))
@@ -180,7 +189,7 @@ func TestGetTokenUser(t *testing.T) {
 		c, _ := NewUserAuth(ts.URL, "user", "password")
 		So(c, ShouldNotBeNil)
 		Convey("Should return an error", func() {
-			t, err := c.GetToken(nil)
+			t, err := c.GetToken(context.Background())
 			So(err, ShouldNotBeNil)
 			So(err, ShouldEqual, api.ErrorUnauthorized)
 			So(t, ShouldBeEmpty)
@@ -191,7 +200,7 @@ func TestGetTokenUser(t *testing.T) {
 		c, _ := NewUserAuth(ts.URL, "user", "password")
 		So(c, ShouldNotBeNil)
 		Convey("Should return an error", func() {
-			t, err := c.GetToken(nil)
+			t, err := c.GetToken(context.Background())
 			So(err, ShouldNotBeNil)
 			So(t, ShouldBeEmpty)
 		})
@@ -201,7 +210,7 @@ func TestGetTokenUser(t *testing.T) {
 		c, _ := NewUserAuth("http://127.0.0.1:32876", "user", "password")
 		So(c, ShouldNotBeNil)
 		Convey("Should error", func() {
-			_, err := c.GetToken(nil)
+			_, err := c.GetToken(context.Background())
 			So(err, ShouldNotBeNil)
 		})
 	})
@@ -230,7 +239,9 @@ func TestGetTokenUser(t *testing.T) {
 				c.So(body, ShouldContainKey, "state_token")
 				c.So(body["state_token"], ShouldEqual, "5c7d1fd1914ffff5bcc2253b3c38ef85a3125bc1")
 				c.So(body, ShouldContainKey, "otp_token")
-				c.So(body["otp_token"], ShouldNotBeEmpty)
+				c.So(body["otp_token"], ShouldEqual, "acooltoken")
+				c.So(body["device_id"], ShouldEqual, "111111")
+				c.So(r.Header.Get("X-Cerberus-Client"), ShouldEqual, api.ClientHeader)
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte(fmt.Sprintf(validLogin, api.AuthUserSuccess, token)))
@@ -238,29 +249,63 @@ func TestGetTokenUser(t *testing.T) {
 			client, _ := NewUserAuth(ts.URL, "user", "password")
 			So(client, ShouldNotBeNil)
 			Convey("Should return a valid token", func() {
-				// Create a temp file for testing the otp token
-				in, err := ioutil.TempFile("", "")
-				So(err, ShouldBeNil)
-				defer in.Close()
-				defer os.Remove(in.Name())
-				io.WriteString(in, "acooltoken\n")
-				// Reset the file to the beginning
-				in.Seek(0, os.SEEK_SET)
-				t, err := client.GetToken(in)
+				client.SetMFAInput(strings.NewReader("acooltoken\n"))
+				t, err := client.GetToken(context.Background())
 				So(err, ShouldBeNil)
 				So(t, ShouldEqual, token)
 				Convey("And should have a valid expiry time", func() {
-					So(client.expiry, ShouldHappenOnOrBefore, time.Now().Add(3600*time.Second))
+					_, expiry, _ := client.loadToken()
+					So(expiry, ShouldHappenOnOrBefore, time.Now().Add(3600*time.Second))
 				})
 			})
 		})
 	})
+	Convey("A user that needs MFA", t, func() {
+		var mfaChecks int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if strings.HasPrefix(r.URL.Path, "/v2/auth/mfa_check") {
+				atomic.AddInt32(&mfaChecks, 1)
+				w.Write([]byte(fmt.Sprintf(validLogin, api.AuthUserSuccess, "a-token")))
+				return
+			}
+			w.Write([]byte(fmt.Sprintf(validLoginMFA, api.AuthUserNeedsMFA, "a-state-token")))
+		}))
+		Reset(ts.Close)
+		client, _ := NewUserAuth(ts.URL, "user", "password")
+		So(client, ShouldNotBeNil)
+		Convey("Should error without checking MFA if no token is given", func() {
+			client.SetMFAInput(strings.NewReader(""))
+			tok, err := client.GetToken(context.Background())
+			So(tok, ShouldBeEmpty)
+			So(err, ShouldEqual, ErrorNoMFAToken)
+			So(atomic.LoadInt32(&mfaChecks), ShouldEqual, 0)
+		})
+		Convey("Should read a token without a trailing newline", func() {
+			client.SetMFAInput(strings.NewReader(" 123456 "))
+			tok, err := client.GetToken(context.Background())
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "a-token")
+		})
+	})
+
+	Convey("A user that needs MFA with no devices", t, TestingServer(http.StatusOK, "/v2/auth/user", http.MethodGet, fmt.Sprintf(`{"status": "%s", "data": {"state_token": "a-state-token", "devices": []}}`, api.AuthUserNeedsMFA), map[string]string{}, func(ts *httptest.Server) {
+		client, _ := NewUserAuth(ts.URL, "user", "password")
+		So(client, ShouldNotBeNil)
+		client.SetMFAInput(strings.NewReader("123456\n"))
+		Convey("Should error instead of panicking", func() {
+			tok, err := client.GetToken(context.Background())
+			So(tok, ShouldBeEmpty)
+			So(err, ShouldEqual, ErrorNoMFADevices)
+		})
+	}))
+
 	Convey("GetToken if already authenticated", t, func() {
-		c, _ := NewUserAuth("http://example.com", "user", "password")
+		c, _ := NewUserAuth("https://example.com", "user", "password")
 		So(c, ShouldNotBeNil)
 		c.setToken("test-token", 3600)
 		Convey("Should return token", func() {
-			t, err := c.GetToken(nil)
+			t, err := c.GetToken(context.Background())
 			So(err, ShouldBeNil)
 			So(t, ShouldEqual, "test-token")
 		})
@@ -276,18 +321,21 @@ func TestRefreshUser(t *testing.T) {
 		Convey("Should return a new valid token", func() {
 			err := c.Refresh()
 			So(err, ShouldBeNil)
-			So(c.token, ShouldEqual, token)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldEqual, token)
 			Convey("And should have a valid expiry time", func() {
-				So(c.expiry, ShouldHappenOnOrBefore, time.Now().Add(3600*time.Second))
+				_, expiry, _ := c.loadToken()
+				So(expiry, ShouldHappenOnOrBefore, time.Now().Add(3600*time.Second))
 			})
 			Convey("X-Vault-Token header should be set", func() {
-				So(c.headers.Get("X-Vault-Token"), ShouldEqual, token)
+				headers, _ := c.GetHeaders()
+				So(headers.Get("X-Vault-Token"), ShouldEqual, token)
 			})
 		})
 	}))
 
 	Convey("Refreshing when not authenticated", t, func() {
-		c, _ := NewUserAuth("http://example.com", "user", "password")
+		c, _ := NewUserAuth("https://example.com", "user", "password")
 		So(c, ShouldNotBeNil)
 		Convey("Should error", func() {
 			err := c.Refresh()
@@ -295,10 +343,9 @@ func TestRefreshUser(t *testing.T) {
 		})
 	})
 	Convey("Refreshing with an expired token", t, func() {
-		c, _ := NewUserAuth("http://example.com", "user", "password")
+		c, _ := NewUserAuth("https://example.com", "user", "password")
 		So(c, ShouldNotBeNil)
-		c.setToken("an-old-token", 3600)
-		c.expiry = time.Now().Add(-2 * time.Minute)
+		c.store.Store("an-old-token", time.Now().Add(-2*time.Minute))
 		Convey("Should error", func() {
 			err := c.Refresh()
 			So(err, ShouldEqual, api.ErrorUnauthenticated)
@@ -319,9 +366,45 @@ func TestRefreshUser(t *testing.T) {
 	// refreshes itself with username/password. But we probably should add a test case
 }
 
+func TestRefreshIfNeededUser(t *testing.T) {
+	var token = "a-new-token"
+	Convey("A token that isn't close to expiring", t, WithServer(api.AuthUserSuccess, http.StatusOK, token, "/v2/auth/user/refresh", http.MethodGet, map[string]string{"X-Vault-Token": "an-old-token"}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		c.setToken("an-old-token", 3600)
+		Convey("Should be kept without contacting Cerberus", func() {
+			refreshed, err := c.RefreshIfNeeded(5 * time.Minute)
+			So(err, ShouldBeNil)
+			So(refreshed, ShouldBeFalse)
+			So(c.RefreshCount(), ShouldEqual, 0)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldEqual, "an-old-token")
+		})
+		Convey("Should be refreshed if it expires within the window", func() {
+			refreshed, err := c.RefreshIfNeeded(2 * time.Hour)
+			So(err, ShouldBeNil)
+			So(refreshed, ShouldBeTrue)
+			So(c.RefreshCount(), ShouldEqual, 1)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldEqual, token)
+		})
+	}))
+
+	Convey("Refreshing if needed when not authenticated", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		Convey("Should error without contacting Cerberus", func() {
+			refreshed, err := c.RefreshIfNeeded(time.Hour)
+			So(err, ShouldEqual, api.ErrorUnauthenticated)
+			So(refreshed, ShouldBeFalse)
+			So(c.RefreshCount(), ShouldEqual, 0)
+		})
+	})
+}
+
 func TestGetHeaders(t *testing.T) {
 	Convey("Getting headers when not authenticated", t, func() {
-		c, _ := NewUserAuth("http://example.com", "user", "password")
+		c, _ := NewUserAuth("https://example.com", "user", "password")
 		So(c, ShouldNotBeNil)
 		Convey("Should error", func() {
 			_, err := c.GetHeaders()
@@ -330,7 +413,7 @@ func TestGetHeaders(t *testing.T) {
 	})
 
 	Convey("Getting headers with authenticated client", t, func() {
-		c, _ := NewUserAuth("http://example.com", "user", "password")
+		c, _ := NewUserAuth("https://example.com", "user", "password")
 		So(c, ShouldNotBeNil)
 		c.setToken("an-old-token", 3600)
 		headers, err := c.GetHeaders()
@@ -346,7 +429,7 @@ func TestGetHeaders(t *testing.T) {
 
 func TestLogoutUser(t *testing.T) {
 	Convey("Logging out when not authenticated", t, func() {
-		c, _ := NewUserAuth("http://example.com", "user", "password")
+		c, _ := NewUserAuth("https://example.com", "user", "password")
 		So(c, ShouldNotBeNil)
 		Convey("Should error", func() {
 			err := c.Logout()
@@ -381,11 +464,206 @@ func TestLogoutUser(t *testing.T) {
 			err := c.Logout()
 			So(err, ShouldBeNil)
 			Convey("Token should no longer be set", func() {
-				So(c.token, ShouldBeEmpty)
+				tok, _, _ := c.loadToken()
+				So(tok, ShouldBeEmpty)
 			})
 			Convey("Headers should no longer be set", func() {
-				So(c.headers.Get("X-Vault-Token"), ShouldBeEmpty)
+				headers, _ := c.withToken(c.headers)
+				So(headers.Get("X-Vault-Token"), ShouldBeEmpty)
 			})
 		})
 	}))
 }
+
+func TestTimeoutUser(t *testing.T) {
+	Convey("A UserAuth with a timeout talking to a server that hangs", t, func() {
+		ts := slowServer()
+		c, err := NewUserAuth(ts.URL, "user", "password")
+		So(err, ShouldBeNil)
+		c.SetTimeout(50 * time.Millisecond)
+		Convey("Should give up on logging in after the timeout", func() {
+			start := time.Now()
+			_, err := c.GetToken(context.Background())
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Convey("Should give up on refreshing after the timeout", func() {
+			c.setToken("an-old-token", 3600)
+			start := time.Now()
+			err := c.Refresh()
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestLogoutWithTimeoutUser(t *testing.T) {
+	Convey("Logging out with an unresponsive server", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		c.setToken("an-old-token", 3600)
+		start := time.Now()
+		err := c.LogoutWithTimeout(50 * time.Millisecond)
+		Convey("Should give up after the timeout", func() {
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+		})
+		Convey("Should still clear the token", func() {
+			So(c.IsAuthenticated(), ShouldBeFalse)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldBeEmpty)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("Logging out with a valid token", t, WithServer(api.AuthUserSuccess, http.StatusNoContent, "", "/v1/auth", http.MethodDelete, map[string]string{"X-Vault-Token": "an-old-token"}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		c.setToken("an-old-token", 3600)
+		Convey("Should not error", func() {
+			So(c.LogoutWithTimeout(time.Second), ShouldBeNil)
+			So(c.IsAuthenticated(), ShouldBeFalse)
+		})
+	}))
+
+	Convey("Logging out with a timeout when not authenticated", t, func() {
+		c, _ := NewUserAuth("https://example.com", "user", "password")
+		So(c, ShouldNotBeNil)
+		Convey("Should error", func() {
+			So(c.LogoutWithTimeout(time.Second), ShouldEqual, api.ErrorUnauthenticated)
+		})
+	})
+}
+
+func TestEnsureAuthenticatedUser(t *testing.T) {
+	var token = "7f6808f1-ede3-2177-aa9d-45f507391310"
+	Convey("A UserAuth that has never authenticated", t, WithServer(api.AuthUserSuccess, http.StatusOK, token, "/v2/auth/user", http.MethodGet, map[string]string{}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		Convey("Should authenticate", func() {
+			So(EnsureAuthenticated(context.Background(), c), ShouldBeNil)
+			So(c.IsAuthenticated(), ShouldBeTrue)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldEqual, token)
+		})
+		Convey("Should not authenticate with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			So(EnsureAuthenticated(ctx, c), ShouldEqual, context.Canceled)
+			So(c.IsAuthenticated(), ShouldBeFalse)
+		})
+	}))
+
+	Convey("A UserAuth with an expired token", t, WithServer(api.AuthUserSuccess, http.StatusOK, token, "/v2/auth/user", http.MethodGet, map[string]string{}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		c.store.Store("an-old-token", time.Now().Add(-time.Minute))
+		Convey("Should get a new token", func() {
+			So(EnsureAuthenticated(context.Background(), c), ShouldBeNil)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldEqual, token)
+		})
+	}))
+
+	Convey("A UserAuth with a valid token", t, func() {
+		c, _ := NewUserAuth("http://127.0.0.1:32876", "user", "password")
+		So(c, ShouldNotBeNil)
+		c.setToken("a-valid-token", 3600)
+		Convey("Should do nothing", func() {
+			So(EnsureAuthenticated(context.Background(), c), ShouldBeNil)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldEqual, "a-valid-token")
+		})
+	})
+
+	Convey("A UserAuth with invalid credentials", t, WithServer(api.AuthUserSuccess, http.StatusUnauthorized, token, "/v2/auth/user", http.MethodGet, map[string]string{}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		Convey("Should return the auth error", func() {
+			So(EnsureAuthenticated(context.Background(), c), ShouldEqual, api.ErrorUnauthorized)
+		})
+	}))
+}
+
+func TestReauthenticateUser(t *testing.T) {
+	var token = "7f6808f1-ede3-2177-aa9d-45f507391310"
+	Convey("A UserAuth with a token that still looks valid", t, WithServer(api.AuthUserSuccess, http.StatusOK, token, "/v2/auth/user", http.MethodGet, map[string]string{}, func(ts *httptest.Server) {
+		c, _ := NewUserAuth(ts.URL, "user", "password")
+		So(c, ShouldNotBeNil)
+		c.setToken("a-rejected-token", 3600)
+		Convey("Should log in again and replace the token", func() {
+			So(c.Reauthenticate(), ShouldBeNil)
+			tok, _, _ := c.loadToken()
+			So(tok, ShouldEqual, token)
+		})
+	}))
+}
+
+func TestRenewTokenUser(t *testing.T) {
+	Convey("An authenticated UserAuth", t, func() {
+		var increment int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/auth/token/self-renew" || r.Header.Get("X-Vault-Token") != "a-token" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var body map[string]int64
+			json.NewDecoder(r.Body).Decode(&body)
+			atomic.StoreInt64(&increment, body["increment"])
+			fmt.Fprint(w, `{"auth": {"client_token": "a-token", "policies": ["web"], "lease_duration": 7200}}`)
+		}))
+		Reset(ts.Close)
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		So(u, ShouldNotBeNil)
+		So(u.setToken("a-token", 300), ShouldBeNil)
+		Convey("Should renew the lease by the increment", func() {
+			So(u.RenewToken(2*time.Hour), ShouldBeNil)
+			So(atomic.LoadInt64(&increment), ShouldEqual, 7200)
+			So(u.TokenTTL(), ShouldBeGreaterThan, time.Hour)
+			So(u.IsAuthenticated(), ShouldBeTrue)
+			So(u.Policies(), ShouldResemble, []string{"web"})
+		})
+		Convey("Should not count as a refresh", func() {
+			So(u.RenewToken(0), ShouldBeNil)
+			So(u.RefreshCount(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("A UserAuth that hit the renewal limit", t, TestingServer(http.StatusBadRequest, "/v1/auth/token/self-renew", http.MethodPost, renewalLimitError, map[string]string{}, func(ts *httptest.Server) {
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		So(u, ShouldNotBeNil)
+		So(u.setToken("a-token", 3600), ShouldBeNil)
+		Convey("Should return ErrorRenewalLimitExceeded", func() {
+			So(errors.Is(u.RenewToken(time.Hour), api.ErrorRenewalLimitExceeded), ShouldBeTrue)
+		})
+	}))
+
+	Convey("A UserAuth whose renewal is rejected without a reason", t, TestingServer(http.StatusBadRequest, "/v1/auth/token/self-renew", http.MethodPost, "", map[string]string{}, func(ts *httptest.Server) {
+		u, _ := NewUserAuth(ts.URL, "user", "password")
+		So(u, ShouldNotBeNil)
+		So(u.setToken("a-token", 3600), ShouldBeNil)
+		Convey("Should not blame the renewal limit", func() {
+			err := u.RenewToken(time.Hour)
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, api.ErrorRenewalLimitExceeded), ShouldBeFalse)
+			So(err.Error(), ShouldContainSubstring, "400")
+		})
+	}))
+
+	Convey("An unauthenticated UserAuth", t, func() {
+		u, _ := NewUserAuth("https://test.example.com", "user", "password")
+		So(u, ShouldNotBeNil)
+		So(u.RenewToken(time.Hour), ShouldEqual, api.ErrorUnauthenticated)
+	})
+}
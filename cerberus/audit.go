@@ -0,0 +1,73 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import "time"
+
+// AuditAction identifies the kind of operation recorded in an AuditEvent
+type AuditAction string
+
+const (
+	// AuditReadSecret is recorded when a secret is read
+	AuditReadSecret AuditAction = "read_secret"
+	// AuditListSecrets is recorded when the secrets under a path are listed
+	AuditListSecrets AuditAction = "list_secrets"
+	// AuditWriteSecret is recorded when a secret is written
+	AuditWriteSecret AuditAction = "write_secret"
+	// AuditDeleteSecret is recorded when a secret is deleted
+	AuditDeleteSecret AuditAction = "delete_secret"
+	// AuditGetSDB is recorded when a single SDB is fetched by ID or name
+	AuditGetSDB AuditAction = "get_sdb"
+	// AuditListSDB is recorded when SDBs are listed
+	AuditListSDB AuditAction = "list_sdb"
+	// AuditCreateSDB is recorded when a SDB is created
+	AuditCreateSDB AuditAction = "create_sdb"
+	// AuditUpdateSDB is recorded when a SDB is updated
+	AuditUpdateSDB AuditAction = "update_sdb"
+	// AuditDeleteSDB is recorded when a SDB is deleted
+	AuditDeleteSDB AuditAction = "delete_sdb"
+)
+
+// AuditEvent describes a single secret or SDB operation performed by the client.
+// It never contains secret values, only what was done and to what
+type AuditEvent struct {
+	// Action is the operation that was performed
+	Action AuditAction
+	// Target is the secret path, or the SDB ID or name, that the operation acted on.
+	// It is empty for operations that don't act on a single object (like listing SDBs)
+	Target string
+	// Success is true if the operation completed without an error
+	Success bool
+	// Err is the error returned by the operation, if any
+	Err error
+	// Time is when the operation finished
+	Time time.Time
+}
+
+// audit sends an AuditEvent to the configured hook, if there is one
+func (c *Client) audit(action AuditAction, target string, err error) {
+	if c.auditHook == nil {
+		return
+	}
+	c.auditHook(AuditEvent{
+		Action:  action,
+		Target:  target,
+		Success: err == nil,
+		Err:     err,
+		Time:    time.Now(),
+	})
+}
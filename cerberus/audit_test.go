@@ -0,0 +1,77 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuditHook(t *testing.T) {
+	Convey("A secret read with an audit hook", t, WithTestServer(http.StatusOK, "/v1/secret/app/test/config", http.MethodGet, secretResponse, func(ts *httptest.Server) {
+		var events []AuditEvent
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithAuditHook(func(e AuditEvent) {
+			events = append(events, e)
+		}))
+		So(cl, ShouldNotBeNil)
+		start := time.Now()
+		_, err := cl.Secret().Read("app/test/config")
+		So(err, ShouldBeNil)
+		Convey("Should record a single successful event", func() {
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Action, ShouldEqual, AuditReadSecret)
+			So(events[0].Target, ShouldEqual, "app/test/config")
+			So(events[0].Success, ShouldBeTrue)
+			So(events[0].Err, ShouldBeNil)
+			So(events[0].Time, ShouldHappenOnOrAfter, start)
+		})
+		Convey("Should not include secret values", func() {
+			So(fmt.Sprintf("%+v", events[0]), ShouldNotContainSubstring, "hunter2")
+		})
+	}))
+
+	Convey("A failed SDB get with an audit hook", t, WithTestServer(http.StatusNotFound, "/v2/safe-deposit-box/a7d703da-faac-11e5-a8a9-7fa3b294cd46", http.MethodGet, "", func(ts *httptest.Server) {
+		var events []AuditEvent
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithAuditHook(func(e AuditEvent) {
+			events = append(events, e)
+		}))
+		So(cl, ShouldNotBeNil)
+		_, err := cl.SDB().Get("a7d703da-faac-11e5-a8a9-7fa3b294cd46")
+		So(err, ShouldNotBeNil)
+		Convey("Should record the failure", func() {
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Action, ShouldEqual, AuditGetSDB)
+			So(events[0].Target, ShouldEqual, "a7d703da-faac-11e5-a8a9-7fa3b294cd46")
+			So(events[0].Success, ShouldBeFalse)
+			So(events[0].Err, ShouldEqual, err)
+		})
+	}))
+
+	Convey("A client without an audit hook", t, WithTestServer(http.StatusOK, "/v1/secret/app/test/config", http.MethodGet, secretResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should still work", func() {
+			_, err := cl.Secret().Read("app/test/config")
+			So(err, ShouldBeNil)
+		})
+	}))
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// BulkResult describes how a WriteSecrets call went. Errors has the error for each path that
+// couldn't be written, and paths that were written aren't in it
+type BulkResult struct {
+	Errors    map[string]error
+	Succeeded int
+	Failed    int
+}
+
+// Err returns the errors of every failed path joined together (in order of path, each with
+// the path it was for), or nil if every write succeeded
+func (b *BulkResult) Err() error {
+	if b.Failed == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(b.Errors))
+	for path := range b.Errors {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	errs := make([]error, 0, len(paths))
+	for _, path := range paths {
+		errs = append(errs, fmt.Errorf("%s: %w", path, b.Errors[path]))
+	}
+	return errors.Join(errs...)
+}
+
+// BulkOption customizes a WriteSecrets call
+type BulkOption func(*bulkConfig) error
+
+// bulkConfig holds the settings from the BulkOptions given to WriteSecrets
+type bulkConfig struct {
+	concurrency int
+}
+
+// WithConcurrency has WriteSecrets write up to n secrets at once instead of one at a time.
+// Requests are still limited by WithMaxConcurrentRequests if the client was created with it
+func WithConcurrency(n int) BulkOption {
+	return func(b *bulkConfig) error {
+		if n < 1 {
+			return fmt.Errorf("Concurrency must be at least 1, got %d", n)
+		}
+		b.concurrency = n
+		return nil
+	}
+}
+
+// WriteSecrets writes the data for each path in entries like WriteSecret, replacing whatever
+// is there. A failure writing one path doesn't stop the others, and the outcome of every
+// path is in the returned BulkResult. Paths are written one at a time, in order of path,
+// unless WithConcurrency is given. The error is only non-nil if nothing could be written,
+// such as when the client is read-only or an option is invalid; use BulkResult.Err to get
+// the errors of individual paths as one error
+func (c *Client) WriteSecrets(entries map[string]map[string]interface{}, opts ...BulkOption) (*BulkResult, error) {
+	cfg := &bulkConfig{concurrency: 1}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkWritable(http.MethodPost); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(entries))
+	for path := range entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	result := &BulkResult{Errors: map[string]error{}}
+	var lock sync.Mutex
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.concurrency && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range work {
+				err := c.WriteSecret(path, entries[path])
+				lock.Lock()
+				if err != nil {
+					result.Errors[path] = err
+					result.Failed++
+				} else {
+					result.Succeeded++
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		work <- path
+	}
+	close(work)
+	wg.Wait()
+	return result, nil
+}
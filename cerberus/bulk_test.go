@@ -0,0 +1,127 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// bulkServer accepts every write except to paths containing "forbidden", which get a 403.
+// The most writes it has seen in flight at once is kept in maxInFlight
+func bulkServer(maxInFlight *int) *httptest.Server {
+	var lock sync.Mutex
+	inFlight := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		if inFlight > *maxInFlight {
+			*maxInFlight = inFlight
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+		}()
+		// Give other writes a chance to overlap with this one
+		time.Sleep(10 * time.Millisecond)
+		if strings.Contains(r.URL.Path, "forbidden") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+var bulkEntries = map[string]map[string]interface{}{
+	"app/restore/one":        {"a": "1"},
+	"app/restore/forbidden1": {"b": "2"},
+	"app/restore/two":        {"c": "3"},
+	"app/restore/forbidden2": {"d": "4"},
+	"app/restore/three":      {"e": "5"},
+}
+
+func TestWriteSecrets(t *testing.T) {
+	Convey("Writing many secrets", t, func() {
+		maxInFlight := 0
+		ts := bulkServer(&maxInFlight)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should write every path and report the ones that failed", func() {
+			result, err := cl.WriteSecrets(bulkEntries)
+			So(err, ShouldBeNil)
+			So(result.Succeeded, ShouldEqual, 3)
+			So(result.Failed, ShouldEqual, 2)
+			So(result.Errors, ShouldHaveLength, 2)
+			var httpErr *HTTPError
+			So(errors.As(result.Errors["app/restore/forbidden1"], &httpErr), ShouldBeTrue)
+			So(httpErr.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(result.Errors, ShouldContainKey, "app/restore/forbidden2")
+			Convey("One at a time by default", func() {
+				So(maxInFlight, ShouldEqual, 1)
+			})
+			Convey("And join the errors with their paths", func() {
+				err := result.Err()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "app/restore/forbidden1: ")
+				So(err.Error(), ShouldContainSubstring, "app/restore/forbidden2: ")
+			})
+		})
+		Convey("Should write with bounded concurrency", func() {
+			result, err := cl.WriteSecrets(bulkEntries, WithConcurrency(2))
+			So(err, ShouldBeNil)
+			So(result.Succeeded, ShouldEqual, 3)
+			So(result.Failed, ShouldEqual, 2)
+			So(maxInFlight, ShouldEqual, 2)
+		})
+		Convey("Should have no error when everything was written", func() {
+			result, err := cl.WriteSecrets(map[string]map[string]interface{}{"app/restore/one": {"a": "1"}})
+			So(err, ShouldBeNil)
+			So(result.Succeeded, ShouldEqual, 1)
+			So(result.Err(), ShouldBeNil)
+		})
+		Convey("Should handle an empty batch", func() {
+			result, err := cl.WriteSecrets(nil, WithConcurrency(4))
+			So(err, ShouldBeNil)
+			So(result.Succeeded, ShouldEqual, 0)
+			So(result.Err(), ShouldBeNil)
+		})
+		Convey("Should reject an invalid concurrency", func() {
+			result, err := cl.WriteSecrets(bulkEntries, WithConcurrency(0))
+			So(err, ShouldNotBeNil)
+			So(result, ShouldBeNil)
+		})
+	})
+
+	Convey("Writing many secrets with a read-only client", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://example.com", "a-cool-token", false, false), WithReadOnly())
+		So(cl, ShouldNotBeNil)
+		result, err := cl.WriteSecrets(bulkEntries)
+		So(result, ShouldBeNil)
+		So(err, ShouldEqual, api.ErrorReadOnly)
+	})
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// secretCache is a read-through cache of secret data keyed by path, used by GetSecret. Entries
+// expire after the TTL, and once the cache is full the least recently used entry is evicted to
+// make room. A nil secretCache caches nothing, which is the default
+type secretCache struct {
+	lock       sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	// order has the most recently used entry at the front
+	order *list.List
+}
+
+// cacheEntry is a cached secret in the secretCache
+type cacheEntry struct {
+	path    string
+	data    map[string]interface{}
+	expires time.Time
+}
+
+func newSecretCache(ttl time.Duration, maxEntries int) *secretCache {
+	return &secretCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// get returns a copy of the cached data for the path, if it is cached and hasn't expired
+func (s *secretCache) get(path string) (map[string]interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	elem, ok := s.entries[path]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.order.MoveToFront(elem)
+	return copyData(entry.data), true
+}
+
+// set caches a copy of the data for the path
+func (s *secretCache) set(path string, data map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry := &cacheEntry{path: path, data: copyData(data), expires: time.Now().Add(s.ttl)}
+	if elem, ok := s.entries[path]; ok {
+		elem.Value = entry
+		s.order.MoveToFront(elem)
+		return
+	}
+	s.entries[path] = s.order.PushFront(entry)
+	for s.order.Len() > s.maxEntries {
+		s.remove(s.order.Back())
+	}
+}
+
+// invalidate removes the path from the cache
+func (s *secretCache) invalidate(path string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if elem, ok := s.entries[path]; ok {
+		s.remove(elem)
+	}
+}
+
+// remove drops an entry. The lock must be held
+func (s *secretCache) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.entries, elem.Value.(*cacheEntry).path)
+}
+
+// copyData returns a shallow copy of secret data so that callers changing the map they
+// were given can't change what is cached
+func copyData(data map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingSecretServer behaves like secretServer, counting the reads it gets in reads. Reads
+// of a path containing "flaky" fail the first time
+func countingSecretServer(reads *int32) *httptest.Server {
+	next := secretServer()
+	handler := next.Config.Handler
+	next.Close()
+	var flaky int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(reads, 1)
+		}
+		if strings.Contains(r.URL.Path, "flaky") && atomic.AddInt32(&flaky, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func TestCache(t *testing.T) {
+	Convey("A client with a cache", t, func() {
+		var reads int32
+		ts := countingSecretServer(&reads)
+		Reset(ts.Close)
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithCache(time.Minute, 2))
+		So(err, ShouldBeNil)
+		Convey("Should serve a second read from the cache", func() {
+			first, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldBeNil)
+			second, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(second, ShouldResemble, first)
+			So(atomic.LoadInt32(&reads), ShouldEqual, 1)
+		})
+		Convey("Should not let callers change what is cached", func() {
+			first, _ := cl.GetSecret("app/sdb/config")
+			first["username"] = "mallory"
+			second, _ := cl.GetSecret("app/sdb/config")
+			So(second["username"], ShouldEqual, "bob")
+		})
+		Convey("Should read the secret again once it is invalidated", func() {
+			cl.GetSecret("app/sdb/config")
+			cl.InvalidateSecret("app/sdb/config")
+			cl.GetSecret("app/sdb/config")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 2)
+		})
+		Convey("Should read the secret again after writing it", func() {
+			cl.GetSecret("app/sdb/config")
+			So(cl.PutSecret("app/sdb/config", map[string]interface{}{"username": "alice"}), ShouldBeNil)
+			cl.GetSecret("app/sdb/config")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 2)
+		})
+		Convey("Should not cache failed reads", func() {
+			_, err := cl.GetSecret("app/sdb/flaky")
+			So(err, ShouldNotBeNil)
+			data, err := cl.GetSecret("app/sdb/flaky")
+			So(err, ShouldBeNil)
+			So(data["username"], ShouldEqual, "bob")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 2)
+		})
+		Convey("Should not cache missing secrets", func() {
+			cl.GetSecret("app/sdb/missing")
+			cl.GetSecret("app/sdb/missing")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 2)
+		})
+		Convey("Should drop the least recently used secret when full", func() {
+			cl.GetSecret("app/sdb/one")
+			cl.GetSecret("app/sdb/two")
+			cl.GetSecret("app/sdb/one")
+			cl.GetSecret("app/sdb/three")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 3)
+			cl.GetSecret("app/sdb/one")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 3)
+			cl.GetSecret("app/sdb/two")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 4)
+		})
+		Convey("Should be safe to use from many goroutines", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					cl.GetSecret("app/sdb/config")
+					if i%5 == 0 {
+						cl.InvalidateSecret("app/sdb/config")
+					}
+				}(i)
+			}
+			wg.Wait()
+			data, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(data["username"], ShouldEqual, "bob")
+		})
+	})
+
+	Convey("A cached secret past its TTL", t, func() {
+		var reads int32
+		ts := countingSecretServer(&reads)
+		Reset(ts.Close)
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithCache(20*time.Millisecond, 10))
+		So(err, ShouldBeNil)
+		Convey("Should be read again", func() {
+			cl.GetSecret("app/sdb/config")
+			time.Sleep(40 * time.Millisecond)
+			cl.GetSecret("app/sdb/config")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Invalid cache settings", t, func() {
+		Convey("Should be rejected", func() {
+			_, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithCache(0, 10))
+			So(err, ShouldNotBeNil)
+			_, err = NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithCache(time.Minute, 0))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("A client without a cache", t, func() {
+		var reads int32
+		ts := countingSecretServer(&reads)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should read every time", func() {
+			cl.GetSecret("app/sdb/config")
+			cl.GetSecret("app/sdb/config")
+			cl.InvalidateSecret("app/sdb/config")
+			So(atomic.LoadInt32(&reads), ShouldEqual, 2)
+		})
+	})
+}
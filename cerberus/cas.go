@@ -0,0 +1,131 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorCASMismatch is returned by WriteSecretCAS when the secret is no longer at the expected
+// version, because someone else wrote it first. Read the secret again and retry the write
+var ErrorCASMismatch = fmt.Errorf("Secret was not written: it is not at the expected version")
+
+// ErrorCASUnsupported is returned by WriteSecretCAS when the secret backend doesn't version
+// secrets, so it can't check the version before writing. Nothing is written
+var ErrorCASUnsupported = fmt.Errorf("Secret was not written: the secret backend does not support check-and-set")
+
+// casDataPrefix is where a versioned secret backend takes writes with a check-and-set option
+const casDataPrefix = "data/"
+
+// casMetadataPrefix is where a versioned secret backend keeps the version history of a secret
+const casMetadataPrefix = "metadata/"
+
+// casConfigPath is where a versioned secret backend keeps its settings
+const casConfigPath = "config"
+
+// casMismatchMessage is part of the error a versioned secret backend returns when the
+// check-and-set version doesn't match
+const casMismatchMessage = "check-and-set"
+
+// casWrite is the body of a write with a check-and-set option
+type casWrite struct {
+	Options casOptions             `json:"options"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+type casOptions struct {
+	CAS int `json:"cas"`
+}
+
+// WriteSecretCAS replaces the secret at the given path with data, but only if the secret is
+// currently at expectedVersion, so that concurrent writers can't overwrite each other's
+// changes. An expectedVersion of 0 only writes the secret if it doesn't exist yet. This needs
+// a secret backend that versions secrets and takes writes with a check-and-set option the way
+// the Vault KV version 2 backend does. Returns ErrorCASMismatch if the secret is at a different
+// version and ErrorCASUnsupported if the backend doesn't version secrets. The backend is
+// checked before writing, because one that doesn't version secrets would take the write to
+// any path as an ordinary secret. Path should not be prefaced with a "/"
+func (c *Client) WriteSecretCAS(path string, data map[string]interface{}, expectedVersion int) (err error) {
+	defer func() { c.audit(AuditWriteSecret, path, err) }()
+	if err := c.checkWritable(http.MethodPost); err != nil {
+		return err
+	}
+	if expectedVersion < 0 {
+		return fmt.Errorf("Expected version cannot be negative, got %d", expectedVersion)
+	}
+	if err := ValidateSecretWrite(path, data); err != nil {
+		return err
+	}
+	if err := c.checkVersioned(path); err != nil {
+		return err
+	}
+	body := casWrite{Options: casOptions{CAS: expectedVersion}, Data: data}
+	if _, err := c.secretRequest(http.MethodPost, casDataPrefix+path, map[string]string{}, body); err != nil {
+		return casError(err)
+	}
+	c.cache.invalidate(path)
+	if c.writes != nil {
+		c.writes.wrote(path, data)
+	}
+	return nil
+}
+
+// checkVersioned returns ErrorCASUnsupported if the secret backend doesn't version secrets.
+// A versioned backend has metadata for a secret that exists, and settings for the mount
+// otherwise. A backend that doesn't version secrets has neither
+func (c *Client) checkVersioned(path string) error {
+	for _, p := range []string{casMetadataPrefix + path, casConfigPath} {
+		sec, err := c.secretRequest(http.MethodGet, p, map[string]string{}, nil)
+		if err != nil {
+			return fmt.Errorf("Error while checking whether the secret backend versions secrets: %w", err)
+		}
+		if sec == nil {
+			continue
+		}
+		if _, ok := sec.Data["current_version"]; ok {
+			return nil
+		}
+		if _, ok := sec.Data["max_versions"]; ok {
+			return nil
+		}
+	}
+	return ErrorCASUnsupported
+}
+
+// casError turns the error from a check-and-set write into ErrorCASMismatch or
+// ErrorCASUnsupported where it is one of those
+func casError(err error) error {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return fmt.Errorf("Error while writing secret: %w", err)
+	}
+	switch httpErr.StatusCode {
+	case http.StatusBadRequest:
+		for _, d := range httpErr.Details {
+			if strings.Contains(d, casMismatchMessage) {
+				return ErrorCASMismatch
+			}
+		}
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		// There is no versioned data endpoint to write to
+		return ErrorCASUnsupported
+	}
+	return fmt.Errorf("Error while writing secret: %w", err)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// versionedSecretServer behaves like a versioned secret backend for a single secret that is
+// at the given version, keeping the data of the last successful write in written. A version
+// of 0 means the secret doesn't exist yet. Reads of the secret keep returning what it held
+// before the last write for the given number of reads after it
+func versionedSecretServer(version int, written map[string]interface{}, lag int) *httptest.Server {
+	var lock sync.Mutex
+	visible := map[string]interface{}{}
+	staleReads := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/app/sdb/config":
+			if staleReads > 0 {
+				staleReads--
+			} else {
+				visible = copySecretData(written)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": visible})
+			return
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/config":
+			w.Write([]byte(`{"data": {"cas_required": false, "max_versions": 0}}`))
+			return
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/metadata/app/sdb/config" && version > 0:
+			fmt.Fprintf(w, `{"data": {"current_version": %d}}`, version)
+			return
+		case r.Method != http.MethodPost || r.URL.Path != "/v1/secret/data/app/sdb/config":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body casWrite
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Options.CAS != version {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": ["check-and-set parameter did not match the current version"]}`))
+			return
+		}
+		version++
+		visible = copySecretData(written)
+		staleReads = lag
+		for k, v := range body.Data {
+			written[k] = v
+		}
+		fmt.Fprintf(w, `{"data": {"version": %d}}`, version)
+	}))
+}
+
+// copySecretData returns a shallow copy of the data of a secret
+func copySecretData(data map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		c[k] = v
+	}
+	return c
+}
+
+// unversionedSecretServer behaves like a secret backend that doesn't version secrets, which
+// takes a write to any path as a secret at that path. Writes are kept in written by path
+func unversionedSecretServer(written map[string]interface{}) *httptest.Server {
+	var lock sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := written[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case http.MethodPost, http.MethodPut:
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			written[r.URL.Path] = body
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestWriteSecretCAS(t *testing.T) {
+	Convey("A secret at version 3", t, func() {
+		written := map[string]interface{}{}
+		ts := versionedSecretServer(3, written, 0)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should be written when the version matches", func() {
+			err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter3"}, 3)
+			So(err, ShouldBeNil)
+			So(written["password"], ShouldEqual, "hunter3")
+			Convey("And should not be written again at the old version", func() {
+				err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter4"}, 3)
+				So(err, ShouldEqual, ErrorCASMismatch)
+				So(written["password"], ShouldEqual, "hunter3")
+			})
+		})
+		Convey("Should return ErrorCASMismatch when the version doesn't match", func() {
+			err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter3"}, 2)
+			So(err, ShouldEqual, ErrorCASMismatch)
+			So(written, ShouldBeEmpty)
+		})
+		Convey("Should error for a negative version", func() {
+			err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter3"}, -1)
+			So(err, ShouldNotBeNil)
+			So(written, ShouldBeEmpty)
+		})
+	})
+
+	Convey("A lagging secret at version 3 with read after write consistency", t, func() {
+		written := map[string]interface{}{"password": "hunter2"}
+		ts := versionedSecretServer(3, written, 2)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithReadAfterWriteConsistency())
+		So(cl, ShouldNotBeNil)
+		Convey("Should read the new value after writing it", func() {
+			err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter3"}, 3)
+			So(err, ShouldBeNil)
+			sec, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(sec["password"], ShouldEqual, "hunter3")
+		})
+	})
+
+	Convey("A secret that doesn't exist yet", t, func() {
+		written := map[string]interface{}{}
+		ts := versionedSecretServer(0, written, 0)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should be created at version 0", func() {
+			err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter3"}, 0)
+			So(err, ShouldBeNil)
+			So(written["password"], ShouldEqual, "hunter3")
+		})
+	})
+
+	Convey("A secret backend that doesn't version secrets", t, func() {
+		written := map[string]interface{}{}
+		ts := unversionedSecretServer(written)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return ErrorCASUnsupported without writing anything", func() {
+			err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter3"}, 1)
+			So(err, ShouldEqual, ErrorCASUnsupported)
+			So(written, ShouldBeEmpty)
+		})
+	})
+
+	Convey("A read-only client", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), WithReadOnly())
+		So(cl, ShouldNotBeNil)
+		Convey("Should not write the secret", func() {
+			err := cl.WriteSecretCAS("app/sdb/config", map[string]interface{}{"password": "hunter3"}, 1)
+			So(err, ShouldEqual, api.ErrorReadOnly)
+		})
+	})
+}
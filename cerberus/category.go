@@ -19,6 +19,7 @@ package cerberus
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
 )
@@ -28,16 +29,20 @@ type Category struct {
 	c *Client
 }
 
+// ErrorCategoryNotFound is returned when a category with the given name does not exist
+var ErrorCategoryNotFound = fmt.Errorf("Unable to find category")
+
 var categoryBasePath = "/v1/category"
 
-// List returns a list of roles that can be granted
+// List returns a list of the categories SDBs can be created in. This always asks Cerberus
+// for the current list, unlike IDForName
 func (r *Category) List() ([]*api.Category, error) {
 	resp, err := r.c.DoRequest(http.MethodGet, categoryBasePath, map[string]string{}, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error while trying to get categories: %v", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error while trying to GET categories. Got HTTP status code %d", resp.StatusCode)
+		return nil, r.c.httpError(resp, "Error while trying to GET categories")
 	}
 	var categoryList = []*api.Category{}
 	err = parseResponse(resp.Body, &categoryList)
@@ -46,3 +51,57 @@ func (r *Category) List() ([]*api.Category, error) {
 	}
 	return categoryList, nil
 }
+
+// IDForName returns the ID of the category matching the given name. The name can be
+// either the display name (e.g. "Applications") or the path (e.g. "app") and is matched
+// case insensitively. The list of categories is fetched once and cached for the lifetime
+// of the client, and concurrent lookups made while the cache is empty share a single
+// request. Call Refresh to pick up categories added since. Returns ErrorCategoryNotFound if
+// no category matches
+func (r *Category) IDForName(name string) (string, error) {
+	cached, err := r.c.categories.get(func() (interface{}, error) {
+		return r.List()
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, v := range cached.([]*api.Category) {
+		if strings.EqualFold(v.DisplayName, name) || strings.EqualFold(v.Path, name) {
+			return v.ID, nil
+		}
+	}
+	return "", ErrorCategoryNotFound
+}
+
+// Refresh throws away the categories cached by IDForName, so that the next lookup gets the
+// current list from Cerberus
+func (r *Category) Refresh() {
+	r.c.categories.invalidate()
+}
+
+// ListWithCounts returns every category along with how many SDBs the authenticated user can see in
+// it. The SDB list is only fetched once no matter how many categories there are. Categories without
+// any SDBs are included with a count of 0
+func (r *Category) ListWithCounts() ([]api.CategorySummary, error) {
+	categories, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	sdbs, err := r.c.SDB().List()
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, v := range sdbs {
+		counts[v.CategoryID]++
+	}
+	summaries := make([]api.CategorySummary, 0, len(categories))
+	for _, v := range categories {
+		summaries = append(summaries, api.CategorySummary{
+			ID:    v.ID,
+			Name:  v.DisplayName,
+			Count: counts[v.ID],
+		})
+	}
+	return summaries, nil
+}
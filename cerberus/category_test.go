@@ -72,7 +72,7 @@ var expectedResponseList = []*api.Category{
 
 func TestListCategory(t *testing.T) {
 	Convey("A valid call to List", t, WithTestServer(http.StatusOK, "/v1/category", http.MethodGet, categoryResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid list of categories", func() {
 			categories, err := cl.Category().List()
@@ -82,7 +82,7 @@ func TestListCategory(t *testing.T) {
 	}))
 
 	Convey("An invalid call to List", t, WithTestServer(http.StatusInternalServerError, "/v1/category", http.MethodGet, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			categories, err := cl.Category().List()
@@ -92,7 +92,7 @@ func TestListCategory(t *testing.T) {
 	}))
 
 	Convey("A List to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			categories, err := cl.Category().List()
@@ -101,3 +101,98 @@ func TestListCategory(t *testing.T) {
 		})
 	})
 }
+
+func TestCategoryIDForName(t *testing.T) {
+	Convey("A valid category name", t, WithTestServer(http.StatusOK, "/v1/category", http.MethodGet, categoryResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the ID for a display name", func() {
+			id, err := cl.Category().IDForName("Applications")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46")
+		})
+		Convey("Should return the ID for a path", func() {
+			id, err := cl.Category().IDForName("shared")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "f7ffb890-faaa-11e5-a8a9-7fa3b294cd46")
+		})
+	}))
+
+	Convey("An unknown category name", t, WithTestServer(http.StatusOK, "/v1/category", http.MethodGet, categoryResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return ErrorCategoryNotFound", func() {
+			id, err := cl.Category().IDForName("Platform")
+			So(err, ShouldEqual, ErrorCategoryNotFound)
+			So(id, ShouldBeEmpty)
+		})
+	}))
+
+	Convey("A category added after the list was cached", t, func() {
+		body := categoryResponse
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write([]byte(body))
+		}))
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		_, err := cl.Category().IDForName("Applications")
+		So(err, ShouldBeNil)
+		body = `[{"id": "a-new-id", "display_name": "Platform", "path": "platform"}]`
+		Convey("Should not be found until the cache is refreshed", func() {
+			_, err := cl.Category().IDForName("Platform")
+			So(err, ShouldEqual, ErrorCategoryNotFound)
+			So(calls, ShouldEqual, 1)
+			cl.Category().Refresh()
+			id, err := cl.Category().IDForName("Platform")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "a-new-id")
+			So(calls, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestListWithCountsCategory(t *testing.T) {
+	var sdbResponse = `[
+		{"id": "fb013540-fb5f-11e5-ba72-e899458df21a", "category_id": "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46"},
+		{"id": "06f82494-fb60-11e5-ba72-e899458df21a", "category_id": "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46"}
+	]`
+	Convey("A valid call to ListWithCounts", t, func() {
+		var sdbCalls int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path == "/v1/category" {
+				w.Write([]byte(categoryResponse))
+				return
+			}
+			sdbCalls++
+			w.Write([]byte(sdbResponse))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return a count for every category", func() {
+			summaries, err := cl.Category().ListWithCounts()
+			So(err, ShouldBeNil)
+			So(summaries, ShouldResemble, []api.CategorySummary{
+				{ID: "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46", Name: "Applications", Count: 2},
+				{ID: "f7ffb890-faaa-11e5-a8a9-7fa3b294cd46", Name: "Shared", Count: 0},
+			})
+			So(sdbCalls, ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A call to ListWithCounts when categories can't be listed", t, WithTestServer(http.StatusInternalServerError, "/v1/category", http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should error", func() {
+			summaries, err := cl.Category().ListWithCounts()
+			So(err, ShouldNotBeNil)
+			So(summaries, ShouldBeNil)
+		})
+	}))
+}
@@ -18,16 +18,22 @@ package cerberus
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
-	"os"
+	"time"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
 	"github.com/ecimionatto/cerberus-go-client/auth"
+	"github.com/ecimionatto/cerberus-go-client/utils"
 	vault "github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Client is the main client for interacting with Cerberus
@@ -36,32 +42,147 @@ type Client struct {
 	CerberusURL    *url.URL
 	vaultClient    *vault.Client
 	httpClient     *http.Client
+	transport      *transportConfig
+	retry          retryPolicy
+	auditHook      func(AuditEvent)
+	writes         *writeTracker
+	cache          *secretCache
+	signer         func(*http.Request) error
+	watchInterval  time.Duration
+	timeout        time.Duration
+	warmup         bool
+	reauth         bool
+	timing         *requestTimer
+	readOnly       bool
+	errorVerbosity ErrorVerbosity
+	basePath       *string
+	traffic        *byteCounter
+	metrics        auth.MetricsRecorder
+	tracer         trace.Tracer
+	logger         auth.Logger
+	userAgent      string
+	limiter        requestLimiter
+	roles          lookupCache
+	categories     lookupCache
 }
 
-// NewClient creates a new Client given an Authentication method.
-// This method expects a file (which can be nil) as a source for a OTP used for MFA against Cerberus (if needed).
-// If it is a file, it expect the token and a new line.
-func NewClient(authMethod auth.Auth, otpFile *os.File) (*Client, error) {
+// NewClient creates a new Client given an Authentication method, which is used to authenticate
+// right away. If the method needs an OTP for MFA (such as auth.UserAuth), it is read from wherever
+// the method was configured to read it from. Any number of Options can be passed to customize
+// the client
+func NewClient(authMethod auth.Auth, opts ...Option) (*Client, error) {
+	c := &Client{
+		Authentication: authMethod,
+		transport:      newTransportConfig(),
+		traffic:        &byteCounter{},
+		errorVerbosity: ErrorVerbosityStatus,
+		timeout:        DefaultTimeout,
+		metrics:        auth.NopMetrics,
+		userAgent:      api.DefaultUserAgent,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.transport.insecureSkipVerify {
+		c.warnf("TLS certificate verification is disabled by WithInsecureSkipTLSVerify, so the connection to %s can be intercepted. This is only meant for local testing and must never be used in production", authMethod.GetURL().Host)
+	}
+	// The auth method talks to the same server, so it gets the same TLS settings (such as a
+	// client certificate) before it logs in
+	if setter, ok := authMethod.(interface {
+		SetTLSConfig(*tls.Config)
+	}); ok {
+		setter.SetTLSConfig(c.transport.tlsConfig())
+	}
+	// Requests made by the auth method are timed too, starting with logging in
+	if c.timing != nil {
+		if wrapper, ok := authMethod.(interface {
+			WrapTransport(func(http.RoundTripper) http.RoundTripper)
+		}); ok {
+			wrapper.WrapTransport(c.timing.wrap)
+		}
+	}
 	// Get the token and authenticate
-	token, loginErr := authMethod.GetToken(otpFile)
+	token, loginErr := authMethod.GetToken(context.Background())
 	if loginErr != nil {
 		return nil, loginErr
 	}
 	// Setup the vault client
 	vaultConfig := vault.DefaultConfig()
 	vaultConfig.Address = authMethod.GetURL().String()
+	// The vault client gets its own copy of the transport because it reconfigures the
+	// transport it is given for HTTP/2, which would undo WithHTTP2(false)
+	vaultConfig.HttpClient.Transport = c.transport.build()
+	vaultConfig.HttpClient.Timeout = c.timeout
 	vclient, clientErr := vault.NewClient(vaultConfig)
 	if clientErr != nil {
 		return nil, fmt.Errorf("Error while setting up vault client: %v", clientErr)
 	}
 	// Used the returned token to set it as the token for this client as well
 	vclient.SetToken(token)
-	return &Client{
-		Authentication: authMethod,
-		CerberusURL:    authMethod.GetURL(),
-		vaultClient:    vclient,
-		httpClient:     &http.Client{},
-	}, nil
+	c.CerberusURL = authMethod.GetURL()
+	if c.basePath != nil {
+		// Also covers auth methods without a SetBasePath method
+		withPath := *c.CerberusURL
+		withPath.Path = *c.basePath
+		withPath.RawPath = ""
+		c.CerberusURL = &withPath
+	}
+	c.vaultClient = vclient
+	c.httpClient = &http.Client{Transport: c.transport.build(), Timeout: c.timeout}
+	if c.timing != nil {
+		c.httpClient.Transport = c.timing.wrap(c.httpClient.Transport)
+	}
+	if c.warmup {
+		c.warmConnection()
+	}
+	return c, nil
+}
+
+// warnf logs a warning with the logger from WithLogger, or with the standard library logger if
+// none was set so that warnings are never silently dropped
+func (c *Client) warnf(format string, args ...interface{}) {
+	if c.logger == nil {
+		log.Printf("[WARN] "+format, args...)
+		return
+	}
+	auth.Warnf(c.logger, format, args...)
+}
+
+// debugf logs a debug message with the logger from WithLogger, if there is one
+func (c *Client) debugf(format string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Debugf(format, args...)
+	}
+}
+
+// DefaultTimeout is how long each request to Cerberus is given to finish, including reading
+// the response body, before it is abandoned
+const DefaultTimeout = 30 * time.Second
+
+// warmupTimeout is how long warmConnection waits for the healthcheck before giving up
+const warmupTimeout = 5 * time.Second
+
+// warmConnection makes a healthcheck request so that there is already an open connection
+// (with the TLS handshake done) in the transport's pool for the first real request to use.
+// Any failure is ignored because the connection would be opened on demand anyway
+func (c *Client) warmConnection() {
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+	var healthURL = *c.CerberusURL
+	healthURL.Path = utils.JoinPath(healthURL.Path, "/healthcheck")
+	req, err := http.NewRequest(http.MethodGet, healthURL.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	// The body has to be read to the end for the connection to be reused
+	discardBody(resp)
 }
 
 // SDB returns the SDB client
@@ -74,7 +195,7 @@ func (c *Client) SDB() *SDB {
 // Secret returns the Secret client
 func (c *Client) Secret() *Secret {
 	return &Secret{
-		v: c.vaultClient.Logical(),
+		c: c,
 	}
 }
 
@@ -104,50 +225,61 @@ func (c *Client) Metadata() *Metadata {
 var ErrorBodyNotReturned = fmt.Errorf("No error body returned from server")
 
 // DoRequest is used to perform an HTTP request with the given method and path
-// This method is what is called by other parts of the client and is exposed for advanced usage
+// This method is what is called by other parts of the client and is exposed for advanced usage.
+// If retries are enabled with WithRetry, transient failures are retried before returning, but
+// only for idempotent methods
 func (c *Client) DoRequest(method, path string, params map[string]string, data interface{}) (*http.Response, error) {
-	// Get a copy of the base URL and add the path
+	return c.doRequest(context.Background(), method, path, params, data)
+}
+
+// doRequest is DoRequest with a context that can cancel the request or set a deadline for it.
+// The context also cuts short any wait between retries
+func (c *Client) doRequest(ctx context.Context, method, path string, params map[string]string, data interface{}) (*http.Response, error) {
+	if err := c.checkWritable(method); err != nil {
+		return nil, err
+	}
+	// Get a copy of the base URL and add the path onto its base path
 	var baseURL = *c.CerberusURL
-	baseURL.Path = path
+	baseURL.Path = utils.JoinPath(baseURL.Path, path)
 	p := baseURL.Query()
 	// Add the params in to the request
 	for k, v := range params {
 		p.Add(k, v)
 	}
 	baseURL.RawQuery = p.Encode()
-	var req *http.Request
-	var err error
-	if data == nil {
-		req, err = http.NewRequest(method, baseURL.String(), nil)
-	} else {
-		// Encode the body to send in the request if one was given
-		body := &bytes.Buffer{}
-		err := json.NewEncoder(body).Encode(data)
-		if err != nil {
+	// Encode the body to send in the request if one was given. It is kept as bytes
+	// so that it can be resent if the request is retried
+	var body []byte
+	if data != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(data); err != nil {
 			return nil, err
 		}
-		req, err = http.NewRequest(method, baseURL.String(), body)
+		body = buf.Bytes()
 	}
-
+	resp, err := c.send(ctx, method, baseURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
-	headers, headerErr := c.Authentication.GetHeaders()
-	if headerErr != nil {
-		return nil, headerErr
-	}
-	req.Header = headers
-	resp, respErr := c.httpClient.Do(req)
-	if respErr != nil {
-		return nil, respErr
+	if c.reauth && resp.StatusCode == http.StatusUnauthorized {
+		// The token may have expired after it was checked, so get a new one and try
+		// exactly once more. A second 401 is returned as is
+		discardBody(resp)
+		if err := c.reauthenticate(); err != nil {
+			return nil, err
+		}
+		resp, err = c.send(ctx, method, baseURL.String(), body)
+		if err != nil {
+			return nil, err
+		}
 	}
 	// Cerberus uses a refresh token header. If that header is sent with a value of "true,"
 	// refresh the token before returning
 	if resp.Header.Get("X-Refresh-Token") == "true" {
 		if err := c.Authentication.Refresh(); err != nil {
-			// logging here
+			c.debugf("Error while refreshing the token after Cerberus asked for it: %v", err)
 		}
-		tok, err := c.Authentication.GetToken(nil)
+		tok, err := c.Authentication.GetToken(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -157,21 +289,152 @@ func (c *Client) DoRequest(method, path string, params map[string]string, data i
 	return resp, nil
 }
 
+// checkWritable returns api.ErrorReadOnly if the client is read-only and the given
+// method could change something on the server
+func (c *Client) checkWritable(method string) error {
+	if !c.readOnly {
+		return nil
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	return api.ErrorReadOnly
+}
+
+// send sends a request with the current auth headers, retrying transient failures if retries
+// are enabled and it is safe to send the request again. A nil body sends a request without one
+func (c *Client) send(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	var respErr error
+	for attempt := 1; ; attempt++ {
+		var req *http.Request
+		var err error
+		if body == nil {
+			req, err = http.NewRequest(method, url, nil)
+		} else {
+			req, err = http.NewRequest(method, url, bytes.NewReader(body))
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Let the auth method know the token is in use so a logout can wait for the request
+		done := func() {}
+		if tracker, ok := c.Authentication.(auth.RequestTracker); ok {
+			var trackErr error
+			if done, trackErr = tracker.TrackRequest(); trackErr != nil {
+				return nil, trackErr
+			}
+		}
+		headers, headerErr := c.Authentication.GetHeaders()
+		if headerErr != nil {
+			done()
+			return nil, headerErr
+		}
+		// The headers are copied so that setting the User-Agent never changes the auth method's
+		req.Header = make(http.Header, len(headers)+1)
+		for k, v := range headers {
+			req.Header[k] = v
+		}
+		req.Header.Set("User-Agent", c.userAgent)
+		reqCtx, connected := traceConnection(ctx)
+		req = req.WithContext(reqCtx)
+		if c.signer != nil {
+			if err := c.signer(req); err != nil {
+				done()
+				return nil, fmt.Errorf("Error while signing request: %v", err)
+			}
+		}
+		if req.Body != nil {
+			req.Body = &countingReadCloser{ReadCloser: req.Body, count: &c.traffic.sent}
+		}
+		if err := c.limiter.acquire(ctx); err != nil {
+			done()
+			return nil, err
+		}
+		start := time.Now()
+		resp, respErr = c.httpClient.Do(req)
+		c.limiter.release()
+		done()
+		c.observeRequest(req, resp, start)
+		if c.logger != nil {
+			auth.LogRequest(c.logger, req, resp, respErr, start, attempt)
+		}
+		if resp != nil && resp.Body != nil {
+			resp.Body = &countingReadCloser{ReadCloser: resp.Body, count: &c.traffic.received}
+		}
+		var delay time.Duration
+		if respErr == nil && resp.StatusCode == http.StatusTooManyRequests {
+			// Wait as long as Cerberus asked before trying again, but only if it is willing
+			// to wait that long. Otherwise the caller gets to decide what to do
+			rateErr := rateLimitError(resp)
+			discardBody(resp)
+			if attempt >= c.retry.attempts() || !canWait(ctx, rateErr.RetryAfter) {
+				return nil, rateErr
+			}
+			delay = rateErr.RetryAfter
+			if delay == 0 {
+				delay = c.retry.delay(attempt)
+			}
+		} else {
+			if attempt >= c.retry.attempts() || !shouldRetry(method, connected(), resp, respErr) || ctx.Err() != nil {
+				break
+			}
+			discardBody(resp)
+			delay = c.retry.delay(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if respErr != nil {
+		if ctx.Err() == nil && errors.Is(respErr, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("Request to Cerberus timed out after %v: %w", c.timeout, respErr)
+		}
+		return nil, respErr
+	}
+	return resp, nil
+}
+
+// reauthenticate gets a new token after Cerberus rejected the current one. Auth methods that
+// implement auth.Reauthenticator log in again from scratch, while any other method is refreshed
+func (c *Client) reauthenticate() error {
+	if r, ok := c.Authentication.(auth.Reauthenticator); ok {
+		if err := r.Reauthenticate(); err != nil {
+			return fmt.Errorf("Error while reauthenticating: %v", err)
+		}
+	} else if err := c.Authentication.Refresh(); err != nil {
+		return fmt.Errorf("Error while reauthenticating: %v", err)
+	}
+	tok, err := c.Authentication.GetToken(context.Background())
+	if err != nil {
+		return err
+	}
+	c.vaultClient.SetToken(tok)
+	return nil
+}
+
 // parseResponse marshals the given body into the given interface. It should be used just like
 // json.Marshal in that you pass a pointer to the function.
 func parseResponse(r io.Reader, parseTo interface{}) error {
 	// Decode the body into the provided interface
 	if err := json.NewDecoder(r).Decode(parseTo); err != nil {
+		// The decoder only returns EOF if there was nothing but whitespace in the body
+		if err == io.EOF {
+			return api.ErrorEmptyResponse
+		}
 		return err
 	}
 	return nil
 }
 
-// handleAPIError is a helper for parsing an error response body from the API.
+// handleAPIError is a helper for parsing an error response body from the API into an *api.CerberusError.
 // If the body doesn't have an error, it will return ErrorBodyNotReturned to indicate that there was no error body sent (probably means there was a server error)
-func handleAPIError(r io.Reader) error {
+func handleAPIError(resp *http.Response) error {
 	var apiErr = api.ErrorResponse{}
-	if err := json.NewDecoder(r).Decode(&apiErr); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
 		// If the body is empty or a string, it will hit this error
 		if err == io.EOF {
 			return ErrorBodyNotReturned
@@ -183,5 +446,5 @@ func handleAPIError(r io.Reader) error {
 	if apiErr.ErrorID == "" {
 		return ErrorBodyNotReturned
 	}
-	return apiErr
+	return &api.CerberusError{StatusCode: resp.StatusCode, ErrorResponse: apiErr}
 }
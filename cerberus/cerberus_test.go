@@ -18,15 +18,18 @@ package cerberus
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"os"
 	"testing"
+	"time"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
+	"github.com/ecimionatto/cerberus-go-client/auth"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -54,7 +57,7 @@ func GenerateMockAuth(cerberusURL, token string, tokenErr, refreshErr bool) *Moc
 	}
 }
 
-func (m *MockAuth) GetToken(f *os.File) (string, error) {
+func (m *MockAuth) GetToken(ctx context.Context) (string, error) {
 	if !m.getTokenErr {
 		return m.token, nil
 	}
@@ -89,7 +92,7 @@ func (m *MockAuth) GetURL() *url.URL {
 func TestNewCerberusClient(t *testing.T) {
 	Convey("Valid setup arguments", t, func() {
 		m := GenerateMockAuth("http://example.com", "a-cool-token", false, false)
-		c, err := NewClient(m, nil)
+		c, err := NewClient(m)
 		Convey("Should result in a valid client", func() {
 			So(err, ShouldBeNil)
 			So(c, ShouldNotBeNil)
@@ -98,7 +101,7 @@ func TestNewCerberusClient(t *testing.T) {
 
 	Convey("Bad login to get token", t, func() {
 		m := GenerateMockAuth("http://example.com", "a-cool-token", true, false)
-		c, err := NewClient(m, nil)
+		c, err := NewClient(m)
 		Convey("Should error", func() {
 			So(err, ShouldNotBeNil)
 			So(c, ShouldBeNil)
@@ -109,7 +112,7 @@ func TestNewCerberusClient(t *testing.T) {
 func TestSubclients(t *testing.T) {
 	Convey("A valid client", t, func() {
 		m := GenerateMockAuth("http://example.com", "a-cool-token", false, false)
-		c, _ := NewClient(m, nil)
+		c, _ := NewClient(m)
 		So(c, ShouldNotBeNil)
 		Convey("Should return a valid SDB client", func() {
 			So(c.SDB(), ShouldNotBeNil)
@@ -194,7 +197,7 @@ func TestDoRequest(t *testing.T) {
 		"rightOut":                  "5",
 	}
 	Convey("Valid GET request", t, WithServer(http.StatusOK, false, "/v1/blah", http.MethodGet, "", map[string]string{}, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid response", func() {
 			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
@@ -204,7 +207,7 @@ func TestDoRequest(t *testing.T) {
 	}))
 
 	Convey("Valid request with params", t, WithServer(http.StatusOK, false, "/v1/blah", http.MethodGet, "", testParams, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid response", func() {
 			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", testParams, nil)
@@ -214,7 +217,7 @@ func TestDoRequest(t *testing.T) {
 	}))
 
 	Convey("Valid POST request", t, WithServer(http.StatusOK, true, "/v1/books/armaments", http.MethodPost, "holy hand grenade of antioch", map[string]string{}, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		var testData = map[string]string{
 			"character": "Brother Maynard",
@@ -228,7 +231,7 @@ func TestDoRequest(t *testing.T) {
 	}))
 
 	Convey("Valid POST request", t, WithServer(http.StatusOK, true, "/v1/books/armaments", http.MethodPost, "holy hand grenade of antioch", map[string]string{}, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		var testData = map[string]string{
 			"character": "Brother Maynard",
@@ -245,7 +248,7 @@ func TestDoRequest(t *testing.T) {
 	}))
 
 	Convey("Valid POST request with failed refresh", t, WithServer(http.StatusOK, true, "/v1/books/armaments", http.MethodPost, "holy hand grenade of antioch", map[string]string{}, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, true), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, true))
 		So(cl, ShouldNotBeNil)
 		var testData = map[string]string{
 			"character": "Brother Maynard",
@@ -259,7 +262,7 @@ func TestDoRequest(t *testing.T) {
 	}))
 
 	Convey("A request to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
@@ -281,19 +284,22 @@ func TestHandleAPIError(t *testing.T) {
 		}
 	}]
 }`))
-		expected := api.ErrorResponse{
-			ErrorID: "a041aa4d-1d5a-4eed-8e8a-6dc18bdf96db",
-			Errors: []api.ErrorDetail{
-				api.ErrorDetail{
-					Code:    99208,
-					Message: "The name may not be blank.",
-					Metadata: map[string]interface{}{
-						"field": "name",
+		expected := &api.CerberusError{
+			StatusCode: http.StatusBadRequest,
+			ErrorResponse: api.ErrorResponse{
+				ErrorID: "a041aa4d-1d5a-4eed-8e8a-6dc18bdf96db",
+				Errors: []api.ErrorDetail{
+					api.ErrorDetail{
+						Code:    99208,
+						Message: "The name may not be blank.",
+						Metadata: map[string]interface{}{
+							"field": "name",
+						},
 					},
 				},
 			},
 		}
-		err := handleAPIError(buf)
+		err := handleAPIError(&http.Response{StatusCode: http.StatusBadRequest, Body: ioutil.NopCloser(buf)})
 		Convey("Should parse correctly", func() {
 			So(err, ShouldNotBeNil)
 			So(err, ShouldResemble, expected)
@@ -301,7 +307,7 @@ func TestHandleAPIError(t *testing.T) {
 	})
 	Convey("Empty body", t, func() {
 		buf := bytes.NewBuffer([]byte(""))
-		err := handleAPIError(buf)
+		err := handleAPIError(&http.Response{StatusCode: http.StatusBadRequest, Body: ioutil.NopCloser(buf)})
 		Convey("Should have a normal error response", func() {
 			So(err, ShouldNotBeNil)
 			So(err, ShouldEqual, ErrorBodyNotReturned)
@@ -312,11 +318,409 @@ func TestHandleAPIError(t *testing.T) {
 			"id": 1,
 			"name": "weirdobj"
 		`))
-		err := handleAPIError(buf)
+		err := handleAPIError(&http.Response{StatusCode: http.StatusBadRequest, Body: ioutil.NopCloser(buf)})
 		Convey("Should have a normal error response", func() {
 			So(err, ShouldNotBeNil)
-			So(err, ShouldNotHaveSameTypeAs, api.ErrorResponse{})
+			So(err, ShouldNotHaveSameTypeAs, &api.CerberusError{})
 			So(err, ShouldNotEqual, ErrorBodyNotReturned)
 		})
 	})
 }
+
+func TestRequestSigner(t *testing.T) {
+	// sign puts the method, path, token, and body together so the server can check that
+	// the signature covers everything
+	sign := func(req *http.Request) error {
+		body := []byte{}
+		if req.GetBody != nil {
+			rc, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			body, _ = ioutil.ReadAll(rc)
+		}
+		req.Header.Set("X-Signature", fmt.Sprintf("%s %s %s %s", req.Method, req.URL.Path, req.Header.Get("X-Vault-Token"), bytes.TrimSpace(body)))
+		return nil
+	}
+	Convey("A client with a request signer", t, func() {
+		var signature string
+		var body []byte
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			signature = r.Header.Get("X-Signature")
+			body, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRequestSigner(sign))
+		So(cl, ShouldNotBeNil)
+		Convey("Should sign requests after the default headers are set", func() {
+			_, err := cl.DoRequest(http.MethodPost, "/v1/blah", map[string]string{}, map[string]string{"a": "b"})
+			So(err, ShouldBeNil)
+			So(signature, ShouldEqual, `POST /v1/blah a-cool-token {"a":"b"}`)
+			Convey("And should not consume the body", func() {
+				So(string(bytes.TrimSpace(body)), ShouldEqual, `{"a":"b"}`)
+			})
+		})
+		Convey("Should sign secret requests", func() {
+			_, err := cl.Secret().Write("app/test/config", map[string]interface{}{"a": "b"})
+			So(err, ShouldBeNil)
+			So(signature, ShouldStartWith, "PUT /v1/secret/app/test/config")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A client with a failing request signer", t, func() {
+		var called bool
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRequestSigner(func(*http.Request) error {
+			return fmt.Errorf("no signing key")
+		}))
+		So(cl, ShouldNotBeNil)
+		Convey("Should not send the request", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no signing key")
+			So(resp, ShouldBeNil)
+			So(called, ShouldBeFalse)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+// reauthMockAuth is a MockAuth that can log in again to get a new token
+type reauthMockAuth struct {
+	*MockAuth
+	reauths int
+}
+
+func (r *reauthMockAuth) Reauthenticate() error {
+	r.reauths++
+	r.token = "a-fresh-token"
+	r.headers.Set("X-Vault-Token", r.token)
+	return nil
+}
+
+func TestReauthOnUnauthorized(t *testing.T) {
+	Convey("A server that rejects an expired token", t, func() {
+		requests := 0
+		rejectAll := false
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if rejectAll || r.Header.Get("X-Vault-Token") != "a-fresh-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		m := &reauthMockAuth{MockAuth: GenerateMockAuth(ts.URL, "an-expired-token", false, false)}
+		Convey("Should return the 401 by default", func() {
+			cl, _ := NewClient(m)
+			So(cl, ShouldNotBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(m.reauths, ShouldEqual, 0)
+		})
+		Convey("Should reauthenticate and retry once when enabled", func() {
+			cl, _ := NewClient(m, WithReauthOnUnauthorized())
+			So(cl, ShouldNotBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(m.reauths, ShouldEqual, 1)
+			So(requests, ShouldEqual, 2)
+			So(cl.vaultClient.Token(), ShouldEqual, "a-fresh-token")
+		})
+		Convey("Should only retry once if the new token is rejected too", func() {
+			rejectAll = true
+			cl, _ := NewClient(m, WithReauthOnUnauthorized())
+			So(cl, ShouldNotBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(m.reauths, ShouldEqual, 1)
+			So(requests, ShouldEqual, 2)
+		})
+		Convey("Should return the error if an auth method without Reauthenticate can't refresh", func() {
+			cl, _ := NewClient(GenerateMockAuth(ts.URL, "an-expired-token", false, true), WithReauthOnUnauthorized())
+			So(cl, ShouldNotBeNil)
+			_, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldNotBeNil)
+			So(requests, ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	Convey("A read-only client", t, func() {
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"data": {"key": "value"}}`))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithReadOnly())
+		So(cl, ShouldNotBeNil)
+		Convey("Should refuse writes without sending anything", func() {
+			_, err := cl.Secret().Write("app/sdb/config", map[string]interface{}{"key": "value"})
+			So(err, ShouldEqual, api.ErrorReadOnly)
+			_, err = cl.Secret().Delete("app/sdb/config")
+			So(err, ShouldEqual, api.ErrorReadOnly)
+			_, err = cl.SDB().Create(&api.SafeDepositBox{Name: "test"})
+			So(err, ShouldEqual, api.ErrorReadOnly)
+			_, err = cl.SDB().CreateMany([]api.SafeDepositBox{{Name: "test"}}, CreateManyOpts{})
+			So(err, ShouldEqual, api.ErrorReadOnly)
+			_, err = cl.SDB().Update("an-id", &api.SafeDepositBox{})
+			So(err, ShouldEqual, api.ErrorReadOnly)
+			So(cl.SDB().Delete("an-id"), ShouldEqual, api.ErrorReadOnly)
+			_, err = cl.DoRequest(http.MethodPost, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldEqual, api.ErrorReadOnly)
+			So(requests, ShouldEqual, 0)
+		})
+		Convey("Should still allow reads", func() {
+			sec, err := cl.Secret().Read("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(sec.Data["key"], ShouldEqual, "value")
+			So(requests, ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	Convey("A client talking to a server that hangs", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithTimeout(50*time.Millisecond))
+		So(err, ShouldBeNil)
+		Convey("Should give up on requests after the timeout", func() {
+			start := time.Now()
+			_, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "timed out after 50ms")
+		})
+		Convey("Should give up on secret reads after the timeout", func() {
+			_, err := cl.GetSecret("app/sdb/config")
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+	Convey("A client with an auth method that takes a timeout", t, func() {
+		m := &timeoutMockAuth{MockAuth: GenerateMockAuth("https://example.com", "a-cool-token", false, false)}
+		_, err := NewClient(m, WithTimeout(10*time.Second))
+		So(err, ShouldBeNil)
+		Convey("Should give the auth method the same timeout", func() {
+			So(m.timeout, ShouldEqual, 10*time.Second)
+		})
+	})
+	Convey("A negative timeout", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithTimeout(-time.Second))
+		Convey("Should be rejected", func() {
+			So(cl, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// timeoutMockAuth is a MockAuth that takes a timeout like the auth types do
+type timeoutMockAuth struct {
+	*MockAuth
+	timeout time.Duration
+}
+
+func (m *timeoutMockAuth) SetTimeout(d time.Duration) {
+	m.timeout = d
+}
+
+// basePathMockAuth is a MockAuth that takes a base path like the auth types do
+type basePathMockAuth struct {
+	*MockAuth
+	basePath string
+}
+
+func (b *basePathMockAuth) SetBasePath(basePath string) {
+	b.basePath = basePath
+}
+
+func TestBaseURLPath(t *testing.T) {
+	Convey("A client with a base path", t, WithTestServer(http.StatusOK, "/cerberus/v1/secret/app/sdb", http.MethodGet, "{}", func(ts *httptest.Server) {
+		m := &basePathMockAuth{MockAuth: GenerateMockAuth(ts.URL, "a-cool-token", false, false)}
+		recorder := &fakeRecorder{}
+		cl, err := NewClient(m, WithBaseURLPath("/cerberus/"), WithMetricsRecorder(recorder))
+		So(err, ShouldBeNil)
+		Convey("Should pass the base path to the auth method", func() {
+			So(m.basePath, ShouldEqual, "/cerberus")
+		})
+		Convey("Should prefix every request with it", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/secret/app/sdb/config", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			discardBody(resp)
+			So(recorder.requests, ShouldResemble, []string{"/v1/secret 200"})
+		})
+	}))
+
+	Convey("A client whose auth method has a base path in its URL", t, WithTestServer(http.StatusOK, "/cerberus/v1/secret/app/sdb", http.MethodGet, "{}", func(ts *httptest.Server) {
+		cl, err := NewClient(GenerateMockAuth(ts.URL+"/cerberus", "a-cool-token", false, false))
+		So(err, ShouldBeNil)
+		Convey("Should prefix every request with it", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "v1/secret/app/sdb/config", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+		})
+	}))
+
+	Convey("A base path with a query string", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://test.example.com", "a-cool-token", false, false), WithBaseURLPath("/cerberus?a=b"))
+		Convey("Should error", func() {
+			So(cl, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRequestLogging(t *testing.T) {
+	Convey("A client with a logger and retries", t, func() {
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		l := &recordingLogger{}
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithLogger(l), WithRetry(2, time.Millisecond))
+		So(err, ShouldBeNil)
+		resp, err := cl.DoRequest(http.MethodGet, "/v1/secret/app/sdb/config", map[string]string{"token": "a-cool-token"}, nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		discardBody(resp)
+		Convey("Should log an event for every attempt", func() {
+			So(l.messages, ShouldHaveLength, 2)
+			So(l.messages[0], ShouldContainSubstring, `"method":"GET"`)
+			So(l.messages[0], ShouldContainSubstring, `"status":503`)
+			So(l.messages[0], ShouldContainSubstring, `"attempt":1`)
+			So(l.messages[1], ShouldContainSubstring, `"status":200`)
+			So(l.messages[1], ShouldContainSubstring, `"attempt":2`)
+			So(l.messages[1], ShouldContainSubstring, `"duration_ms":`)
+		})
+		Convey("Should never log the token", func() {
+			So(l.String(), ShouldContainSubstring, `"X-Vault-Token":["REDACTED"]`)
+			So(l.String(), ShouldNotContainSubstring, "a-cool-token")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+// userAgentMockAuth is a MockAuth that takes a user agent like the auth types do
+type userAgentMockAuth struct {
+	*MockAuth
+	userAgent string
+}
+
+func (u *userAgentMockAuth) SetUserAgent(userAgent string) error {
+	u.userAgent = userAgent
+	return nil
+}
+
+func TestUserAgent(t *testing.T) {
+	Convey("A Cerberus server", t, func() {
+		var userAgents []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgents = append(userAgents, r.Header.Get("User-Agent"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		Convey("Should get the default user agent", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			discardBody(resp)
+			So(userAgents, ShouldResemble, []string{api.DefaultUserAgent})
+		})
+		Convey("Should get the user agent set with WithUserAgent", func() {
+			m := &userAgentMockAuth{MockAuth: GenerateMockAuth(ts.URL, "a-cool-token", false, false)}
+			cl, err := NewClient(m, WithUserAgent("dagobah-service/1.0"))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			discardBody(resp)
+			So(userAgents, ShouldResemble, []string{"dagobah-service/1.0"})
+			Convey("And should still send the auth method's headers", func() {
+				So(resp.Request.Header.Get("X-Vault-Token"), ShouldEqual, "a-cool-token")
+			})
+			Convey("And should pass it to the auth method", func() {
+				So(m.userAgent, ShouldEqual, "dagobah-service/1.0")
+			})
+			Convey("And should not change the auth method's headers", func() {
+				So(m.headers.Get("User-Agent"), ShouldBeEmpty)
+			})
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("An invalid user agent", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithUserAgent("dagobah\nX-Injected: true"))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
+
+func TestAPIVersion(t *testing.T) {
+	Convey("A Cerberus server", t, func() {
+		var paths []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.Method+" "+r.URL.Path)
+			w.Write([]byte(`{"status": "success", "data": {"client_token": {"client_token": "a-cool-token", "lease_duration": 3600}}}`))
+		}))
+		Reset(func() {
+			ts.Close()
+		})
+		a, err := auth.NewUserAuth(ts.URL, "user", "password")
+		So(err, ShouldBeNil)
+		Convey("Should pass the API version to the auth method", func() {
+			cl, err := NewClient(a, WithAPIVersion(auth.APIVersion1))
+			So(err, ShouldBeNil)
+			So(cl, ShouldNotBeNil)
+			So(paths, ShouldResemble, []string{"GET /v1/auth/user"})
+		})
+		Convey("Should error with an unsupported API version", func() {
+			cl, err := NewClient(a, WithAPIVersion(auth.APIVersion(3)))
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+
+	Convey("An auth method that can't change the API version", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithAPIVersion(auth.APIVersion1))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
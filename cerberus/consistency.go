@@ -0,0 +1,116 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const (
+	// readAfterWriteTimeout is how long after a write reads of the same path will
+	// wait for the new value to show up
+	readAfterWriteTimeout = 2 * time.Second
+	// readAfterWriteInterval is how long to wait between reads of a stale path
+	readAfterWriteInterval = 50 * time.Millisecond
+)
+
+// writeTracker remembers recent writes so that reads of the same path can wait until the
+// write is visible. Only a hash of what was written is kept, never the secret itself
+type writeTracker struct {
+	lock    sync.Mutex
+	pending map[string]pendingWrite
+}
+
+// pendingWrite is a write that hasn't been seen by a read yet
+type pendingWrite struct {
+	// hash is the hash of the written data, or nil if the secret was deleted
+	hash  []byte
+	until time.Time
+}
+
+func newWriteTracker() *writeTracker {
+	return &writeTracker{pending: map[string]pendingWrite{}}
+}
+
+// hashSecretData returns a hash of the data in a secret, or nil if there is no secret.
+// Map keys are sorted when encoding so equal data always gives the same hash
+func hashSecretData(data map[string]interface{}) []byte {
+	if data == nil {
+		return nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// wrote records that data was written to the path. A nil data means the path was deleted
+func (w *writeTracker) wrote(path string, data map[string]interface{}) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.pending[path] = pendingWrite{
+		hash:  hashSecretData(data),
+		until: time.Now().Add(readAfterWriteTimeout),
+	}
+}
+
+// stale returns whether the secret read from the path is older than the last write to it.
+// Once a read sees the write (or the wait runs out) the path is no longer tracked
+func (w *writeTracker) stale(path string, sec *vault.Secret) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	p, ok := w.pending[path]
+	if !ok {
+		return false
+	}
+	var data map[string]interface{}
+	if sec != nil {
+		data = sec.Data
+	}
+	if string(hashSecretData(data)) == string(p.hash) || time.Now().After(p.until) {
+		delete(w.pending, path)
+		return false
+	}
+	return true
+}
+
+// consistentRead calls read and, if read after write consistency is on and the result is
+// older than a recent write to the same path, keeps calling it until the write shows up or
+// the wait runs out. If the wait runs out, the last (stale) result is returned
+func (c *Client) consistentRead(ctx context.Context, path string, read func() (*vault.Secret, error)) (*vault.Secret, error) {
+	sec, err := read()
+	if c.writes == nil {
+		return sec, err
+	}
+	for err == nil && c.writes.stale(path, sec) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(readAfterWriteInterval):
+		}
+		sec, err = read()
+	}
+	return sec, err
+}
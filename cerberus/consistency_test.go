@@ -0,0 +1,105 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// laggyServer accepts writes to any path but keeps serving the old value for the
+// given number of reads after each write
+func laggyServer(lag int, reads *int) *httptest.Server {
+	var lock sync.Mutex
+	current := `{"data": {"value": "old"}}`
+	next := ""
+	staleReads := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			next = `{"data": {"value": "new"}}`
+			staleReads = lag
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		*reads++
+		if next != "" {
+			if staleReads == 0 {
+				current, next = next, ""
+			}
+			staleReads--
+		}
+		w.Write([]byte(current))
+	}))
+}
+
+func TestReadAfterWriteConsistency(t *testing.T) {
+	Convey("A lagging backend with read after write consistency", t, func() {
+		var reads int
+		ts := laggyServer(2, &reads)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithReadAfterWriteConsistency())
+		So(cl, ShouldNotBeNil)
+		_, err := cl.Secret().Write("app/test/config", map[string]interface{}{"value": "new"})
+		So(err, ShouldBeNil)
+		Convey("Should wait for the write to show up", func() {
+			sec, err := cl.Secret().ReadWithContext(context.Background(), "app/test/config")
+			So(err, ShouldBeNil)
+			So(sec.Data["value"], ShouldEqual, "new")
+			So(reads, ShouldEqual, 3)
+			Convey("And should not wait on later reads", func() {
+				sec, err := cl.Secret().Read("app/test/config")
+				So(err, ShouldBeNil)
+				So(sec.Data["value"], ShouldEqual, "new")
+				So(reads, ShouldEqual, 4)
+			})
+		})
+		Convey("Should not wait on other paths", func() {
+			sec, err := cl.Secret().Read("app/other/config")
+			So(err, ShouldBeNil)
+			So(sec.Data["value"], ShouldEqual, "old")
+			So(reads, ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A lagging backend without read after write consistency", t, func() {
+		var reads int
+		ts := laggyServer(2, &reads)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		_, err := cl.Secret().Write("app/test/config", map[string]interface{}{"value": "new"})
+		So(err, ShouldBeNil)
+		Convey("Should return the stale value", func() {
+			sec, err := cl.Secret().Read("app/test/config")
+			So(err, ShouldBeNil)
+			So(sec.Data["value"], ShouldEqual, "old")
+			So(reads, ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// ErrorVerbosity controls how much context is included in the message of an HTTPError
+type ErrorVerbosity int
+
+const (
+	// ErrorVerbosityCause only includes what went wrong, which suits user facing tools
+	ErrorVerbosityCause ErrorVerbosity = iota
+	// ErrorVerbosityStatus adds the HTTP status code. This is the default
+	ErrorVerbosityStatus
+	// ErrorVerbosityRequestID adds the request ID returned by Cerberus, if there is one
+	ErrorVerbosityRequestID
+	// ErrorVerbosityHeaders adds the request and response headers, with any tokens redacted
+	ErrorVerbosityHeaders
+)
+
+// requestIDHeader is the response header holding the ID Cerberus gave the request
+const requestIDHeader = "X-Request-Id"
+
+// HTTPError is returned when Cerberus responds with an unexpected status code
+type HTTPError struct {
+	// Message describes what the client was trying to do, if known
+	Message string
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Details are any error messages returned in the response body
+	Details []string
+	// RequestID is the ID Cerberus gave the request, if it returned one
+	RequestID string
+	// RequestHeader and ResponseHeader are the headers of the request and response
+	// with the values of any headers holding tokens redacted
+	RequestHeader  http.Header
+	ResponseHeader http.Header
+
+	verbosity ErrorVerbosity
+}
+
+// Error returns a message with as much context as the client's error verbosity allows
+func (e *HTTPError) Error() string {
+	var msg string
+	if e.verbosity == ErrorVerbosityCause {
+		cause := http.StatusText(e.StatusCode)
+		if len(e.Details) > 0 {
+			cause = strings.Join(e.Details, ", ")
+		}
+		if e.Message == "" {
+			return cause
+		}
+		return e.Message + ": " + cause
+	}
+	msg = fmt.Sprintf("Got HTTP status code %d", e.StatusCode)
+	if e.Message != "" {
+		msg = e.Message + ". " + msg
+	}
+	if len(e.Details) > 0 {
+		msg += ": " + strings.Join(e.Details, ", ")
+	}
+	if e.verbosity >= ErrorVerbosityRequestID && e.RequestID != "" {
+		msg += fmt.Sprintf(" (request ID %s)", e.RequestID)
+	}
+	if e.verbosity >= ErrorVerbosityHeaders {
+		msg += fmt.Sprintf(". Request headers: %s. Response headers: %s", formatHeaders(e.RequestHeader), formatHeaders(e.ResponseHeader))
+	}
+	return msg
+}
+
+// httpError returns an HTTPError for the response at the client's error verbosity
+func (c *Client) httpError(resp *http.Response, message string, details ...string) error {
+	e := &HTTPError{
+		Message:        message,
+		StatusCode:     resp.StatusCode,
+		Details:        details,
+		RequestID:      resp.Header.Get(requestIDHeader),
+		ResponseHeader: utils.RedactHeaders(resp.Header),
+		verbosity:      c.errorVerbosity,
+	}
+	if resp.Request != nil {
+		e.RequestHeader = utils.RedactHeaders(resp.Request.Header)
+	}
+	return e
+}
+
+// formatHeaders formats headers on one line in a stable order
+func formatHeaders(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %s", k, strings.Join(h[k], ", ")))
+	}
+	return "{" + strings.Join(parts, "; ") + "}"
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrorVerbosity(t *testing.T) {
+	Convey("A request that fails", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", "req-1234")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		list := func(opts ...Option) error {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-secret-token", false, false), opts...)
+			So(err, ShouldBeNil)
+			_, err = cl.SDB().List()
+			So(err, ShouldNotBeNil)
+			return err
+		}
+		Convey("Should include the status code by default", func() {
+			err := list()
+			So(err.Error(), ShouldEqual, "Error while trying to GET SDB list. Got HTTP status code 503")
+			httpErr, ok := err.(*HTTPError)
+			So(ok, ShouldBeTrue)
+			So(httpErr.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(httpErr.RequestID, ShouldEqual, "req-1234")
+		})
+		Convey("Should only include the cause at the lowest verbosity", func() {
+			err := list(WithErrorVerbosity(ErrorVerbosityCause))
+			So(err.Error(), ShouldEqual, "Error while trying to GET SDB list: Service Unavailable")
+		})
+		Convey("Should include the request ID", func() {
+			err := list(WithErrorVerbosity(ErrorVerbosityRequestID))
+			So(err.Error(), ShouldEqual, "Error while trying to GET SDB list. Got HTTP status code 503 (request ID req-1234)")
+		})
+		Convey("Should include the headers with the token redacted", func() {
+			err := list(WithErrorVerbosity(ErrorVerbosityHeaders))
+			So(err.Error(), ShouldContainSubstring, "X-Vault-Token: REDACTED")
+			So(err.Error(), ShouldContainSubstring, "X-Request-Id: req-1234")
+			So(err.Error(), ShouldNotContainSubstring, "a-secret-token")
+			So(err.(*HTTPError).RequestHeader.Get("X-Vault-Token"), ShouldEqual, "REDACTED")
+		})
+		Convey("Should reject an unknown verbosity", func() {
+			_, err := NewClient(GenerateMockAuth(ts.URL, "a-secret-token", false, false), WithErrorVerbosity(ErrorVerbosity(42)))
+			So(err, ShouldNotBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A secret request that fails with error details", t, WithTestServer(http.StatusForbidden, "/v1/secret/app/sdb/config", http.MethodGet, `{"errors": ["permission denied"]}`, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-secret-token", false, false), WithErrorVerbosity(ErrorVerbosityCause))
+		So(cl, ShouldNotBeNil)
+		Convey("Should use the details as the cause", func() {
+			_, err := cl.Secret().Read("app/sdb/config")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "Error while reading secret: permission denied")
+		})
+	}))
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import "context"
+
+// requestLimiter bounds how many requests are being sent to Cerberus at once. A nil
+// limiter doesn't limit anything
+type requestLimiter chan struct{}
+
+func newRequestLimiter(n int) requestLimiter {
+	return make(requestLimiter, n)
+}
+
+// acquire waits for a free slot, giving up with the context's error if it is done first
+func (l requestLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken by acquire
+func (l requestLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	Convey("A client with a concurrency limit", t, func() {
+		var current, peak int64
+		release := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt64(&current, 1)
+			for {
+				p := atomic.LoadInt64(&peak)
+				if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+					break
+				}
+			}
+			if r.URL.Path == "/v1/secret/app/blocked" {
+				<-release
+			} else {
+				time.Sleep(5 * time.Millisecond)
+			}
+			atomic.AddInt64(&current, -1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(secretResponse))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithMaxConcurrentRequests(3))
+		So(cl, ShouldNotBeNil)
+		Convey("Should never have more than the limit in flight", func() {
+			var wg sync.WaitGroup
+			var failed int64
+			for i := 0; i < 30; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := cl.Secret().Read("app/config"); err != nil {
+						atomic.AddInt64(&failed, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			So(failed, ShouldEqual, 0)
+			So(atomic.LoadInt64(&peak), ShouldBeLessThanOrEqualTo, 3)
+			So(atomic.LoadInt64(&peak), ShouldBeGreaterThan, 0)
+		})
+		Convey("Should give up waiting when the context is done", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cl.Secret().Read("app/blocked")
+				}()
+			}
+			for atomic.LoadInt64(&current) < 3 {
+				time.Sleep(time.Millisecond)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			sec, err := cl.Secret().ReadWithContext(ctx, "app/config")
+			So(err, ShouldNotBeNil)
+			So(sec, ShouldBeNil)
+			So(atomic.LoadInt64(&peak), ShouldEqual, 3)
+			close(release)
+			wg.Wait()
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+	Convey("A limit below 1 should be rejected", t, func() {
+		cl, err := NewClient(GenerateMockAuth("http://example.com", "a-cool-token", false, false), WithMaxConcurrentRequests(0))
+		So(err, ShouldNotBeNil)
+		So(cl, ShouldBeNil)
+	})
+}
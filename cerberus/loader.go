@@ -0,0 +1,356 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tagName is the struct tag used to map a field to a secret key
+const tagName = "cerberus"
+
+// Source is somewhere configuration values can be loaded from by LoadInto
+type Source interface {
+	// Lookup returns the value for the given key and whether or not it was found.
+	// An error should only be returned if the source itself could not be read
+	Lookup(key string) (interface{}, bool, error)
+}
+
+// secretSource is a Source backed by a single Cerberus secret path. The secret is
+// read the first time a key is looked up and reused after that
+type secretSource struct {
+	s    *Secret
+	path string
+	once sync.Once
+	data map[string]interface{}
+	err  error
+}
+
+// Source returns a Source that reads its values from the secret at the given path.
+// Path should not be prefaced with a "/"
+func (s *Secret) Source(path string) Source {
+	return &secretSource{
+		s:    s,
+		path: path,
+	}
+}
+
+func (ss *secretSource) Lookup(key string) (interface{}, bool, error) {
+	ss.once.Do(func() {
+		sec, err := ss.s.Read(ss.path)
+		if err != nil {
+			ss.err = fmt.Errorf("Error while reading secret %s: %v", ss.path, err)
+			return
+		}
+		if sec == nil {
+			ss.err = fmt.Errorf("No secret found at path %s", ss.path)
+			return
+		}
+		ss.data = sec.Data
+	})
+	if ss.err != nil {
+		return nil, false, ss.err
+	}
+	v, ok := ss.data[key]
+	return v, ok, nil
+}
+
+// ReadInto reads the secret at the given path and stores its values in the struct
+// pointed to by out. See LoadInto for how fields are matched to keys
+func (s *Secret) ReadInto(path string, out interface{}) error {
+	return LoadInto(out, s.Source(path))
+}
+
+// ReadIntoPartial is ReadInto, but sets every field it can and returns the errors for
+// values that couldn't be converted instead of failing. See LoadIntoPartial
+func (s *Secret) ReadIntoPartial(path string, out interface{}) (map[string]error, error) {
+	return LoadIntoPartial(out, s.Source(path))
+}
+
+// envSource is a Source that looks up keys as environment variables
+type envSource struct {
+	prefix string
+}
+
+// EnvSource returns a Source that looks up keys as environment variables. The variable
+// name is the prefix followed by the key in upper case, with any character that isn't
+// a letter or number replaced by an underscore. For example, with a prefix of "APP_"
+// the key "db-password" is looked up as APP_DB_PASSWORD
+func EnvSource(prefix string) Source {
+	return envSource{prefix: prefix}
+}
+
+func (e envSource) Lookup(key string) (interface{}, bool, error) {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(key))
+	v, ok := os.LookupEnv(e.prefix + name)
+	return v, ok, nil
+}
+
+// LoadInto populates the struct pointed to by out from the given sources. Sources are
+// applied in order, so a value from a later source overrides a value from an earlier
+// one. A common pattern is to list Cerberus first and the environment second so that
+// environment variables can override what is stored in Cerberus:
+//
+//	err := cerberus.LoadInto(&cfg, client.Secret().Source("app/my-sdb/config"), cerberus.EnvSource("MYAPP_"))
+//
+// Each exported field is matched to a key using the `cerberus` struct tag, or the field
+// name if there is no tag. A tag of "-" skips the field. Fields that aren't found in any
+// source are left untouched, so defaults can be set before calling LoadInto. String values
+// are parsed into the field's type (ints, floats, bools, and time.Duration are supported)
+// and any other value is converted through its JSON representation
+func LoadInto(out interface{}, sources ...Source) error {
+	_, err := loadInto(out, false, sources)
+	return err
+}
+
+// LoadIntoPartial is LoadInto for a secret with a mix of good and bad values. Instead of
+// failing on the first value that can't be converted to its field's type, it sets every
+// field it can and returns the errors for the rest keyed by the secret key, so callers can
+// decide whether a missing field matters. The map is empty if every value was set. An error
+// is still returned if a source can't be read or out isn't a pointer to a struct
+func LoadIntoPartial(out interface{}, sources ...Source) (map[string]error, error) {
+	return loadInto(out, true, sources)
+}
+
+// loadInto does the work for LoadInto and LoadIntoPartial. If partial is true, errors setting
+// a field are collected and returned in the map instead of stopping the load
+func loadInto(out interface{}, partial bool, sources []Source) (map[string]error, error) {
+	fieldErrs := map[string]error{}
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("LoadInto requires a non-nil pointer to a struct, got %T", out)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		// Skip unexported fields
+		if field.PkgPath != "" {
+			continue
+		}
+		key := field.Name
+		if tag, ok := field.Tag.Lookup(tagName); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				key = tag
+			}
+		}
+		for _, src := range sources {
+			v, found, err := src.Lookup(key)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			if err := setField(rv.Field(i), v); err != nil {
+				err = fmt.Errorf("Unable to set field %s from key %s: %v", field.Name, key, err)
+				if !partial {
+					return nil, err
+				}
+				fieldErrs[key] = err
+				continue
+			}
+			// A later source with a good value fixes an earlier bad one
+			delete(fieldErrs, key)
+		}
+	}
+	return fieldErrs, nil
+}
+
+// structField is a field for LoadStruct along with the secret it comes from
+type structField struct {
+	index int
+	name  string
+	path  string
+	key   string
+}
+
+// parseStructTag parses a LoadStruct tag of the form "path=app/db,key=password". It returns
+// false if the tag isn't in that form, such as an empty tag or a plain key used by LoadInto
+func parseStructTag(tag string) (path, key string, ok bool, err error) {
+	if !strings.Contains(tag, "=") {
+		return "", "", false, nil
+	}
+	for _, part := range strings.Split(tag, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return "", "", false, fmt.Errorf("Invalid tag option %q, expected name=value", part)
+		}
+		switch kv[0] {
+		case "path":
+			path = kv[1]
+		case "key":
+			key = kv[1]
+		default:
+			return "", "", false, fmt.Errorf("Unknown tag option %q", kv[0])
+		}
+	}
+	if path == "" {
+		return "", "", false, nil
+	}
+	if key == "" {
+		return "", "", false, fmt.Errorf("Tag with path %s is missing a key", path)
+	}
+	return path, key, true, nil
+}
+
+// LoadStruct populates the struct pointed to by out from secrets at multiple paths. Each field
+// says which secret and key it comes from with a `cerberus` struct tag of the form
+// "path=<secret path>,key=<key>":
+//
+//	type Config struct {
+//		DBPassword string        `cerberus:"path=app/my-sdb/db,key=password"`
+//		APIKey     string        `cerberus:"path=app/my-sdb/api,key=key"`
+//		Timeout    time.Duration `cerberus:"path=app/my-sdb/api,key=timeout"`
+//	}
+//
+// Every path is read once, with the reads done together using ReadMany. Fields without a tag in
+// this form are left untouched. Values are converted the same way as LoadInto. Unlike LoadInto, a
+// field whose secret or key doesn't exist is an error. All of the fields that couldn't be set are
+// reported in the error along with the path and key they were meant to come from
+func (s *Secret) LoadStruct(out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("LoadStruct requires a non-nil pointer to a struct, got %T", out)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	var fields []structField
+	var paths []string
+	seen := map[string]bool{}
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		// Skip unexported fields
+		if field.PkgPath != "" {
+			continue
+		}
+		path, key, ok, err := parseStructTag(field.Tag.Get(tagName))
+		if err != nil {
+			return fmt.Errorf("Invalid tag on field %s: %v", field.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		fields = append(fields, structField{index: i, name: field.Name, path: path, key: key})
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	data := map[string]map[string]interface{}{}
+	readErrs := map[string]error{}
+	for _, result := range s.ReadMany(context.Background(), paths) {
+		if result.Err != nil {
+			readErrs[result.Path] = result.Err
+		} else if result.Secret != nil {
+			data[result.Path] = result.Secret.Data
+		}
+	}
+
+	var failures []string
+	for _, f := range fields {
+		var err error
+		if readErr, ok := readErrs[f.path]; ok {
+			err = fmt.Errorf("Error while reading secret: %v", readErr)
+		} else if secret, ok := data[f.path]; !ok {
+			err = fmt.Errorf("No secret found")
+		} else if v, ok := secret[f.key]; !ok {
+			err = fmt.Errorf("Key not found in secret")
+		} else {
+			err = setField(rv.Field(f.index), v)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("field %s (path %s, key %s): %v", f.name, f.path, f.key, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Unable to load struct: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setField assigns v to the given field, converting it to the field's type
+func setField(f reflect.Value, v interface{}) error {
+	str, isString := v.(string)
+	if !isString {
+		// Anything that isn't a string came from JSON, so let JSON convert it
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(raw, f.Addr().Interface())
+	}
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(str, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(str, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(str, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	default:
+		// For anything more complex, treat the string as JSON
+		return json.Unmarshal([]byte(str), f.Addr().Interface())
+	}
+	return nil
+}
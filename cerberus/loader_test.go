@@ -0,0 +1,236 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var secretResponse = `{
+	"data": {
+		"username": "bob",
+		"password": "hunter2",
+		"port": 5432,
+		"debug": false,
+		"timeout": "5s"
+	}
+}`
+
+type testConfig struct {
+	Username string        `cerberus:"username"`
+	Password string        `cerberus:"password"`
+	Port     int           `cerberus:"port"`
+	Debug    bool          `cerberus:"debug"`
+	Timeout  time.Duration `cerberus:"timeout"`
+	Region   string        `cerberus:"region"`
+	Ignored  string        `cerberus:"-"`
+}
+
+func TestLoadInto(t *testing.T) {
+	Convey("A secret source", t, WithTestServer(http.StatusOK, "/v1/secret/app/test/config", http.MethodGet, secretResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should populate the struct", func() {
+			cfg := testConfig{Region: "us-west-2", Ignored: "untouched"}
+			err := cl.Secret().ReadInto("app/test/config", &cfg)
+			So(err, ShouldBeNil)
+			So(cfg, ShouldResemble, testConfig{
+				Username: "bob",
+				Password: "hunter2",
+				Port:     5432,
+				Debug:    false,
+				Timeout:  5 * time.Second,
+				Region:   "us-west-2",
+				Ignored:  "untouched",
+			})
+		})
+
+		Convey("Should be overridden by a later env source", func() {
+			os.Setenv("TESTAPP_PASSWORD", "correct-horse")
+			os.Setenv("TESTAPP_PORT", "6543")
+			os.Setenv("TESTAPP_DEBUG", "true")
+			cfg := testConfig{}
+			err := LoadInto(&cfg, cl.Secret().Source("app/test/config"), EnvSource("TESTAPP_"))
+			So(err, ShouldBeNil)
+			So(cfg.Username, ShouldEqual, "bob")
+			So(cfg.Password, ShouldEqual, "correct-horse")
+			So(cfg.Port, ShouldEqual, 6543)
+			So(cfg.Debug, ShouldBeTrue)
+			Reset(func() {
+				os.Unsetenv("TESTAPP_PASSWORD")
+				os.Unsetenv("TESTAPP_PORT")
+				os.Unsetenv("TESTAPP_DEBUG")
+			})
+		})
+	}))
+
+	Convey("A secret source that doesn't exist", t, WithTestServer(http.StatusNotFound, "/v1/secret/app/test/config", http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should error", func() {
+			cfg := testConfig{}
+			err := cl.Secret().ReadInto("app/test/config", &cfg)
+			So(err, ShouldNotBeNil)
+		})
+	}))
+
+	Convey("An env source with a value that can't be parsed", t, func() {
+		os.Setenv("TESTAPP_PORT", "not-a-number")
+		cfg := testConfig{}
+		err := LoadInto(&cfg, EnvSource("TESTAPP_"))
+		Convey("Should error with the field name", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Port")
+		})
+		Reset(func() {
+			os.Unsetenv("TESTAPP_PORT")
+		})
+	})
+
+	Convey("A non-pointer destination", t, func() {
+		Convey("Should error", func() {
+			So(LoadInto(testConfig{}, EnvSource("")), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLoadIntoPartial(t *testing.T) {
+	var mixedResponse = `{
+	"data": {
+		"username": "bob",
+		"password": "hunter2",
+		"port": "5432x",
+		"debug": false,
+		"timeout": "5 seconds"
+	}
+}`
+	Convey("A secret with some bad values", t, WithTestServer(http.StatusOK, "/v1/secret/app/test/config", http.MethodGet, mixedResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should fail the whole read by default", func() {
+			cfg := testConfig{}
+			So(cl.Secret().ReadInto("app/test/config", &cfg), ShouldNotBeNil)
+		})
+		Convey("Should set the good fields and return errors for the bad ones in partial mode", func() {
+			cfg := testConfig{}
+			fieldErrs, err := cl.Secret().ReadIntoPartial("app/test/config", &cfg)
+			So(err, ShouldBeNil)
+			So(cfg.Username, ShouldEqual, "bob")
+			So(cfg.Password, ShouldEqual, "hunter2")
+			So(fieldErrs, ShouldHaveLength, 2)
+			So(fieldErrs["port"], ShouldNotBeNil)
+			So(fieldErrs["timeout"], ShouldNotBeNil)
+		})
+		Convey("Should drop the error for a bad value that a later source overrides", func() {
+			os.Setenv("TESTAPP_PORT", "6543")
+			cfg := testConfig{}
+			fieldErrs, err := LoadIntoPartial(&cfg, cl.Secret().Source("app/test/config"), EnvSource("TESTAPP_"))
+			So(err, ShouldBeNil)
+			So(cfg.Port, ShouldEqual, 6543)
+			So(fieldErrs, ShouldHaveLength, 1)
+			So(fieldErrs, ShouldContainKey, "timeout")
+			Reset(func() {
+				os.Unsetenv("TESTAPP_PORT")
+			})
+		})
+	}))
+
+	Convey("A secret source that doesn't exist", t, WithTestServer(http.StatusNotFound, "/v1/secret/app/test/config", http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should still error in partial mode", func() {
+			cfg := testConfig{}
+			fieldErrs, err := cl.Secret().ReadIntoPartial("app/test/config", &cfg)
+			So(err, ShouldNotBeNil)
+			So(fieldErrs, ShouldBeNil)
+		})
+	}))
+}
+
+type multiPathConfig struct {
+	DBUser     string        `cerberus:"path=app/sdb/db,key=username"`
+	DBPassword string        `cerberus:"path=app/sdb/db,key=password"`
+	Timeout    time.Duration `cerberus:"path=app/sdb/api,key=timeout"`
+	Port       int           `cerberus:"path=app/sdb/api,key=port"`
+	Untagged   string
+}
+
+func TestLoadStruct(t *testing.T) {
+	Convey("A struct with fields from multiple paths", t, func() {
+		var lock sync.Mutex
+		reads := map[string]int{}
+		ts := secretServer()
+		inner := ts.Config.Handler
+		ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			reads[r.URL.Path]++
+			lock.Unlock()
+			inner.ServeHTTP(w, r)
+		})
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should populate every tagged field and read each path once", func() {
+			cfg := multiPathConfig{Untagged: "untouched"}
+			So(cl.Secret().LoadStruct(&cfg), ShouldBeNil)
+			So(cfg, ShouldResemble, multiPathConfig{
+				DBUser:     "bob",
+				DBPassword: "hunter2",
+				Timeout:    5 * time.Second,
+				Port:       5432,
+				Untagged:   "untouched",
+			})
+			lock.Lock()
+			defer lock.Unlock()
+			So(reads, ShouldResemble, map[string]int{"/v1/secret/app/sdb/db": 1, "/v1/secret/app/sdb/api": 1})
+		})
+		Convey("Should report every field that couldn't be set", func() {
+			var cfg struct {
+				User    string `cerberus:"path=app/sdb/db,key=username"`
+				Missing string `cerberus:"path=app/sdb/missing,key=password"`
+				NoKey   string `cerberus:"path=app/sdb/db,key=nope"`
+				BadPort bool   `cerberus:"path=app/sdb/db,key=port"`
+			}
+			err := cl.Secret().LoadStruct(&cfg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "field Missing (path app/sdb/missing, key password)")
+			So(err.Error(), ShouldContainSubstring, "field NoKey (path app/sdb/db, key nope)")
+			So(err.Error(), ShouldContainSubstring, "field BadPort")
+			So(cfg.User, ShouldEqual, "bob")
+		})
+		Convey("Should error on an invalid tag", func() {
+			var cfg struct {
+				NoKey string `cerberus:"path=app/sdb/db"`
+			}
+			err := cl.Secret().LoadStruct(&cfg)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "NoKey")
+		})
+		Convey("Should error for a non-pointer destination", func() {
+			So(cl.Secret().LoadStruct(multiPathConfig{}), ShouldNotBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import "sync"
+
+// lookupCache lazily caches the result of a fetch (such as listing roles) for the
+// lifetime of a client. Concurrent calls made while the cache is cold are coalesced
+// so that only one fetch is ever in flight and every caller sees the same result
+type lookupCache struct {
+	lock       sync.Mutex
+	value      interface{}
+	loaded     bool
+	inFlight   *lookupCall
+	generation uint64
+}
+
+// lookupCall is a fetch in progress that other callers can wait on
+type lookupCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// get returns the cached value, calling fetch to populate it if needed. Errors are
+// returned to every caller waiting on the fetch but are never cached
+func (l *lookupCache) get(fetch func() (interface{}, error)) (interface{}, error) {
+	l.lock.Lock()
+	if l.loaded {
+		v := l.value
+		l.lock.Unlock()
+		return v, nil
+	}
+	if call := l.inFlight; call != nil {
+		l.lock.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &lookupCall{}
+	call.wg.Add(1)
+	l.inFlight = call
+	gen := l.generation
+	l.lock.Unlock()
+
+	call.value, call.err = fetch()
+
+	l.lock.Lock()
+	// Only store the result if nobody invalidated the cache while we were fetching
+	if call.err == nil && gen == l.generation {
+		l.value = call.value
+		l.loaded = true
+	}
+	if l.inFlight == call {
+		l.inFlight = nil
+	}
+	l.lock.Unlock()
+	call.wg.Done()
+	return call.value, call.err
+}
+
+// invalidate clears the cached value so the next get fetches it again
+func (l *lookupCache) invalidate() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.value = nil
+	l.loaded = false
+	l.inFlight = nil
+	l.generation++
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package cerberus
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -36,8 +37,14 @@ type MetadataOpts struct {
 
 var metadataBasePath = "/v1/metadata"
 
-// List returns a MetadataResponse which is a wrapper containing pagination data and an array of metadata objects
+// List returns a MetadataResponse which is a wrapper containing pagination data and an array of metadata objects.
+// Only admins can get metadata, so anyone else gets api.ErrorForbidden
 func (m *Metadata) List(opts MetadataOpts) (*api.MetadataResponse, error) {
+	return m.listWithContext(context.Background(), opts)
+}
+
+// listWithContext does the work of List with a context for the request
+func (m *Metadata) listWithContext(ctx context.Context, opts MetadataOpts) (*api.MetadataResponse, error) {
 	// Set the limit opt to default if it isn't set
 	if opts.Limit == 0 {
 		opts.Limit = 100
@@ -46,17 +53,20 @@ func (m *Metadata) List(opts MetadataOpts) (*api.MetadataResponse, error) {
 	var params = map[string]string{}
 	params["limit"] = fmt.Sprintf("%d", opts.Limit)
 	params["offset"] = fmt.Sprintf("%d", opts.Offset)
-	resp, err := m.c.DoRequest(http.MethodGet, metadataBasePath, params, nil)
+	resp, err := m.c.doRequest(ctx, http.MethodGet, metadataBasePath, params, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Error while trying to get roles: %v", err)
+		return nil, fmt.Errorf("Error while trying to get metadata: %v", err)
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, api.ErrorForbidden
 	}
 	// Check if it is a bad request (improperly set params)
 	if resp.StatusCode == http.StatusBadRequest {
 		// Return the API error to the user
-		return nil, handleAPIError(resp.Body)
+		return nil, handleAPIError(resp)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error while trying to GET metadata. Got HTTP status code %d", resp.StatusCode)
+		return nil, m.c.httpError(resp, "Error while trying to GET metadata")
 	}
 	var metadataResp = &api.MetadataResponse{}
 	err = parseResponse(resp.Body, metadataResp)
@@ -65,3 +75,30 @@ func (m *Metadata) List(opts MetadataOpts) (*api.MetadataResponse, error) {
 	}
 	return metadataResp, nil
 }
+
+// All pages through List and returns the metadata for every SDB, such as for auditing who
+// owns and has access to each one. Like List, it returns api.ErrorForbidden for non-admins
+func (m *Metadata) All() ([]api.SDBMetadata, error) {
+	return m.listAll(context.Background())
+}
+
+// listAll follows the pagination of List and returns the metadata for every SDB
+func (m *Metadata) listAll(ctx context.Context) ([]api.SDBMetadata, error) {
+	var all []api.SDBMetadata
+	opts := MetadataOpts{}
+	for {
+		page, err := m.listWithContext(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Metadata...)
+		if !page.HasNext {
+			return all, nil
+		}
+		// Guard against a server that keeps sending us back to the same page
+		if page.NextOffset <= int(opts.Offset) {
+			return nil, fmt.Errorf("Error while trying to get metadata: next offset %d does not come after offset %d", page.NextOffset, opts.Offset)
+		}
+		opts.Offset = uint(page.NextOffset)
+	}
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package cerberus
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
@@ -112,7 +115,7 @@ var expectedMetadata = &api.MetadataResponse{
 
 func TestListMetadata(t *testing.T) {
 	Convey("A valid call to List", t, WithTestServer(http.StatusOK, "/v1/metadata", http.MethodGet, metadataBody, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid list of roles", func() {
 			roles, err := cl.Metadata().List(MetadataOpts{})
@@ -122,7 +125,7 @@ func TestListMetadata(t *testing.T) {
 	}))
 
 	Convey("An invalid call to List", t, WithTestServer(http.StatusInternalServerError, "/v1/metadata", http.MethodGet, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			roles, err := cl.Metadata().List(MetadataOpts{})
@@ -132,20 +135,20 @@ func TestListMetadata(t *testing.T) {
 	}))
 
 	Convey("Invalid params", t, WithTestServer(http.StatusBadRequest, "/v1/metadata", http.MethodGet, errorResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			roles, err := cl.Metadata().List(MetadataOpts{Offset: 1000000})
 			So(err, ShouldNotBeNil)
 			So(roles, ShouldBeNil)
-			Convey("And return an API ErrorResponse", func() {
+			Convey("And return a CerberusError", func() {
 				So(err, ShouldResemble, expectedError)
 			})
 		})
 	}))
 
 	Convey("A List to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			roles, err := cl.Metadata().List(MetadataOpts{})
@@ -154,3 +157,59 @@ func TestListMetadata(t *testing.T) {
 		})
 	})
 }
+
+// pagedMetadataServer returns a server that serves the given number of SDBs from the metadata
+// endpoint, honoring the limit and offset params. Calls counts the requests made
+func pagedMetadataServer(total int, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		page := api.MetadataResponse{Limit: limit, Offset: offset, TotalCount: total}
+		for i := offset; i < total && i < offset+limit; i++ {
+			page.Metadata = append(page.Metadata, api.SDBMetadata{Name: fmt.Sprintf("sdb-%d", i)})
+		}
+		page.ResultCount = len(page.Metadata)
+		if offset+limit < total {
+			page.HasNext = true
+			page.NextOffset = offset + limit
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+}
+
+func TestAllMetadata(t *testing.T) {
+	Convey("A server with several pages of metadata", t, func() {
+		calls := 0
+		ts := pagedMetadataServer(250, &calls)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the metadata from every page", func() {
+			all, err := cl.Metadata().All()
+			So(err, ShouldBeNil)
+			So(all, ShouldHaveLength, 250)
+			So(all[0].Name, ShouldEqual, "sdb-0")
+			So(all[249].Name, ShouldEqual, "sdb-249")
+			So(calls, ShouldEqual, 3)
+		})
+	})
+	Convey("A server that doesn't move on to the next page", t, WithTestServer(http.StatusOK, "/v1/metadata", http.MethodGet, `{"has_next": true, "next_offset": 0}`, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should error instead of looping forever", func() {
+			all, err := cl.Metadata().All()
+			So(err, ShouldNotBeNil)
+			So(all, ShouldBeNil)
+		})
+	}))
+	Convey("A user that isn't an admin", t, WithTestServer(http.StatusForbidden, "/v1/metadata", http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should get ErrorForbidden", func() {
+			all, err := cl.Metadata().All()
+			So(err, ShouldEqual, api.ErrorForbidden)
+			So(all, ShouldBeNil)
+		})
+	}))
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// metricsEndpoint returns the endpoint a request path is recorded under in metrics, which is
+// its first two segments (such as /v1/secret or /v2/safe-deposit-box). The rest is dropped so
+// that secret paths and SDB IDs stay out of metrics, where each would be a new series
+func metricsEndpoint(path string) string {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(segments) > 2 {
+		segments = segments[:2]
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// observeRequest records a request that was started at start with the metrics recorder and
+// on the span in its context. resp is nil if the request failed without a response
+func (c *Client) observeRequest(req *http.Request, resp *http.Response, start time.Time) {
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	reqPath := req.URL.Path
+	if c.CerberusURL != nil {
+		reqPath = strings.TrimPrefix(reqPath, c.CerberusURL.Path)
+	}
+	endpoint := metricsEndpoint(reqPath)
+	c.metrics.ObserveRequest(endpoint, status, time.Since(start))
+	utils.CurrentSpan(req.Context()).SetRequest(endpoint, status)
+}
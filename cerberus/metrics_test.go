@@ -0,0 +1,100 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/auth"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRecorder is an auth.MetricsRecorder that keeps the requests it is given
+type fakeRecorder struct {
+	lock     sync.Mutex
+	requests []string
+}
+
+func (f *fakeRecorder) ObserveRequest(endpoint string, status int, dur time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests = append(f.requests, fmt.Sprintf("%s %d", endpoint, status))
+}
+
+func (f *fakeRecorder) IncAuth(result string) {}
+
+// metricsMockAuth is a MockAuth that takes a metrics recorder like the auth types do
+type metricsMockAuth struct {
+	*MockAuth
+	recorder auth.MetricsRecorder
+}
+
+func (m *metricsMockAuth) SetMetricsRecorder(r auth.MetricsRecorder) {
+	m.recorder = r
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	Convey("Request paths", t, func() {
+		So(metricsEndpoint("/v1/secret/app/sdb/config"), ShouldEqual, "/v1/secret")
+		So(metricsEndpoint("/v2/safe-deposit-box/a-uuid"), ShouldEqual, "/v2/safe-deposit-box")
+		So(metricsEndpoint("/v1/metadata"), ShouldEqual, "/v1/metadata")
+		So(metricsEndpoint("/healthcheck"), ShouldEqual, "/healthcheck")
+		So(metricsEndpoint(""), ShouldEqual, "/")
+	})
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	Convey("A client with a metrics recorder", t, WithTestServer(http.StatusNotFound, "/v1/secret/app/sdb", http.MethodGet, "{}", func(ts *httptest.Server) {
+		recorder := &fakeRecorder{}
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithMetricsRecorder(recorder))
+		So(cl, ShouldNotBeNil)
+		Convey("Should record each request under its endpoint", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/secret/app/sdb/config", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			discardBody(resp)
+			So(recorder.requests, ShouldResemble, []string{"/v1/secret 404"})
+		})
+	}))
+
+	Convey("A request that gets no response", t, func() {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+		recorder := &fakeRecorder{}
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithMetricsRecorder(recorder))
+		So(cl, ShouldNotBeNil)
+		Convey("Should be recorded with a status of 0", func() {
+			_, err := cl.DoRequest(http.MethodGet, "/v1/role", map[string]string{}, nil)
+			So(err, ShouldNotBeNil)
+			So(recorder.requests, ShouldResemble, []string{"/v1/role 0"})
+		})
+	})
+
+	Convey("An auth method that takes a metrics recorder", t, func() {
+		m := &metricsMockAuth{MockAuth: GenerateMockAuth("https://test.example.com", "a-cool-token", false, false)}
+		recorder := &fakeRecorder{}
+		_, err := NewClient(m, WithMetricsRecorder(recorder))
+		So(err, ShouldBeNil)
+		Convey("Should be given the same recorder", func() {
+			So(m.recorder, ShouldEqual, recorder)
+		})
+	})
+}
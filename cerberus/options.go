@@ -0,0 +1,538 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/auth"
+	"github.com/ecimionatto/cerberus-go-client/utils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option is a functional option for configuring a Client. Options are passed
+// to NewClient and are applied in order before the client authenticates
+type Option func(*Client) error
+
+// WithMinTLSVersion sets the minimum TLS version the client will negotiate with
+// Cerberus (e.g. tls.VersionTLS12). Connections to a server that can't meet the
+// minimum will fail during the handshake. Defaults to TLS 1.2
+func WithMinTLSVersion(version uint16) Option {
+	return func(c *Client) error {
+		switch version {
+		case tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+		default:
+			return fmt.Errorf("Unsupported minimum TLS version: %#04x", version)
+		}
+		c.transport.minTLSVersion = version
+		return nil
+	}
+}
+
+// WithCertificatePins pins the Cerberus server to the given public keys. Each pin is the base64
+// encoded SHA-256 hash of a certificate's DER encoded SubjectPublicKeyInfo, and connections are
+// rejected unless the server's certificate has one of the pinned keys (on top of the usual CA
+// checks). Passing more than one pin lets a new key be rolled out before the old one is retired.
+// A pin can be computed from a certificate file with:
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func WithCertificatePins(pins []string) Option {
+	return func(c *Client) error {
+		if len(pins) == 0 {
+			return fmt.Errorf("At least one certificate pin must be given")
+		}
+		c.transport.pins = make(map[string]bool, len(pins))
+		for _, pin := range pins {
+			sum, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(sum) != 32 {
+				return fmt.Errorf("Certificate pin %q is not a base64 encoded SHA-256 hash", pin)
+			}
+			c.transport.pins[pin] = true
+		}
+		return nil
+	}
+}
+
+// WithClientCertificate makes the client present cert to Cerberus, for gateways that require
+// mutual TLS. The certificate is presented whenever the server asks for one, and it works along
+// with every other TLS option. The auth method is given the same TLS settings, so logging in
+// also presents the certificate
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(c *Client) error {
+		if len(cert.Certificate) == 0 {
+			return fmt.Errorf("Client certificate is empty")
+		}
+		c.transport.clientCerts = []tls.Certificate{cert}
+		return nil
+	}
+}
+
+// WithClientCertificateFile is the same as WithClientCertificate, but loads the certificate and
+// its private key from PEM encoded files
+func WithClientCertificateFile(certFile, keyFile string) Option {
+	return func(c *Client) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("Error while loading client certificate: %v", err)
+		}
+		return WithClientCertificate(cert)(c)
+	}
+}
+
+// WithRootCAs sets the CAs that are trusted to sign Cerberus' certificate, for deployments
+// behind a gateway with a certificate from a private CA. Only the CAs in the pool are trusted,
+// so to trust the system CAs as well, start from x509.SystemCertPool. Certificates are still
+// fully verified. The auth method is given the same TLS settings. Defaults to the system pool
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) error {
+		if pool == nil {
+			return fmt.Errorf("Root CA pool cannot be nil")
+		}
+		c.transport.rootCAs = pool
+		return nil
+	}
+}
+
+// WithRootCAFile is the same as WithRootCAs, but loads the CAs from a file of PEM encoded
+// certificates
+func WithRootCAFile(path string) Option {
+	return func(c *Client) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Error while reading root CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("No PEM encoded certificates found in root CA file %s", path)
+		}
+		return WithRootCAs(pool)(c)
+	}
+}
+
+// WithInsecureSkipTLSVerify turns off verification of Cerberus' certificate. It is ONLY for
+// testing against a throwaway local Cerberus with a self-signed certificate, and must never be
+// used in production because anyone on the network can then intercept the connection, token
+// and secrets included. Every client created with it logs a warning (through the logger from
+// WithLogger, or the standard library logger if there isn't one). The auth method is given the
+// same TLS settings. To trust a private CA instead, use WithRootCAs
+func WithInsecureSkipTLSVerify() Option {
+	return func(c *Client) error {
+		c.transport.insecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithHTTP2 controls whether the client will use HTTP/2 with servers that support it. With it
+// disabled, only HTTP/1.1 is offered during the TLS handshake (ALPN) and used, which is an
+// escape hatch for proxies and load balancers that don't handle HTTP/2 properly. Defaults to
+// the Go default, which is to use HTTP/2 when the server supports it
+func WithHTTP2(enabled bool) Option {
+	return func(c *Client) error {
+		c.transport.disableHTTP2 = !enabled
+		return nil
+	}
+}
+
+// WithMaxIdleConns sets the most idle connections to Cerberus the client keeps open for reuse,
+// across all hosts. 0 means there is no limit. Defaults to the Go default of 100
+func WithMaxIdleConns(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return fmt.Errorf("Max idle connections cannot be negative, got %d", n)
+		}
+		c.transport.maxIdleConns = n
+		return nil
+	}
+}
+
+// WithMaxIdleConnsPerHost sets the most idle connections the client keeps open for reuse to
+// each host. A service making many concurrent requests should raise this to about the number of
+// requests in flight at once, since connections over the limit are closed as soon as their
+// request is done and have to be opened again (with a new TLS handshake) for the next one.
+// Defaults to the Go default of 2
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) error {
+		if n < 1 {
+			return fmt.Errorf("Max idle connections per host must be at least 1, got %d", n)
+		}
+		c.transport.maxIdleConnsPerHost = n
+		return nil
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept open for reuse before it is
+// closed. 0 means idle connections are kept open until the server closes them. Defaults to
+// the Go default of 90 seconds
+func WithIdleConnTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return fmt.Errorf("Idle connection timeout cannot be negative, got %v", timeout)
+		}
+		c.transport.idleConnTimeout = timeout
+		return nil
+	}
+}
+
+// WithConnectionWarmup makes NewClient open a connection to Cerberus (with a request to its
+// healthcheck endpoint) before returning, so that the first real request doesn't have to wait
+// for a TLS handshake. The connection uses the same transport settings as every other request
+// and is kept open for the transport's idle timeout. A failed warmup is ignored. This is off
+// by default
+func WithConnectionWarmup() Option {
+	return func(c *Client) error {
+		c.warmup = true
+		return nil
+	}
+}
+
+// WithTimeout sets how long each request to Cerberus is given to finish, including reading
+// the response body. A request that takes longer is abandoned and the error returned wraps
+// context.DeadlineExceeded, which can be checked for with errors.Is. When retries are enabled,
+// the timeout applies to each attempt. A timeout of 0 means requests never time out. If the
+// auth method has a SetTimeout method, as the auth types in the auth package do, it is given
+// the same timeout so that logging in, refreshing, and logging out use it too. Defaults to
+// DefaultTimeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return fmt.Errorf("Timeout cannot be negative, got %v", timeout)
+		}
+		c.timeout = timeout
+		if setter, ok := c.Authentication.(interface {
+			SetTimeout(time.Duration)
+		}); ok {
+			setter.SetTimeout(timeout)
+		}
+		return nil
+	}
+}
+
+// WithReauthOnUnauthorized makes the client get a new token and retry a request once when
+// Cerberus responds with a 401, which can happen if the token expires while a request is in
+// flight. Auth methods that implement auth.Reauthenticator log in again, and any other auth
+// method is refreshed. If the retried request also gets a 401, that response is returned.
+// This is off by default
+func WithReauthOnUnauthorized() Option {
+	return func(c *Client) error {
+		c.reauth = true
+		return nil
+	}
+}
+
+// WithRequestTiming records how long each phase (DNS, connecting, the TLS handshake, and
+// waiting for the first byte) of every request to Cerberus takes, using net/http/httptrace.
+// The timing of the most recent request is returned by LastRequestTiming. This covers every
+// request made by the client, and the requests made by the auth method if it has a
+// WrapTransport method (all of the auth types in this library do). This is off by default to
+// avoid the overhead
+func WithRequestTiming() Option {
+	return func(c *Client) error {
+		c.timing = &requestTimer{}
+		return nil
+	}
+}
+
+// WithReadOnly makes the client refuse to change anything in Cerberus. Writing or deleting
+// secrets and creating, updating, or deleting SDBs return api.ErrorReadOnly without anything
+// being sent, as does any request made with DoRequest that isn't a GET, HEAD, or OPTIONS. This
+// is enforced by the client no matter what the token is allowed to do. Reads and authentication
+// are not affected
+func WithReadOnly() Option {
+	return func(c *Client) error {
+		c.readOnly = true
+		return nil
+	}
+}
+
+// WithErrorVerbosity sets how much context is included in the message of an HTTPError, from
+// just the cause (ErrorVerbosityCause), which suits user facing tools, up to the request and
+// response headers (ErrorVerbosityHeaders) for detailed logs. Tokens are redacted from headers
+// at every level. Defaults to ErrorVerbosityStatus
+func WithErrorVerbosity(level ErrorVerbosity) Option {
+	return func(c *Client) error {
+		if level < ErrorVerbosityCause || level > ErrorVerbosityHeaders {
+			return fmt.Errorf("Unsupported error verbosity: %d", level)
+		}
+		c.errorVerbosity = level
+		return nil
+	}
+}
+
+// WithMaxConcurrentRequests limits the client to sending at most n requests to Cerberus at a
+// time, across every subclient and goroutine using it, so that a busy service (or a bulk
+// operation like ReadMany) can't overwhelm Cerberus. Requests over the limit wait in line for
+// a free slot rather than failing. A request waiting for a slot gives up when its context is
+// done, returning the context's error. A slot is held while a request is sent and until the
+// response headers arrive, and each retry of a request waits for a slot again. Requests made
+// by the auth method are not limited. By default there is no limit
+func WithMaxConcurrentRequests(n int) Option {
+	return func(c *Client) error {
+		if n < 1 {
+			return fmt.Errorf("Max concurrent requests must be at least 1, got %d", n)
+		}
+		c.limiter = newRequestLimiter(n)
+		return nil
+	}
+}
+
+// WithRetry enables retrying GET, HEAD, OPTIONS, and DELETE requests that fail with a
+// transient server error (500, 502, 503, or 504) or a network error. Other requests, like
+// writing a secret, are only retried if they failed before connecting to Cerberus, since
+// they may have been applied even though they failed. maxAttempts is the total number of
+// times a request is tried, including the first. baseDelay is the starting delay for the default exponential
+// backoff with jitter. Requests that are rate limited (a 429) are retried after the delay
+// in the Retry-After header instead, as long as it is no more than 30 seconds and doesn't
+// go past the context's deadline. By default requests are not retried, and a rate limited
+// request returns an api.RateLimitError
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(c *Client) error {
+		if maxAttempts < 1 {
+			return fmt.Errorf("Max attempts must be at least 1, got %d", maxAttempts)
+		}
+		c.retry.maxAttempts = maxAttempts
+		c.retry.baseDelay = baseDelay
+		return nil
+	}
+}
+
+// WithBackoffFunc sets the function used to decide how long to wait between retries,
+// giving full control over the backoff curve. The function is given the number of the
+// attempt that just failed, starting at 1. Passing nil uses the default exponential
+// backoff with jitter. This has no effect unless retries are enabled with WithRetry
+func WithBackoffFunc(f func(attempt int) time.Duration) Option {
+	return func(c *Client) error {
+		c.retry.backoff = f
+		return nil
+	}
+}
+
+// WithReadAfterWriteConsistency makes a read of a secret that was just written or deleted by this
+// client wait until the change is visible, to work around replication lag in the backend. Reads
+// of a recently changed path are retried for up to 2 seconds after the write; if the change still
+// hasn't shown up by then, the value that was read is returned anyway. Only a hash of what was
+// written is kept to compare against. Reads of other paths are not affected. This is off by default
+func WithReadAfterWriteConsistency() Option {
+	return func(c *Client) error {
+		c.writes = newWriteTracker()
+		return nil
+	}
+}
+
+// WithCache makes GetSecret cache the data of the secrets it reads for the given TTL, so that
+// reading the same secret again within the TTL doesn't make a request to Cerberus. At most
+// maxEntries secrets are cached, and the least recently used one is dropped to make room for
+// another. Failed reads and missing secrets are never cached. Writing or deleting a secret
+// through this client removes it from the cache, and InvalidateSecret does the same on
+// demand. Changes made by anyone else aren't seen until the TTL runs out. Only GetSecret uses
+// the cache; the Secret subclient always reads from Cerberus. This is off by default
+func WithCache(ttl time.Duration, maxEntries int) Option {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("Cache TTL must be greater than 0, got %v", ttl)
+		}
+		if maxEntries < 1 {
+			return fmt.Errorf("Cache max entries must be at least 1, got %d", maxEntries)
+		}
+		c.cache = newSecretCache(ttl, maxEntries)
+		return nil
+	}
+}
+
+// WithRequestSigner sets a function that is called with every request right before it is
+// sent, after all of the default and authentication headers have been set, so that it can
+// add a signature (or any other headers) for a gateway in front of Cerberus. The body of the
+// request can be read without consuming it by calling req.GetBody. If the signer returns an
+// error the request is not sent and the error is returned. The signer is called again for
+// each retry of a request
+func WithRequestSigner(signer func(*http.Request) error) Option {
+	return func(c *Client) error {
+		c.signer = signer
+		return nil
+	}
+}
+
+// WithWatchInterval sets how often Secret.Watch reads a secret to check it for changes.
+// Defaults to DefaultWatchInterval
+func WithWatchInterval(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("Watch interval must be greater than 0, got %v", interval)
+		}
+		c.watchInterval = interval
+		return nil
+	}
+}
+
+// WithAuditHook sets a function that is called after every secret and SDB operation
+// with an AuditEvent describing what was done, which can be used to keep an audit trail
+// of secret access. Events never include secret values. The hook is called synchronously,
+// so it should return quickly. By default there is no hook
+func WithAuditHook(hook func(AuditEvent)) Option {
+	return func(c *Client) error {
+		c.auditHook = hook
+		return nil
+	}
+}
+
+// WithMetricsRecorder sets where metrics about the requests made to Cerberus are sent. Every
+// request, including each retry, is recorded with its status code and how long it took to get
+// the response headers. Requests are recorded under the first two segments of their path (such
+// as /v1/secret) so that secret paths and SDB IDs never end up in metrics. If the auth method
+// has a SetMetricsRecorder method, as the auth types in the auth package do, it is given the
+// same recorder so that logging in, refreshing, and logging out are recorded too. The
+// prometheus package has a recorder for Prometheus. By default nothing is recorded
+func WithMetricsRecorder(m auth.MetricsRecorder) Option {
+	return func(c *Client) error {
+		if m == nil {
+			m = auth.NopMetrics
+		}
+		c.metrics = m
+		if setter, ok := c.Authentication.(interface {
+			SetMetricsRecorder(auth.MetricsRecorder)
+		}); ok {
+			setter.SetMetricsRecorder(m)
+		}
+		return nil
+	}
+}
+
+// WithTracerProvider turns on OpenTelemetry tracing for secret operations. Each read, list,
+// write, and delete of a secret gets a span (such as cerberus.secret.read) under the span in
+// the context it was given, with the endpoint and HTTP status code of the request as attributes,
+// and is marked as failed if there was an error. If the auth method has a SetTracerProvider
+// method, as the auth types in the auth package do, it is given the same provider so that
+// logging in, refreshing, and logging out are traced too. Tracing is off by default, and when
+// it is off no spans are created at all
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Client) error {
+		c.tracer = utils.Tracer(tp)
+		if setter, ok := c.Authentication.(interface {
+			SetTracerProvider(trace.TracerProvider)
+		}); ok {
+			setter.SetTracerProvider(tp)
+		}
+		return nil
+	}
+}
+
+// WithBaseURLPath sets the path (such as /cerberus) that every endpoint is prefixed with when
+// Cerberus is behind a reverse proxy that serves it from a path, replacing any path in the URL
+// of the auth method. If the auth method has a SetBasePath method, as the auth types in the
+// auth package do, it is given the same path so that logging in, refreshing, and logging out
+// go through the proxy too. Leading and trailing slashes don't matter. This is the same as
+// giving the auth method a URL with the path, such as https://gateway.example.com/cerberus
+func WithBaseURLPath(basePath string) Option {
+	return func(c *Client) error {
+		if strings.ContainsAny(basePath, "?#") {
+			return fmt.Errorf("Base path cannot have a query string or fragment, got %q", basePath)
+		}
+		normalized := utils.NormalizeBasePath(basePath)
+		c.basePath = &normalized
+		if setter, ok := c.Authentication.(interface {
+			SetBasePath(string)
+		}); ok {
+			setter.SetBasePath(normalized)
+		}
+		return nil
+	}
+}
+
+// WithProxy sends every request to Cerberus through the proxy at proxyURL, the same way as the
+// SetProxy method of the auth types, and sets it on the auth method too if it has SetProxy
+func WithProxy(proxyURL string) Option {
+	return func(c *Client) error {
+		proxy, err := utils.ProxyFunc(proxyURL)
+		if err != nil {
+			return err
+		}
+		c.transport.proxy = proxy
+		if setter, ok := c.Authentication.(interface {
+			SetProxy(string) error
+		}); ok {
+			if err := setter.SetProxy(proxyURL); err != nil {
+				return fmt.Errorf("Error while setting the proxy of the auth method: %v", err)
+			}
+		}
+		return nil
+	}
+}
+
+// WithLogger sets where the client writes diagnostic messages and warnings, and passes the
+// logger on to the auth method if it takes one. Tokens and credentials are never logged. By
+// default debug messages are discarded and warnings go to the standard library logger
+func WithLogger(l auth.Logger) Option {
+	return func(c *Client) error {
+		if l == nil {
+			return fmt.Errorf("Logger cannot be nil")
+		}
+		c.logger = l
+		if setter, ok := c.Authentication.(interface {
+			SetLogger(auth.Logger)
+		}); ok {
+			setter.SetLogger(l)
+		}
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request to Cerberus, which proxies
+// and network rules can use to identify the traffic. It is passed on to the auth method if it
+// supports it (all of the auth types in this library do), so logging in, refreshing, and
+// logging out send it too. It is separate from the X-Cerberus-Client header, which is always
+// sent. Returns an error if the value is empty or has characters that aren't allowed in a
+// header. Defaults to api.DefaultUserAgent
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) error {
+		if err := utils.ValidateUserAgent(userAgent); err != nil {
+			return err
+		}
+		c.userAgent = userAgent
+		if setter, ok := c.Authentication.(interface {
+			SetUserAgent(string) error
+		}); ok {
+			if err := setter.SetUserAgent(userAgent); err != nil {
+				return fmt.Errorf("Error while setting the user agent of the auth method: %v", err)
+			}
+		}
+		return nil
+	}
+}
+
+// WithAPIVersion sets which version of the Cerberus authentication API the auth method logs in
+// and refreshes with, for servers that don't have the default auth.DefaultAPIVersion
+// endpoints. Returns an error if the version isn't supported or the auth method can't change it
+func WithAPIVersion(v auth.APIVersion) Option {
+	return func(c *Client) error {
+		setter, ok := c.Authentication.(interface {
+			SetAPIVersion(auth.APIVersion) error
+		})
+		if !ok {
+			return fmt.Errorf("The auth method does not support setting the API version")
+		}
+		if err := setter.SetAPIVersion(v); err != nil {
+			return fmt.Errorf("Error while setting the API version of the auth method: %v", err)
+		}
+		return nil
+	}
+}
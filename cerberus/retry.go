@@ -0,0 +1,126 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	"github.com/ecimionatto/cerberus-go-client/utils"
+)
+
+// retryPolicy controls how many times and how often a failed request is retried
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	backoff     utils.BackoffFunc
+}
+
+// attempts returns the total number of tries allowed for a request
+func (r retryPolicy) attempts() int {
+	if r.maxAttempts < 1 {
+		return 1
+	}
+	return r.maxAttempts
+}
+
+// delay returns how long to wait after the given failed attempt, falling back to
+// exponential backoff with jitter if no backoff function was set
+func (r retryPolicy) delay(attempt int) time.Duration {
+	if r.backoff != nil {
+		return r.backoff(attempt)
+	}
+	base := r.baseDelay
+	if base <= 0 {
+		base = utils.DefaultRetryBaseDelay
+	}
+	return utils.ExponentialJitterBackoff(base, utils.DefaultRetryMaxDelay)(attempt)
+}
+
+// isIdempotent returns whether sending a request with the given method twice has the same effect
+// as sending it once
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry returns whether a request with the given method should be tried again after a
+// transient failure. A write that isn't idempotent may have been applied even though it failed,
+// so it is only retried if it never got as far as a connection to Cerberus
+func shouldRetry(method string, connected bool, resp *http.Response, err error) bool {
+	if !isIdempotent(method) {
+		return err != nil && !connected
+	}
+	if err != nil {
+		return true
+	}
+	return utils.IsRetryableStatus(resp.StatusCode)
+}
+
+// traceConnection returns a context that records whether a request sent with it got a
+// connection to the server, and a function that reports whether it did
+func traceConnection(ctx context.Context) (context.Context, func() bool) {
+	var connected int32
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			atomic.StoreInt32(&connected, 1)
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), func() bool {
+		return atomic.LoadInt32(&connected) == 1
+	}
+}
+
+// maxRetryAfter is the longest a request will wait to be retried after being rate limited
+const maxRetryAfter = 30 * time.Second
+
+// rateLimitError returns the error for a 429 response, with the delay from its Retry-After header
+func rateLimitError(resp *http.Response) *api.RateLimitError {
+	wait, _ := utils.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return &api.RateLimitError{RetryAfter: wait}
+}
+
+// canWait returns whether a rate limited request can wait the given time before being retried,
+// which it can't if that is longer than maxRetryAfter or would go past the context's deadline
+func canWait(ctx context.Context, wait time.Duration) bool {
+	if wait > maxRetryAfter {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+		return false
+	}
+	return true
+}
+
+// discardBody reads and closes a response body we no longer need so the
+// connection can be reused
+func discardBody(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// flakyServer returns a server that responds with failCode for the first failures
+// requests and then with a 200. The number of requests received is stored in calls
+func flakyServer(failures int32, failCode int, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		// Make sure the body is resent on every attempt
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method == http.MethodPost && len(body) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if n <= failures {
+			w.WriteHeader(failCode)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestRetry(t *testing.T) {
+	Convey("A server that fails twice and then succeeds", t, func() {
+		var calls int32
+		ts := flakyServer(2, http.StatusServiceUnavailable, &calls)
+		Convey("Should succeed with enough retries", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(3, time.Millisecond))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+		})
+		Convey("Should not retry a write that reached Cerberus", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(3, time.Millisecond))
+			So(err, ShouldBeNil)
+			for _, method := range []string{http.MethodPost, http.MethodPut} {
+				atomic.StoreInt32(&calls, 0)
+				resp, err := cl.DoRequest(method, "/v1/blah", map[string]string{}, map[string]string{"foo": "bar"})
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+				So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			}
+		})
+		Convey("Should return the last failure when out of attempts", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(2, time.Millisecond))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		})
+		Convey("Should not retry by default", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A server that returns a client error", t, func() {
+		var calls int32
+		ts := flakyServer(2, http.StatusForbidden, &calls)
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(3, time.Millisecond))
+		So(err, ShouldBeNil)
+		Convey("Should not retry", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A server that can't be connected to", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		ts.Close()
+		var attempts []int
+		backoff := func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Millisecond
+		}
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(3, time.Millisecond), WithBackoffFunc(backoff))
+		So(err, ShouldBeNil)
+		Convey("Should retry a write, since it was never sent", func() {
+			_, err := cl.DoRequest(http.MethodPost, "/v1/blah", map[string]string{}, map[string]string{"foo": "bar"})
+			So(err, ShouldNotBeNil)
+			So(attempts, ShouldResemble, []int{1, 2})
+		})
+	})
+
+	Convey("An invalid number of attempts", t, func() {
+		cl, err := NewClient(GenerateMockAuth("http://example.com", "a-cool-token", false, false), WithRetry(0, time.Millisecond))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
+
+func TestBackoffFunc(t *testing.T) {
+	Convey("A custom backoff function", t, func() {
+		var calls int32
+		ts := flakyServer(2, http.StatusBadGateway, &calls)
+		var attempts []int
+		backoff := func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Millisecond
+		}
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(3, time.Hour), WithBackoffFunc(backoff))
+		So(err, ShouldBeNil)
+		Convey("Should be called with each failed attempt", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(attempts, ShouldResemble, []int{1, 2})
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A nil backoff function", t, func() {
+		cl, err := NewClient(GenerateMockAuth("http://example.com", "a-cool-token", false, false), WithRetry(3, 10*time.Millisecond), WithBackoffFunc(nil))
+		So(err, ShouldBeNil)
+		Convey("Should fall back to the default exponential backoff", func() {
+			So(cl.retry.delay(1), ShouldBeLessThanOrEqualTo, 10*time.Millisecond)
+			So(cl.retry.delay(3), ShouldBeLessThanOrEqualTo, 40*time.Millisecond)
+		})
+	})
+}
+
+// rateLimitedServer returns a server that responds with a 429 and the given Retry-After header
+// value (from retryAfter) to the first request and then with a 200
+func rateLimitedServer(retryAfter func() string, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) == 1 {
+			w.Header().Set("Retry-After", retryAfter())
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestRateLimited(t *testing.T) {
+	Convey("A server that rate limits with a delay in seconds", t, func() {
+		var calls int32
+		ts := rateLimitedServer(func() string { return "1" }, &calls)
+		Reset(ts.Close)
+		Convey("Should wait that long before retrying", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(2, time.Millisecond))
+			So(err, ShouldBeNil)
+			start := time.Now()
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Second)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		})
+		Convey("Should return a RateLimitError without retries", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(resp, ShouldBeNil)
+			So(errors.Is(err, api.ErrorRateLimited), ShouldBeTrue)
+			var rateErr *api.RateLimitError
+			So(errors.As(err, &rateErr), ShouldBeTrue)
+			So(rateErr.RetryAfter, ShouldEqual, time.Second)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+		Convey("Should not wait past the context's deadline", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(2, time.Millisecond))
+			So(err, ShouldBeNil)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err = cl.doRequest(ctx, http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(errors.Is(err, api.ErrorRateLimited), ShouldBeTrue)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+	})
+	Convey("A server that rate limits with a date", t, func() {
+		var calls int32
+		ts := rateLimitedServer(func() string {
+			return time.Now().Add(time.Second).UTC().Format(http.TimeFormat)
+		}, &calls)
+		Reset(ts.Close)
+		Convey("Should retry once the date has passed", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(2, time.Millisecond))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		})
+	})
+	Convey("A server that asks for a longer wait than the client allows", t, func() {
+		var calls int32
+		ts := rateLimitedServer(func() string { return "3600" }, &calls)
+		Reset(ts.Close)
+		Convey("Should return right away", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRetry(3, time.Millisecond))
+			So(err, ShouldBeNil)
+			_, err = cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			var rateErr *api.RateLimitError
+			So(errors.As(err, &rateErr), ShouldBeTrue)
+			So(rateErr.RetryAfter, ShouldEqual, time.Hour)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+	})
+}
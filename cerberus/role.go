@@ -19,6 +19,7 @@ package cerberus
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
 )
@@ -28,6 +29,9 @@ type Role struct {
 	c *Client
 }
 
+// ErrorRoleNotFound is returned when a role with the given name does not exist
+var ErrorRoleNotFound = fmt.Errorf("Unable to find role")
+
 var roleBasePath = "/v1/role"
 
 // List returns a list of roles that can be granted
@@ -37,7 +41,7 @@ func (r *Role) List() ([]*api.Role, error) {
 		return nil, fmt.Errorf("Error while trying to get roles: %v", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error while trying to GET roles. Got HTTP status code %d", resp.StatusCode)
+		return nil, r.c.httpError(resp, "Error while trying to GET roles")
 	}
 	var roleList = []*api.Role{}
 	err = parseResponse(resp.Body, &roleList)
@@ -46,3 +50,29 @@ func (r *Role) List() ([]*api.Role, error) {
 	}
 	return roleList, nil
 }
+
+// IDForName returns the ID of the role with the given name (such as "owner", "write",
+// or "read"). Names are matched case insensitively. The list of roles is fetched once
+// and cached for the lifetime of the client, and concurrent lookups made while the
+// cache is empty share a single request. Call Refresh to pick up changes to the roles.
+// Returns ErrorRoleNotFound if no role matches
+func (r *Role) IDForName(name string) (string, error) {
+	cached, err := r.c.roles.get(func() (interface{}, error) {
+		return r.List()
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, v := range cached.([]*api.Role) {
+		if strings.EqualFold(v.Name, name) {
+			return v.ID, nil
+		}
+	}
+	return "", ErrorRoleNotFound
+}
+
+// Refresh throws away the roles cached by IDForName, so that the next lookup gets the
+// current list from Cerberus
+func (r *Role) Refresh() {
+	r.c.roles.invalidate()
+}
@@ -19,6 +19,8 @@ package cerberus
 import (
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -68,7 +70,7 @@ var expectedList = []*api.Role{
 
 func TestListRole(t *testing.T) {
 	Convey("A valid call to List", t, WithTestServer(http.StatusOK, "/v1/role", http.MethodGet, listResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid list of roles", func() {
 			roles, err := cl.Role().List()
@@ -78,7 +80,7 @@ func TestListRole(t *testing.T) {
 	}))
 
 	Convey("An invalid call to List", t, WithTestServer(http.StatusInternalServerError, "/v1/role", http.MethodGet, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			roles, err := cl.Role().List()
@@ -88,7 +90,7 @@ func TestListRole(t *testing.T) {
 	}))
 
 	Convey("A List to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			roles, err := cl.Role().List()
@@ -97,3 +99,119 @@ func TestListRole(t *testing.T) {
 		})
 	})
 }
+
+func TestRoleIDForName(t *testing.T) {
+	Convey("A valid role name", t, WithTestServer(http.StatusOK, "/v1/role", http.MethodGet, listResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the ID", func() {
+			id, err := cl.Role().IDForName("read")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "f800558e-faaa-11e5-a8a9-7fa3b294cd46")
+		})
+		Convey("Should match case insensitively", func() {
+			id, err := cl.Role().IDForName("Owner")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "f7fff4d6-faaa-11e5-a8a9-7fa3b294cd46")
+		})
+	}))
+
+	Convey("An unknown role name", t, WithTestServer(http.StatusOK, "/v1/role", http.MethodGet, listResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return ErrorRoleNotFound", func() {
+			id, err := cl.Role().IDForName("write")
+			So(err, ShouldEqual, ErrorRoleNotFound)
+			So(id, ShouldBeEmpty)
+		})
+	}))
+
+	Convey("A role added after the list was cached", t, func() {
+		var calls int32
+		withWrite := int32(0)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusOK)
+			if atomic.LoadInt32(&withWrite) == 0 {
+				w.Write([]byte(listResponse))
+				return
+			}
+			w.Write([]byte(`[{"id": "f800297e-faaa-11e5-a8a9-7fa3b294cd46", "name": "write"}]`))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should be found once the cache is refreshed", func() {
+			_, err := cl.Role().IDForName("write")
+			So(err, ShouldEqual, ErrorRoleNotFound)
+			atomic.StoreInt32(&withWrite, 1)
+			_, err = cl.Role().IDForName("write")
+			So(err, ShouldEqual, ErrorRoleNotFound)
+			cl.Role().Refresh()
+			id, err := cl.Role().IDForName("write")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "f800297e-faaa-11e5-a8a9-7fa3b294cd46")
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A lookup that fails", t, func() {
+		var calls int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(listResponse))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should not cache the error", func() {
+			_, err := cl.Role().IDForName("read")
+			So(err, ShouldNotBeNil)
+			id, err := cl.Role().IDForName("read")
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "f800558e-faaa-11e5-a8a9-7fa3b294cd46")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("Many concurrent lookups with a cold cache", t, func() {
+		var calls int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			// Hold the request open long enough for every goroutine to pile up
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(listResponse))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should only fetch the roles once", func() {
+			var wg sync.WaitGroup
+			ids := make([]string, 50)
+			errs := make([]error, 50)
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					ids[i], errs[i] = cl.Role().IDForName("read")
+				}(i)
+			}
+			wg.Wait()
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			for i := range ids {
+				So(errs[i], ShouldBeNil)
+				So(ids[i], ShouldEqual, "f800558e-faaa-11e5-a8a9-7fa3b294cd46")
+			}
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -17,9 +17,11 @@ limitations under the License.
 package cerberus
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
 )
@@ -27,8 +29,83 @@ import (
 // ErrorSafeDepositBoxNotFound is returned when a specified deposit box is not found
 var ErrorSafeDepositBoxNotFound = fmt.Errorf("Unable to find Safe Deposit Box")
 
+// ErrorSafeDepositBoxNameBlank is returned when a Safe Deposit Box name is empty or only whitespace
+var ErrorSafeDepositBoxNameBlank = fmt.Errorf("Safe Deposit Box name may not be blank")
+
+// ErrorSafeDepositBoxOwnerBlank is returned when a Safe Deposit Box would be left without an owner
+var ErrorSafeDepositBoxOwnerBlank = fmt.Errorf("Safe Deposit Box owner may not be blank")
+
+// ErrorSafeDepositBoxExists is returned when a Safe Deposit Box can't be created because one
+// with the same name already exists
+var ErrorSafeDepositBoxExists = fmt.Errorf("A Safe Deposit Box with that name already exists")
+
+// ErrorSafeDepositBoxNameAmbiguous is returned by GetByName when more than one Safe Deposit
+// Box has the requested name. Cerberus keeps names unique, so this should never happen
+var ErrorSafeDepositBoxNameAmbiguous = fmt.Errorf("More than one Safe Deposit Box has that name")
+
 var sdbBasePath = "/v2/safe-deposit-box"
 
+// sdbNameMaxLength is the longest name Cerberus will accept for a Safe Deposit Box
+const sdbNameMaxLength = 100
+
+// ValidateSDBName checks a Safe Deposit Box name against the naming rules enforced
+// by Cerberus so that invalid names can be caught without a round trip to the server.
+// These rules should be kept in sync with the Cerberus management service. A valid name:
+//
+//   - is not blank
+//   - is no longer than 100 characters
+//   - contains only letters, numbers, spaces, hyphens (-), and underscores (_)
+//
+// The returned error describes the first rule that is violated
+func ValidateSDBName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return ErrorSafeDepositBoxNameBlank
+	}
+	if l := utf8.RuneCountInString(name); l > sdbNameMaxLength {
+		return fmt.Errorf("Safe Deposit Box name is %d characters long. It may not be longer than %d characters", l, sdbNameMaxLength)
+	}
+	for _, r := range name {
+		if !isValidSDBNameRune(r) {
+			return fmt.Errorf("Safe Deposit Box name contains invalid character %q. Only letters, numbers, spaces, hyphens, and underscores are allowed", r)
+		}
+	}
+	return nil
+}
+
+func isValidSDBNameRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case r == ' ', r == '-', r == '_':
+		return true
+	}
+	return false
+}
+
+// CheckDuplicateNames returns every name that is used by more than one of the given Safe
+// Deposit Boxes, in the order the duplicates are first found. Names are compared ignoring
+// case and surrounding whitespace, the same way Cerberus does when checking for uniqueness.
+// Returns nil if there are no duplicates
+func CheckDuplicateNames(sdbs []api.SafeDepositBox) []string {
+	seen := map[string]int{}
+	var dups []string
+	for _, v := range sdbs {
+		key := strings.ToLower(strings.TrimSpace(v.Name))
+		seen[key]++
+		if seen[key] == 2 {
+			dups = append(dups, v.Name)
+		}
+	}
+	return dups
+}
+
+// CreateManyOpts are options for SDB.CreateMany
+type CreateManyOpts struct {
+	// AllowDuplicates skips the check for duplicate names in the batch and leaves it to
+	// the server to reject any duplicates
+	AllowDuplicates bool
+}
+
 // SDB is a client for managing and reading SafeDepositBox objects
 type SDB struct {
 	// a pointer to its parent client
@@ -36,27 +113,54 @@ type SDB struct {
 }
 
 // GetByName is a helper method that takes a SDB name and attempts
-// to locate that box in a list of SDBs the client has access to
-func (s *SDB) GetByName(name string) (*api.SafeDepositBox, error) {
+// to locate that box in a list of SDBs the client has access to. The name
+// must match exactly. Returns ErrorSafeDepositBoxNotFound if no SDB matches and
+// ErrorSafeDepositBoxNameAmbiguous if more than one does
+func (s *SDB) GetByName(name string) (box *api.SafeDepositBox, err error) {
+	defer func() { s.c.audit(AuditGetSDB, name, err) }()
 	if len(name) == 0 {
 		return nil, ErrorSafeDepositBoxNotFound
 	}
-	allSDB, err := s.List()
+	allSDB, err := s.list()
 	if err != nil {
 		return nil, err
 	}
+	var found *api.SafeDepositBox
 	for _, v := range allSDB {
-		if v.Name == name {
-			return v, nil
+		if v.Name != name {
+			continue
 		}
+		// Don't guess which box was meant
+		if found != nil {
+			return nil, ErrorSafeDepositBoxNameAmbiguous
+		}
+		found = v
 	}
 	// If we didn't find it in the list, return error that it wasn't found
-	return nil, ErrorSafeDepositBoxNotFound
+	if found == nil {
+		return nil, ErrorSafeDepositBoxNotFound
+	}
+	return found, nil
+}
+
+// Exists returns whether a SDB with the given name exists among the SDBs the client
+// has access to. Unlike GetByName, a missing SDB is not an error; an error is only
+// returned if the lookup itself fails (such as an auth or connection problem)
+func (s *SDB) Exists(name string) (bool, error) {
+	_, err := s.GetByName(name)
+	if err == ErrorSafeDepositBoxNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Get returns a single SDB given an ID. Returns ErrorSafeDepositBoxNotFound
 // if the ID does not exist
-func (s *SDB) Get(id string) (*api.SafeDepositBox, error) {
+func (s *SDB) Get(id string) (box *api.SafeDepositBox, err error) {
+	defer func() { s.c.audit(AuditGetSDB, id, err) }()
 	if len(id) == 0 {
 		return nil, ErrorSafeDepositBoxNotFound
 	}
@@ -69,7 +173,7 @@ func (s *SDB) Get(id string) (*api.SafeDepositBox, error) {
 		return nil, ErrorSafeDepositBoxNotFound
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error while trying to GET SDB. Got HTTP status code %d", resp.StatusCode)
+		return nil, s.c.httpError(resp, "Error while trying to GET SDB")
 	}
 	err = parseResponse(resp.Body, returnedSDB)
 	if err != nil {
@@ -78,15 +182,54 @@ func (s *SDB) Get(id string) (*api.SafeDepositBox, error) {
 	return returnedSDB, nil
 }
 
+// PrincipalsWithAccess returns the ARNs of all of the IAM principals that have been granted
+// access to the SDB with the given ID, in the order the API returns them. Returns
+// ErrorSafeDepositBoxNotFound if the ID does not exist
+func (s *SDB) PrincipalsWithAccess(id string) ([]string, error) {
+	sdb, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	arns := make([]string, 0, len(sdb.IAMPrincipalPermissions))
+	for _, v := range sdb.IAMPrincipalPermissions {
+		arns = append(arns, v.IAMPrincipalARN)
+	}
+	return arns, nil
+}
+
 // List returns a list of all SDBs the authenticated user is allowed to see
-func (s *SDB) List() ([]*api.SafeDepositBox, error) {
+func (s *SDB) List() (list []*api.SafeDepositBox, err error) {
+	defer func() { s.c.audit(AuditListSDB, "", err) }()
+	return s.list()
+}
+
+// ListByOwner returns the SDBs the authenticated user is allowed to see that are owned
+// by the given group. Group names are compared case-insensitively
+func (s *SDB) ListByOwner(owner string) ([]*api.SafeDepositBox, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	owner = strings.TrimSpace(owner)
+	owned := []*api.SafeDepositBox{}
+	for _, v := range all {
+		if strings.EqualFold(strings.TrimSpace(v.Owner), owner) {
+			owned = append(owned, v)
+		}
+	}
+	return owned, nil
+}
+
+// list does the actual work of List without recording an audit event so that it
+// can be used by other operations
+func (s *SDB) list() ([]*api.SafeDepositBox, error) {
 	sdbList := []*api.SafeDepositBox{}
 	resp, err := s.c.DoRequest(http.MethodGet, sdbBasePath, map[string]string{}, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error while trying to list SDB: %v", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error while trying to GET SDB list. Got HTTP status code %d", resp.StatusCode)
+		return nil, s.c.httpError(resp, "Error while trying to GET SDB list")
 	}
 	err = parseResponse(resp.Body, &sdbList)
 	if err != nil {
@@ -96,7 +239,14 @@ func (s *SDB) List() ([]*api.SafeDepositBox, error) {
 }
 
 // Create creates a new Safe Deposit Box and returns the newly created object
-func (s *SDB) Create(newSDB *api.SafeDepositBox) (*api.SafeDepositBox, error) {
+func (s *SDB) Create(newSDB *api.SafeDepositBox) (box *api.SafeDepositBox, err error) {
+	defer func() { s.c.audit(AuditCreateSDB, newSDB.Name, err) }()
+	if err := s.c.checkWritable(http.MethodPost); err != nil {
+		return nil, err
+	}
+	if err := ValidateSDBName(newSDB.Name); err != nil {
+		return nil, err
+	}
 	// Create the object we are returning
 	createdSDB := &api.SafeDepositBox{}
 	resp, err := s.c.DoRequest(http.MethodPost, sdbBasePath, map[string]string{}, newSDB)
@@ -105,13 +255,13 @@ func (s *SDB) Create(newSDB *api.SafeDepositBox) (*api.SafeDepositBox, error) {
 	}
 	if resp.StatusCode == http.StatusBadRequest {
 		// Return the API error to the user
-		return nil, handleAPIError(resp.Body)
+		return nil, handleAPIError(resp)
 	}
 	// If it isn't a bad request, make sure it is a good request and return an error if it isn't
 	if resp.StatusCode != http.StatusCreated {
-		apiErr := handleAPIError(resp.Body)
+		apiErr := handleAPIError(resp)
 		if apiErr == ErrorBodyNotReturned {
-			return nil, fmt.Errorf("Error while creating SDB. Got HTTP status code %d. %v", resp.StatusCode, apiErr)
+			return nil, s.c.httpError(resp, "Error while creating SDB", apiErr.Error())
 		}
 		return nil, apiErr
 	}
@@ -123,14 +273,48 @@ func (s *SDB) Create(newSDB *api.SafeDepositBox) (*api.SafeDepositBox, error) {
 	return createdSDB, nil
 }
 
+// CreateMany creates each of the given Safe Deposit Boxes in order and returns the created
+// objects. Before anything is sent to the server, the batch is checked with CheckDuplicateNames
+// and rejected if any names are repeated (unless opts.AllowDuplicates is set). If a creation
+// fails, the boxes created up to that point are returned along with the error
+func (s *SDB) CreateMany(sdbs []api.SafeDepositBox, opts CreateManyOpts) ([]*api.SafeDepositBox, error) {
+	if err := s.c.checkWritable(http.MethodPost); err != nil {
+		return nil, err
+	}
+	if !opts.AllowDuplicates {
+		if dups := CheckDuplicateNames(sdbs); len(dups) > 0 {
+			return nil, fmt.Errorf("Safe Deposit Box names are used more than once in the batch: %s", strings.Join(dups, ", "))
+		}
+	}
+	created := make([]*api.SafeDepositBox, 0, len(sdbs))
+	for i := range sdbs {
+		box, err := s.Create(&sdbs[i])
+		if err != nil {
+			return created, fmt.Errorf("Error while creating SDB %s: %v", sdbs[i].Name, err)
+		}
+		created = append(created, box)
+	}
+	return created, nil
+}
+
 // Update updates an existing Safe Deposit Box. Any fields that are not null in the passed object
 // will overwrite any fields on the current object
-func (s *SDB) Update(id string, updatedSDB *api.SafeDepositBox) (*api.SafeDepositBox, error) {
+func (s *SDB) Update(id string, updatedSDB *api.SafeDepositBox) (box *api.SafeDepositBox, err error) {
+	defer func() { s.c.audit(AuditUpdateSDB, id, err) }()
+	if err := s.c.checkWritable(http.MethodPut); err != nil {
+		return nil, err
+	}
 	id = strings.TrimSpace(id)
 	// Check to make sure the ID isn't empty
 	if id == "" {
 		return nil, ErrorSafeDepositBoxNotFound
 	}
+	// The name is only validated if it is being changed
+	if updatedSDB.Name != "" {
+		if err := ValidateSDBName(updatedSDB.Name); err != nil {
+			return nil, err
+		}
+	}
 	returnedSDB := &api.SafeDepositBox{}
 	resp, err := s.c.DoRequest(http.MethodPut, sdbBasePath+"/"+id, map[string]string{}, updatedSDB)
 	if err != nil {
@@ -141,12 +325,12 @@ func (s *SDB) Update(id string, updatedSDB *api.SafeDepositBox) (*api.SafeDeposi
 	}
 	if resp.StatusCode == http.StatusBadRequest {
 		// Return the API error to the user
-		return nil, handleAPIError(resp.Body)
+		return nil, handleAPIError(resp)
 	}
 	if resp.StatusCode != http.StatusOK {
-		apiErr := handleAPIError(resp.Body)
+		apiErr := handleAPIError(resp)
 		if apiErr == ErrorBodyNotReturned {
-			return nil, fmt.Errorf("Error while updating SDB. Got HTTP status code %d. %v", resp.StatusCode, apiErr)
+			return nil, s.c.httpError(resp, "Error while updating SDB", apiErr.Error())
 		}
 		return nil, apiErr
 	}
@@ -158,8 +342,144 @@ func (s *SDB) Update(id string, updatedSDB *api.SafeDepositBox) (*api.SafeDeposi
 	return returnedSDB, nil
 }
 
+// TransferOwnership makes the given AD group the owner of the Safe Deposit Box with the given ID.
+// Nothing else about the SDB is changed: the current SDB is read first and sent back with only the
+// owner replaced, so its permissions are kept as they are. Returns ErrorSafeDepositBoxOwnerBlank
+// without making any requests if the new owner is blank
+func (s *SDB) TransferOwnership(id, newOwner string) error {
+	newOwner = strings.TrimSpace(newOwner)
+	if newOwner == "" {
+		return ErrorSafeDepositBoxOwnerBlank
+	}
+	if err := s.c.checkWritable(http.MethodPut); err != nil {
+		return err
+	}
+	current, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	_, err = s.Update(current.ID, &api.SafeDepositBox{
+		Description:             current.Description,
+		CategoryID:              current.CategoryID,
+		Owner:                   newOwner,
+		UserGroupPermissions:    current.UserGroupPermissions,
+		IAMPrincipalPermissions: current.IAMPrincipalPermissions,
+	})
+	return err
+}
+
+// CloneOption customizes a CloneSDB call
+type CloneOption func(*cloneConfig)
+
+// cloneConfig holds the settings from the CloneOptions given to CloneSDB
+type cloneConfig struct {
+	copySecrets bool
+}
+
+// WithSecrets has CloneSDB also copy every secret in the source SDB to the same relative
+// path in the new one. Without it a clone only has the structure (category and permissions)
+// of the source
+func WithSecrets() CloneOption {
+	return func(c *cloneConfig) {
+		c.copySecrets = true
+	}
+}
+
+// CloneSDB creates a new SDB named newName and owned by newOwner with the same description,
+// category, and permissions as the SDB with the ID sourceID. Returns ErrorSafeDepositBoxExists
+// if an SDB named newName (ignoring case) already exists. With WithSecrets, the secrets are
+// read the same way as for a Snapshot and written with WriteSecrets. If copying any of them
+// fails, the new SDB is returned along with the error so that it can be cleaned up or retried
+func (c *Client) CloneSDB(sourceID, newName, newOwner string, opts ...CloneOption) (*api.SafeDepositBox, error) {
+	cfg := &cloneConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	newOwner = strings.TrimSpace(newOwner)
+	if newOwner == "" {
+		return nil, ErrorSafeDepositBoxOwnerBlank
+	}
+	if err := ValidateSDBName(newName); err != nil {
+		return nil, err
+	}
+	if err := c.checkWritable(http.MethodPost); err != nil {
+		return nil, err
+	}
+	// Cerberus derives the path from the name, so names that only differ by case collide
+	existing, err := c.SDB().list()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range existing {
+		if strings.EqualFold(v.Name, newName) {
+			return nil, ErrorSafeDepositBoxExists
+		}
+	}
+	source, err := c.SDB().Get(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	// The permission IDs belong to the source SDB, so only the principals and roles are copied
+	clone := &api.SafeDepositBox{
+		Name:        newName,
+		Description: source.Description,
+		CategoryID:  source.CategoryID,
+		Owner:       newOwner,
+	}
+	for _, p := range source.UserGroupPermissions {
+		clone.UserGroupPermissions = append(clone.UserGroupPermissions, api.UserGroupPermission{
+			Name:   p.Name,
+			RoleID: p.RoleID,
+		})
+	}
+	for _, p := range source.IAMPrincipalPermissions {
+		clone.IAMPrincipalPermissions = append(clone.IAMPrincipalPermissions, api.IAMPrincipal{
+			IAMPrincipalARN: p.IAMPrincipalARN,
+			RoleID:          p.RoleID,
+		})
+	}
+	created, err := c.SDB().Create(clone)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.copySecrets {
+		if err := c.copySecrets(source.Path, created.Path); err != nil {
+			return created, fmt.Errorf("Error while copying secrets to cloned SDB: %w", err)
+		}
+	}
+	return created, nil
+}
+
+// copySecrets copies every secret in the SDB at the path from to the same relative path in
+// the SDB at the path to
+func (c *Client) copySecrets(from, to string) error {
+	from = strings.TrimSuffix(from, "/") + "/"
+	to = strings.TrimSuffix(to, "/") + "/"
+	secrets, err := c.snapshotSecrets(context.Background(), from, true)
+	if err != nil {
+		return err
+	}
+	entries := map[string]map[string]interface{}{}
+	for _, sec := range secrets {
+		if sec.Data == nil {
+			// Deleted since it was listed
+			continue
+		}
+		entries[to+strings.TrimPrefix(sec.Path, from)] = sec.Data
+	}
+	result, err := c.WriteSecrets(entries)
+	if err != nil {
+		return err
+	}
+	return result.Err()
+}
+
 // Delete deletes the Safe Deposit Box with the given ID
-func (s *SDB) Delete(id string) error {
+func (s *SDB) Delete(id string) (err error) {
+	defer func() { s.c.audit(AuditDeleteSDB, id, err) }()
+	if err := s.c.checkWritable(http.MethodDelete); err != nil {
+		return err
+	}
 	id = strings.TrimSpace(id)
 	// Check to make sure the ID isn't empty
 	if id == "" {
@@ -173,9 +493,9 @@ func (s *SDB) Delete(id string) error {
 		return ErrorSafeDepositBoxNotFound
 	}
 	if resp.StatusCode != http.StatusOK {
-		apiErr := handleAPIError(resp.Body)
+		apiErr := handleAPIError(resp)
 		if apiErr == ErrorBodyNotReturned {
-			return fmt.Errorf("Error while deleting SDB. Got HTTP status code %d. %v", resp.StatusCode, apiErr)
+			return s.c.httpError(resp, "Error while deleting SDB", apiErr.Error())
 		}
 		return apiErr
 	}
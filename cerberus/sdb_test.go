@@ -17,9 +17,11 @@ limitations under the License.
 package cerberus
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
@@ -56,14 +58,17 @@ var errorResponse = `{
 	}]
 }`
 
-var expectedError = api.ErrorResponse{
-	ErrorID: "a041aa4d-1d5a-4eed-8e8a-6dc18bdf96db",
-	Errors: []api.ErrorDetail{
-		api.ErrorDetail{
-			Code:    99208,
-			Message: "The name may not be blank.",
-			Metadata: map[string]interface{}{
-				"field": "name",
+var expectedError = &api.CerberusError{
+	StatusCode: http.StatusBadRequest,
+	ErrorResponse: api.ErrorResponse{
+		ErrorID: "a041aa4d-1d5a-4eed-8e8a-6dc18bdf96db",
+		Errors: []api.ErrorDetail{
+			api.ErrorDetail{
+				Code:    99208,
+				Message: "The name may not be blank.",
+				Metadata: map[string]interface{}{
+					"field": "name",
+				},
 			},
 		},
 	},
@@ -118,7 +123,7 @@ func TestGetSDB(t *testing.T) {
 	}
 
 	Convey("A valid GET of ID", t, WithTestServer(http.StatusOK, fmt.Sprintf("/v2/safe-deposit-box/%s", id), http.MethodGet, fmt.Sprintf(validResponse, id), func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid SDB", func() {
 			box, err := cl.SDB().Get(id)
@@ -127,8 +132,18 @@ func TestGetSDB(t *testing.T) {
 		})
 	}))
 
+	Convey("A GET of ID that succeeds with an empty body", t, WithTestServer(http.StatusOK, fmt.Sprintf("/v2/safe-deposit-box/%s", id), http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return an empty response error", func() {
+			sdb, err := cl.SDB().Get(id)
+			So(err, ShouldEqual, api.ErrorEmptyResponse)
+			So(sdb, ShouldBeNil)
+		})
+	}))
+
 	Convey("A GET of nonexistent ID", t, WithTestServer(http.StatusNotFound, fmt.Sprintf("/v2/safe-deposit-box/%s", id), http.MethodGet, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return SDB not found error", func() {
 			box, err := cl.SDB().Get(id)
@@ -138,7 +153,7 @@ func TestGetSDB(t *testing.T) {
 	}))
 
 	Convey("A GET request that encounters a server error", t, WithTestServer(http.StatusInternalServerError, fmt.Sprintf("/v2/safe-deposit-box/%s", id), http.MethodGet, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().Get(id)
@@ -148,7 +163,7 @@ func TestGetSDB(t *testing.T) {
 	}))
 
 	Convey("A GET to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().Get(id)
@@ -158,7 +173,7 @@ func TestGetSDB(t *testing.T) {
 	})
 
 	Convey("A GET with an empty ID", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().Get("")
@@ -174,13 +189,15 @@ func TestListSDB(t *testing.T) {
 			"id": "fb013540-fb5f-11e5-ba72-e899458df21a",
 			"name": "Web",
 			"path": "app/web",
-			"category_id": "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46"
+			"category_id": "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46",
+			"owner": "Lst-web.team"
 		},
 		{
 			"id": "06f82494-fb60-11e5-ba72-e899458df21a",
 			"name": "OneLogin",
 			"path": "shared/onelogin",
-			"category_id": "f7ffb890-faaa-11e5-a8a9-7fa3b294cd46"
+			"category_id": "f7ffb890-faaa-11e5-a8a9-7fa3b294cd46",
+			"owner": "Lst-identity.team"
 		}
 	]`
 
@@ -190,17 +207,19 @@ func TestListSDB(t *testing.T) {
 			Name:       "Web",
 			Path:       "app/web",
 			CategoryID: "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46",
+			Owner:      "Lst-web.team",
 		},
 		&api.SafeDepositBox{
 			ID:         "06f82494-fb60-11e5-ba72-e899458df21a",
 			Name:       "OneLogin",
 			Path:       "shared/onelogin",
 			CategoryID: "f7ffb890-faaa-11e5-a8a9-7fa3b294cd46",
+			Owner:      "Lst-identity.team",
 		},
 	}
 
 	Convey("A valid call to List", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid list of SDB", func() {
 			boxes, err := cl.SDB().List()
@@ -209,8 +228,33 @@ func TestListSDB(t *testing.T) {
 		})
 	}))
 
+	Convey("A valid call to ListByOwner", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return only the SDBs owned by the group", func() {
+			boxes, err := cl.SDB().ListByOwner("lst-web.TEAM")
+			So(err, ShouldBeNil)
+			So(boxes, ShouldResemble, expectedResponse[:1])
+		})
+		Convey("Should return an empty list for a group that owns nothing", func() {
+			boxes, err := cl.SDB().ListByOwner("Lst-nobody")
+			So(err, ShouldBeNil)
+			So(boxes, ShouldBeEmpty)
+		})
+	}))
+
+	Convey("A call to ListByOwner that encounters a server error", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return an error", func() {
+			boxes, err := cl.SDB().ListByOwner("Lst-web.team")
+			So(err, ShouldNotBeNil)
+			So(boxes, ShouldBeNil)
+		})
+	}))
+
 	Convey("A call to List that encounters a server error", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			boxes, err := cl.SDB().List()
@@ -220,7 +264,7 @@ func TestListSDB(t *testing.T) {
 	}))
 
 	Convey("A List to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			boxes, err := cl.SDB().List()
@@ -230,6 +274,57 @@ func TestListSDB(t *testing.T) {
 	})
 }
 
+func TestSDBExists(t *testing.T) {
+	var validResponse = `[
+		{
+			"id": "fb013540-fb5f-11e5-ba72-e899458df21a",
+			"name": "Web",
+			"path": "app/web",
+			"category_id": "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46"
+		}
+	]`
+
+	Convey("A list containing the SDB", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return true", func() {
+			exists, err := cl.SDB().Exists("Web")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+		})
+		Convey("Should return false without an error for a missing SDB", func() {
+			exists, err := cl.SDB().Exists("Mobile")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		})
+		Convey("Should return false without an error for an empty name", func() {
+			exists, err := cl.SDB().Exists("")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		})
+	}))
+
+	Convey("An unauthorized list", t, WithTestServer(http.StatusUnauthorized, "/v2/safe-deposit-box", http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return an error", func() {
+			exists, err := cl.SDB().Exists("Web")
+			So(err, ShouldNotBeNil)
+			So(exists, ShouldBeFalse)
+		})
+	}))
+
+	Convey("A non-responsive server", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return an error", func() {
+			exists, err := cl.SDB().Exists("Web")
+			So(err, ShouldNotBeNil)
+			So(exists, ShouldBeFalse)
+		})
+	})
+}
+
 func TestGetByName(t *testing.T) {
 	var validResponse = `[
 		{
@@ -254,7 +349,7 @@ func TestGetByName(t *testing.T) {
 	}
 
 	Convey("A valid call to GetByName", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return a valid SDB", func() {
 			box, err := cl.SDB().GetByName("Web")
@@ -264,7 +359,7 @@ func TestGetByName(t *testing.T) {
 	}))
 
 	Convey("GetByName given an invalid name", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return SDB not found error", func() {
 			box, err := cl.SDB().GetByName("Blah")
@@ -273,8 +368,43 @@ func TestGetByName(t *testing.T) {
 		})
 	}))
 
+	var duplicateResponse = `[
+		{
+			"id": "fb013540-fb5f-11e5-ba72-e899458df21a",
+			"name": "Web",
+			"path": "app/web",
+			"category_id": "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46"
+		},
+		{
+			"id": "06f82494-fb60-11e5-ba72-e899458df21a",
+			"name": "Web",
+			"path": "app/web-2",
+			"category_id": "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46"
+		}
+	]`
+
+	Convey("GetByName given a name used by more than one SDB", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box", http.MethodGet, duplicateResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return an ambiguous name error", func() {
+			box, err := cl.SDB().GetByName("Web")
+			So(err, ShouldEqual, ErrorSafeDepositBoxNameAmbiguous)
+			So(box, ShouldBeNil)
+		})
+	}))
+
+	Convey("GetByName given a name that only differs by case", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return SDB not found error", func() {
+			box, err := cl.SDB().GetByName("web")
+			So(err, ShouldEqual, ErrorSafeDepositBoxNotFound)
+			So(box, ShouldBeNil)
+		})
+	}))
+
 	Convey("A call to GetByName with an empty name", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().GetByName("")
@@ -284,7 +414,7 @@ func TestGetByName(t *testing.T) {
 	})
 
 	Convey("A call to GetByName that encounters a server error", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box", http.MethodGet, validResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().GetByName("Web")
@@ -294,7 +424,7 @@ func TestGetByName(t *testing.T) {
 	}))
 
 	Convey("A GetByName to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().GetByName("Web")
@@ -374,7 +504,7 @@ func TestCreateSDB(t *testing.T) {
 	}
 
 	Convey("A valid new SDB object", t, WithTestServer(http.StatusCreated, "/v2/safe-deposit-box", http.MethodPost, fmt.Sprintf(validResponse, id), func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should create successfully", func() {
 			box, err := cl.SDB().Create(newSDB)
@@ -386,48 +516,60 @@ func TestCreateSDB(t *testing.T) {
 	}))
 
 	Convey("An invalid new SDB object", t, WithTestServer(http.StatusBadRequest, "/v2/safe-deposit-box", http.MethodPost, errorResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		var badSDB = *newSDB
-		badSDB.Name = ""
+		badSDB.Owner = ""
 		Convey("Should error", func() {
 			box, err := cl.SDB().Create(&badSDB)
 			So(err, ShouldNotBeNil)
 			So(box, ShouldBeNil)
-			Convey("And return an API ErrorResponse", func() {
+			Convey("And return a CerberusError", func() {
 				So(err, ShouldResemble, expectedError)
 			})
 		})
 	}))
 
+	Convey("A new SDB object with an invalid name", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		var badSDB = *newSDB
+		badSDB.Name = ""
+		Convey("Should error without calling the server", func() {
+			box, err := cl.SDB().Create(&badSDB)
+			So(err, ShouldEqual, ErrorSafeDepositBoxNameBlank)
+			So(box, ShouldBeNil)
+		})
+	})
+
 	Convey("An bad server response", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box", http.MethodPost, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			box, err := cl.SDB().Create(newSDB)
 			So(err, ShouldNotBeNil)
 			So(box, ShouldBeNil)
-			Convey("And should not be an API ErrorResponse", func() {
-				So(err, ShouldNotHaveSameTypeAs, api.ErrorResponse{})
+			Convey("And should not be a CerberusError", func() {
+				So(err, ShouldNotHaveSameTypeAs, &api.CerberusError{})
 			})
 		})
 	}))
 
 	Convey("An bad server response with an invalid body", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box", http.MethodPost, "blah", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			box, err := cl.SDB().Create(newSDB)
 			So(err, ShouldNotBeNil)
 			So(box, ShouldBeNil)
-			Convey("And should not be an API ErrorResponse", func() {
-				So(err, ShouldNotHaveSameTypeAs, api.ErrorResponse{})
+			Convey("And should not be a CerberusError", func() {
+				So(err, ShouldNotHaveSameTypeAs, &api.CerberusError{})
 			})
 		})
 	}))
 
 	Convey("A Create to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().Create(newSDB)
@@ -491,7 +633,7 @@ func TestUpdateSDB(t *testing.T) {
 	}
 
 	Convey("A valid SDB object", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box/"+id, http.MethodPut, fmt.Sprintf(validResponse, id), func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should update successfully", func() {
 			box, err := cl.SDB().Update(id, updated)
@@ -503,7 +645,7 @@ func TestUpdateSDB(t *testing.T) {
 	}))
 
 	Convey("An invalid SDB object", t, WithTestServer(http.StatusBadRequest, "/v2/safe-deposit-box/"+id, http.MethodPut, errorResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		var badSDB = *updated
 		badSDB.ID = "you-shouldn't-change-this"
@@ -511,14 +653,27 @@ func TestUpdateSDB(t *testing.T) {
 			box, err := cl.SDB().Update(id, &badSDB)
 			So(err, ShouldNotBeNil)
 			So(box, ShouldBeNil)
-			Convey("And return an API ErrorResponse", func() {
+			Convey("And return a CerberusError", func() {
 				So(err, ShouldResemble, expectedError)
 			})
 		})
 	}))
 
+	Convey("An SDB object with an invalid new name", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		var badSDB = *updated
+		badSDB.Name = "stage/prod"
+		Convey("Should error without calling the server", func() {
+			box, err := cl.SDB().Update(id, &badSDB)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid character")
+			So(box, ShouldBeNil)
+		})
+	})
+
 	Convey("An update to a non-existent ID", t, WithTestServer(http.StatusNotFound, "/v2/safe-deposit-box/blah", http.MethodPut, "blah", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			box, err := cl.SDB().Update("blah", updated)
@@ -531,33 +686,33 @@ func TestUpdateSDB(t *testing.T) {
 	}))
 
 	Convey("An bad server response", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box/"+id, http.MethodPut, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			box, err := cl.SDB().Update(id, updated)
 			So(err, ShouldNotBeNil)
 			So(box, ShouldBeNil)
-			Convey("And should not be an API ErrorResponse", func() {
-				So(err, ShouldNotHaveSameTypeAs, api.ErrorResponse{})
+			Convey("And should not be a CerberusError", func() {
+				So(err, ShouldNotHaveSameTypeAs, &api.CerberusError{})
 			})
 		})
 	}))
 
 	Convey("An bad server response with an invalid body", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box/"+id, http.MethodPut, "blah", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			box, err := cl.SDB().Update(id, updated)
 			So(err, ShouldNotBeNil)
 			So(box, ShouldBeNil)
-			Convey("And should not be an API ErrorResponse", func() {
-				So(err, ShouldNotHaveSameTypeAs, api.ErrorResponse{})
+			Convey("And should not be a CerberusError", func() {
+				So(err, ShouldNotHaveSameTypeAs, &api.CerberusError{})
 			})
 		})
 	}))
 
 	Convey("An Update to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().Update(id, updated)
@@ -567,7 +722,7 @@ func TestUpdateSDB(t *testing.T) {
 	})
 
 	Convey("A call to Update with an empty ID", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			box, err := cl.SDB().Update("", updated)
@@ -582,7 +737,7 @@ func TestDeleteSDB(t *testing.T) {
 	var id = "a7d703da-faac-11e5-a8a9-7fa3b294cd46"
 
 	Convey("A valid delete", t, WithTestServer(http.StatusOK, "/v2/safe-deposit-box/"+id, http.MethodDelete, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should complete successfully", func() {
 			err := cl.SDB().Delete(id)
@@ -591,19 +746,19 @@ func TestDeleteSDB(t *testing.T) {
 	}))
 
 	Convey("An invalid delete", t, WithTestServer(http.StatusBadRequest, "/v2/safe-deposit-box/"+id, http.MethodDelete, errorResponse, func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			err := cl.SDB().Delete(id)
 			So(err, ShouldNotBeNil)
-			Convey("And return an API ErrorResponse", func() {
+			Convey("And return a CerberusError", func() {
 				So(err, ShouldResemble, expectedError)
 			})
 		})
 	}))
 
 	Convey("An delete of a non-existent ID", t, WithTestServer(http.StatusNotFound, "/v2/safe-deposit-box/blah", http.MethodDelete, "blah", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			err := cl.SDB().Delete("blah")
@@ -615,19 +770,19 @@ func TestDeleteSDB(t *testing.T) {
 	}))
 
 	Convey("An bad server response", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box/"+id, http.MethodDelete, "", func(ts *httptest.Server) {
-		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should error", func() {
 			err := cl.SDB().Delete(id)
 			So(err, ShouldNotBeNil)
-			Convey("And should not be an API ErrorResponse", func() {
-				So(err, ShouldNotHaveSameTypeAs, api.ErrorResponse{})
+			Convey("And should not be a CerberusError", func() {
+				So(err, ShouldNotHaveSameTypeAs, &api.CerberusError{})
 			})
 		})
 	}))
 
 	Convey("A delete to a non-responsive server", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			err := cl.SDB().Delete(id)
@@ -636,7 +791,7 @@ func TestDeleteSDB(t *testing.T) {
 	})
 
 	Convey("A call to Delete with an empty ID", t, func() {
-		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), nil)
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
 		So(cl, ShouldNotBeNil)
 		Convey("Should return an error", func() {
 			err := cl.SDB().Delete("")
@@ -646,3 +801,295 @@ func TestDeleteSDB(t *testing.T) {
 	})
 
 }
+
+func TestValidateSDBName(t *testing.T) {
+	Convey("A valid name", t, func() {
+		So(ValidateSDBName("My cool_sdb-2"), ShouldBeNil)
+	})
+	Convey("A blank name", t, func() {
+		So(ValidateSDBName(""), ShouldEqual, ErrorSafeDepositBoxNameBlank)
+		So(ValidateSDBName("   "), ShouldEqual, ErrorSafeDepositBoxNameBlank)
+	})
+	Convey("A name that is too long", t, func() {
+		err := ValidateSDBName(strings.Repeat("a", sdbNameMaxLength+1))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "may not be longer than 100 characters")
+	})
+	Convey("A name at the maximum length", t, func() {
+		So(ValidateSDBName(strings.Repeat("a", sdbNameMaxLength)), ShouldBeNil)
+	})
+	Convey("A name with invalid characters", t, func() {
+		err := ValidateSDBName("bad.name")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, `'.'`)
+	})
+}
+
+func TestPrincipalsWithAccessSDB(t *testing.T) {
+	var id = "a7d703da-faac-11e5-a8a9-7fa3b294cd46"
+	var validResponse = `{
+    "id": "a7d703da-faac-11e5-a8a9-7fa3b294cd46",
+    "name": "Stage",
+    "iam_principal_permissions": [
+        {
+            "id": "d05bf72e-faad-11e5-a8a9-7fa3b294cd46",
+            "iam_principal_arn": "arn:aws:iam::1111111111:role/role-name",
+            "role_id": "f800558e-faaa-11e5-a8a9-7fa3b294cd46"
+        },
+        {
+            "id": "e05bf72e-faad-11e5-a8a9-7fa3b294cd46",
+            "iam_principal_arn": "arn:aws:iam::1111111111:role/other-role",
+            "role_id": "f800558e-faaa-11e5-a8a9-7fa3b294cd46"
+        }
+    ]
+}`
+	Convey("An SDB with IAM principals", t, WithTestServer(http.StatusOK, fmt.Sprintf("/v2/safe-deposit-box/%s", id), http.MethodGet, validResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the granted ARNs", func() {
+			arns, err := cl.SDB().PrincipalsWithAccess(id)
+			So(err, ShouldBeNil)
+			So(arns, ShouldResemble, []string{
+				"arn:aws:iam::1111111111:role/role-name",
+				"arn:aws:iam::1111111111:role/other-role",
+			})
+		})
+	}))
+
+	Convey("A nonexistent SDB", t, WithTestServer(http.StatusNotFound, fmt.Sprintf("/v2/safe-deposit-box/%s", id), http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return ErrorSafeDepositBoxNotFound", func() {
+			arns, err := cl.SDB().PrincipalsWithAccess(id)
+			So(err, ShouldEqual, ErrorSafeDepositBoxNotFound)
+			So(arns, ShouldBeNil)
+		})
+	}))
+}
+
+func TestCheckDuplicateNames(t *testing.T) {
+	Convey("A batch with duplicate names", t, func() {
+		dups := CheckDuplicateNames([]api.SafeDepositBox{
+			{Name: "Web"},
+			{Name: "Stage"},
+			{Name: "web "},
+			{Name: "Stage"},
+			{Name: "WEB"},
+		})
+		Convey("Should return each duplicate once", func() {
+			So(dups, ShouldResemble, []string{"web ", "Stage"})
+		})
+	})
+
+	Convey("A batch without duplicate names", t, func() {
+		Convey("Should return nil", func() {
+			So(CheckDuplicateNames([]api.SafeDepositBox{{Name: "Web"}, {Name: "Stage"}}), ShouldBeNil)
+		})
+	})
+}
+
+func TestCreateManySDB(t *testing.T) {
+	var validResponse = `{"id": "a7d703da-faac-11e5-a8a9-7fa3b294cd46", "name": "Stage"}`
+	batch := []api.SafeDepositBox{
+		{Name: "Stage", Owner: "Lst-digital.platform-tools.internal"},
+		{Name: "stage", Owner: "Lst-digital.platform-tools.internal"},
+	}
+	Convey("A batch with duplicate names", t, func() {
+		var called bool
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should be rejected before calling the server", func() {
+			created, err := cl.SDB().CreateMany(batch, CreateManyOpts{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "stage")
+			So(created, ShouldBeNil)
+			So(called, ShouldBeFalse)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A batch with duplicates allowed", t, WithTestServer(http.StatusCreated, "/v2/safe-deposit-box", http.MethodPost, validResponse, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should create every SDB", func() {
+			created, err := cl.SDB().CreateMany(batch, CreateManyOpts{AllowDuplicates: true})
+			So(err, ShouldBeNil)
+			So(created, ShouldHaveLength, 2)
+		})
+	}))
+
+	Convey("A batch that fails on the server", t, WithTestServer(http.StatusInternalServerError, "/v2/safe-deposit-box", http.MethodPost, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the error", func() {
+			created, err := cl.SDB().CreateMany(batch[:1], CreateManyOpts{})
+			So(err, ShouldNotBeNil)
+			So(created, ShouldBeEmpty)
+		})
+	}))
+}
+
+func TestTransferOwnership(t *testing.T) {
+	var id = "a7d703da-faac-11e5-a8a9-7fa3b294cd46"
+	var current = api.SafeDepositBox{
+		ID:          id,
+		Name:        "Stage",
+		Description: "Sensitive configuration properties for the stage micro-service.",
+		Path:        "app/stage",
+		CategoryID:  "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46",
+		Owner:       "Lst-digital.platform-tools.internal",
+		UserGroupPermissions: []api.UserGroupPermission{
+			{ID: "3fc6455c-faad-11e5-a8a9-7fa3b294cd46", Name: "Lst-CDT.CloudPlatformEngine.FTE", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+		},
+		IAMPrincipalPermissions: []api.IAMPrincipal{
+			{ID: "d05bf72e-faad-11e5-a8a9-7fa3b294cd46", IAMPrincipalARN: "arn:aws:iam::1111111111:role/role-name", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+		},
+	}
+
+	Convey("Transferring ownership of an SDB", t, func() {
+		var sent *api.SafeDepositBox
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != sdbBasePath+"/"+id {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			switch r.Method {
+			case http.MethodGet:
+				json.NewEncoder(w).Encode(current)
+			case http.MethodPut:
+				sent = &api.SafeDepositBox{}
+				json.NewDecoder(r.Body).Decode(sent)
+				updated := current
+				updated.Owner = sent.Owner
+				json.NewEncoder(w).Encode(updated)
+			}
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should change only the owner", func() {
+			So(cl.SDB().TransferOwnership(id, " Lst-new.team "), ShouldBeNil)
+			So(sent, ShouldNotBeNil)
+			So(sent.Owner, ShouldEqual, "Lst-new.team")
+			So(sent.UserGroupPermissions, ShouldResemble, current.UserGroupPermissions)
+			So(sent.IAMPrincipalPermissions, ShouldResemble, current.IAMPrincipalPermissions)
+			So(sent.CategoryID, ShouldEqual, current.CategoryID)
+			So(sent.Description, ShouldEqual, current.Description)
+		})
+		Convey("Should refuse a blank owner without calling the server", func() {
+			So(cl.SDB().TransferOwnership(id, "  "), ShouldEqual, ErrorSafeDepositBoxOwnerBlank)
+			So(sent, ShouldBeNil)
+		})
+		Convey("Should return not found for a missing SDB", func() {
+			So(cl.SDB().TransferOwnership("blah", "Lst-new.team"), ShouldEqual, ErrorSafeDepositBoxNotFound)
+			So(sent, ShouldBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestCloneSDB(t *testing.T) {
+	var id = "a7d703da-faac-11e5-a8a9-7fa3b294cd46"
+	var source = api.SafeDepositBox{
+		ID:          id,
+		Name:        "Stage",
+		Description: "Sensitive configuration properties for the stage micro-service.",
+		Path:        "app/stage/",
+		CategoryID:  "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46",
+		Owner:       "Lst-digital.platform-tools.internal",
+		UserGroupPermissions: []api.UserGroupPermission{
+			{ID: "3fc6455c-faad-11e5-a8a9-7fa3b294cd46", Name: "Lst-CDT.CloudPlatformEngine.FTE", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+		},
+		IAMPrincipalPermissions: []api.IAMPrincipal{
+			{ID: "d05bf72e-faad-11e5-a8a9-7fa3b294cd46", IAMPrincipalARN: "arn:aws:iam::1111111111:role/role-name", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+		},
+	}
+
+	Convey("Cloning an SDB", t, func() {
+		var sent *api.SafeDepositBox
+		written := map[string]map[string]interface{}{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			listing := r.URL.Query().Get("list") == "true"
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == sdbBasePath:
+				json.NewEncoder(w).Encode([]api.SafeDepositBox{source})
+			case r.Method == http.MethodGet && r.URL.Path == sdbBasePath+"/"+id:
+				json.NewEncoder(w).Encode(source)
+			case r.Method == http.MethodPost && r.URL.Path == sdbBasePath:
+				sent = &api.SafeDepositBox{}
+				json.NewDecoder(r.Body).Decode(sent)
+				created := *sent
+				created.ID = "e4ed0d6e-fb5f-11e5-ba72-e899458df21a"
+				created.Path = "app/onboarding/"
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(created)
+			case listing && r.URL.Path == "/v1/secret/app/stage/":
+				w.Write([]byte(`{"data": {"keys": ["nested/", "config"]}}`))
+			case listing && r.URL.Path == "/v1/secret/app/stage/nested/":
+				w.Write([]byte(`{"data": {"keys": ["db"]}}`))
+			case r.Method == http.MethodGet && (r.URL.Path == "/v1/secret/app/stage/config" || r.URL.Path == "/v1/secret/app/stage/nested/db"):
+				w.Write([]byte(secretResponse))
+			case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/secret/app/onboarding/"):
+				data := map[string]interface{}{}
+				json.NewDecoder(r.Body).Decode(&data)
+				written[r.URL.Path] = data
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should copy the structure under the new name and owner", func() {
+			box, err := cl.CloneSDB(id, "Onboarding", "Lst-new.team")
+			So(err, ShouldBeNil)
+			So(box.Name, ShouldEqual, "Onboarding")
+			So(sent.Owner, ShouldEqual, "Lst-new.team")
+			So(sent.CategoryID, ShouldEqual, source.CategoryID)
+			So(sent.Description, ShouldEqual, source.Description)
+			So(sent.UserGroupPermissions, ShouldResemble, []api.UserGroupPermission{
+				{Name: "Lst-CDT.CloudPlatformEngine.FTE", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+			})
+			So(sent.IAMPrincipalPermissions, ShouldResemble, []api.IAMPrincipal{
+				{IAMPrincipalARN: "arn:aws:iam::1111111111:role/role-name", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+			})
+			So(written, ShouldBeEmpty)
+		})
+		Convey("Should copy secrets when asked", func() {
+			box, err := cl.CloneSDB(id, "Onboarding", "Lst-new.team", WithSecrets())
+			So(err, ShouldBeNil)
+			So(box, ShouldNotBeNil)
+			So(written, ShouldHaveLength, 2)
+			So(written["/v1/secret/app/onboarding/config"]["username"], ShouldEqual, "bob")
+			So(written["/v1/secret/app/onboarding/nested/db"]["password"], ShouldEqual, "hunter2")
+		})
+		Convey("Should refuse a name that is already taken", func() {
+			box, err := cl.CloneSDB(id, "stage", "Lst-new.team", WithSecrets())
+			So(err, ShouldEqual, ErrorSafeDepositBoxExists)
+			So(box, ShouldBeNil)
+			So(sent, ShouldBeNil)
+		})
+		Convey("Should refuse a blank owner", func() {
+			box, err := cl.CloneSDB(id, "Onboarding", " ")
+			So(err, ShouldEqual, ErrorSafeDepositBoxOwnerBlank)
+			So(box, ShouldBeNil)
+			So(sent, ShouldBeNil)
+		})
+		Convey("Should return not found for a missing source", func() {
+			box, err := cl.CloneSDB("blah", "Onboarding", "Lst-new.team")
+			So(err, ShouldEqual, ErrorSafeDepositBoxNotFound)
+			So(box, ShouldBeNil)
+			So(sent, ShouldBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -17,36 +17,276 @@ limitations under the License.
 package cerberus
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
 	vault "github.com/hashicorp/vault/api"
 )
 
-// Note: This is not tested because it is a simple wrapper on top of Vault, which has its own tests
-
-// Secret wraps the vault.Logical client to make sure all paths are prefaced
-// with "secret". This does not expose Unwrap because it will not work with
+// Secret is a subclient for reading and writing secrets. All paths are prefaced with "secret"
+// and requests go through the same DoRequest path as the rest of the client, while responses
+// are returned as vault.Secrets. This does not expose Unwrap because it will not work with
 // Cerberus' path routing
 type Secret struct {
-	v *vault.Logical
+	c *Client
 }
 
 const pathPrefix = "secret/"
 
+// ReadManyConcurrency is the maximum number of secrets ReadMany will read at once
+const ReadManyConcurrency = 8
+
+// ErrorSecretReadTimeout is returned for a path in ReadMany that didn't finish
+// within its share of the context deadline
+var ErrorSecretReadTimeout = fmt.Errorf("Timed out while reading secret")
+
+// SecretResult is the result of reading a single path with ReadMany. Exactly
+// like Read, Secret is nil with no error if nothing exists at the path
+type SecretResult struct {
+	Path   string
+	Secret *vault.Secret
+	Err    error
+}
+
+// vaultErrorResponse is the error body returned by the secret backend
+type vaultErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+// do performs a request against the secret backend in a span named for the operation, such
+// as cerberus.secret.read, if tracing is on
+func (s *Secret) do(ctx context.Context, method, path string, params map[string]string, data interface{}) (*vault.Secret, error) {
+	ctx, span := utils.StartSpan(ctx, s.c.tracer, secretSpanName(method, params))
+	sec, err := s.request(ctx, method, path, params, data)
+	return sec, span.End(err)
+}
+
+// secretSpanName returns the name of the span for a request to the secret backend
+func secretSpanName(method string, params map[string]string) string {
+	switch {
+	case method == http.MethodGet && params["list"] == "true":
+		return "cerberus.secret.list"
+	case method == http.MethodGet:
+		return "cerberus.secret.read"
+	case method == http.MethodDelete:
+		return "cerberus.secret.delete"
+	}
+	return "cerberus.secret.write"
+}
+
+// request performs a request against the secret backend and handles the response the same
+// way the vault client does: a 404 on a read or list means there is nothing at the path,
+// and a response without a body is a nil secret
+func (s *Secret) request(ctx context.Context, method, path string, params map[string]string, data interface{}) (*vault.Secret, error) {
+	resp, err := s.c.doRequest(ctx, method, "/v1/"+pathPrefix+path, params, data)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		var vaultErr vaultErrorResponse
+		if parseResponse(resp.Body, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return nil, s.c.httpError(resp, "", vaultErr.Errors...)
+		}
+		return nil, s.c.httpError(resp, "")
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	sec, err := vault.ParseSecret(resp.Body)
+	if err == io.EOF {
+		return nil, nil
+	}
+	return sec, err
+}
+
 // Delete deletes the given path. Path should not be prefaced with a "/"
-func (s *Secret) Delete(path string) (*vault.Secret, error) {
-	return s.v.Delete(pathPrefix + path)
+func (s *Secret) Delete(path string) (sec *vault.Secret, err error) {
+	defer func() { s.c.audit(AuditDeleteSecret, path, err) }()
+	if err := s.c.checkWritable(http.MethodDelete); err != nil {
+		return nil, err
+	}
+	sec, err = s.do(context.Background(), http.MethodDelete, path, map[string]string{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error while deleting secret: %v", err)
+	}
+	s.c.cache.invalidate(path)
+	if s.c.writes != nil {
+		s.c.writes.wrote(path, nil)
+	}
+	return sec, nil
 }
 
 // List lists secrets at the given path. Path should not be prefaced with a "/"
-func (s *Secret) List(path string) (*vault.Secret, error) {
-	return s.v.List(pathPrefix + path)
+func (s *Secret) List(path string) (sec *vault.Secret, err error) {
+	defer func() { s.c.audit(AuditListSecrets, path, err) }()
+	sec, err = s.do(context.Background(), http.MethodGet, path, map[string]string{"list": "true"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error while listing secrets: %v", err)
+	}
+	return sec, nil
 }
 
 // Read returns the secret at the given path. Path should not be prefaced with a "/"
-func (s *Secret) Read(path string) (*vault.Secret, error) {
-	return s.v.Read(pathPrefix + path)
+func (s *Secret) Read(path string) (sec *vault.Secret, err error) {
+	return s.ReadWithContext(context.Background(), path)
+}
+
+// ReadWithContext is the same as Read, but the request is abandoned if the context
+// is cancelled or its deadline passes
+func (s *Secret) ReadWithContext(ctx context.Context, path string) (sec *vault.Secret, err error) {
+	defer func() { s.c.audit(AuditReadSecret, path, err) }()
+	sec, err = s.c.consistentRead(ctx, path, func() (*vault.Secret, error) {
+		return s.do(ctx, http.MethodGet, path, map[string]string{}, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading secret: %v", err)
+	}
+	return sec, nil
+}
+
+// ReadMany reads all of the given paths in parallel (up to ReadManyConcurrency at a time)
+// and returns a result for every path, in the same order they were given. A failure
+// reading one path does not stop the others, so the results may be partial.
+//
+// If the context has a deadline, each path only gets a share of the time that is left
+// so that a few slow paths can't use up the whole budget before the rest have started.
+// When a path starts, the remaining time is divided by the number of rounds of reads
+// still needed to get through the paths that haven't started yet, so time saved by
+// paths that finish early goes to the ones after them. A path that runs out of time
+// gets ErrorSecretReadTimeout. If the parent context itself is cancelled or expires,
+// any path that hasn't finished gets the context's error instead
+func (s *Secret) ReadMany(ctx context.Context, paths []string) []SecretResult {
+	results := make([]SecretResult, len(paths))
+	workers := ReadManyConcurrency
+	if len(paths) < workers {
+		workers = len(paths)
+	}
+	var lock sync.Mutex
+	next := 0
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				lock.Lock()
+				if next >= len(paths) {
+					lock.Unlock()
+					return
+				}
+				i := next
+				next++
+				lock.Unlock()
+				// Include this path in the count of paths yet to be read
+				rounds := (len(paths) - i + workers - 1) / workers
+				results[i] = s.readWithBudget(ctx, paths[i], rounds)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// readWithBudget reads a single path for ReadMany, giving it 1/rounds of the time
+// left before the context deadline
+func (s *Secret) readWithBudget(ctx context.Context, path string, rounds int) SecretResult {
+	result := SecretResult{Path: path}
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+	reqCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, deadline.Sub(time.Now())/time.Duration(rounds))
+		defer cancel()
+	}
+	result.Secret, result.Err = s.ReadWithContext(reqCtx, path)
+	if result.Err != nil {
+		// Tell the caller why the path was cut short
+		if err := ctx.Err(); err != nil {
+			result.Err = err
+		} else if reqCtx.Err() == context.DeadlineExceeded {
+			result.Err = ErrorSecretReadTimeout
+		}
+	}
+	return result
+}
+
+// Flatten reads every secret in the SDB with the given path (e.g. "app/my-sdb") and returns all
+// of their keys in a single flat map, which is handy for feeding templating tools. Each key is the
+// path of the secret relative to the SDB followed by the key inside the secret, so the "password"
+// key of the secret at "app/my-sdb/db" becomes "db/password". String values are used as is and any
+// other value (numbers, booleans, lists, and objects) is JSON encoded, so the number 5432 becomes
+// "5432" and a list becomes something like `["a","b"]`
+func (s *Secret) Flatten(sdbPath string) (map[string]string, error) {
+	ctx := context.Background()
+	folder := strings.TrimSuffix(sdbPath, "/") + "/"
+	paths, err := s.c.secretPaths(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+	flat := map[string]string{}
+	for _, p := range paths {
+		sec, err := s.ReadWithContext(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if sec == nil {
+			continue
+		}
+		prefix := strings.TrimPrefix(p, folder) + "/"
+		for k, v := range sec.Data {
+			str, err := valueString(v)
+			if err != nil {
+				return nil, fmt.Errorf("Error while encoding value of %s in %s: %v", k, p, err)
+			}
+			flat[prefix+k] = str
+		}
+	}
+	return flat, nil
+}
+
+// valueString returns a secret value as a string. Strings are returned as is and
+// anything else is JSON encoded
+func valueString(v interface{}) (string, error) {
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
 }
 
 // Write creates a new secret at the given path. Path should not be prefaced with a "/"
-func (s *Secret) Write(path string, data map[string]interface{}) (*vault.Secret, error) {
-	return s.v.Write(pathPrefix+path, data)
+func (s *Secret) Write(path string, data map[string]interface{}) (sec *vault.Secret, err error) {
+	defer func() { s.c.audit(AuditWriteSecret, path, err) }()
+	if err := s.c.checkWritable(http.MethodPut); err != nil {
+		return nil, err
+	}
+	if err := ValidateSecretWrite(path, data); err != nil {
+		return nil, err
+	}
+	sec, err = s.do(context.Background(), http.MethodPut, path, map[string]string{}, data)
+	if err != nil {
+		return nil, fmt.Errorf("Error while writing secret: %v", err)
+	}
+	s.c.cache.invalidate(path)
+	if s.c.writes != nil {
+		s.c.writes.wrote(path, data)
+	}
+	return sec, nil
 }
@@ -0,0 +1,184 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/utils"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// secretServer serves secretResponse for every path except ones containing "slow",
+// which hang until the request is cancelled, and ones containing "missing", which 404
+func secretServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "slow"):
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			w.WriteHeader(http.StatusGatewayTimeout)
+		case strings.Contains(r.URL.Path, "missing"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(secretResponse))
+		}
+	}))
+}
+
+func TestFlatten(t *testing.T) {
+	Convey("Flattening an SDB", t, func() {
+		ts := snapshotServer()
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return every key of every secret", func() {
+			flat, err := cl.Secret().Flatten("app/dev-demo")
+			So(err, ShouldBeNil)
+			So(flat, ShouldHaveLength, 10)
+			So(flat["config/username"], ShouldEqual, "bob")
+			So(flat["nested/db/password"], ShouldEqual, "hunter2")
+			Convey("And should JSON encode non-string values", func() {
+				So(flat["config/port"], ShouldEqual, "5432")
+				So(flat["config/debug"], ShouldEqual, "false")
+			})
+		})
+		Convey("Should return an empty map for an empty SDB", func() {
+			flat, err := cl.Secret().Flatten("shared/iam-w-d-wasd/")
+			So(err, ShouldBeNil)
+			So(flat, ShouldBeEmpty)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestReadMany(t *testing.T) {
+	Convey("Reading many secrets", t, func() {
+		ts := secretServer()
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Without a deadline should return every result in order", func() {
+			results := cl.Secret().ReadMany(context.Background(), []string{"app/one", "app/missing", "app/two"})
+			So(results, ShouldHaveLength, 3)
+			So(results[0].Path, ShouldEqual, "app/one")
+			So(results[0].Err, ShouldBeNil)
+			So(results[0].Secret.Data["username"], ShouldEqual, "bob")
+			So(results[1].Path, ShouldEqual, "app/missing")
+			So(results[1].Err, ShouldBeNil)
+			So(results[1].Secret, ShouldBeNil)
+			So(results[2].Secret.Data["password"], ShouldEqual, "hunter2")
+		})
+
+		Convey("With a deadline should time out slow paths without starving the rest", func() {
+			paths := []string{"app/slow"}
+			for i := 0; i < ReadManyConcurrency; i++ {
+				paths = append(paths, fmt.Sprintf("app/fast%d", i))
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			start := time.Now()
+			results := cl.Secret().ReadMany(ctx, paths)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(results, ShouldHaveLength, len(paths))
+			So(results[0].Err, ShouldEqual, ErrorSecretReadTimeout)
+			So(results[0].Secret, ShouldBeNil)
+			for _, r := range results[1:] {
+				So(r.Err, ShouldBeNil)
+				So(r.Secret, ShouldNotBeNil)
+			}
+		})
+
+		Convey("With a cancelled context should return the context error", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			results := cl.Secret().ReadMany(ctx, []string{"app/one", "app/two"})
+			So(results[0].Err, ShouldEqual, context.Canceled)
+			So(results[1].Err, ShouldEqual, context.Canceled)
+		})
+
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestSecretTracing(t *testing.T) {
+	Convey("A client with tracing on", t, func() {
+		ts := secretServer()
+		recorder := tracetest.NewSpanRecorder()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithTracerProvider(tp))
+		So(cl, ShouldNotBeNil)
+
+		Convey("Should trace a read under the span in the context", func() {
+			ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+			_, err := cl.Secret().ReadWithContext(ctx, "app/sdb/config")
+			parent.End()
+			So(err, ShouldBeNil)
+			spans := recorder.Ended()
+			So(spans, ShouldHaveLength, 2)
+			So(spans[0].Name(), ShouldEqual, "cerberus.secret.read")
+			So(spans[0].Parent().SpanID(), ShouldEqual, parent.SpanContext().SpanID())
+			So(spans[0].Status().Code, ShouldEqual, codes.Unset)
+			attrs := map[attribute.Key]attribute.Value{}
+			for _, kv := range spans[0].Attributes() {
+				attrs[kv.Key] = kv.Value
+			}
+			So(attrs[utils.AttributeEndpoint].AsString(), ShouldEqual, "/v1/secret")
+			So(attrs[utils.AttributeStatusCode].AsInt64(), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Should mark a failed operation", func() {
+			So(cl.DeleteSecret("app/missing"), ShouldNotBeNil)
+			spans := recorder.Ended()
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].Name(), ShouldEqual, "cerberus.secret.delete")
+			So(spans[0].Status().Code, ShouldEqual, codes.Error)
+		})
+
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A client without tracing", t, func() {
+		ts := secretServer()
+		defer ts.Close()
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should not start any spans", func() {
+			ctx := context.Background()
+			sctx, span := utils.StartSpan(ctx, cl.tracer, "cerberus.secret.read")
+			So(span, ShouldBeNil)
+			So(sctx == ctx, ShouldBeTrue)
+		})
+	})
+}
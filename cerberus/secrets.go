@@ -0,0 +1,197 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// ErrorSecretNotFound is returned by DeleteSecret when there is no secret at the path
+var ErrorSecretNotFound = fmt.Errorf("No secret was found at the given path")
+
+// The methods in this file are shortcuts on the Client for working with the data of secrets
+// directly, without going through the vault.Secret returned by the Secret subclient. If
+// Cerberus rejects the token with a 401, they get a new token and try once more even if
+// WithReauthOnUnauthorized wasn't used
+
+// GetSecret returns the data of the secret at the given path, or nil if there is nothing
+// there. Path should not be prefaced with a "/"
+func (c *Client) GetSecret(path string) (map[string]interface{}, error) {
+	return c.getSecret(context.Background(), path)
+}
+
+// getSecret is GetSecret with a context that can cancel the request
+func (c *Client) getSecret(ctx context.Context, path string) (data map[string]interface{}, err error) {
+	defer func() { c.audit(AuditReadSecret, path, err) }()
+	if data, ok := c.cache.get(path); ok {
+		return data, nil
+	}
+	sec, err := c.consistentRead(ctx, path, func() (*vault.Secret, error) {
+		return c.secretRequestWithContext(ctx, http.MethodGet, path, map[string]string{}, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading secret: %w", err)
+	}
+	if sec == nil {
+		return nil, nil
+	}
+	c.cache.set(path, sec.Data)
+	return sec.Data, nil
+}
+
+// InvalidateSecret removes the secret at the given path from the cache set up with WithCache,
+// so that the next GetSecret reads it from Cerberus. It does nothing if caching is off
+func (c *Client) InvalidateSecret(path string) {
+	c.cache.invalidate(path)
+}
+
+// PutSecret replaces the data of the secret at the given path. Path should not be prefaced
+// with a "/"
+func (c *Client) PutSecret(path string, data map[string]interface{}) (err error) {
+	defer func() { c.audit(AuditWriteSecret, path, err) }()
+	return c.writeSecret(http.MethodPut, path, data)
+}
+
+// WriteSecret replaces the whole secret at the given path with the given data using a POST,
+// creating it if it doesn't exist. Any keys in the secret that aren't in data are removed;
+// use PatchSecret to keep them. Path should not be prefaced with a "/"
+func (c *Client) WriteSecret(path string, data map[string]interface{}) (err error) {
+	defer func() { c.audit(AuditWriteSecret, path, err) }()
+	return c.writeSecret(http.MethodPost, path, data)
+}
+
+// PatchSecret sets the given keys in the secret at the given path, leaving any other keys in
+// it as they are. The secret is created if it doesn't exist. Cerberus has no way to patch a
+// secret, so the secret is read, merged with data, and written back. This means a change made
+// by someone else between the read and the write can be lost. Path should not be prefaced
+// with a "/"
+func (c *Client) PatchSecret(path string, data map[string]interface{}) (err error) {
+	defer func() { c.audit(AuditWriteSecret, path, err) }()
+	if err := c.checkWritable(http.MethodPost); err != nil {
+		return err
+	}
+	existing, err := c.secretRequest(http.MethodGet, path, map[string]string{}, nil)
+	if err != nil {
+		return fmt.Errorf("Error while reading secret to patch: %w", err)
+	}
+	merged := map[string]interface{}{}
+	if existing != nil {
+		// Numbers are read as json.Number, so they are written back exactly as they were
+		for k, v := range existing.Data {
+			merged[k] = v
+		}
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return c.writeSecret(http.MethodPost, path, merged)
+}
+
+// writeSecret writes data to the secret at the given path with the given method
+func (c *Client) writeSecret(method, path string, data map[string]interface{}) error {
+	if err := c.checkWritable(method); err != nil {
+		return err
+	}
+	if err := ValidateSecretWrite(path, data); err != nil {
+		return err
+	}
+	if _, err := c.secretRequest(method, path, map[string]string{}, data); err != nil {
+		return fmt.Errorf("Error while writing secret: %w", err)
+	}
+	c.cache.invalidate(path)
+	if c.writes != nil {
+		c.writes.wrote(path, data)
+	}
+	return nil
+}
+
+// ListSecrets returns the keys directly under the given path. Keys ending in a "/" are folders
+// that can be listed in turn. An empty slice is returned if there is nothing at the path. Path
+// should not be prefaced with a "/"
+func (c *Client) ListSecrets(path string) ([]string, error) {
+	return c.listSecrets(context.Background(), path)
+}
+
+// listSecrets is ListSecrets with a context that can cancel the request
+func (c *Client) listSecrets(ctx context.Context, path string) (keys []string, err error) {
+	defer func() { c.audit(AuditListSecrets, path, err) }()
+	sec, err := c.secretRequestWithContext(ctx, http.MethodGet, path, map[string]string{"list": "true"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error while listing secrets: %w", err)
+	}
+	keys = []string{}
+	if sec == nil {
+		return keys, nil
+	}
+	list, _ := sec.Data["keys"].([]interface{})
+	for _, v := range list {
+		if key, ok := v.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// DeleteSecret deletes the secret at the given path, returning ErrorSecretNotFound if there is
+// no secret there. Path should not be prefaced with a "/"
+func (c *Client) DeleteSecret(path string) (err error) {
+	defer func() { c.audit(AuditDeleteSecret, path, err) }()
+	if err := c.checkWritable(http.MethodDelete); err != nil {
+		return err
+	}
+	if _, err := c.secretRequest(http.MethodDelete, path, map[string]string{}, nil); err != nil {
+		if httpErr, ok := err.(*HTTPError); ok && httpErr.StatusCode == http.StatusNotFound {
+			return ErrorSecretNotFound
+		}
+		return fmt.Errorf("Error while deleting secret: %w", err)
+	}
+	c.cache.invalidate(path)
+	if c.writes != nil {
+		c.writes.wrote(path, nil)
+	}
+	return nil
+}
+
+// secretRequest makes a request to the secret backend, getting a new token and retrying
+// once if the first attempt gets a 401. When WithReauthOnUnauthorized is in use the retry
+// has already been done by doRequest, so it isn't done again
+func (c *Client) secretRequest(method, path string, params map[string]string, data interface{}) (*vault.Secret, error) {
+	return c.secretRequestWithContext(context.Background(), method, path, params, data)
+}
+
+// secretRequestWithContext is secretRequest with a context that can cancel the request
+func (c *Client) secretRequestWithContext(ctx context.Context, method, path string, params map[string]string, data interface{}) (*vault.Secret, error) {
+	s := c.Secret()
+	sec, err := s.do(ctx, method, path, params, data)
+	if c.reauth || !isUnauthorized(err) {
+		return sec, err
+	}
+	if err := c.reauthenticate(); err != nil {
+		return nil, err
+	}
+	return s.do(ctx, method, path, params, data)
+}
+
+// isUnauthorized returns whether the error is an HTTPError for a 401
+func isUnauthorized(err error) bool {
+	httpErr, ok := err.(*HTTPError)
+	return ok && httpErr.StatusCode == http.StatusUnauthorized
+}
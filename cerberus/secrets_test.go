@@ -0,0 +1,366 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/ecimionatto/cerberus-go-client/auth"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// unauthorizedOnceServer rejects the first request with a 401 and then behaves like
+// secretServer, counting every request it gets
+func unauthorizedOnceServer(requests *int32) *httptest.Server {
+	// Only the handler of secretServer is needed
+	next := secretServer()
+	handler := next.Config.Handler
+	next.Close()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func TestGetSecret(t *testing.T) {
+	Convey("Getting a secret", t, func() {
+		ts := secretServer()
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the data of the secret", func() {
+			data, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(data["username"], ShouldEqual, "bob")
+			So(data["password"], ShouldEqual, "hunter2")
+		})
+		Convey("Should return nil for a missing secret", func() {
+			data, err := cl.GetSecret("app/sdb/missing")
+			So(err, ShouldBeNil)
+			So(data, ShouldBeNil)
+		})
+	})
+
+	Convey("Getting a secret with an expired token", t, func() {
+		var requests int32
+		ts := unauthorizedOnceServer(&requests)
+		Reset(ts.Close)
+		m := GenerateMockAuth(ts.URL, "a-cool-token", false, false)
+		cl, _ := NewClient(m)
+		So(cl, ShouldNotBeNil)
+		Convey("Should refresh the token and try again", func() {
+			data, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(data["username"], ShouldEqual, "bob")
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			So(m.token, ShouldEqual, refreshedToken)
+		})
+	})
+
+	Convey("Getting a secret when the token can't be refreshed", t, func() {
+		var requests int32
+		ts := unauthorizedOnceServer(&requests)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, true))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the refresh error", func() {
+			data, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldNotBeNil)
+			So(data, ShouldBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+		})
+	})
+}
+
+// assumeRoleResponse is what STS returns when the role to decrypt tokens with is assumed
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>AKIDEXAMPLE</AccessKeyId>
+      <SecretAccessKey>a-secret-key</SecretAccessKey>
+      <SessionToken>a-session-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::111111111:assumed-role/fake-role/session</Arn>
+      <AssumedRoleId>AROAEXAMPLE:session</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata>
+    <RequestId>a-request-id</RequestId>
+  </ResponseMetadata>
+</AssumeRoleResponse>`
+
+// awsCerberusServer is a Cerberus that also stands in for STS and KMS, so that a real AWSAuth
+// can log in to it. Every login hands out a new token (token-1, token-2, and so on) with a
+// lease of the given number of seconds. Secrets can be read with any token from login number
+// oldest onwards, and every older token is rejected with a 401
+func awsCerberusServer(lease int, logins, oldest *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("X-Amz-Target") == "TrentService.Decrypt":
+			// The auth data isn't really encrypted, so the plaintext is the ciphertext
+			var in struct{ CiphertextBlob []byte }
+			json.NewDecoder(r.Body).Decode(&in)
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			json.NewEncoder(w).Encode(map[string]interface{}{"KeyId": "a-key", "Plaintext": in.CiphertextBlob})
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprint(w, assumeRoleResponse)
+		case r.URL.Path == "/v2/auth/iam-principal":
+			n := atomic.AddInt32(logins, 1)
+			plain := fmt.Sprintf(`{"client_token": "token-%d", "policies": ["web"], "lease_duration": %d}`, n, lease)
+			fmt.Fprintf(w, `{"auth_data": %q}`, base64.StdEncoding.EncodeToString([]byte(plain)))
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/"):
+			var n int32
+			fmt.Sscanf(r.Header.Get("X-Vault-Token"), "token-%d", &n)
+			if n < 1 || n < atomic.LoadInt32(oldest) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"data": {"key": "value"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetSecretAWSExpiry(t *testing.T) {
+	Convey("A client with an AWSAuth whose token runs out between reads", t, func() {
+		var logins, oldest int32
+		// A 2 second lease is treated as expired after 1 second
+		ts := awsCerberusServer(2, &logins, &oldest)
+		Reset(ts.Close)
+		sess, err := session.NewSession(&aws.Config{
+			Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "a-secret-key", ""),
+			Endpoint:    aws.String(ts.URL),
+			Region:      aws.String("us-west-2"),
+		})
+		So(err, ShouldBeNil)
+		a, err := auth.NewAWSAuthWithRole(ts.URL, "arn:aws:iam::111111111:role/fake-role", "us-west-2", sess)
+		So(err, ShouldBeNil)
+		cl, err := NewClient(a)
+		So(err, ShouldBeNil)
+		Convey("Should send the request and log in again when Cerberus rejects the token", func() {
+			data, err := cl.GetSecret("app/foo/bar")
+			So(err, ShouldBeNil)
+			So(data["key"], ShouldEqual, "value")
+			for a.IsAuthenticated() {
+				time.Sleep(10 * time.Millisecond)
+			}
+			// Cerberus stops taking the first token too
+			atomic.StoreInt32(&oldest, 2)
+			data, err = cl.GetSecret("app/foo/bar")
+			So(err, ShouldBeNil)
+			So(data["key"], ShouldEqual, "value")
+			So(atomic.LoadInt32(&logins), ShouldEqual, 2)
+			So(a.IsAuthenticated(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestListSecrets(t *testing.T) {
+	Convey("Listing secrets", t, func() {
+		ts := snapshotServer()
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return secrets and folders", func() {
+			keys, err := cl.ListSecrets("app/dev-demo/")
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"nested/", "config"})
+			Convey("And should list a folder", func() {
+				keys, err := cl.ListSecrets("app/dev-demo/" + keys[0])
+				So(err, ShouldBeNil)
+				So(keys, ShouldResemble, []string{"db"})
+			})
+		})
+		Convey("Should return an empty slice for an empty path", func() {
+			keys, err := cl.ListSecrets("shared/iam-w-d-wasd/")
+			So(err, ShouldBeNil)
+			So(keys, ShouldNotBeNil)
+			So(keys, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestPutSecret(t *testing.T) {
+	Convey("Putting a secret", t, func() {
+		var requests int32
+		var written map[string]interface{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method != http.MethodPut || r.URL.Path != "/v1/secret/app/sdb/config" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&written)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should write the data after refreshing an expired token", func() {
+			err := cl.PutSecret("app/sdb/config", map[string]interface{}{"username": "alice"})
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			So(written["username"], ShouldEqual, "alice")
+		})
+	})
+
+	Convey("Putting a secret with a read-only client", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://example.com", "a-cool-token", false, false), WithReadOnly())
+		So(cl, ShouldNotBeNil)
+		Convey("Should refuse", func() {
+			So(cl.PutSecret("app/sdb/config", map[string]interface{}{"username": "alice"}), ShouldNotBeNil)
+		})
+	})
+}
+
+// writableSecretServer returns a server that keeps secrets in the given map, which maps paths
+// to the raw JSON of their data. The method of every write is recorded in methods
+func writableSecretServer(secrets map[string]string, methods *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1/secret/")
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := secrets[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"data": ` + data + `}`))
+		default:
+			*methods = append(*methods, r.Method)
+			body, _ := ioutil.ReadAll(r.Body)
+			secrets[path] = string(body)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestWriteSecret(t *testing.T) {
+	Convey("Writing a secret", t, func() {
+		secrets := map[string]string{"app/sdb/config": `{"username": "bob", "password": "hunter2"}`}
+		methods := []string{}
+		ts := writableSecretServer(secrets, &methods)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should replace the whole secret with a POST", func() {
+			So(cl.WriteSecret("app/sdb/config", map[string]interface{}{"username": "alice"}), ShouldBeNil)
+			So(methods, ShouldResemble, []string{http.MethodPost})
+			data, err := cl.GetSecret("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, map[string]interface{}{"username": "alice"})
+		})
+	})
+
+	Convey("Writing a secret with a read-only client", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://example.com", "a-cool-token", false, false), WithReadOnly())
+		So(cl, ShouldNotBeNil)
+		Convey("Should refuse", func() {
+			So(cl.WriteSecret("app/sdb/config", map[string]interface{}{"username": "alice"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPatchSecret(t *testing.T) {
+	Convey("Patching a secret", t, func() {
+		secrets := map[string]string{"app/sdb/config": `{"username": "bob", "port": 5432, "ratio": 0.25, "debug": false, "hosts": ["a", "b"]}`}
+		methods := []string{}
+		ts := writableSecretServer(secrets, &methods)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should leave the other keys intact", func() {
+			So(cl.PatchSecret("app/sdb/config", map[string]interface{}{"username": "alice", "password": "hunter2"}), ShouldBeNil)
+			So(methods, ShouldResemble, []string{http.MethodPost})
+			var written map[string]interface{}
+			So(json.Unmarshal([]byte(secrets["app/sdb/config"]), &written), ShouldBeNil)
+			So(written, ShouldResemble, map[string]interface{}{
+				"username": "alice",
+				"password": "hunter2",
+				"port":     float64(5432),
+				"ratio":    0.25,
+				"debug":    false,
+				"hosts":    []interface{}{"a", "b"},
+			})
+		})
+		Convey("Should create a secret that doesn't exist", func() {
+			So(cl.PatchSecret("app/sdb/new", map[string]interface{}{"token": "abc"}), ShouldBeNil)
+			So(secrets["app/sdb/new"], ShouldContainSubstring, `"token":"abc"`)
+		})
+	})
+
+	Convey("Patching a secret with a read-only client", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://example.com", "a-cool-token", false, false), WithReadOnly())
+		So(cl, ShouldNotBeNil)
+		Convey("Should refuse", func() {
+			So(cl.PatchSecret("app/sdb/config", map[string]interface{}{"username": "alice"}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDeleteSecret(t *testing.T) {
+	Convey("Deleting a secret", t, WithServer(http.StatusNoContent, false, "/v1/secret/app/sdb/config", http.MethodDelete, "", map[string]string{}, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should succeed", func() {
+			So(cl.DeleteSecret("app/sdb/config"), ShouldBeNil)
+		})
+	}))
+
+	Convey("Deleting a missing secret", t, WithServer(http.StatusNotFound, false, "/v1/secret/app/sdb/config", http.MethodDelete, "", map[string]string{}, func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return ErrorSecretNotFound", func() {
+			So(cl.DeleteSecret("app/sdb/config"), ShouldEqual, ErrorSecretNotFound)
+		})
+	}))
+
+	Convey("Deleting a secret with a token that is rejected", t, WithServer(http.StatusUnauthorized, false, "/v1/secret/app/sdb/config", http.MethodDelete, "", map[string]string{}, func(ts *httptest.Server) {
+		m := GenerateMockAuth(ts.URL, "a-cool-token", false, false)
+		cl, _ := NewClient(m)
+		So(cl, ShouldNotBeNil)
+		Convey("Should refresh the token once and then error", func() {
+			err := cl.DeleteSecret("app/sdb/config")
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotEqual, ErrorSecretNotFound)
+			So(err.Error(), ShouldContainSubstring, "401")
+			So(m.token, ShouldEqual, refreshedToken)
+		})
+	}))
+}
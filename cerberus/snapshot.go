@@ -0,0 +1,137 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+)
+
+// SnapshotOpts controls what is included in a Snapshot
+type SnapshotOpts struct {
+	// IncludeValues adds the value of every secret to the snapshot. This is off by
+	// default because a snapshot with values in it is as sensitive as Cerberus itself
+	IncludeValues bool
+	// DesiredStateOnly leaves out the details that change without anyone changing what is
+	// in Cerberus: when the snapshot was taken and when and by whom each SDB was created
+	// and last updated. Together with Encode, this makes snapshots of an unchanged
+	// deployment byte for byte identical, so they can be kept in git and diffed
+	DesiredStateOnly bool
+}
+
+// Snapshot is a point in time export of every SDB in Cerberus along with the paths of
+// the secrets they contain. SDBs and secrets are sorted by path so that two snapshots
+// can be compared directly
+type Snapshot struct {
+	Created time.Time     `json:"created_ts"`
+	SDBs    []SDBSnapshot `json:"safe_deposit_boxes"`
+}
+
+// SDBSnapshot is the definition of a single SDB and its secrets in a Snapshot
+type SDBSnapshot struct {
+	Metadata api.SDBMetadata  `json:"metadata"`
+	Secrets  []SecretSnapshot `json:"secrets"`
+}
+
+// SecretSnapshot is a single secret in a Snapshot. Data is only set if values were
+// requested with SnapshotOpts.IncludeValues
+type SecretSnapshot struct {
+	Path string                 `json:"path"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Snapshot exports every SDB and the paths of all of the secrets in each of them. This uses
+// the metadata endpoint, so it requires an admin token. Secret values are only included if
+// opts.IncludeValues is set. The context can be used to cancel a snapshot of a large deployment
+func (c *Client) Snapshot(ctx context.Context, opts SnapshotOpts) (*Snapshot, error) {
+	metadata, err := c.Metadata().listAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error while listing SDBs for snapshot: %v", err)
+	}
+	snap := &Snapshot{
+		Created: time.Now().UTC(),
+		SDBs:    make([]SDBSnapshot, 0, len(metadata)),
+	}
+	if opts.DesiredStateOnly {
+		snap.Created = time.Time{}
+	}
+	for _, m := range metadata {
+		if opts.DesiredStateOnly {
+			m.Created = time.Time{}
+			m.CreatedBy = ""
+			m.LastUpdated = time.Time{}
+			m.LastUpdatedBy = ""
+		}
+		secrets, err := c.snapshotSecrets(ctx, m.Path, opts.IncludeValues)
+		if err != nil {
+			return nil, fmt.Errorf("Error while taking snapshot of SDB %s: %v", m.Name, err)
+		}
+		snap.SDBs = append(snap.SDBs, SDBSnapshot{Metadata: m, Secrets: secrets})
+	}
+	sort.Slice(snap.SDBs, func(i, j int) bool {
+		return snap.SDBs[i].Metadata.Path < snap.SDBs[j].Metadata.Path
+	})
+	return snap, nil
+}
+
+// Encode writes the snapshot to w as indented JSON. The output is deterministic: SDBs and
+// secrets are sorted by path and the keys of every object (including permissions and secret
+// values) are sorted, so encoding the same snapshot always gives the same bytes. Use
+// SnapshotOpts.DesiredStateOnly to also leave out timestamps so that two snapshots of an
+// unchanged deployment are identical
+func (s *Snapshot) Encode(w io.Writer) error {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error while encoding snapshot: %v", err)
+	}
+	// End with a newline so the file plays nicely with diff tools
+	if _, err := w.Write(append(out, '\n')); err != nil {
+		return fmt.Errorf("Error while writing snapshot: %v", err)
+	}
+	return nil
+}
+
+// snapshotSecrets returns every secret in the SDB at sdbPath, sorted by path. The data of each
+// secret is only read if includeValues is set
+func (c *Client) snapshotSecrets(ctx context.Context, sdbPath string, includeValues bool) ([]SecretSnapshot, error) {
+	paths, err := c.secretPaths(ctx, sdbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error while listing secrets: %v", err)
+	}
+	sort.Strings(paths)
+	secrets := make([]SecretSnapshot, 0, len(paths))
+	for _, p := range paths {
+		secret := SecretSnapshot{Path: p}
+		if includeValues {
+			sec, err := c.Secret().ReadWithContext(ctx, p)
+			if err != nil {
+				return nil, fmt.Errorf("Error while reading secret %s: %v", p, err)
+			}
+			if sec != nil {
+				secret.Data = sec.Data
+			}
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
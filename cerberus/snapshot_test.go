@@ -0,0 +1,151 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// snapshotServer serves metadataBody along with a small tree of secrets in the first SDB
+func snapshotServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		listing := r.URL.Query().Get("list") == "true"
+		switch {
+		case r.URL.Path == "/v1/metadata":
+			w.Write([]byte(metadataBody))
+		case listing && r.URL.Path == "/v1/secret/app/dev-demo/":
+			w.Write([]byte(`{"data": {"keys": ["nested/", "config"]}}`))
+		case listing && r.URL.Path == "/v1/secret/app/dev-demo/nested/":
+			w.Write([]byte(`{"data": {"keys": ["db"]}}`))
+		case !listing && r.URL.Path == "/v1/secret/app/dev-demo/config", !listing && r.URL.Path == "/v1/secret/app/dev-demo/nested/db":
+			w.Write([]byte(secretResponse))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestSnapshot(t *testing.T) {
+	Convey("A snapshot", t, func() {
+		ts := snapshotServer()
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Without values should list every SDB and secret path", func() {
+			snap, err := cl.Snapshot(context.Background(), SnapshotOpts{})
+			So(err, ShouldBeNil)
+			So(snap.SDBs, ShouldHaveLength, 2)
+			So(snap.SDBs[0].Metadata.Path, ShouldEqual, "app/dev-demo/")
+			So(snap.SDBs[0].Secrets, ShouldResemble, []SecretSnapshot{
+				{Path: "app/dev-demo/config"},
+				{Path: "app/dev-demo/nested/db"},
+			})
+			So(snap.SDBs[1].Metadata.Path, ShouldEqual, "shared/iam-w-d-wasd/")
+			So(snap.SDBs[1].Secrets, ShouldBeEmpty)
+		})
+		Convey("With values should include secret data", func() {
+			snap, err := cl.Snapshot(context.Background(), SnapshotOpts{IncludeValues: true})
+			So(err, ShouldBeNil)
+			So(snap.SDBs[0].Secrets[0].Data["username"], ShouldEqual, "bob")
+		})
+		Convey("With a cancelled context should error", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			snap, err := cl.Snapshot(ctx, SnapshotOpts{})
+			So(err, ShouldNotBeNil)
+			So(snap, ShouldBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestSnapshotEncode(t *testing.T) {
+	Convey("Encoding snapshots of the same state", t, func() {
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			listing := r.URL.Query().Get("list") == "true"
+			switch {
+			case r.URL.Path == "/v1/metadata":
+				// Only the audit details change between snapshots
+				calls++
+				body := metadataBody
+				if calls > 1 {
+					body = strings.Replace(body, "2017-01-04T23:18:40-08:00", "2017-03-09T10:00:00-08:00", -1)
+					body = strings.Replace(body, "justin.field@nike.com", "someone.else@nike.com", -1)
+				}
+				w.Write([]byte(body))
+			case listing && r.URL.Path == "/v1/secret/app/dev-demo/":
+				// The keys come back in a different order each time
+				if calls > 1 {
+					w.Write([]byte(`{"data": {"keys": ["config", "nested/"]}}`))
+				} else {
+					w.Write([]byte(`{"data": {"keys": ["nested/", "config"]}}`))
+				}
+			case listing && r.URL.Path == "/v1/secret/app/dev-demo/nested/":
+				w.Write([]byte(`{"data": {"keys": ["db"]}}`))
+			case !listing && strings.HasPrefix(r.URL.Path, "/v1/secret/app/dev-demo/"):
+				w.Write([]byte(secretResponse))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		encode := func(opts SnapshotOpts) string {
+			snap, err := cl.Snapshot(context.Background(), opts)
+			So(err, ShouldBeNil)
+			var buf bytes.Buffer
+			So(snap.Encode(&buf), ShouldBeNil)
+			return buf.String()
+		}
+		Convey("Should be identical with only the desired state", func() {
+			opts := SnapshotOpts{IncludeValues: true, DesiredStateOnly: true}
+			first := encode(opts)
+			second := encode(opts)
+			So(second, ShouldEqual, first)
+			So(first, ShouldNotContainSubstring, "justin.field@nike.com")
+			So(first, ShouldContainSubstring, "hunter2")
+			So(strings.HasSuffix(first, "}\n"), ShouldBeTrue)
+		})
+		Convey("Should differ when the audit details are kept", func() {
+			first := encode(SnapshotOpts{})
+			second := encode(SnapshotOpts{})
+			So(second, ShouldNotEqual, first)
+		})
+		Convey("Should be the same every time the same snapshot is encoded", func() {
+			snap, err := cl.Snapshot(context.Background(), SnapshotOpts{IncludeValues: true})
+			So(err, ShouldBeNil)
+			var a, b bytes.Buffer
+			So(snap.Encode(&a), ShouldBeNil)
+			So(snap.Encode(&b), ShouldBeNil)
+			So(a.String(), ShouldEqual, b.String())
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultTemplateFunc is the name of the template function ResolveTemplate uses for secret references
+const DefaultTemplateFunc = "cerberus"
+
+// TemplateOpts configures how ResolveTemplate finds secret references. Empty fields
+// use the defaults of "{{", "}}", and DefaultTemplateFunc
+type TemplateOpts struct {
+	// LeftDelim and RightDelim are the template action delimiters
+	LeftDelim  string
+	RightDelim string
+	// FuncName is the name of the function that references a secret
+	FuncName string
+}
+
+// ResolveTemplate renders a text/template that references secret values with calls like
+// {{cerberus "app/my-sdb/db" "password"}}, where the first argument is the path of the secret
+// and the second is the key in it. Every path referenced by the template is read once, with
+// the reads done together using ReadMany, before the values are substituted in. Values that
+// aren't strings are JSON encoded the same way as Flatten. If any reference can't be resolved
+// because the secret or key doesn't exist, an error listing all of them is returned
+func (s *Secret) ResolveTemplate(tmpl string, opts TemplateOpts) (string, error) {
+	funcName := opts.FuncName
+	if funcName == "" {
+		funcName = DefaultTemplateFunc
+	}
+	// The first pass only collects the paths that are referenced
+	var paths []string
+	seen := map[string]bool{}
+	collect := func(path, key string) string {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+		return ""
+	}
+	if _, err := renderTemplate(tmpl, opts, funcName, collect); err != nil {
+		return "", err
+	}
+
+	data := map[string]map[string]interface{}{}
+	for _, result := range s.ReadMany(context.Background(), paths) {
+		if result.Err != nil {
+			return "", fmt.Errorf("Error while reading secret %s: %v", result.Path, result.Err)
+		}
+		if result.Secret != nil {
+			data[result.Path] = result.Secret.Data
+		}
+	}
+
+	// The second pass substitutes the values, keeping track of any that are missing
+	var unresolved []string
+	var encodeErr error
+	lookup := func(path, key string) string {
+		v, ok := data[path][key]
+		if !ok {
+			unresolved = append(unresolved, fmt.Sprintf("%s %s", path, key))
+			return ""
+		}
+		str, err := valueString(v)
+		if err != nil && encodeErr == nil {
+			encodeErr = fmt.Errorf("Error while encoding value of %s in %s: %v", key, path, err)
+		}
+		return str
+	}
+	out, err := renderTemplate(tmpl, opts, funcName, lookup)
+	if err != nil {
+		return "", err
+	}
+	if encodeErr != nil {
+		return "", encodeErr
+	}
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return "", fmt.Errorf("Unable to resolve secret references: %s", strings.Join(unresolved, ", "))
+	}
+	return out, nil
+}
+
+// renderTemplate parses and executes the template with fn as the secret reference function
+func renderTemplate(tmpl string, opts TemplateOpts, funcName string, fn func(path, key string) string) (string, error) {
+	t, err := template.New("cerberus").
+		Delims(opts.LeftDelim, opts.RightDelim).
+		Funcs(template.FuncMap{funcName: fn}).
+		Option("missingkey=error").
+		Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("Error while parsing template: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, nil); err != nil {
+		return "", fmt.Errorf("Error while rendering template: %v", err)
+	}
+	return buf.String(), nil
+}
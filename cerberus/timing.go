@@ -0,0 +1,150 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// RequestTiming is how long each phase of a request to Cerberus took. Phases that
+// didn't happen (such as DNS and connecting when a pooled connection was reused)
+// are zero
+type RequestTiming struct {
+	Method string
+	Path   string
+	// DNS is how long the host name lookup took
+	DNS time.Duration
+	// Connect is how long it took to open the TCP connection
+	Connect time.Duration
+	// TLSHandshake is how long the TLS handshake took
+	TLSHandshake time.Duration
+	// TimeToFirstByte is the time from starting the request to getting the first byte of the response
+	TimeToFirstByte time.Duration
+	// Total is the time from starting the request to having the response headers
+	Total time.Duration
+	// ReusedConnection is whether the request was sent on a connection from the pool
+	ReusedConnection bool
+}
+
+// requestTimer keeps the timing of the most recent request sent through any of the transports
+// it wrapped
+type requestTimer struct {
+	lock sync.Mutex
+	last *RequestTiming
+}
+
+// wrap returns a transport that sends requests with next and records their timing
+func (r *requestTimer) wrap(next http.RoundTripper) http.RoundTripper {
+	return &timingTransport{next: next, timer: r}
+}
+
+// record saves the timing of a request that just finished
+func (r *requestTimer) record(timing RequestTiming) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.last = &timing
+}
+
+// lastTiming returns the timing of the most recent request, if there has been one
+func (r *requestTimer) lastTiming() (RequestTiming, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.last == nil {
+		return RequestTiming{}, false
+	}
+	return *r.last, true
+}
+
+// timingTransport wraps a transport to record the timing of every request sent through it
+type timingTransport struct {
+	next  http.RoundTripper
+	timer *requestTimer
+}
+
+// RoundTrip sends the request with an httptrace.ClientTrace attached and records its timing
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The trace callbacks can be called from the goroutine dialing the connection,
+	// so everything they touch is guarded by a lock
+	var lock sync.Mutex
+	timing := RequestTiming{Method: req.Method, Path: req.URL.Path}
+	var dnsStart, connectStart, tlsStart time.Time
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			timing.DNS = time.Since(dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			lock.Lock()
+			defer lock.Unlock()
+			connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			timing.Connect = time.Since(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			lock.Lock()
+			defer lock.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			timing.TLSHandshake = time.Since(tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			timing.ReusedConnection = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			lock.Lock()
+			defer lock.Unlock()
+			timing.TimeToFirstByte = time.Since(start)
+		},
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	lock.Lock()
+	timing.Total = time.Since(start)
+	recorded := timing
+	lock.Unlock()
+	t.timer.record(recorded)
+	return resp, err
+}
+
+// LastRequestTiming returns how long each phase of the most recent request to Cerberus took,
+// whether it was made by the client or by the auth method, which helps tell whether slowness is
+// coming from DNS, the TLS handshake, or the server itself. It returns false if request timing wasn't enabled with WithRequestTiming or no request has
+// been made yet. When requests are made concurrently, this is whichever finished last
+func (c *Client) LastRequestTiming() (RequestTiming, bool) {
+	if c.timing == nil {
+		return RequestTiming{}, false
+	}
+	return c.timing.lastTiming()
+}
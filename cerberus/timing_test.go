@@ -0,0 +1,86 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ecimionatto/cerberus-go-client/auth"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestTiming(t *testing.T) {
+	Convey("A client with request timing enabled", t, WithTestServer(http.StatusOK, "/v1/blah", http.MethodGet, "{}", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRequestTiming())
+		So(cl, ShouldNotBeNil)
+		Convey("Should have no timing before the first request", func() {
+			_, ok := cl.LastRequestTiming()
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Should record the timing of a request", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			discardBody(resp)
+			timing, ok := cl.LastRequestTiming()
+			So(ok, ShouldBeTrue)
+			So(timing.Method, ShouldEqual, http.MethodGet)
+			So(timing.Path, ShouldEqual, "/v1/blah")
+			So(timing.ReusedConnection, ShouldBeFalse)
+			So(timing.Connect, ShouldBeGreaterThan, 0)
+			So(timing.TimeToFirstByte, ShouldBeGreaterThan, 0)
+			So(timing.Total, ShouldBeGreaterThanOrEqualTo, timing.TimeToFirstByte)
+			Convey("And should show a reused connection for the next one", func() {
+				resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+				So(err, ShouldBeNil)
+				discardBody(resp)
+				timing, ok := cl.LastRequestTiming()
+				So(ok, ShouldBeTrue)
+				So(timing.ReusedConnection, ShouldBeTrue)
+				So(timing.Connect, ShouldEqual, 0)
+			})
+		})
+	}))
+
+	Convey("A client with request timing and an auth method that logs in", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status": "success", "data": {"client_token": {"client_token": "a-cool-token", "lease_duration": 3600}}}`))
+		}))
+		Reset(ts.Close)
+		a, err := auth.NewUserAuth(ts.URL, "user", "password")
+		So(err, ShouldBeNil)
+		cl, err := NewClient(a, WithRequestTiming())
+		So(err, ShouldBeNil)
+		Convey("Should record the timing of the login", func() {
+			timing, ok := cl.LastRequestTiming()
+			So(ok, ShouldBeTrue)
+			So(timing.Method, ShouldEqual, http.MethodGet)
+			So(timing.Path, ShouldEqual, "/v2/auth/user")
+			So(timing.TimeToFirstByte, ShouldBeGreaterThan, 0)
+		})
+	})
+
+	Convey("A client without request timing", t, func() {
+		cl, _ := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should never have timing", func() {
+			_, ok := cl.LastRequestTiming()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
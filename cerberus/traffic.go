@@ -0,0 +1,54 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// byteCounter keeps running totals of the bytes sent and received by a client.
+// It is always allocated on its own so the counters are aligned for atomic access
+type byteCounter struct {
+	sent     int64
+	received int64
+}
+
+// countingReadCloser adds the number of bytes read through it to a counter
+type countingReadCloser struct {
+	io.ReadCloser
+	count *int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
+
+// BytesSent returns the total number of request body bytes the client has sent to Cerberus,
+// including retried requests. Headers aren't counted
+func (c *Client) BytesSent() int64 {
+	return atomic.LoadInt64(&c.traffic.sent)
+}
+
+// BytesReceived returns the total number of response body bytes the client has read from
+// Cerberus, including the bodies of responses to requests that were retried. Bytes are counted
+// as they are read, so a response body that is never read isn't counted. Headers aren't counted
+func (c *Client) BytesReceived() int64 {
+	return atomic.LoadInt64(&c.traffic.received)
+}
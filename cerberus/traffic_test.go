@@ -0,0 +1,57 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBytesTransferred(t *testing.T) {
+	var responseBody = `{"data": {"key": "value"}}`
+	Convey("A new client", t, func() {
+		var received int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			received += len(b)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(responseBody))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should start at zero", func() {
+			So(cl.BytesSent(), ShouldEqual, 0)
+			So(cl.BytesReceived(), ShouldEqual, 0)
+		})
+		Convey("Should count the bodies of requests and responses", func() {
+			_, err := cl.Secret().Write("app/sdb/config", map[string]interface{}{"key": "value"})
+			So(err, ShouldBeNil)
+			_, err = cl.Secret().Read("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(cl.BytesSent(), ShouldEqual, received)
+			So(cl.BytesSent(), ShouldBeGreaterThan, 0)
+			So(cl.BytesReceived(), ShouldEqual, 2*len(responseBody))
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultMinTLSVersion is the lowest TLS version the client will negotiate unless
+// told otherwise with WithMinTLSVersion
+const defaultMinTLSVersion uint16 = tls.VersionTLS12
+
+// transportConfig holds all of the settings that go into building the HTTP transport
+// for a Client. Every TLS related option writes to this so there is exactly one place
+// where the tls.Config gets assembled
+type transportConfig struct {
+	minTLSVersion uint16
+	// pins holds the base64 SHA-256 hashes of the allowed server public keys
+	pins map[string]bool
+	// disableHTTP2 forces HTTP/1.1 even if the server supports HTTP/2
+	disableHTTP2 bool
+	// rootCAs are the CAs trusted to sign the server's certificate. Nil uses the system pool
+	rootCAs *x509.CertPool
+	// insecureSkipVerify turns off verification of the server's certificate
+	insecureSkipVerify bool
+	// clientCerts are presented to servers that ask for a client certificate (mutual TLS)
+	clientCerts []tls.Certificate
+	// proxy picks the proxy for each request. Nil leaves the Go default, which uses the
+	// proxy environment variables
+	proxy func(*http.Request) (*url.URL, error)
+	// maxIdleConns, maxIdleConnsPerHost, and idleConnTimeout size the pool of idle
+	// connections kept open for reuse. They start out as the Go defaults
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+func newTransportConfig() *transportConfig {
+	def := http.DefaultTransport.(*http.Transport)
+	return &transportConfig{
+		minTLSVersion:       defaultMinTLSVersion,
+		maxIdleConns:        def.MaxIdleConns,
+		maxIdleConnsPerHost: def.MaxIdleConnsPerHost,
+		idleConnTimeout:     def.IdleConnTimeout,
+	}
+}
+
+// tlsConfig builds the TLS configuration from the current settings
+func (t *transportConfig) tlsConfig() *tls.Config {
+	conf := &tls.Config{
+		MinVersion: t.minTLSVersion,
+	}
+	if len(t.pins) > 0 {
+		conf.VerifyPeerCertificate = t.verifyPins
+	}
+	if t.rootCAs != nil {
+		conf.RootCAs = t.rootCAs
+	}
+	if t.insecureSkipVerify {
+		conf.InsecureSkipVerify = true
+	}
+	if len(t.clientCerts) > 0 {
+		conf.Certificates = t.clientCerts
+	}
+	if t.disableHTTP2 {
+		// Only offer HTTP/1.1 during ALPN so the server never picks h2
+		conf.NextProtos = []string{"http/1.1"}
+	}
+	return conf
+}
+
+// verifyPins checks that the public key of the server's leaf certificate matches one of the
+// configured pins. It runs after the normal certificate verification, so a pinned connection
+// still has to have a certificate that is trusted
+func (t *transportConfig) verifyPins(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("Server did not present a certificate to check against the certificate pins")
+	}
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("Error while parsing server certificate: %v", err)
+	}
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	if !t.pins[pin] {
+		return fmt.Errorf("Server certificate for %s has public key hash %s, which does not match any of the certificate pins", leaf.Subject.CommonName, pin)
+	}
+	return nil
+}
+
+// build returns a new transport based on the default Go transport (so things like
+// proxy environment variables are still honored) with the TLS configuration applied
+func (t *transportConfig) build() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = t.tlsConfig()
+	tr.MaxIdleConns = t.maxIdleConns
+	tr.MaxIdleConnsPerHost = t.maxIdleConnsPerHost
+	tr.IdleConnTimeout = t.idleConnTimeout
+	if t.proxy != nil {
+		tr.Proxy = t.proxy
+	}
+	if t.disableHTTP2 {
+		tr.ForceAttemptHTTP2 = false
+		// A non-nil, empty map is how the transport is told not to upgrade to HTTP/2
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return tr
+}
@@ -0,0 +1,663 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/auth"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMinTLSVersion(t *testing.T) {
+	Convey("A client with no TLS options", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false))
+		So(err, ShouldBeNil)
+		So(cl, ShouldNotBeNil)
+		Convey("Should default to TLS 1.2", func() {
+			tr := cl.httpClient.Transport.(*http.Transport)
+			So(tr.TLSClientConfig.MinVersion, ShouldEqual, tls.VersionTLS12)
+		})
+	})
+
+	Convey("A client with a minimum TLS version set", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithMinTLSVersion(tls.VersionTLS13))
+		So(err, ShouldBeNil)
+		So(cl, ShouldNotBeNil)
+		Convey("Should use the given version", func() {
+			tr := cl.httpClient.Transport.(*http.Transport)
+			So(tr.TLSClientConfig.MinVersion, ShouldEqual, tls.VersionTLS13)
+		})
+	})
+
+	Convey("A client with an invalid TLS version", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithMinTLSVersion(0x0200))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+
+	Convey("A server that only supports TLS 1.1", t, func() {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		ts.TLS = &tls.Config{
+			MinVersion: tls.VersionTLS10,
+			MaxVersion: tls.VersionTLS11,
+		}
+		ts.StartTLS()
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(err, ShouldBeNil)
+		So(cl, ShouldNotBeNil)
+		Convey("Should refuse to connect", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "protocol version")
+			So(resp, ShouldBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestCertificatePins(t *testing.T) {
+	Convey("A TLS server", t, func() {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+		pin := base64.StdEncoding.EncodeToString(sum[:])
+		otherPin := base64.StdEncoding.EncodeToString(make([]byte, 32))
+		rawCerts := [][]byte{ts.Certificate().Raw}
+		Convey("Should be accepted when its key is pinned", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithCertificatePins([]string{otherPin, pin}))
+			So(err, ShouldBeNil)
+			verify := cl.httpClient.Transport.(*http.Transport).TLSClientConfig.VerifyPeerCertificate
+			So(verify, ShouldNotBeNil)
+			So(verify(rawCerts, nil), ShouldBeNil)
+		})
+		Convey("Should be rejected when its key is not pinned", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithCertificatePins([]string{otherPin}))
+			So(err, ShouldBeNil)
+			err = cl.httpClient.Transport.(*http.Transport).TLSClientConfig.VerifyPeerCertificate(rawCerts, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, pin)
+			So(err.Error(), ShouldContainSubstring, "does not match")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A client without pins", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false))
+		So(err, ShouldBeNil)
+		Convey("Should not check pins", func() {
+			So(cl.httpClient.Transport.(*http.Transport).TLSClientConfig.VerifyPeerCertificate, ShouldBeNil)
+		})
+	})
+
+	Convey("An invalid pin", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithCertificatePins([]string{"not-a-pin"}))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
+
+func TestHTTP2(t *testing.T) {
+	// newClient returns a client for the server that trusts the server's certificate
+	newClient := func(ts *httptest.Server, opts ...Option) *Client {
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), opts...)
+		So(err, ShouldBeNil)
+		pool := x509.NewCertPool()
+		pool.AddCert(ts.Certificate())
+		cl.httpClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+		return cl
+	}
+	Convey("A server that supports HTTP/2", t, func() {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		Convey("Should use HTTP/2 by default", func() {
+			resp, err := newClient(ts).DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.Proto, ShouldEqual, "HTTP/2.0")
+		})
+		Convey("Should use HTTP/1.1 with HTTP/2 disabled", func() {
+			resp, err := newClient(ts, WithHTTP2(false)).DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.Proto, ShouldEqual, "HTTP/1.1")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
+
+func TestConnectionWarmup(t *testing.T) {
+	Convey("A client created with connection warmup", t, func() {
+		var lock sync.Mutex
+		var healthchecks, conns int
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthcheck" {
+				lock.Lock()
+				healthchecks++
+				lock.Unlock()
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				lock.Lock()
+				conns++
+				lock.Unlock()
+			}
+		}
+		ts.Start()
+		cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithConnectionWarmup())
+		So(err, ShouldBeNil)
+		Convey("Should have called the healthcheck", func() {
+			lock.Lock()
+			defer lock.Unlock()
+			So(healthchecks, ShouldEqual, 1)
+		})
+		Convey("Should reuse the warm connection for the first request", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			lock.Lock()
+			defer lock.Unlock()
+			So(conns, ShouldEqual, 1)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A client created with connection warmup for a server that is down", t, func() {
+		cl, err := NewClient(GenerateMockAuth("http://127.0.0.1:32876", "a-cool-token", false, false), WithConnectionWarmup())
+		Convey("Should still be created", func() {
+			So(err, ShouldBeNil)
+			So(cl, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConnectionPool(t *testing.T) {
+	Convey("A client with no connection pool options", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false))
+		So(err, ShouldBeNil)
+		Convey("Should use the Go defaults", func() {
+			def := http.DefaultTransport.(*http.Transport)
+			tr := cl.httpClient.Transport.(*http.Transport)
+			So(tr.MaxIdleConns, ShouldEqual, def.MaxIdleConns)
+			So(tr.MaxIdleConnsPerHost, ShouldEqual, def.MaxIdleConnsPerHost)
+			So(tr.IdleConnTimeout, ShouldEqual, def.IdleConnTimeout)
+		})
+	})
+
+	Convey("A client with connection pool options", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false),
+			WithMaxIdleConns(0), WithMaxIdleConnsPerHost(50), WithIdleConnTimeout(time.Minute))
+		So(err, ShouldBeNil)
+		Convey("Should apply them to the transport", func() {
+			tr := cl.httpClient.Transport.(*http.Transport)
+			So(tr.MaxIdleConns, ShouldEqual, 0)
+			So(tr.MaxIdleConnsPerHost, ShouldEqual, 50)
+			So(tr.IdleConnTimeout, ShouldEqual, time.Minute)
+		})
+	})
+
+	Convey("A client with invalid connection pool options", t, func() {
+		Convey("Should error for negative max idle connections", func() {
+			cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithMaxIdleConns(-1))
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+		Convey("Should error for less than 1 max idle connection per host", func() {
+			cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithMaxIdleConnsPerHost(0))
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+		Convey("Should error for a negative idle connection timeout", func() {
+			cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithIdleConnTimeout(-time.Second))
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
+
+// benchmarkConcurrentRequests sends b.N requests from many goroutines and reports how many
+// connections the server had to accept for every 1000 requests
+func benchmarkConcurrentRequests(b *testing.B, opts ...Option) {
+	var conns int64
+	var lock sync.Mutex
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			conns++
+			lock.Unlock()
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			discardBody(resp)
+		}
+	})
+	b.StopTimer()
+	lock.Lock()
+	defer lock.Unlock()
+	b.ReportMetric(float64(conns)*1000/float64(b.N), "conns/1000req")
+}
+
+func BenchmarkConnectionReuseDefault(b *testing.B) {
+	benchmarkConcurrentRequests(b)
+}
+
+func BenchmarkConnectionReuseTuned(b *testing.B) {
+	benchmarkConcurrentRequests(b, WithMaxIdleConnsPerHost(128))
+}
+
+// proxyMockAuth is a MockAuth that takes a proxy like the auth types do
+type proxyMockAuth struct {
+	*MockAuth
+	proxyURL string
+}
+
+func (p *proxyMockAuth) SetProxy(proxyURL string) error {
+	p.proxyURL = proxyURL
+	return nil
+}
+
+func TestProxy(t *testing.T) {
+	Convey("A client with a proxy", t, func() {
+		var lock sync.Mutex
+		var hosts, proxyAuth []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			hosts = append(hosts, r.URL.Host)
+			proxyAuth = append(proxyAuth, r.Header.Get("Proxy-Authorization"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		proxyURL := "http://user:pass@" + proxy.Listener.Addr().String()
+		m := &proxyMockAuth{MockAuth: GenerateMockAuth("http://cerberus.example.com", "a-cool-token", false, false)}
+		cl, err := NewClient(m, WithProxy(proxyURL))
+		So(err, ShouldBeNil)
+		Convey("Should pass the proxy to the auth method", func() {
+			So(m.proxyURL, ShouldEqual, proxyURL)
+		})
+		Convey("Should send requests through the proxy with its credentials", func() {
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+			lock.Lock()
+			defer lock.Unlock()
+			So(hosts, ShouldResemble, []string{"cerberus.example.com"})
+			So(proxyAuth, ShouldResemble, []string{"Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))})
+		})
+		Reset(func() {
+			proxy.Close()
+		})
+	})
+
+	Convey("A client with an invalid proxy", t, func() {
+		for _, proxyURL := range []string{"ftp://proxy.example.com", "http://", "://bad"} {
+			cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithProxy(proxyURL))
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		}
+	})
+}
+
+// newClientCertificate returns a new self-signed client certificate and its PEM encoded
+// certificate and key
+func newClientCertificate() (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cerberus-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	So(err, ShouldBeNil)
+	return cert, certPEM, keyPEM
+}
+
+// tlsMockAuth is a MockAuth that takes a TLS configuration like the auth types do
+type tlsMockAuth struct {
+	*MockAuth
+	tlsConfig *tls.Config
+}
+
+func (m *tlsMockAuth) SetTLSConfig(conf *tls.Config) {
+	m.tlsConfig = conf
+}
+
+func TestClientCertificate(t *testing.T) {
+	Convey("A server that requires a client certificate", t, func() {
+		cert, certPEM, keyPEM := newClientCertificate()
+		clientCAs := x509.NewCertPool()
+		So(clientCAs.AppendCertsFromPEM(certPEM), ShouldBeTrue)
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		ts.StartTLS()
+		rootCAs := x509.NewCertPool()
+		rootCAs.AddCert(ts.Certificate())
+		Convey("Should complete the handshake with the certificate", func() {
+			m := &tlsMockAuth{MockAuth: GenerateMockAuth(ts.URL, "a-cool-token", false, false)}
+			cl, err := NewClient(m, WithClientCertificate(cert), WithRootCAs(rootCAs))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+			Convey("And should give the certificate to the auth method", func() {
+				So(m.tlsConfig, ShouldNotBeNil)
+				So(m.tlsConfig.Certificates, ShouldHaveLength, 1)
+			})
+		})
+		Convey("Should complete the handshake with a certificate loaded from files", func() {
+			dir, err := ioutil.TempDir("", "cerberus-mtls")
+			So(err, ShouldBeNil)
+			certFile := filepath.Join(dir, "client.crt")
+			keyFile := filepath.Join(dir, "client.key")
+			So(ioutil.WriteFile(certFile, certPEM, 0600), ShouldBeNil)
+			So(ioutil.WriteFile(keyFile, keyPEM, 0600), ShouldBeNil)
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithClientCertificateFile(certFile, keyFile), WithRootCAs(rootCAs))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+			Reset(func() {
+				os.RemoveAll(dir)
+			})
+		})
+		Convey("Should fail the handshake without a certificate", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRootCAs(rootCAs))
+			So(err, ShouldBeNil)
+			_, err = cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldNotBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A chain of auth methods behind a server that requires a client certificate", t, func() {
+		cert, certPEM, _ := newClientCertificate()
+		clientCAs := x509.NewCertPool()
+		So(clientCAs.AppendCertsFromPEM(certPEM), ShouldBeTrue)
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2/auth/user" {
+				w.Write([]byte(`{"status": "success", "data": {"client_token": {"client_token": "a-cool-token", "lease_duration": 3600}}}`))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		ts.StartTLS()
+		Reset(ts.Close)
+		rootCAs := x509.NewCertPool()
+		rootCAs.AddCert(ts.Certificate())
+		user, err := auth.NewUserAuth(ts.URL, "user", "password")
+		So(err, ShouldBeNil)
+		Convey("Should log in with the certificate", func() {
+			cl, err := NewClient(auth.Chain(user), WithClientCertificate(cert), WithRootCAs(rootCAs))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+		})
+	})
+
+	Convey("Client certificate files that don't exist", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithClientCertificateFile("/nonexistent/client.crt", "/nonexistent/client.key"))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
+
+// newTestServerWithCA returns a started TLS server with a certificate signed by a new CA, along
+// with the PEM encoded CA certificate
+func newTestServerWithCA() (*httptest.Server, []byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Private CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	So(err, ShouldBeNil)
+	ca, err := x509.ParseCertificate(caDER)
+	So(err, ShouldBeNil)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "cerberus.internal"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	So(err, ShouldBeNil)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	ts.StartTLS()
+	return ts, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+func TestRootCAs(t *testing.T) {
+	Convey("A server with a certificate from a private CA", t, func() {
+		ts, caPEM := newTestServerWithCA()
+		pool := x509.NewCertPool()
+		So(pool.AppendCertsFromPEM(caPEM), ShouldBeTrue)
+		Convey("Should be trusted when the CA is", func() {
+			m := &tlsMockAuth{MockAuth: GenerateMockAuth(ts.URL, "a-cool-token", false, false)}
+			cl, err := NewClient(m, WithRootCAs(pool))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+			Convey("And should still verify certificates", func() {
+				So(cl.httpClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify, ShouldBeFalse)
+			})
+			Convey("And should give the CAs to the auth method", func() {
+				So(m.tlsConfig.RootCAs == pool, ShouldBeTrue)
+			})
+		})
+		Convey("Should be trusted when the CA is loaded from a file", func() {
+			dir, err := ioutil.TempDir("", "cerberus-ca")
+			So(err, ShouldBeNil)
+			caFile := filepath.Join(dir, "ca.pem")
+			So(ioutil.WriteFile(caFile, caPEM, 0600), ShouldBeNil)
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithRootCAFile(caFile))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+			Reset(func() {
+				os.RemoveAll(dir)
+			})
+		})
+		Convey("Should fail verification when the CA isn't trusted", func() {
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+			So(err, ShouldBeNil)
+			_, err = cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "certificate")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+
+	Convey("A root CA file without certificates", t, func() {
+		dir, err := ioutil.TempDir("", "cerberus-ca")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		caFile := filepath.Join(dir, "ca.pem")
+		So(ioutil.WriteFile(caFile, []byte("not a certificate"), 0600), ShouldBeNil)
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithRootCAFile(caFile))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
+
+// recordingLogger keeps every message it is given, with warnings marked as such
+type recordingLogger struct {
+	lock     sync.Mutex
+	messages []string
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Warnf(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.messages = append(r.messages, "WARN "+fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) String() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return strings.Join(r.messages, "\n")
+}
+
+func TestInsecureSkipTLSVerify(t *testing.T) {
+	Convey("A server with a self-signed certificate", t, func() {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		Convey("Should work with verification turned off", func() {
+			l := &recordingLogger{}
+			m := &tlsMockAuth{MockAuth: GenerateMockAuth(ts.URL, "a-cool-token", false, false)}
+			cl, err := NewClient(m, WithInsecureSkipTLSVerify(), WithLogger(l))
+			So(err, ShouldBeNil)
+			resp, err := cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			discardBody(resp)
+			Convey("And should warn about it", func() {
+				So(l.String(), ShouldStartWith, "WARN TLS certificate verification is disabled")
+			})
+			Convey("And should turn it off for the auth method too", func() {
+				So(m.tlsConfig.InsecureSkipVerify, ShouldBeTrue)
+			})
+		})
+		Convey("Should warn through the standard library logger without a logger", func() {
+			buf := &bytes.Buffer{}
+			log.SetOutput(buf)
+			defer log.SetOutput(os.Stderr)
+			_, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithInsecureSkipTLSVerify())
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "[WARN] TLS certificate verification is disabled")
+		})
+		Convey("Should fail verification without it", func() {
+			l := &recordingLogger{}
+			cl, err := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithLogger(l))
+			So(err, ShouldBeNil)
+			_, err = cl.DoRequest(http.MethodGet, "/v1/blah", map[string]string{}, nil)
+			So(err, ShouldNotBeNil)
+			So(l.String(), ShouldNotContainSubstring, "WARN")
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+)
+
+// ErrorSafeDepositBoxCategoryBlank is returned when a new Safe Deposit Box has no category ID
+var ErrorSafeDepositBoxCategoryBlank = fmt.Errorf("Safe Deposit Box category ID may not be blank")
+
+// ErrorSecretPathBlank is returned when a secret is written to an empty path
+var ErrorSecretPathBlank = fmt.Errorf("Secret path may not be blank")
+
+// ValidateSDB checks a new Safe Deposit Box the way Create would, without sending anything. On
+// top of the name check that Create does, it checks that the owner and category ID are set,
+// that every permission names a group (or an IAM principal ARN) and a role ID, and that no group
+// or principal is given more than one permission. Every problem found is returned, joined with errors.Join, so sentinel errors like
+// ErrorSafeDepositBoxNameBlank can be checked for with errors.Is. Whether the category and
+// role IDs exist isn't checked; use SDB.Validate for that
+func ValidateSDB(sdb *api.SafeDepositBox) error {
+	return validateSDB(sdb, false)
+}
+
+// ValidateSDBUpdate is ValidateSDB for the changes passed to Update, where fields that are left
+// empty are not changed. Only the fields that are set are checked
+func ValidateSDBUpdate(sdb *api.SafeDepositBox) error {
+	return validateSDB(sdb, true)
+}
+
+// validateSDB returns every structural problem with the SDB. For an update, the name, owner,
+// and category are only checked if they are set
+func validateSDB(sdb *api.SafeDepositBox, update bool) error {
+	if sdb == nil {
+		return fmt.Errorf("Safe Deposit Box may not be nil")
+	}
+	var errs []error
+	if !update || sdb.Name != "" {
+		if err := ValidateSDBName(sdb.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (!update || sdb.Owner != "") && strings.TrimSpace(sdb.Owner) == "" {
+		errs = append(errs, ErrorSafeDepositBoxOwnerBlank)
+	}
+	if (!update || sdb.CategoryID != "") && strings.TrimSpace(sdb.CategoryID) == "" {
+		errs = append(errs, ErrorSafeDepositBoxCategoryBlank)
+	}
+	groups := map[string]bool{}
+	for i, p := range sdb.UserGroupPermissions {
+		name := strings.TrimSpace(p.Name)
+		switch {
+		case name == "":
+			errs = append(errs, fmt.Errorf("User group permission %d has no group name", i+1))
+		case groups[strings.ToLower(name)]:
+			errs = append(errs, fmt.Errorf("User group %s is given more than one permission", name))
+		}
+		groups[strings.ToLower(name)] = true
+		if strings.TrimSpace(p.RoleID) == "" {
+			errs = append(errs, fmt.Errorf("User group permission %d has no role ID", i+1))
+		}
+	}
+	principals := map[string]bool{}
+	for i, p := range sdb.IAMPrincipalPermissions {
+		arn := strings.TrimSpace(p.IAMPrincipalARN)
+		switch {
+		case arn == "":
+			errs = append(errs, fmt.Errorf("IAM principal permission %d has no IAM principal ARN", i+1))
+		case !isARN(arn):
+			errs = append(errs, fmt.Errorf("IAM principal permission %d has an invalid ARN %q", i+1, arn))
+		case principals[arn]:
+			errs = append(errs, fmt.Errorf("IAM principal %s is given more than one permission", arn))
+		}
+		principals[arn] = true
+		if strings.TrimSpace(p.RoleID) == "" {
+			errs = append(errs, fmt.Errorf("IAM principal permission %d has no role ID", i+1))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isARN returns whether s has the shape of an ARN (arn:partition:service:region:account:resource)
+func isARN(s string) bool {
+	parts := strings.SplitN(s, ":", 6)
+	return len(parts) == 6 && parts[0] == "arn" && parts[1] != "" && parts[2] != "" && parts[5] != ""
+}
+
+// Validate checks a new Safe Deposit Box with ValidateSDB, and also checks that its category
+// and every role ID in its permissions exist in Cerberus. The IDs are checked against the same
+// cached lists used by Category.IDForName and Role.IDForName, so this reads from Cerberus at
+// most once per list but never changes anything
+func (s *SDB) Validate(sdb *api.SafeDepositBox) error {
+	if err := ValidateSDB(sdb); err != nil {
+		return err
+	}
+	return s.validateIDs(sdb)
+}
+
+// ValidateUpdate is Validate for the changes passed to Update, using ValidateSDBUpdate
+func (s *SDB) ValidateUpdate(sdb *api.SafeDepositBox) error {
+	if err := ValidateSDBUpdate(sdb); err != nil {
+		return err
+	}
+	return s.validateIDs(sdb)
+}
+
+// validateIDs returns an error for every category or role ID in the SDB that doesn't exist
+func (s *SDB) validateIDs(sdb *api.SafeDepositBox) error {
+	var errs []error
+	if sdb.CategoryID != "" {
+		cached, err := s.c.categories.get(func() (interface{}, error) {
+			return s.c.Category().List()
+		})
+		if err != nil {
+			return fmt.Errorf("Error while validating category: %w", err)
+		}
+		found := false
+		for _, v := range cached.([]*api.Category) {
+			found = found || v.ID == sdb.CategoryID
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("Safe Deposit Box category ID %q does not exist", sdb.CategoryID))
+		}
+	}
+	if len(sdb.UserGroupPermissions) > 0 || len(sdb.IAMPrincipalPermissions) > 0 {
+		cached, err := s.c.roles.get(func() (interface{}, error) {
+			return s.c.Role().List()
+		})
+		if err != nil {
+			return fmt.Errorf("Error while validating roles: %w", err)
+		}
+		roles := map[string]bool{}
+		for _, v := range cached.([]*api.Role) {
+			roles[v.ID] = true
+		}
+		for _, p := range sdb.UserGroupPermissions {
+			if !roles[p.RoleID] {
+				errs = append(errs, fmt.Errorf("Role ID %q for user group %s does not exist", p.RoleID, p.Name))
+			}
+		}
+		for _, p := range sdb.IAMPrincipalPermissions {
+			if !roles[p.RoleID] {
+				errs = append(errs, fmt.Errorf("Role ID %q for IAM principal %s does not exist", p.RoleID, p.IAMPrincipalARN))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateSecretWrite checks a secret write the way the client would before sending it: the
+// path may not be blank, and the data must be encodable as JSON. Nothing is sent to Cerberus
+func ValidateSecretWrite(path string, data map[string]interface{}) error {
+	if strings.TrimSpace(path) == "" {
+		return ErrorSecretPathBlank
+	}
+	if _, err := json.Marshal(data); err != nil {
+		return fmt.Errorf("Secret data can't be encoded as JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ecimionatto/cerberus-go-client/api"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// validSDB returns a new SDB that passes validation, using the IDs in categoryResponse and listResponse
+func validSDB() *api.SafeDepositBox {
+	return &api.SafeDepositBox{
+		Name:       "Stage",
+		CategoryID: "f7ff85a0-faaa-11e5-a8a9-7fa3b294cd46",
+		Owner:      "Lst-digital.platform-tools.internal",
+		UserGroupPermissions: []api.UserGroupPermission{
+			{Name: "Lst-CDT.CloudPlatformEngine.FTE", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+		},
+		IAMPrincipalPermissions: []api.IAMPrincipal{
+			{IAMPrincipalARN: "arn:aws:iam::1111111111:role/role-name", RoleID: "f800558e-faaa-11e5-a8a9-7fa3b294cd46"},
+		},
+	}
+}
+
+func TestValidateSDB(t *testing.T) {
+	Convey("A valid SDB", t, func() {
+		So(ValidateSDB(validSDB()), ShouldBeNil)
+	})
+
+	Convey("A nil SDB", t, func() {
+		So(ValidateSDB(nil), ShouldNotBeNil)
+	})
+
+	Convey("An SDB missing its name, owner, and category", t, func() {
+		sdb := validSDB()
+		sdb.Name = " "
+		sdb.Owner = ""
+		sdb.CategoryID = ""
+		err := ValidateSDB(sdb)
+		Convey("Should return every problem", func() {
+			So(errors.Is(err, ErrorSafeDepositBoxNameBlank), ShouldBeTrue)
+			So(errors.Is(err, ErrorSafeDepositBoxOwnerBlank), ShouldBeTrue)
+			So(errors.Is(err, ErrorSafeDepositBoxCategoryBlank), ShouldBeTrue)
+			So(strings.Split(err.Error(), "\n"), ShouldHaveLength, 3)
+		})
+	})
+
+	Convey("An SDB with bad permissions", t, func() {
+		sdb := validSDB()
+		sdb.UserGroupPermissions = []api.UserGroupPermission{
+			{Name: "", RoleID: "a-role"},
+			{Name: "Lst-team", RoleID: ""},
+			{Name: "lst-team", RoleID: "a-role"},
+		}
+		sdb.IAMPrincipalPermissions = []api.IAMPrincipal{
+			{IAMPrincipalARN: "", RoleID: "a-role"},
+			{IAMPrincipalARN: "role/not-an-arn", RoleID: "a-role"},
+			{IAMPrincipalARN: "arn:aws:iam::1111111111:role/role-name", RoleID: "a-role"},
+			{IAMPrincipalARN: "arn:aws:iam::1111111111:role/role-name", RoleID: " "},
+		}
+		Convey("Should describe each one", func() {
+			So(strings.Split(ValidateSDB(sdb).Error(), "\n"), ShouldResemble, []string{
+				"User group permission 1 has no group name",
+				"User group permission 2 has no role ID",
+				"User group lst-team is given more than one permission",
+				"IAM principal permission 1 has no IAM principal ARN",
+				`IAM principal permission 2 has an invalid ARN "role/not-an-arn"`,
+				"IAM principal arn:aws:iam::1111111111:role/role-name is given more than one permission",
+				"IAM principal permission 4 has no role ID",
+			})
+		})
+	})
+
+	Convey("An update", t, func() {
+		Convey("Should allow fields to be left out", func() {
+			So(ValidateSDBUpdate(&api.SafeDepositBox{Description: "new description"}), ShouldBeNil)
+		})
+		Convey("Should check the fields that are set", func() {
+			err := ValidateSDBUpdate(&api.SafeDepositBox{Name: "bad/name", Owner: " "})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid character '/'")
+			So(errors.Is(err, ErrorSafeDepositBoxOwnerBlank), ShouldBeTrue)
+		})
+	})
+}
+
+// lookupServer serves the category and role lists and counts every request it gets
+func lookupServer(hits *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		switch r.URL.Path {
+		case categoryBasePath:
+			w.Write([]byte(categoryResponse))
+		case roleBasePath:
+			w.Write([]byte(listResponse))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+}
+
+func TestSDBValidate(t *testing.T) {
+	Convey("Validating against Cerberus", t, func() {
+		var hits int
+		ts := lookupServer(&hits)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should accept known IDs with one read of each list", func() {
+			So(cl.SDB().Validate(validSDB()), ShouldBeNil)
+			So(cl.SDB().Validate(validSDB()), ShouldBeNil)
+			So(hits, ShouldEqual, 2)
+		})
+		Convey("Should reject unknown IDs", func() {
+			sdb := validSDB()
+			sdb.CategoryID = "not-a-category"
+			sdb.IAMPrincipalPermissions[0].RoleID = "not-a-role"
+			So(strings.Split(cl.SDB().Validate(sdb).Error(), "\n"), ShouldResemble, []string{
+				`Safe Deposit Box category ID "not-a-category" does not exist`,
+				`Role ID "not-a-role" for IAM principal arn:aws:iam::1111111111:role/role-name does not exist`,
+			})
+		})
+		Convey("Should not look anything up for a structural error", func() {
+			sdb := validSDB()
+			sdb.Owner = ""
+			So(errors.Is(cl.SDB().Validate(sdb), ErrorSafeDepositBoxOwnerBlank), ShouldBeTrue)
+			So(hits, ShouldEqual, 0)
+		})
+		Convey("Should only check the IDs an update sets", func() {
+			So(cl.SDB().ValidateUpdate(&api.SafeDepositBox{Description: "new description"}), ShouldBeNil)
+			So(hits, ShouldEqual, 0)
+		})
+		Reset(ts.Close)
+	})
+}
+
+func TestValidateSecretWrite(t *testing.T) {
+	Convey("A valid secret write", t, func() {
+		So(ValidateSecretWrite("app/sdb/config", map[string]interface{}{"key": "value"}), ShouldBeNil)
+	})
+	Convey("A blank path", t, func() {
+		So(ValidateSecretWrite(" ", map[string]interface{}{"key": "value"}), ShouldEqual, ErrorSecretPathBlank)
+	})
+	Convey("Data that can't be encoded", t, func() {
+		err := ValidateSecretWrite("app/sdb/config", map[string]interface{}{"key": math.Inf(1)})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "Secret data can't be encoded as JSON")
+	})
+	Convey("A write with invalid data", t, func() {
+		var hits int
+		ts := lookupServer(&hits)
+		defer ts.Close()
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should fail without sending anything", func() {
+			So(cl.WriteSecret("", map[string]interface{}{"key": "value"}), ShouldEqual, ErrorSecretPathBlank)
+			_, err := cl.Secret().Write("app/sdb/config", map[string]interface{}{"key": make(chan int)})
+			So(err, ShouldNotBeNil)
+			So(hits, ShouldEqual, 0)
+		})
+	})
+}
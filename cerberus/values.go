@@ -0,0 +1,99 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrorSecretKeyNotFound is returned by the SecretValues accessors when the key doesn't exist
+var ErrorSecretKeyNotFound = fmt.Errorf("Key not found in secret")
+
+// SecretValues is the data of a secret with accessors for parsing values that are
+// stored as strings, such as timeouts and feature flags
+type SecretValues map[string]interface{}
+
+// ReadValues reads the secret at the given path and returns its data as SecretValues.
+// Unlike Read, it is an error if nothing exists at the path
+func (s *Secret) ReadValues(path string) (SecretValues, error) {
+	sec, err := s.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if sec == nil {
+		return nil, fmt.Errorf("No secret found at path %s", path)
+	}
+	return SecretValues(sec.Data), nil
+}
+
+// String returns the value for the given key, which must be a string
+func (v SecretValues) String(key string) (string, error) {
+	raw, ok := v[key]
+	if !ok {
+		return "", ErrorSecretKeyNotFound
+	}
+	str, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("Value for key %s is a %T, not a string", key, raw)
+	}
+	return str, nil
+}
+
+// Duration parses the value for the given key as a duration using time.ParseDuration (e.g. "30s")
+func (v SecretValues) Duration(key string) (time.Duration, error) {
+	str, err := v.String(key)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("Error while parsing value for key %s as a duration: %v", key, err)
+	}
+	return d, nil
+}
+
+// Bool parses the value for the given key as a bool using strconv.ParseBool (e.g. "true" or "0").
+// A value that was stored as a JSON boolean is returned as is
+func (v SecretValues) Bool(key string) (bool, error) {
+	if b, ok := v[key].(bool); ok {
+		return b, nil
+	}
+	str, err := v.String(key)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, fmt.Errorf("Error while parsing value for key %s as a bool: %v", key, err)
+	}
+	return b, nil
+}
+
+// Time parses the value for the given key as a time in the given layout (e.g. time.RFC3339)
+func (v SecretValues) Time(key, layout string) (time.Time, error) {
+	str, err := v.String(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, str)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Error while parsing value for key %s as a time: %v", key, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSecretValues(t *testing.T) {
+	Convey("A set of secret values", t, func() {
+		values := SecretValues{
+			"timeout":    "30s",
+			"enabled":    "true",
+			"json-flag":  false,
+			"rotated-at": "2017-05-02T15:04:05Z",
+			"port":       5432.0,
+			"garbage":    "not-a-value",
+		}
+		Convey("Should parse a duration", func() {
+			d, err := values.Duration("timeout")
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, 30*time.Second)
+		})
+		Convey("Should parse a bool", func() {
+			b, err := values.Bool("enabled")
+			So(err, ShouldBeNil)
+			So(b, ShouldBeTrue)
+			b, err = values.Bool("json-flag")
+			So(err, ShouldBeNil)
+			So(b, ShouldBeFalse)
+		})
+		Convey("Should parse a time", func() {
+			ts, err := values.Time("rotated-at", time.RFC3339)
+			So(err, ShouldBeNil)
+			So(ts.Equal(time.Date(2017, 5, 2, 15, 4, 5, 0, time.UTC)), ShouldBeTrue)
+		})
+		Convey("Should error on values that can't be parsed", func() {
+			_, err := values.Duration("garbage")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "garbage")
+			_, err = values.Bool("garbage")
+			So(err, ShouldNotBeNil)
+			_, err = values.Time("garbage", time.RFC3339)
+			So(err, ShouldNotBeNil)
+			_, err = values.Time("rotated-at", time.Kitchen)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Should error on values that aren't strings", func() {
+			_, err := values.Duration("port")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "float64")
+		})
+		Convey("Should return ErrorSecretKeyNotFound for a missing key", func() {
+			_, err := values.Duration("nope")
+			So(err, ShouldEqual, ErrorSecretKeyNotFound)
+			_, err = values.Bool("nope")
+			So(err, ShouldEqual, ErrorSecretKeyNotFound)
+			_, err = values.Time("nope", time.RFC3339)
+			So(err, ShouldEqual, ErrorSecretKeyNotFound)
+		})
+	})
+
+	Convey("Reading secret values", t, func() {
+		ts := secretServer()
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should return the data of the secret", func() {
+			values, err := cl.Secret().ReadValues("app/sdb/config")
+			So(err, ShouldBeNil)
+			So(values, ShouldNotBeEmpty)
+		})
+		Convey("Should error if there is no secret at the path", func() {
+			values, err := cl.Secret().ReadValues("app/sdb/missing")
+			So(err, ShouldNotBeNil)
+			So(values, ShouldBeNil)
+		})
+		Reset(func() {
+			ts.Close()
+		})
+	})
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ErrorSecretTreeTooDeep is returned by WalkSecrets when folders are nested deeper than
+// MaxWalkDepth, which happens when Cerberus keeps listing the same folder inside itself
+var ErrorSecretTreeTooDeep = fmt.Errorf("Secret folders are nested too deeply to walk")
+
+// MaxWalkDepth is how many levels of folders WalkSecrets descends into below the root
+const MaxWalkDepth = 32
+
+// WalkSecrets reads every secret under the given path (e.g. "app/my-sdb"), descending into
+// any folders, and calls fn with the path and data of each one. Folders are walked depth
+// first in the order Cerberus lists them. If fn returns an error, the walk stops and that
+// error is returned as is. Path should not be prefaced with a "/"
+func (c *Client) WalkSecrets(root string, fn func(path string, data map[string]interface{}) error) error {
+	return c.WalkSecretsWithContext(context.Background(), root, fn)
+}
+
+// WalkSecretsWithContext is the same as WalkSecrets, but stops and returns the context's
+// error if the context is cancelled or its deadline passes
+func (c *Client) WalkSecretsWithContext(ctx context.Context, root string, fn func(path string, data map[string]interface{}) error) error {
+	return c.walkSecretPaths(ctx, root, func(path string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := c.getSecret(ctx, path)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			// The secret was deleted after the folder was listed
+			return nil
+		}
+		return fn(path, data)
+	})
+}
+
+// walkSecretPaths walks the folders under root like WalkSecrets, but only calls visit with
+// the path of each secret without reading it
+func (c *Client) walkSecretPaths(ctx context.Context, root string, visit func(path string) error) error {
+	w := &secretWalker{c: c, visit: visit, visited: map[string]bool{}}
+	return w.walk(ctx, strings.TrimSuffix(root, "/")+"/", 0)
+}
+
+// secretPaths returns the path of every secret under root, found the same way as WalkSecrets
+func (c *Client) secretPaths(ctx context.Context, root string) ([]string, error) {
+	var paths []string
+	err := c.walkSecretPaths(ctx, root, func(path string) error {
+		paths = append(paths, path)
+		return nil
+	})
+	return paths, err
+}
+
+// CollectSecrets reads every secret under the given path like WalkSecrets and returns their
+// data keyed by their full path. Path should not be prefaced with a "/"
+func (c *Client) CollectSecrets(root string) (map[string]map[string]interface{}, error) {
+	secrets := map[string]map[string]interface{}{}
+	err := c.WalkSecrets(root, func(path string, data map[string]interface{}) error {
+		secrets[path] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// secretWalker keeps track of a walk through a tree of secrets
+type secretWalker struct {
+	c *Client
+	// visit is called with the path of each secret that is found
+	visit func(path string) error
+	// visited is every folder and secret that has been seen, so that nothing is walked twice
+	// if Cerberus lists it more than once
+	visited map[string]bool
+}
+
+// walk lists the folder (which ends in a "/") and reads or descends into everything in it
+func (w *secretWalker) walk(ctx context.Context, folder string, depth int) error {
+	if depth > MaxWalkDepth {
+		return ErrorSecretTreeTooDeep
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	keys, err := w.c.listSecrets(ctx, folder)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if !isChildKey(k) {
+			// A key like "./" or "../" would have the walk go around in circles
+			continue
+		}
+		path := folder + k
+		if w.visited[path] {
+			continue
+		}
+		w.visited[path] = true
+		if strings.HasSuffix(k, "/") {
+			if err := w.walk(ctx, path, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.visit(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isChildKey returns whether a key from a listing is a secret or folder under the folder
+// that was listed, rather than something that points back up the tree
+func isChildKey(key string) bool {
+	name := strings.TrimSuffix(key, "/")
+	if name == "" || strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
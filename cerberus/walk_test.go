@@ -0,0 +1,139 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// secretTreeServer serves the given folders (keyed by path with a trailing "/") and secrets.
+// A folder whose path contains "endless" lists another folder inside itself forever
+func secretTreeServer(folders map[string][]string, secrets map[string]map[string]interface{}, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		path := strings.TrimPrefix(r.URL.Path, "/v1/secret/")
+		if r.URL.Query().Get("list") == "true" {
+			keys, ok := folders[path]
+			if strings.Contains(path, "endless") {
+				keys, ok = []string{"endless/"}, true
+			}
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+			return
+		}
+		data, ok := secrets[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+var walkFolders = map[string][]string{
+	"app/backup/":               {"config", "nested/", "./", "../", "config", "gone"},
+	"app/backup/nested/":        {"db", "deeper/"},
+	"app/backup/nested/deeper/": {"api"},
+	"app/empty/":                {},
+}
+
+var walkSecrets = map[string]map[string]interface{}{
+	"app/backup/config":            {"username": "bob"},
+	"app/backup/nested/db":         {"password": "hunter2"},
+	"app/backup/nested/deeper/api": {"key": "abc123"},
+}
+
+func TestWalkSecrets(t *testing.T) {
+	Convey("Walking a tree of secrets", t, func() {
+		var requests int32
+		ts := secretTreeServer(walkFolders, walkSecrets, &requests)
+		Reset(ts.Close)
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should call fn for every secret at every level once", func() {
+			var paths []string
+			err := cl.WalkSecrets("app/backup", func(path string, data map[string]interface{}) error {
+				paths = append(paths, path)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(paths, ShouldResemble, []string{"app/backup/config", "app/backup/nested/db", "app/backup/nested/deeper/api"})
+		})
+		Convey("Should collect the data of every secret", func() {
+			secrets, err := cl.CollectSecrets("app/backup/")
+			So(err, ShouldBeNil)
+			So(secrets, ShouldHaveLength, 3)
+			So(secrets["app/backup/nested/deeper/api"]["key"], ShouldEqual, "abc123")
+			So(secrets["app/backup/config"]["username"], ShouldEqual, "bob")
+		})
+		Convey("Should return nothing for an empty folder", func() {
+			secrets, err := cl.CollectSecrets("app/empty")
+			So(err, ShouldBeNil)
+			So(secrets, ShouldBeEmpty)
+		})
+		Convey("Should stop at the first error from fn", func() {
+			stop := errors.New("stop")
+			calls := 0
+			err := cl.WalkSecrets("app/backup", func(path string, data map[string]interface{}) error {
+				calls++
+				return stop
+			})
+			So(err, ShouldEqual, stop)
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("Should stop when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			err := cl.WalkSecretsWithContext(ctx, "app/backup", func(path string, data map[string]interface{}) error {
+				cancel()
+				return nil
+			})
+			So(err, ShouldEqual, context.Canceled)
+			atomic.StoreInt32(&requests, 0)
+			So(cl.WalkSecretsWithContext(ctx, "app/backup", nil), ShouldEqual, context.Canceled)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 0)
+		})
+		Convey("Should give up on folders that nest forever", func() {
+			err := cl.WalkSecrets("app/endless", func(path string, data map[string]interface{}) error {
+				return nil
+			})
+			So(err, ShouldEqual, ErrorSecretTreeTooDeep)
+		})
+	})
+}
+
+func TestIsChildKey(t *testing.T) {
+	Convey("Keys from a listing", t, func() {
+		So(isChildKey("config"), ShouldBeTrue)
+		So(isChildKey("nested/"), ShouldBeTrue)
+		for _, k := range []string{"", "/", "./", "../", "..", "/etc", "a//b", "a/../b"} {
+			So(isChildKey(k), ShouldBeFalse)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watch checks a secret for changes unless
+// set with WithWatchInterval
+const DefaultWatchInterval = 30 * time.Second
+
+// Watch returns a channel that receives the data of the secret at the given path whenever it
+// changes. Cerberus has no way to push changes, so the secret is read every watch interval (30
+// seconds unless set with WithWatchInterval) and sent on the channel if it is different from the
+// last value sent. The current value is always sent first. If the secret is deleted, nil is sent.
+//
+// Because changes are found by polling, a change is seen up to one interval late, and a value that
+// is changed and then changed back between two reads is never seen. Failed reads are skipped and
+// tried again on the next interval. An error is only returned if the first read fails. The channel
+// is closed once the context is cancelled
+func (s *Secret) Watch(ctx context.Context, path string) (<-chan map[string]interface{}, error) {
+	sec, err := s.ReadWithContext(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	interval := s.c.watchInterval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	changes := make(chan map[string]interface{})
+	go func() {
+		defer close(changes)
+		var data map[string]interface{}
+		if sec != nil {
+			data = sec.Data
+		}
+		last := hashSecretData(data)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case changes <- data:
+			case <-ctx.Done():
+				return
+			}
+			// Wait for a read that finds something different
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				sec, err := s.ReadWithContext(ctx, path)
+				if err != nil {
+					continue
+				}
+				data = nil
+				if sec != nil {
+					data = sec.Data
+				}
+				if hash := hashSecretData(data); string(hash) != string(last) {
+					last = hash
+					break
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
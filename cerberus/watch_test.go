@@ -0,0 +1,86 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cerberus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatch(t *testing.T) {
+	Convey("Watching a secret", t, func() {
+		var lock sync.Mutex
+		value := "first"
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(fmt.Sprintf(`{"data": {"value": "%s"}}`, value)))
+		}))
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false), WithWatchInterval(10*time.Millisecond))
+		So(cl, ShouldNotBeNil)
+		ctx, cancel := context.WithCancel(context.Background())
+		changes, err := cl.Secret().Watch(ctx, "app/test/config")
+		So(err, ShouldBeNil)
+		Convey("Should send the current value and then each change", func() {
+			So((<-changes)["value"], ShouldEqual, "first")
+			lock.Lock()
+			value = "second"
+			lock.Unlock()
+			So((<-changes)["value"], ShouldEqual, "second")
+		})
+		Convey("Should close the channel when the context is cancelled", func() {
+			<-changes
+			cancel()
+			select {
+			case _, ok := <-changes:
+				So(ok, ShouldBeFalse)
+			case <-time.After(time.Second):
+				So("channel was not closed", ShouldBeEmpty)
+			}
+		})
+		Reset(func() {
+			cancel()
+			ts.Close()
+		})
+	})
+
+	Convey("Watching a secret that can't be read", t, WithTestServer(http.StatusForbidden, "/v1/secret/app/test/config", http.MethodGet, "", func(ts *httptest.Server) {
+		cl, _ := NewClient(GenerateMockAuth(ts.URL, "a-cool-token", false, false))
+		So(cl, ShouldNotBeNil)
+		Convey("Should error", func() {
+			changes, err := cl.Secret().Watch(context.Background(), "app/test/config")
+			So(err, ShouldNotBeNil)
+			So(changes, ShouldBeNil)
+		})
+	}))
+
+	Convey("An invalid watch interval", t, func() {
+		cl, err := NewClient(GenerateMockAuth("https://example.com", "a-cool-token", false, false), WithWatchInterval(0))
+		Convey("Should error", func() {
+			So(err, ShouldNotBeNil)
+			So(cl, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package prometheus records metrics about Cerberus with the Prometheus client library, for use
+// with cerberus.WithMetricsRecorder or the SetMetricsRecorder method of an auth type
+package prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/auth"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorder is an auth.MetricsRecorder that keeps Prometheus metrics:
+//
+//	cerberus_request_duration_seconds  a histogram of request latency by endpoint
+//	cerberus_responses_total           a counter of responses by endpoint and status code
+//	cerberus_auth_total                a counter of auth events by result
+//
+// A status code of 0 means the request failed without getting a response
+type Recorder struct {
+	duration  *prom.HistogramVec
+	responses *prom.CounterVec
+	auth      *prom.CounterVec
+}
+
+var _ auth.MetricsRecorder = (*Recorder)(nil)
+
+// NewRecorder returns a Recorder with its metrics registered with the given registerer, such as
+// prometheus.DefaultRegisterer. It returns an error if the metrics are already registered
+func NewRecorder(reg prom.Registerer) (*Recorder, error) {
+	r := &Recorder{
+		duration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: "cerberus",
+			Name:      "request_duration_seconds",
+			Help:      "How long requests to Cerberus took, by endpoint.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"endpoint"}),
+		responses: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: "cerberus",
+			Name:      "responses_total",
+			Help:      "Responses from Cerberus, by endpoint and HTTP status code.",
+		}, []string{"endpoint", "code"}),
+		auth: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: "cerberus",
+			Name:      "auth_total",
+			Help:      "Authentication events (success, failure, refresh, and logout).",
+		}, []string{"result"}),
+	}
+	for _, c := range []prom.Collector{r.duration, r.responses, r.auth} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("Error while registering Cerberus metrics: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// ObserveRequest records the latency and status code of a request
+func (r *Recorder) ObserveRequest(endpoint string, status int, dur time.Duration) {
+	r.duration.WithLabelValues(endpoint).Observe(dur.Seconds())
+	r.responses.WithLabelValues(endpoint, strconv.Itoa(status)).Inc()
+}
+
+// IncAuth counts an auth event
+func (r *Recorder) IncAuth(result string) {
+	r.auth.WithLabelValues(result).Inc()
+}
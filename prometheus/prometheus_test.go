@@ -0,0 +1,85 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prometheus
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ecimionatto/cerberus-go-client/auth"
+	prom "github.com/prometheus/client_golang/prometheus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// gather returns the value of every sample of each metric family in the registry, keyed by
+// the family name and then by the sample's label values (in the order of the label names)
+// joined with spaces
+func gather(reg *prom.Registry) map[string]map[string]float64 {
+	families, err := reg.Gather()
+	So(err, ShouldBeNil)
+	values := map[string]map[string]float64{}
+	for _, f := range families {
+		values[f.GetName()] = map[string]float64{}
+		for _, m := range f.GetMetric() {
+			key := ""
+			for i, l := range m.GetLabel() {
+				if i > 0 {
+					key += " "
+				}
+				key += l.GetValue()
+			}
+			switch {
+			case m.GetCounter() != nil:
+				values[f.GetName()][key] = m.GetCounter().GetValue()
+			case m.GetHistogram() != nil:
+				values[f.GetName()][key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return values
+}
+
+func TestRecorder(t *testing.T) {
+	Convey("A recorder", t, func() {
+		reg := prom.NewRegistry()
+		r, err := NewRecorder(reg)
+		So(err, ShouldBeNil)
+		Convey("Should count requests by endpoint and status", func() {
+			r.ObserveRequest("/v1/secret", http.StatusOK, 10*time.Millisecond)
+			r.ObserveRequest("/v1/secret", http.StatusOK, 20*time.Millisecond)
+			r.ObserveRequest("/v1/secret", 0, time.Second)
+			values := gather(reg)
+			So(values["cerberus_request_duration_seconds"]["/v1/secret"], ShouldEqual, 3)
+			So(values["cerberus_responses_total"]["200 /v1/secret"], ShouldEqual, 2)
+			So(values["cerberus_responses_total"]["0 /v1/secret"], ShouldEqual, 1)
+		})
+		Convey("Should count auth events by result", func() {
+			r.IncAuth(auth.AuthResultSuccess)
+			r.IncAuth(auth.AuthResultFailure)
+			r.IncAuth(auth.AuthResultFailure)
+			values := gather(reg)
+			So(values["cerberus_auth_total"][auth.AuthResultSuccess], ShouldEqual, 1)
+			So(values["cerberus_auth_total"][auth.AuthResultFailure], ShouldEqual, 2)
+		})
+		Convey("Should fail to register twice", func() {
+			again, err := NewRecorder(reg)
+			So(again, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redacted is what the values of tokens and credentials are replaced with before they are
+// logged or included in an error
+const Redacted = "REDACTED"
+
+// redactedHeaders are the headers whose values are never logged or included in errors
+var redactedHeaders = []string{"X-Vault-Token", "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Amz-Security-Token"}
+
+// RedactHeaders returns a copy of the headers with the values of any that hold tokens or
+// credentials replaced by Redacted
+func RedactHeaders(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for k, v := range h {
+		redacted[k] = append([]string(nil), v...)
+	}
+	for _, name := range redactedHeaders {
+		if _, ok := redacted[http.CanonicalHeaderKey(name)]; ok {
+			redacted.Set(name, Redacted)
+		}
+	}
+	return redacted
+}
+
+// RedactURL returns the URL as a string that is safe to log. Any password in it is replaced by
+// Redacted, as are the values of query parameters whose names mention a token or password
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	safe := *u
+	if safe.User != nil {
+		if _, ok := safe.User.Password(); ok {
+			safe.User = url.UserPassword(safe.User.Username(), Redacted)
+		}
+	}
+	if safe.RawQuery != "" {
+		query := safe.Query()
+		for name := range query {
+			lower := strings.ToLower(name)
+			if strings.Contains(lower, "token") || strings.Contains(lower, "password") {
+				query[name] = []string{Redacted}
+			}
+		}
+		safe.RawQuery = query.Encode()
+	}
+	return safe.String()
+}
+
+// RedactSecrets returns s with every occurrence of the values of the token and credential
+// headers in h replaced by Redacted. It is a last line of defense for text (such as an error
+// message) that might have picked up a token along the way
+func RedactSecrets(s string, h http.Header) string {
+	for _, name := range redactedHeaders {
+		for _, v := range h.Values(name) {
+			if v != "" {
+				s = strings.ReplaceAll(s, v, Redacted)
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetryBaseDelay is the starting delay used by the default backoff
+const DefaultRetryBaseDelay = 100 * time.Millisecond
+
+// DefaultRetryMaxDelay is the longest the default backoff will ever wait between attempts
+const DefaultRetryMaxDelay = 10 * time.Second
+
+// BackoffFunc returns how long to wait before retrying after the given attempt.
+// Attempts are numbered starting at 1 for the first (failed) request
+type BackoffFunc func(attempt int) time.Duration
+
+// ExponentialJitterBackoff returns a BackoffFunc that doubles base on every attempt (capped
+// at max) and then picks a random delay between zero and that value ("full jitter"), which
+// keeps many clients from retrying in lockstep
+func ExponentialJitterBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		if attempt < 1 {
+			attempt = 1
+		}
+		ceiling := base
+		for i := 1; i < attempt && ceiling < max; i++ {
+			ceiling *= 2
+		}
+		if ceiling > max {
+			ceiling = max
+		}
+		if ceiling <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(ceiling) + 1))
+	}
+}
+
+// ParseRetryAfter returns how long a Retry-After header value asks to wait, relative to now.
+// Both forms of the header are supported: a number of seconds and an HTTP date. It returns
+// false if the value is empty or can't be parsed. A date in the past is a wait of 0
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// IsRetryableStatus returns whether a response with the given status code is a transient
+// server failure that is worth retrying
+func IsRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
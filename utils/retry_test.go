@@ -0,0 +1,84 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExponentialJitterBackoff(t *testing.T) {
+	Convey("An exponential backoff", t, func() {
+		backoff := ExponentialJitterBackoff(10*time.Millisecond, 50*time.Millisecond)
+		Convey("Should stay within the exponential ceiling", func() {
+			for i := 0; i < 100; i++ {
+				So(backoff(1), ShouldBeLessThanOrEqualTo, 10*time.Millisecond)
+				So(backoff(2), ShouldBeLessThanOrEqualTo, 20*time.Millisecond)
+				So(backoff(3), ShouldBeLessThanOrEqualTo, 40*time.Millisecond)
+			}
+		})
+		Convey("Should never exceed the max", func() {
+			for i := 0; i < 100; i++ {
+				So(backoff(30), ShouldBeLessThanOrEqualTo, 50*time.Millisecond)
+				So(backoff(30), ShouldBeGreaterThanOrEqualTo, 0)
+			}
+		})
+	})
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	Convey("Transient server errors", t, func() {
+		So(IsRetryableStatus(http.StatusInternalServerError), ShouldBeTrue)
+		So(IsRetryableStatus(http.StatusBadGateway), ShouldBeTrue)
+		So(IsRetryableStatus(http.StatusServiceUnavailable), ShouldBeTrue)
+		So(IsRetryableStatus(http.StatusGatewayTimeout), ShouldBeTrue)
+	})
+	Convey("Client errors and successes", t, func() {
+		So(IsRetryableStatus(http.StatusOK), ShouldBeFalse)
+		So(IsRetryableStatus(http.StatusBadRequest), ShouldBeFalse)
+		So(IsRetryableStatus(http.StatusUnauthorized), ShouldBeFalse)
+		So(IsRetryableStatus(http.StatusForbidden), ShouldBeFalse)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2017, time.July, 1, 12, 0, 0, 0, time.UTC)
+	Convey("A delay in seconds", t, func() {
+		wait, ok := ParseRetryAfter("120", now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 2*time.Minute)
+	})
+	Convey("An HTTP date", t, func() {
+		wait, ok := ParseRetryAfter("Sat, 01 Jul 2017 12:00:30 GMT", now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 30*time.Second)
+	})
+	Convey("An HTTP date in the past", t, func() {
+		wait, ok := ParseRetryAfter("Sat, 01 Jul 2017 11:00:00 GMT", now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 0)
+	})
+	Convey("An invalid value", t, func() {
+		for _, v := range []string{"", "soon", "-5"} {
+			_, ok := ParseRetryAfter(v, now)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
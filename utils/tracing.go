@@ -0,0 +1,102 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name the tracers used by the client are created with
+const TracerName = "github.com/ecimionatto/cerberus-go-client"
+
+// The attributes set on spans
+const (
+	// AttributeEndpoint is the endpoint a request was made to, such as /v2/auth/iam-principal
+	AttributeEndpoint = attribute.Key("cerberus.endpoint")
+	// AttributeStatusCode is the HTTP status code of the response to a request
+	AttributeStatusCode = attribute.Key("http.response.status_code")
+	// AttributeRegion is the AWS region used to authenticate
+	AttributeRegion = attribute.Key("cloud.region")
+)
+
+// Tracer returns the tracer to create spans with from the given provider, or nil if the
+// provider is nil, which turns tracing off
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		return nil
+	}
+	return tp.Tracer(TracerName)
+}
+
+// Span is a span started with StartSpan. Every method is a no-op on a nil *Span, which is what
+// StartSpan returns when tracing is off, so callers never have to check
+type Span struct {
+	span trace.Span
+}
+
+// spanKey is the context key that the current Span is stored under
+type spanKey struct{}
+
+// StartSpan starts a client span with the given name and attributes and returns it along with a
+// context that carries it. If the tracer is nil nothing is started, and the context is returned
+// as is with a nil *Span
+func StartSpan(ctx context.Context, tracer trace.Tracer, name string, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	s := &Span{span: span}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// CurrentSpan returns the innermost Span started with StartSpan in the context, or nil if there
+// isn't one. Spans started by anything else are never returned, so that attributes are only
+// ever set on spans that the client owns
+func CurrentSpan(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetRequest records the endpoint of a request and the status code of its response on the
+// span. A status of 0 means no response was received, and is left out
+func (s *Span) SetRequest(endpoint string, status int) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(AttributeEndpoint.String(endpoint))
+	if status != 0 {
+		s.span.SetAttributes(AttributeStatusCode.Int(status))
+	}
+}
+
+// End ends the span, marking it as failed first if err isn't nil. It returns err unchanged so
+// that it can wrap a return value
+func (s *Span) End(err error) error {
+	if s == nil {
+		return err
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+	return err
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2017 Nike Inc.
+
+Licensed under the Apache License, Version 2.0 (the License);
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an AS IS BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStartSpan(t *testing.T) {
+	Convey("With no tracer", t, func() {
+		ctx := context.Background()
+		sctx, span := StartSpan(ctx, nil, "cerberus.test")
+		Convey("Should return the context as is and a nil span", func() {
+			So(sctx == ctx, ShouldBeTrue)
+			So(span, ShouldBeNil)
+			So(CurrentSpan(sctx), ShouldBeNil)
+		})
+		Convey("Should be safe to use the nil span", func() {
+			span.SetRequest("/v1/secret", 200)
+			err := fmt.Errorf("an error")
+			So(span.End(err), ShouldEqual, err)
+		})
+	})
+
+	Convey("With a tracer", t, func() {
+		recorder := tracetest.NewSpanRecorder()
+		tracer := Tracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		ctx, span := StartSpan(context.Background(), tracer, "cerberus.test", AttributeRegion.String("us-west-2"))
+		Convey("Should put the span in the context", func() {
+			So(CurrentSpan(ctx), ShouldEqual, span)
+		})
+		Convey("Should leave out a status of 0", func() {
+			span.SetRequest("/v1/secret", 0)
+			span.End(nil)
+			ended := recorder.Ended()
+			So(ended, ShouldHaveLength, 1)
+			So(ended[0].Attributes(), ShouldHaveLength, 2)
+			So(ended[0].Status().Code, ShouldEqual, codes.Unset)
+		})
+		Convey("Should record an error", func() {
+			span.End(fmt.Errorf("an error"))
+			ended := recorder.Ended()
+			So(ended[0].Status().Code, ShouldEqual, codes.Error)
+			So(ended[0].Status().Description, ShouldEqual, "an error")
+			So(ended[0].Events(), ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("With no span in the context", t, func() {
+		So(CurrentSpan(context.Background()), ShouldBeNil)
+	})
+}
@@ -20,40 +20,106 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/ecimionatto/cerberus-go-client/api"
+	"golang.org/x/net/http/httpguts"
 )
 
 // ValidateURL takes a cerberus URL and makes sure that it is valid.
-// It expects
+// It expects an https URL with no query string, so that tokens are never sent in plain
+// text. The only exception is http URLs for localhost (such as a test server). The URL can
+// have a path (such as https://gateway.example.com/cerberus) when Cerberus is behind a
+// reverse proxy, and every endpoint is joined onto it with JoinPath
 func ValidateURL(fullURL string) (*url.URL, error) {
+	parsed, err := ValidateURLInsecure(fullURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "https" && !(parsed.Scheme == "http" && isLoopback(parsed.Hostname())) {
+		return nil, fmt.Errorf("Given URL uses the %q scheme. The URL should use https so that tokens are not sent in plain text", parsed.Scheme)
+	}
+	return parsed, nil
+}
+
+// ValidateURLInsecure is the same as ValidateURL, but allows any scheme. It should only be
+// used for testing
+func ValidateURLInsecure(fullURL string) (*url.URL, error) {
 	parsed, err := url.Parse(fullURL)
 	if err != nil {
 		return nil, err
 	}
 	// Make sure they didn't pass other things
-	if parsed.Path != "" {
-		return nil, fmt.Errorf("Given URL contained a path: %s. The URL should not have a path", parsed.Path)
-	}
 	if parsed.RawQuery != "" {
 		return nil, fmt.Errorf("Given URL contained a query string: %s. The URL should not have a query string", parsed.RawQuery)
 	}
+	parsed.Path = NormalizeBasePath(parsed.Path)
+	parsed.RawPath = ""
 	return parsed, nil
 }
 
+// NormalizeBasePath cleans up the base path of a Cerberus URL so it always starts with a
+// slash and never ends with one. An empty path (or just "/") is returned as an empty string
+func NormalizeBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// JoinPath joins an endpoint (such as /v2/auth/iam-principal) onto the base path of a
+// Cerberus URL with exactly one slash between them, whether or not either has its own
+func JoinPath(basePath, endpoint string) string {
+	return NormalizeBasePath(basePath) + "/" + strings.TrimLeft(endpoint, "/")
+}
+
+// ValidateUserAgent checks that a User-Agent isn't empty and only has bytes that are allowed
+// in a header value, so that it can't be used to inject other headers
+func ValidateUserAgent(userAgent string) error {
+	if strings.TrimSpace(userAgent) == "" {
+		return fmt.Errorf("User agent cannot be empty")
+	}
+	if !httpguts.ValidHeaderFieldValue(userAgent) {
+		return fmt.Errorf("User agent %q contains characters that are not allowed in a header", userAgent)
+	}
+	return nil
+}
+
+// isLoopback returns whether the host is localhost or a loopback IP address
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 // CheckAndParse is a helper function to check for user auth and token refresh errors and parse a response. It will return a user friendly error
 func CheckAndParse(resp *http.Response) (*api.UserAuthResponse, error) {
-	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+	if resp.StatusCode == http.StatusUnauthorized {
 		return nil, api.ErrorUnauthorized
 	}
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, api.ErrorForbidden
+	}
 	if resp.StatusCode != http.StatusOK {
+		if apiErr := api.ParseCerberusError(resp.StatusCode, resp.Body); apiErr != nil {
+			return nil, apiErr
+		}
 		return nil, fmt.Errorf("Error while trying to authenticate. Got HTTP response code %d", resp.StatusCode)
 	}
 	decoder := json.NewDecoder(resp.Body)
 	u := &api.UserAuthResponse{}
 	err := decoder.Decode(u)
+	if err == io.EOF {
+		// The decoder only returns EOF if there was nothing but whitespace in the body
+		return nil, api.ErrorEmptyResponse
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Error while trying to parse response from Cerberus: %v", err)
 	}
@@ -25,6 +25,22 @@ import (
 	"github.com/ecimionatto/cerberus-go-client/api"
 )
 
+func TestJoinPath(t *testing.T) {
+	Convey("Joining an endpoint onto a base path", t, func() {
+		So(JoinPath("", "/v2/auth/user"), ShouldEqual, "/v2/auth/user")
+		So(JoinPath("/", "/v2/auth/user"), ShouldEqual, "/v2/auth/user")
+		So(JoinPath("/cerberus", "/v2/auth/user"), ShouldEqual, "/cerberus/v2/auth/user")
+		So(JoinPath("cerberus/", "v2/auth/user"), ShouldEqual, "/cerberus/v2/auth/user")
+		So(JoinPath("/a/b//", "//v1/secret/"), ShouldEqual, "/a/b/v1/secret/")
+	})
+	Convey("Normalizing a base path", t, func() {
+		So(NormalizeBasePath(""), ShouldEqual, "")
+		So(NormalizeBasePath("/"), ShouldEqual, "")
+		So(NormalizeBasePath("cerberus"), ShouldEqual, "/cerberus")
+		So(NormalizeBasePath("/cerberus/"), ShouldEqual, "/cerberus")
+	})
+}
+
 func TestValidateURL(t *testing.T) {
 	Convey("A valid URL", t, func() {
 		parsedURL, err := ValidateURL("https://a.cerberus.com:3030")
@@ -43,19 +59,48 @@ func TestValidateURL(t *testing.T) {
 	})
 
 	Convey("A URL with a path", t, func() {
-		parsedURL, err := ValidateURL("https://a.cerberus.com/foo/bar/baz")
+		parsedURL, err := ValidateURL("https://a.cerberus.com/foo/bar/baz/")
+		Convey("Should keep it as the base path without the trailing slash", func() {
+			So(err, ShouldBeNil)
+			So(parsedURL.Path, ShouldEqual, "/foo/bar/baz")
+		})
+	})
+	Convey("A URL with a path", t, func() {
+		parsedURL, err := ValidateURL("https://a.cerberus.com?i=like&query=params")
 		Convey("Should error", func() {
 			So(err, ShouldNotBeNil)
 			So(parsedURL, ShouldBeNil)
 		})
 	})
-	Convey("A URL with a path", t, func() {
-		parsedURL, err := ValidateURL("https://a.cerberus.com?i=like&query=params")
+	Convey("An http URL", t, func() {
+		parsedURL, err := ValidateURL("http://a.cerberus.com")
+		Convey("Should error with the scheme", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `"http"`)
+			So(parsedURL, ShouldBeNil)
+		})
+		Convey("Should be allowed by ValidateURLInsecure", func() {
+			parsedURL, err := ValidateURLInsecure("http://a.cerberus.com")
+			So(err, ShouldBeNil)
+			So(parsedURL.Host, ShouldEqual, "a.cerberus.com")
+		})
+	})
+	Convey("A URL without a scheme", t, func() {
+		parsedURL, err := ValidateURL("//a.cerberus.com")
 		Convey("Should error", func() {
 			So(err, ShouldNotBeNil)
 			So(parsedURL, ShouldBeNil)
 		})
 	})
+	Convey("An http URL for localhost", t, func() {
+		Convey("Should not error", func() {
+			for _, u := range []string{"http://localhost:8080", "http://127.0.0.1:32876", "http://[::1]:8080"} {
+				parsedURL, err := ValidateURL(u)
+				So(err, ShouldBeNil)
+				So(parsedURL, ShouldNotBeNil)
+			}
+		})
+	})
 }
 
 var authResponseBody = `{
@@ -134,6 +179,22 @@ func TestCheckAndParse(t *testing.T) {
 		})
 	})
 
+	Convey("A successful response with an empty body", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(" \n"))
+		}))
+		defer ts.Close()
+		Convey("Should return an empty response error", func() {
+			resp, err := http.Get(ts.URL)
+			So(err, ShouldBeNil)
+			authResp, err := CheckAndParse(resp)
+			So(err, ShouldEqual, api.ErrorEmptyResponse)
+			So(authResp, ShouldBeNil)
+		})
+	})
+
 	Convey("A forbidden response", t, func() {
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
@@ -145,7 +206,7 @@ func TestCheckAndParse(t *testing.T) {
 			resp, err := http.Get(ts.URL)
 			So(err, ShouldBeNil)
 			authResp, err := CheckAndParse(resp)
-			So(err, ShouldEqual, api.ErrorUnauthorized)
+			So(err, ShouldEqual, api.ErrorForbidden)
 			So(authResp, ShouldBeNil)
 		})
 	})
@@ -166,6 +227,24 @@ func TestCheckAndParse(t *testing.T) {
 		})
 	})
 
+	Convey("An error with a structured body", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error_id": "an-id", "errors": [{"code": 99106, "message": "Bad MFA token"}]}`))
+		}))
+		defer ts.Close()
+		Convey("Should return a CerberusError", func() {
+			resp, err := http.Get(ts.URL)
+			So(err, ShouldBeNil)
+			authResp, err := CheckAndParse(resp)
+			So(authResp, ShouldBeNil)
+			So(err, ShouldHaveSameTypeAs, &api.CerberusError{})
+			So(err.(*api.CerberusError).StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(err.Error(), ShouldContainSubstring, "Bad MFA token")
+		})
+	})
+
 	Convey("A server error", t, func() {
 		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
@@ -182,3 +261,14 @@ func TestCheckAndParse(t *testing.T) {
 		})
 	})
 }
+
+func TestValidateUserAgent(t *testing.T) {
+	Convey("A valid user agent", t, func() {
+		So(ValidateUserAgent("my-service/1.2.3 (+https://example.com)"), ShouldBeNil)
+	})
+	Convey("Invalid user agents", t, func() {
+		for _, ua := range []string{"", "  ", "my-service\r\nX-Vault-Token: stolen", "my-service\x00"} {
+			So(ValidateUserAgent(ua), ShouldNotBeNil)
+		}
+	})
+}